@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+)
+
+// bench load-tests a deployment and prints the rate, latencies and errors
+// it saw. Interrupting it cuts the run short but still prints the results.
+func bench(args []string) {
+	var opts server.BenchOptions
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&opts.Target, "target", "", "the URL to request")
+	fs.StringVar(&opts.Method, "method", "GET", "the method to request it with")
+	fs.IntVar(&opts.Concurrency, "c", 100, "the number of requests to keep in flight")
+	fs.DurationVar(&opts.Duration, "d", 30*time.Second, "how long to run for")
+	fs.DurationVar(&opts.Timeout, "timeout", 10*time.Second, "the time after which a request counts as failed, 0 for none")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench -target URL [-c n] [-d duration]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if opts.Target == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("benchmarking %s for %s with %d connections\n", opts.Target, opts.Duration, opts.Concurrency)
+	res, err := server.Bench(ctx, opts)
+	if err != nil {
+		fmt.Printf("Failed to run benchmark: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("\n%d requests in %s, %.1f requests/s\n", res.Requests, res.Elapsed.Round(time.Millisecond), res.RPS())
+	fmt.Printf("latency p50 %s, p90 %s, p99 %s, max %s\n", res.P50, res.P90, res.P99, res.Max)
+
+	statuses := make([]int, 0, len(res.Statuses))
+	for status := range res.Statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Printf("  %d: %d\n", status, res.Statuses[status])
+	}
+	if res.Errors > 0 {
+		fmt.Printf("%d errors, the first: %v\n", res.Errors, res.FirstError)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// healthcheck requests a health endpoint and exits with 0 if it answers
+// with a 2xx status and 1 otherwise, for container health probes in images
+// that have no curl.
+func healthcheck(args []string) {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	url := fs.String("url", "http://localhost:4221/healthz", "the URL to check")
+	timeout := fs.Duration("timeout", 5*time.Second, "the time after which the check fails")
+	insecure := fs.Bool("insecure", false, "don't verify the TLS certificate, e.g. a self-signed one")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s healthcheck [-url url] [-timeout duration]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
+		},
+	}
+	resp, err := client.Get(*url)
+	if err != nil {
+		fmt.Printf("unhealthy: %v\n", err)
+		os.Exit(1)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		fmt.Printf("unhealthy: %s answered %s\n", *url, resp.Status)
+		os.Exit(1)
+	}
+}
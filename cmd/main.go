@@ -1,271 +1,127 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"flag"
-	"fmt"
-	"io"
-	"io/fs"
 	"net"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"syscall"
-	"time"
-)
-
-const (
-	serverVersion = "0.1"
-	serverName    = "naive-server¯\\_(ツ)_/¯"
-)
-
-const (
-	statusOK                  = 200
-	statusCreated             = 201
-	statusInternalServerError = 500
-	statusNotFound            = 404
-	statusBadRequest          = 400
-	statusMethodNotAllowed    = 405
-)
-
-const (
-	methodGet  = "GET"
-	methodPost = "POST"
-)
-
-const (
-	textStatusOK               = "OK"
-	textStatusCreated          = "Created"
-	textStatusInternal         = "Internal Server Error"
-	textStatusNotFound         = "Not Found"
-	textStatusBadRequest       = "Bad Request"
-	textStatusMethodNotAllowed = "Method Not Allowed"
-)
 
-const (
-	contentTypeTextPlain   = "text/plain"
-	contentTypeOctetStream = "application/octet-stream"
+	"github.com/claudemuller/naive-server/server"
 )
 
-type options struct {
-	directory string
-	host      string
-}
-
 func main() {
-	var opts options
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		bench(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "systemd-install" {
+		systemdInstall(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		healthcheck(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sign" {
+		sign(os.Args[2:])
+		return
+	}
 
-	flag.StringVar(&opts.directory, "directory", "./", "the directory to serve files from")
-	flag.StringVar(&opts.host, "host", "0.0.0.0:4221", "the host and port to run on")
+	var opts server.Options
+	opts.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	l, err := net.Listen("tcp", opts.host)
+	var stdio net.Conn
+	if opts.Stdio {
+		// Standard output carries the responses, so everything else is
+		// logged to standard error.
+		stdio = server.StdioConn(os.Stdin, os.Stdout)
+		os.Stdout = os.Stderr
+	}
+	log := server.NewLogger(opts)
+
+	srv, err := server.New(opts)
 	if err != nil {
-		fmt.Printf("Failed to bind to port %s\n", strings.SplitAfter(opts.host, ":")[1])
+		log.Error("failed to set up server", "err", err)
 		os.Exit(1)
 	}
 
-	shutdownCh := make(chan os.Signal, 1)
-	signal.Notify(shutdownCh, syscall.SIGINT, syscall.SIGTERM)
-	errCh := make(chan error, 1)
-
-	go func() {
-		for {
-			conn, err := l.Accept()
-			if err != nil {
-				fmt.Println("Error accepting connection: ", err.Error())
-				os.Exit(1)
-			}
-
-			go func() {
-				err := handleConn(conn, opts)
-				if err != nil {
-					fmt.Printf("%v\n", err)
-				}
-				conn.Close()
-			}()
+	if stdio != nil {
+		if err := srv.ServeConn(stdio); err != nil {
+			log.Info("request failed", "err", strings.TrimSpace(err.Error()))
 		}
-	}()
-
-	select {
-	case err := <-errCh:
-		fmt.Printf("%v\n", err)
-	case sig := <-shutdownCh:
-		fmt.Printf("received %d signal\n", sig)
-		fmt.Println("server shutdown started")
-
-		// Cleanup
-
-		defer fmt.Println("server shutdown completed")
+		srv.Shutdown()
+		return
 	}
-}
 
-type request struct {
-	method        string
-	httpVersion   string
-	host          string
-	userAgent     string
-	path          string
-	pathParts     []string
-	contentLength int
-}
-
-type content struct {
-	contentType string
-	body        []byte
-}
-
-func handleConn(conn net.Conn, opts options) error {
-	// Parse request
-	reqReader := bufio.NewReader(conn)
-	reqStr, err := reqReader.ReadBytes('\n')
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("error reading request line bytes: %v\n", err)
-	}
-
-	var req request
-
-	n, err := fmt.Sscanf(string(reqStr), "%s %s %s\r\n", &req.method, &req.path, &req.httpVersion)
+	// Under a systemd socket unit the socket is bound already.
+	l, err := server.ActivationListener()
 	if err != nil {
-		return fmt.Errorf("error reading request string: %v\n", err)
+		log.Error("failed to take over the systemd socket", "err", err)
+		os.Exit(1)
 	}
-	if n != 3 {
-		return fmt.Errorf("error reading request string: expected 3 parts")
+	listeners := []net.Listener{l}
+	if l == nil {
+		listeners, err = server.Listen(opts)
+	}
+	if err != nil {
+		log.Error("failed to bind", "host", opts.Host, "err", err)
+		os.Exit(1)
 	}
 
-	// Parse path parts
-	req.pathParts = strings.Split(strings.Trim(req.path, "\r\n "), "/")
-
-	// Parse headers
-	for {
-		headerStr, err := reqReader.ReadBytes('\n')
-		if err != nil {
-			return fmt.Errorf("error reading header line bytes: %v\n", err)
-		}
+	shutdownCh := make(chan os.Signal, 1)
+	signal.Notify(shutdownCh, syscall.SIGINT, syscall.SIGTERM)
+	reloadCh := make(chan os.Signal, 1)
+	signal.Notify(reloadCh, syscall.SIGHUP)
+	errCh := make(chan error, len(listeners)+2)
 
-		// TODO: seems clumbsy :(
-		if len(headerStr) == 2 {
-			break
+	serve := func(fn func() error) {
+		if err := fn(); err != nil {
+			errCh <- err
 		}
-
-		parseHeader(headerStr, &req)
 	}
 
-	if len(req.pathParts) < 2 {
-		conn.Write(buildResponse(statusBadRequest, nil))
-
-		return nil
+	go serve(func() error { return srv.ServeListeners(listeners) })
+	if opts.HTTPRedirect != "" {
+		go serve(srv.ServeRedirects)
 	}
-
-	// Handle POST requests
-	if req.method == methodPost {
-		if req.pathParts[1] == "files" {
-			buf := make([]byte, req.contentLength)
-			if _, err := reqReader.Read(buf); err != nil {
-				conn.Write(buildResponse(statusInternalServerError, nil))
-				return fmt.Errorf("error parsing request: %v\n", err)
-			}
-
-			err = os.WriteFile(opts.directory+req.pathParts[2], buf, fs.ModeAppend)
-			if err != nil {
-				conn.Write(buildResponse(statusInternalServerError, nil))
-				return fmt.Errorf("error writing %s: %v\n", req.pathParts[2], err)
-			}
-			conn.Write(buildResponse(statusCreated, nil))
-
-			return nil
-		}
-
-		conn.Write(buildResponse(statusNotFound, nil))
-
-		return nil
+	if opts.Admin != "" {
+		go serve(srv.ServeAdmin)
 	}
 
-	// Handle GET requests
-	if req.method == methodGet {
-		switch req.pathParts[1] {
-		case "":
-			conn.Write(buildResponse(statusOK, nil))
-		case "echo":
-			c := content{
-				contentType: "text/plain",
-				body:        []byte(strings.Join(req.pathParts[2:], "/")),
-			}
-			conn.Write(buildResponse(statusOK, &c))
-		case "user-agent":
-			c := content{
-				contentType: contentTypeTextPlain,
-				body:        []byte(req.userAgent),
-			}
-			conn.Write(buildResponse(statusOK, &c))
-		case "files":
-			data, err := os.ReadFile(opts.directory + req.pathParts[2])
-			if err != nil {
-				conn.Write(buildResponse(statusNotFound, nil))
-				return fmt.Errorf("error reading %s: %v\n", req.pathParts[2], err)
-			}
-			c := content{
-				contentType: contentTypeOctetStream,
-				body:        data,
+loop:
+	for {
+		select {
+		case <-reloadCh:
+			if err := srv.Reload(); err != nil {
+				log.Error("reload failed", "err", err)
+			} else {
+				log.Info("configuration reloaded")
 			}
-			conn.Write(buildResponse(statusOK, &c))
-		default:
-			conn.Write(buildResponse(statusNotFound, nil))
+		case err := <-errCh:
+			log.Error("serving failed", "err", err)
+			break loop
+		case sig := <-shutdownCh:
+			log.Info("received signal", "signal", sig.String())
+			break loop
+		case <-srv.ShutdownRequested():
+			log.Info("shutdown requested via admin API")
+			break loop
 		}
 	}
 
-	conn.Write(buildResponse(statusMethodNotAllowed, nil))
+	log.Info("server shutdown started")
 
-	return nil
-}
-
-func parseHeader(line []byte, req *request) {
-	parts := strings.Split(string(line), ":")
-
-	switch strings.Trim(parts[0], "\n\r ") {
-	case "Host":
-		req.host = strings.Trim(parts[1], "\r\n ")
-	case "User-Agent":
-		req.userAgent = strings.Trim(parts[1], "\r\n ")
-	case "Content-Length":
-		conLen, err := strconv.Atoi(strings.Trim(parts[1], "\r\n "))
-		if err != nil {
-			// TODO: do something about it ¯\_(ツ)_/¯
-		}
-		req.contentLength = conLen
-	}
-}
-
-func buildResponse(respType int, content *content) []byte {
-	var resp bytes.Buffer
-
-	// Add return status
-	switch respType {
-	case statusOK:
-		resp.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusOK, textStatusOK))
-	case statusCreated:
-		resp.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusCreated, textStatusCreated))
-	case statusNotFound:
-		resp.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusNotFound, textStatusNotFound))
-	case statusMethodNotAllowed:
-		resp.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", statusMethodNotAllowed, textStatusMethodNotAllowed))
-	}
-
-	// Add headers
-	resp.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format("Mon, 02 Jan 2006 15:04:05 MST")))
-	resp.WriteString(fmt.Sprintf("Server: %s v%s\r\n", serverName, serverVersion))
-	if content != nil {
-		resp.WriteString(fmt.Sprintf("Content-Type: %s\r\n", content.contentType))
-		resp.WriteString(fmt.Sprintf("Content-Length: %d\r\n", len(content.body)))
-	}
-	resp.WriteString("\r\n")
-
-	if content != nil {
-		// Add content
-		resp.Write(content.body)
+	if err := srv.Shutdown(); err != nil {
+		log.Error("server shutdown cut short", "err", err)
+		os.Exit(1)
 	}
 
-	return resp.Bytes()
+	log.Info("server shutdown completed")
 }
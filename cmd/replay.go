@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/claudemuller/naive-server/server"
+)
+
+// replay serves the responses of a recording made with -record back until
+// interrupted.
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	host := fs.String("host", "0.0.0.0:4221", "the host and port to serve the recording on")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay [-host host:port] recording\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	r, err := server.NewReplayer(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("Failed to load recording: %v\n", err)
+		os.Exit(1)
+	}
+
+	l, err := net.Listen("tcp", *host)
+	if err != nil {
+		fmt.Printf("Failed to bind to %s: %v\n", *host, err)
+		os.Exit(1)
+	}
+
+	shutdownCh := make(chan os.Signal, 1)
+	signal.Notify(shutdownCh, syscall.SIGINT, syscall.SIGTERM)
+	errCh := make(chan error, 1)
+	go func() { errCh <- r.Serve(l) }()
+
+	fmt.Printf("replaying %s on %s\n", fs.Arg(0), l.Addr())
+	select {
+	case err := <-errCh:
+		fmt.Printf("%v\n", err)
+	case sig := <-shutdownCh:
+		fmt.Printf("received %d signal\n", sig)
+		l.Close()
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+)
+
+// sign prints a URL of a path that the signed middleware of a server with
+// the same -signed-url-secret lets through until it expires.
+func sign(args []string) {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	secret := fs.String("secret", "", "the -signed-url-secret of the server")
+	expires := fs.Duration("expires", 24*time.Hour, "how long the URL works for")
+	ip := fs.String("ip", "", "the only client address the URL works for; empty lets in any")
+	base := fs.String("base", "http://localhost:4221", "the scheme, host and port the URL is for")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s sign [-secret secret] [-expires duration] [-ip address] [-base url] /path\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *secret == "" || fs.NArg() != 1 || !strings.HasPrefix(fs.Arg(0), "/") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := (&url.URL{Path: fs.Arg(0)}).EscapedPath()
+
+	fmt.Println(strings.TrimSuffix(*base, "/") + server.SignURL(*secret, path, time.Now().Add(*expires), *ip))
+}
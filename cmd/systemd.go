@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/claudemuller/naive-server/server"
+)
+
+// systemdInstall prints the systemd units running the server with the flags
+// after its own, or with -install writes them out and enables them.
+func systemdInstall(args []string) {
+	var opts server.SystemdOptions
+	fs := flag.NewFlagSet("systemd-install", flag.ExitOnError)
+	fs.StringVar(&opts.Name, "name", "naive-server", "the name of the units")
+	fs.StringVar(&opts.User, "user", "", "the user to run the server as; empty for a dynamic one")
+	fs.BoolVar(&opts.Socket, "socket", false, "add a socket unit so that systemd binds -host and starts the server on demand")
+	unitDir := fs.String("unit-dir", "/etc/systemd/system", "the directory -install writes the units to")
+	install := fs.Bool("install", false, "write the units out, then enable and start them, instead of printing them")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s systemd-install [-name name] [-socket] [-install] [-- server flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	opts.Args = fs.Args()
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Printf("Failed to find the server binary: %v\n", err)
+		os.Exit(1)
+	}
+	opts.Exec = exe
+
+	service, socket, err := server.SystemdUnits(opts)
+	if err != nil {
+		fmt.Printf("Failed to generate units: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !*install {
+		fmt.Printf("# %s.service\n%s", opts.Name, service)
+		if socket != "" {
+			fmt.Printf("\n# %s.socket\n%s", opts.Name, socket)
+		}
+		return
+	}
+
+	units := map[string]string{opts.Name + ".service": service}
+	enable := opts.Name + ".service"
+	if socket != "" {
+		units[opts.Name+".socket"] = socket
+		enable = opts.Name + ".socket"
+	}
+	for name, unit := range units {
+		path := filepath.Join(*unitDir, name)
+		if err := os.WriteFile(path, []byte(unit), 0o644); err != nil {
+			fmt.Printf("Failed to write %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		fmt.Printf("wrote %s\n", path)
+	}
+	for _, args := range [][]string{{"daemon-reload"}, {"enable", "--now", enable}} {
+		cmd := exec.Command("systemctl", args...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("Failed to run systemctl %s: %v\n", args[0], err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("enabled and started %s\n", enable)
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessFileName is the file in a served directory that sets who may do
+// what in it and below. It is never served, listed or written over HTTP.
+const accessFileName = ".naive-access"
+
+// accessRecheck is how long what is known of an access file is trusted
+// before it is looked at again, so edits on disk take effect soon without
+// every request reading the files of every directory above it.
+const accessRecheck = time.Second
+
+// maxAccessEntries bounds the directories the access files of are kept,
+// since requests can name any number that don't exist.
+const maxAccessEntries = 10000
+
+// accessRules is what the access files above a file make of requests for
+// it. Each file sets what it names and leaves the rest as it was above.
+type accessRules struct {
+	// auth requires the file manager's credentials.
+	auth bool
+	// listing lets directories be listed, archived and searched.
+	listing bool
+	// methods are the methods allowed; nil allows all.
+	methods []string
+	// extensions, if any, are the endings of the names files may be
+	// uploaded under, and denyExtensions those they may not.
+	extensions     []string
+	denyExtensions []string
+	// maxFileSize is the largest file that may be uploaded in bytes; 0 is
+	// unlimited.
+	maxFileSize int64
+	// invalid is set if one of the files couldn't be parsed, so requests
+	// below it are refused rather than let in on the rules above.
+	invalid bool
+}
+
+// accessFile is what one access file sets, each field nil if it is left
+// as it was above.
+type accessFile struct {
+	auth           *bool
+	listing        *bool
+	methods        []string
+	extensions     []string
+	denyExtensions []string
+	maxFileSize    *int64
+}
+
+// parseAccessFile parses an access file, of lines holding a directive and
+// its value: 'auth on|off', 'listing on|off', 'methods GET PUT ...',
+// 'extensions .jpg .png ...' and 'deny-extensions .exe ...' for the names
+// uploads may and may not have, none lifting the list set above, and
+// 'max-file-size 10MiB' for the largest upload, 0 for no limit. Blank lines
+// and lines starting with # are skipped.
+func parseAccessFile(data []byte) (*accessFile, error) {
+	onOff := func(fields []string) (*bool, error) {
+		if len(fields) != 2 || fields[1] != "on" && fields[1] != "off" {
+			return nil, fmt.Errorf("expected '%s on|off'", fields[0])
+		}
+		on := fields[1] == "on"
+		return &on, nil
+	}
+
+	var f accessFile
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		var err error
+		switch fields[0] {
+		case "auth":
+			f.auth, err = onOff(fields)
+		case "listing":
+			f.listing, err = onOff(fields)
+		case "methods":
+			f.methods = []string{}
+			for _, m := range fields[1:] {
+				m = strings.ToUpper(m)
+				switch m {
+				case methodGet, methodPost, methodPut, methodDelete, methodMove:
+					f.methods = append(f.methods, m)
+				default:
+					err = fmt.Errorf("unknown method %q", m)
+				}
+			}
+		case "extensions":
+			f.extensions, err = parseExtensions(fields[1:])
+		case "deny-extensions":
+			f.denyExtensions, err = parseExtensions(fields[1:])
+		case "max-file-size":
+			var size byteRate
+			if len(fields) != 2 || size.Set(fields[1]) != nil || size < 0 {
+				err = fmt.Errorf("expected 'max-file-size <bytes>'")
+				break
+			}
+			n := int64(size)
+			f.maxFileSize = &n
+		default:
+			err = fmt.Errorf("unknown directive %q, want auth, listing, methods, extensions, deny-extensions or max-file-size", fields[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+
+	return &f, sc.Err()
+}
+
+// parseExtensions parses the extensions of an extensions directive, which
+// start with a dot and are matched whatever their case.
+func parseExtensions(fields []string) ([]string, error) {
+	exts := []string{}
+	for _, ext := range fields {
+		if len(ext) < 2 || ext[0] != '.' || strings.Contains(ext, "/") {
+			return nil, fmt.Errorf("invalid extension %q, want one like .jpg", ext)
+		}
+		exts = append(exts, strings.ToLower(ext))
+	}
+
+	return exts, nil
+}
+
+// accessEntry is what is known of the access file of a directory.
+type accessEntry struct {
+	checked time.Time
+	modTime time.Time
+	size    int64
+	// file is nil if there is no access file, or it couldn't be parsed.
+	file    *accessFile
+	invalid bool
+}
+
+// accessCache holds the access files of the directories requests were
+// made below, by directory.
+type accessCache struct {
+	mu      sync.Mutex
+	entries map[string]*accessEntry
+	log     *slog.Logger
+}
+
+func newAccessCache(log *slog.Logger) *accessCache {
+	return &accessCache{entries: make(map[string]*accessEntry), log: log}
+}
+
+// lookup returns what is known of the access file of dir, reading it again
+// if it changed since it was last looked at.
+func (c *accessCache) lookup(store storage, dir string) *accessEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	e := c.entries[dir]
+	if e != nil && now.Sub(e.checked) < accessRecheck {
+		return e
+	}
+
+	name := joinName(dir, accessFileName)
+	info, err := store.Stat(name)
+	switch {
+	case err != nil || info.IsDir():
+		// Most directories have none, and files have none below them.
+		e = &accessEntry{}
+	case e != nil && (e.file != nil || e.invalid) && info.ModTime().Equal(e.modTime) && info.Size() == e.size:
+	default:
+		e = &accessEntry{modTime: info.ModTime(), size: info.Size()}
+		f, err := store.Open(name)
+		if err == nil {
+			var buf bytes.Buffer
+			_, err = buf.ReadFrom(f)
+			f.Close()
+			if err == nil {
+				e.file, err = parseAccessFile(buf.Bytes())
+			}
+		}
+		if err != nil {
+			c.log.Error("error reading access file", "file", name, "err", err)
+			e.file, e.invalid = nil, true
+		}
+	}
+	e.checked = now
+	if len(c.entries) >= maxAccessEntries {
+		clear(c.entries)
+	}
+	c.entries[dir] = e
+
+	return e
+}
+
+// accessRules returns the rules the access files in the directories from
+// the root down to name, and in name itself if it is one, make.
+func (s *Server) accessRules(name string) accessRules {
+	rules := accessRules{listing: true}
+	dirs := []string{""}
+	if name = cleanName(name); name != "" {
+		elems := strings.Split(name, "/")
+		for i := range elems {
+			dirs = append(dirs, strings.Join(elems[:i+1], "/"))
+		}
+	}
+	for _, dir := range dirs {
+		e := s.access.lookup(s.store, dir)
+		if e.invalid {
+			rules.invalid = true
+		}
+		if e.file == nil {
+			continue
+		}
+		if e.file.auth != nil {
+			rules.auth = *e.file.auth
+		}
+		if e.file.listing != nil {
+			rules.listing = *e.file.listing
+		}
+		if e.file.methods != nil {
+			rules.methods = e.file.methods
+		}
+		if e.file.extensions != nil {
+			rules.extensions = e.file.extensions
+		}
+		if e.file.denyExtensions != nil {
+			rules.denyExtensions = e.file.denyExtensions
+		}
+		if e.file.maxFileSize != nil {
+			rules.maxFileSize = *e.file.maxFileSize
+		}
+	}
+
+	return rules
+}
+
+// allows reports whether the rules let in a request with method made with
+// or without the file manager's credentials.
+func (r accessRules) allows(method string, authorized bool) bool {
+	return !r.invalid && (!r.auth || authorized) && (r.methods == nil || slices.Contains(r.methods, method))
+}
+
+// checkAccess answers a /files request the access files over it don't let
+// through, and reports whether it may go on. A move must be let through
+// where the file goes too.
+func (s *Server) checkAccess(conn net.Conn, req request) bool {
+	name := req.fileName()
+	rules := s.accessRules(name)
+	var to accessRules
+	dest, moving := "", false
+	if req.method == methodMove {
+		if dest, moving = destinationName(req.headers.get("Destination")); moving {
+			to = s.accessRules(path.Dir(dest))
+			rules.auth = rules.auth || to.auth
+			rules.invalid = rules.invalid || to.invalid
+		}
+	}
+
+	switch {
+	case rules.invalid:
+		conn.Write(buildResponse(statusInternalServerError, nil))
+	case rules.auth && !s.authorized(req):
+		conn.Write(unauthorized())
+	case !rules.allows(req.method, true) || req.method == methodMove && !to.allows(methodMove, true):
+		h := header{}
+		h.set("Allow", strings.Join(rules.methods, ", "))
+		conn.Write(buildResponseHeaders(statusMethodNotAllowed, h, nil))
+	case moving && !to.takesName(dest):
+		refuseUpload(conn, statusUnsupportedMediaType, fmt.Sprintf("files named %s can't be moved there", path.Base(dest)))
+	case req.method == methodGet && !rules.listing && (req.query.Get("archive") != "" || s.isDir(name)):
+		conn.Write(buildResponse(statusForbidden, nil))
+	default:
+		return true
+	}
+
+	return false
+}
+
+// isDir reports whether name is a directory in the store.
+func (s *Server) isDir(name string) bool {
+	info, err := s.store.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+// listableStorage leaves out of its listings the directories whose access
+// files don't let the request it was made for list them, so archives and
+// searches only take in what the client could list by itself.
+type listableStorage struct {
+	storage
+	s          *Server
+	authorized bool
+}
+
+// listable returns the store as req may walk it.
+func (s *Server) listable(req request) storage {
+	return listableStorage{storage: s.store, s: s, authorized: s.authorized(req)}
+}
+
+func (l listableStorage) List(dir string) ([]fs.FileInfo, error) {
+	if rules := l.s.accessRules(dir); !rules.allows(methodGet, l.authorized) || !rules.listing {
+		return nil, nil
+	}
+	infos, err := l.storage.List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(infos, func(info fs.FileInfo) bool {
+		if !info.IsDir() {
+			return false
+		}
+		rules := l.s.accessRules(joinName(dir, info.Name()))
+		return !rules.allows(methodGet, l.authorized) || !rules.listing
+	}), nil
+}
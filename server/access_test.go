@@ -0,0 +1,146 @@
+package server_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestAccessFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("pub/a.txt", "a")
+	write("private/s.txt", "secret")
+	write("private/.naive-access", "# staff only\nauth on\n")
+	write("drop/.naive-access", "methods PUT post\nlisting off\n")
+	write("drop/sub/.naive-access", "methods GET\n")
+	write("drop/sub/y.txt", "y")
+	write("bad/.naive-access", "auth maybe\n")
+	write("bad/b.txt", "b")
+
+	opts := servertest.Options()
+	opts.Storage = "disk"
+	opts.Directory = dir
+	opts.UIAuth = "me:secret"
+	c := servertest.NewPipe(t, opts).Client()
+	auth := basicAuth("me", "secret")
+
+	c.Get("/files/pub/a.txt").AssertStatus(http.StatusOK)
+	c.Get("/files/private/s.txt").AssertStatus(http.StatusUnauthorized)
+	c.Get("/files/private/").AssertStatus(http.StatusUnauthorized)
+	c.Do(http.MethodGet, "/files/private/s.txt", nil, auth).AssertStatus(http.StatusOK).AssertBody("secret")
+	resp := c.Do(http.MethodGet, "/files/private/", nil, auth).AssertStatus(http.StatusOK)
+	if bytes.Contains(resp.Body, []byte(".naive-access")) {
+		t.Error("the listing shows the access file")
+	}
+
+	// The files themselves can't be read or written over HTTP.
+	c.Do(http.MethodGet, "/files/private/.naive-access", nil, auth).AssertStatus(http.StatusNotFound)
+	c.Do(http.MethodPut, "/files/private/.naive-access", strings.NewReader("auth off"), auth).AssertStatus(http.StatusNotFound)
+	c.Do(http.MethodPut, "/files/pub/.naive-access", strings.NewReader("auth on"), nil).AssertStatus(http.StatusNotFound)
+
+	// Methods and listing are set for the subtree, nearer files winning.
+	c.Do(http.MethodPut, "/files/drop/x.txt", strings.NewReader("x"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/drop/x.txt").AssertStatus(http.StatusMethodNotAllowed).AssertHeader("Allow", "PUT, POST")
+	c.Get("/files/drop/sub/y.txt").AssertStatus(http.StatusOK).AssertBody("y")
+	c.Get("/files/drop/sub/").AssertStatus(http.StatusForbidden)
+	c.Get("/files/drop/sub/?archive=zip").AssertStatus(http.StatusForbidden)
+	c.Do(http.MethodPut, "/files/drop/sub/z.txt", strings.NewReader("z"), nil).AssertStatus(http.StatusMethodNotAllowed)
+	c.Do("MOVE", "/files/pub/a.txt", nil, http.Header{"Authorization": auth["Authorization"], "Destination": {"/files/drop/sub/a.txt"}}).
+		AssertStatus(http.StatusMethodNotAllowed)
+
+	// A file that can't be parsed shuts its subtree.
+	c.Do(http.MethodGet, "/files/bad/b.txt", nil, auth).AssertStatus(http.StatusInternalServerError)
+
+	// Searches and archives leave out what couldn't be listed.
+	resp = c.Get("/search?q=.txt").AssertBodyContains("pub/a.txt")
+	for _, hidden := range []string{"s.txt", "y.txt", "b.txt"} {
+		if bytes.Contains(resp.Body, []byte(hidden)) {
+			t.Errorf("the search found %s", hidden)
+		}
+	}
+	c.Do(http.MethodGet, "/search?q=s.txt", nil, auth).AssertBodyContains("private/s.txt")
+	resp = c.Get("/files/?archive=zip").AssertStatus(http.StatusOK)
+	zr, err := zip.NewReader(bytes.NewReader(resp.Body), int64(len(resp.Body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, "private") || strings.HasPrefix(f.Name, "drop/") || strings.Contains(f.Name, ".naive-access") {
+			t.Errorf("the archive holds %s", f.Name)
+		}
+	}
+
+	// Edits on disk take effect soon.
+	write("private/.naive-access", "auth off\n")
+	time.Sleep(1100 * time.Millisecond)
+	c.Get("/files/private/s.txt").AssertStatus(http.StatusOK)
+}
+
+func TestAccessFileUploadLimits(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "images", "raw"), 0o755)
+	os.WriteFile(filepath.Join(dir, ".naive-access"), []byte("deny-extensions .exe .SH\nmax-file-size 1KiB\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "images", ".naive-access"), []byte("extensions .png .tar.gz\nmax-file-size 8\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "images", "raw", ".naive-access"), []byte("extensions\nmax-file-size 0\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0o644)
+
+	opts := servertest.Options()
+	opts.Storage = "disk"
+	opts.Directory = dir
+	c := servertest.NewPipe(t, opts).Client()
+	put := func(name, body string) *servertest.Response {
+		return c.Do(http.MethodPut, "/files/"+name, strings.NewReader(body), nil)
+	}
+
+	put("a.txt", "a").AssertStatus(http.StatusCreated)
+	put("run.exe", "x").AssertStatus(http.StatusUnsupportedMediaType).AssertBody("files named run.exe can't be uploaded here\n")
+	put("run.sh", "x").AssertStatus(http.StatusUnsupportedMediaType)
+	put("big.txt", strings.Repeat("x", 1025)).AssertStatus(http.StatusRequestEntityTooLarge).
+		AssertBody("files larger than 1024 bytes can't be uploaded here\n")
+
+	// Nearer files win, and the deny list above still holds.
+	put("images/a.PNG", "png").AssertStatus(http.StatusCreated)
+	put("images/a.tar.gz", "tgz").AssertStatus(http.StatusCreated)
+	put("images/a.gz", "gz").AssertStatus(http.StatusUnsupportedMediaType)
+	put("images/b.png", "123456789").AssertStatus(http.StatusRequestEntityTooLarge)
+	put("images/raw/a.txt", strings.Repeat("x", 2000)).AssertStatus(http.StatusCreated)
+	put("images/raw/run.exe", "x").AssertStatus(http.StatusUnsupportedMediaType)
+
+	// A body of no stated length is cut off once it grows too large.
+	c.Raw("PUT /files/images/c.png HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n" +
+		"5\r\n12345\r\n5\r\n67890\r\n0\r\n\r\n").AssertStatus(http.StatusRequestEntityTooLarge)
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, _ := w.CreateFormFile("file", "d.png")
+	fw.Write([]byte("123456789"))
+	w.Close()
+	c.Do(http.MethodPost, "/files/images/", &body, http.Header{"Content-Type": {w.FormDataContentType()}}).
+		AssertStatus(http.StatusRequestEntityTooLarge)
+	for _, name := range []string{"run.exe", "big.txt", "images/b.png", "images/c.png", "images/d.png"} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+			t.Errorf("%s was stored", name)
+		}
+	}
+
+	// Files can't be moved in under a name they couldn't be uploaded with.
+	c.Do("MOVE", "/files/notes.txt", nil, http.Header{"Destination": {"/files/images/notes.txt"}}).
+		AssertStatus(http.StatusUnsupportedMediaType)
+	c.Do("MOVE", "/files/notes.txt", nil, http.Header{"Destination": {"/files/notes.exe"}}).
+		AssertStatus(http.StatusUnsupportedMediaType)
+}
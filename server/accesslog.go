@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLogFormat is the Common Log Format.
+const defaultLogFormat = `%remote - - [%time] "%method %target %proto" %status %bytes`
+
+// logEntry is what is known about an exchange once it is over.
+type logEntry struct {
+	req      request
+	status   int
+	bytes    int64
+	start    time.Time
+	duration time.Duration
+}
+
+// logField writes one part of an access log line.
+type logField func(b *bytes.Buffer, e *logEntry)
+
+// logVariables are the %name variables of access log formats.
+var logVariables = map[string]logField{
+	"remote": func(b *bytes.Buffer, e *logEntry) { b.WriteString(e.req.clientIP) },
+	"method": func(b *bytes.Buffer, e *logEntry) { b.WriteString(e.req.method) },
+	"path":   func(b *bytes.Buffer, e *logEntry) { b.WriteString(e.req.path) },
+	"target": func(b *bytes.Buffer, e *logEntry) { b.WriteString(e.req.target()) },
+	"query":  func(b *bytes.Buffer, e *logEntry) { b.WriteString(e.req.rawQuery) },
+	"proto":  func(b *bytes.Buffer, e *logEntry) { b.WriteString(e.req.httpVersion) },
+	"host":   func(b *bytes.Buffer, e *logEntry) { logValue(b, e.req.host) },
+	"id":     func(b *bytes.Buffer, e *logEntry) { b.WriteString(e.req.id) },
+	"status": func(b *bytes.Buffer, e *logEntry) { b.WriteString(strconv.Itoa(e.status)) },
+	"bytes":  func(b *bytes.Buffer, e *logEntry) { b.WriteString(strconv.FormatInt(e.bytes, 10)) },
+	"duration": func(b *bytes.Buffer, e *logEntry) {
+		b.WriteString(strconv.FormatFloat(e.duration.Seconds()*1000, 'f', 3, 64))
+	},
+	"time":    func(b *bytes.Buffer, e *logEntry) { b.WriteString(e.start.Format("02/Jan/2006:15:04:05 -0700")) },
+	"country": func(b *bytes.Buffer, e *logEntry) { logValue(b, e.req.geo.Country) },
+	"asn": func(b *bytes.Buffer, e *logEntry) {
+		if e.req.geo.ASN == 0 {
+			b.WriteString("-")
+			return
+		}
+		b.WriteString("AS" + strconv.FormatUint(uint64(e.req.geo.ASN), 10))
+	},
+}
+
+// logValue writes v, or - if it is empty, the way access logs mark missing
+// values.
+func logValue(b *bytes.Buffer, v string) {
+	if v == "" {
+		v = "-"
+	}
+	b.WriteString(v)
+}
+
+// parseLogFormat compiles an access log format. Variables are written as
+// %name, or %{Name}i for the request header Name; %% is a literal %.
+func parseLogFormat(format string) ([]logField, error) {
+	var fields []logField
+	literal := func(s string) {
+		if s != "" {
+			fields = append(fields, func(b *bytes.Buffer, _ *logEntry) { b.WriteString(s) })
+		}
+	}
+
+	for {
+		i := strings.IndexByte(format, '%')
+		if i < 0 {
+			literal(format)
+			return fields, nil
+		}
+		literal(format[:i])
+		format = format[i+1:]
+
+		switch {
+		case strings.HasPrefix(format, "%"):
+			literal("%")
+			format = format[1:]
+		case strings.HasPrefix(format, "{"):
+			name, rest, ok := strings.Cut(format[1:], "}")
+			if !ok || name == "" || !strings.HasPrefix(rest, "i") {
+				return nil, fmt.Errorf("invalid header variable at %q", "%"+format)
+			}
+			fields = append(fields, func(b *bytes.Buffer, e *logEntry) { logValue(b, e.req.headers.get(name)) })
+			format = rest[1:]
+		default:
+			end := 0
+			for end < len(format) && format[end] >= 'a' && format[end] <= 'z' {
+				end++
+			}
+			field, ok := logVariables[format[:end]]
+			if !ok {
+				return nil, fmt.Errorf("unknown variable %q", "%"+format[:end])
+			}
+			fields = append(fields, field)
+			format = format[end:]
+		}
+	}
+}
+
+// accessLog writes a line per exchange in a configurable format.
+type accessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	f      *os.File
+	fields []logField
+
+	// Only one in sample successful exchanges quicker than slow is logged;
+	// errors and slow exchanges always are.
+	sample   int64
+	slow     time.Duration
+	eligible atomic.Int64
+	logged   atomic.Int64
+	skipped  atomic.Int64
+}
+
+// accessLogStats are the access log counters published by the admin API.
+type accessLogStats struct {
+	SampleRate int64 `json:"sample_rate"`
+	Logged     int64 `json:"logged"`
+	Skipped    int64 `json:"skipped"`
+}
+
+// openAccessLog opens the log at path, - meaning standard output, to append
+// lines in format to, logging one in sample successful exchanges quicker
+// than slow.
+func openAccessLog(path, format string, sample int, slow time.Duration) (*accessLog, error) {
+	fields, err := parseLogFormat(format)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing access log format: %v", err)
+	}
+
+	l := &accessLog{w: os.Stdout, fields: fields, sample: int64(max(sample, 1)), slow: slow}
+	if path != "-" {
+		l.f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		l.w = l.f
+	}
+
+	return l, nil
+}
+
+// log writes the line for the exchange of req over ex, begun at start.
+func (l *accessLog) log(req request, ex *exchangeConn, start time.Time) {
+	e := logEntry{
+		req:      req,
+		status:   ex.status,
+		bytes:    ex.written,
+		start:    start,
+		duration: time.Since(start),
+	}
+	if !l.sampled(&e) {
+		l.skipped.Add(1)
+		return
+	}
+	l.logged.Add(1)
+
+	var b bytes.Buffer
+	for _, field := range l.fields {
+		field(&b, &e)
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.w.Write(b.Bytes())
+}
+
+// sampled reports whether the exchange e makes it into the log. Errors and
+// slow exchanges always do, so sampling only thins out the unremarkable.
+func (l *accessLog) sampled(e *logEntry) bool {
+	if l.sample == 1 || e.status >= statusBadRequest || l.slow > 0 && e.duration >= l.slow {
+		return true
+	}
+
+	return l.eligible.Add(1)%l.sample == 0
+}
+
+func (l *accessLog) stats() accessLogStats {
+	return accessLogStats{
+		SampleRate: l.sample,
+		Logged:     l.logged.Load(),
+		Skipped:    l.skipped.Load(),
+	}
+}
+
+func (l *accessLog) close() {
+	if l.f != nil {
+		l.f.Close()
+	}
+}
@@ -0,0 +1,84 @@
+package server_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	opts := servertest.Options()
+	opts.AccessLog = path
+	opts.AccessLogFormat = `%method %target %status %bytes %{User-Agent}i %{X-Missing}i %duration 100%%`
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	c.Do(http.MethodGet, "/echo/hi?x=1", nil, http.Header{"User-Agent": {"tester"}}).AssertStatus(http.StatusOK)
+	c.Get("/nowhere").AssertStatus(http.StatusNotFound)
+	s.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []*regexp.Regexp{
+		regexp.MustCompile(`^GET /echo/hi\?x=1 200 2 tester - \d+\.\d{3} 100%$`),
+		regexp.MustCompile(`^GET /nowhere 404 [1-9]\d* Go-http-client/1\.1 - \d+\.\d{3} 100%$`),
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got log lines %q", lines)
+	}
+	for i, re := range want {
+		if !re.MatchString(lines[i]) {
+			t.Errorf("log line %q doesn't match %s", lines[i], re)
+		}
+	}
+}
+
+func TestAccessLogFormatInvalid(t *testing.T) {
+	for _, format := range []string{"%nope", "%{User-Agent}x", "%{"} {
+		opts := servertest.Options()
+		opts.AccessLog = filepath.Join(t.TempDir(), "access.log")
+		opts.AccessLogFormat = format
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error for format %q", format)
+		}
+	}
+}
+
+func TestAccessLogSample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	opts := servertest.Options()
+	opts.AccessLog = path
+	opts.AccessLogFormat = `%target %status`
+	opts.AccessLogSample = 3
+	opts.AccessLogSlow = 100 * time.Millisecond
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	for i := 1; i <= 6; i++ {
+		c.Get("/echo/" + strconv.Itoa(i)).AssertStatus(http.StatusOK)
+	}
+	c.Get("/nowhere").AssertStatus(http.StatusNotFound)
+	c.Get("/delay/150ms").AssertStatus(http.StatusOK)
+	s.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "/echo/3 200\n/echo/6 200\n/nowhere 404\n/delay/150ms 200\n"
+	if string(data) != want {
+		t.Errorf("got log %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,571 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// acmeRenewBefore is how long before it expires a certificate is
+	// renewed.
+	acmeRenewBefore = 30 * 24 * time.Hour
+	// acmeRetry is how long to wait after failing to get a certificate.
+	acmeRetry = time.Hour
+	// acmePollTimeout bounds the wait for the CA to validate challenges
+	// and issue the certificate.
+	acmePollTimeout = 5 * time.Minute
+	// acmePollInterval is how often the CA is asked whether it is done.
+	acmePollInterval = time.Second
+)
+
+// DNSProvider publishes the TXT records that answer ACME DNS-01
+// challenges. Present adds value to the records of fqdn, a name like
+// _acme-challenge.example.com., and CleanUp removes it again once the CA
+// has looked.
+type DNSProvider interface {
+	Present(fqdn, value string) error
+	CleanUp(fqdn, value string) error
+}
+
+var (
+	dnsProvidersMu sync.Mutex
+	// dnsProviders make the providers -acme-dns names, from the config
+	// following the name.
+	dnsProviders = map[string]func(config string) (DNSProvider, error){
+		"exec": newExecDNSProvider,
+	}
+)
+
+// RegisterDNSProvider makes a DNS provider available to -acme-dns as
+// name:config, config being whatever newProvider needs, like the file
+// holding the credentials of a DNS host's API.
+func RegisterDNSProvider(name string, newProvider func(config string) (DNSProvider, error)) {
+	dnsProvidersMu.Lock()
+	defer dnsProvidersMu.Unlock()
+
+	dnsProviders[name] = newProvider
+}
+
+// newDNSProvider makes the provider of a -acme-dns name:config.
+func newDNSProvider(spec string) (DNSProvider, error) {
+	name, config, _ := strings.Cut(spec, ":")
+
+	dnsProvidersMu.Lock()
+	newProvider := dnsProviders[name]
+	dnsProvidersMu.Unlock()
+	if newProvider == nil {
+		return nil, fmt.Errorf("unknown DNS provider %q", name)
+	}
+
+	return newProvider(config)
+}
+
+// execDNSProvider runs a command to change the records, as
+//
+//	command present|cleanup fqdn value
+//
+// so any DNS host can be scripted for.
+type execDNSProvider struct {
+	command string
+}
+
+func newExecDNSProvider(command string) (DNSProvider, error) {
+	if command == "" {
+		return nil, fmt.Errorf("the exec DNS provider needs a command, as exec:/path/to/command")
+	}
+
+	return execDNSProvider{command: command}, nil
+}
+
+func (p execDNSProvider) run(action, fqdn, value string) error {
+	out, err := exec.Command(p.command, action, fqdn, value).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", p.command, action, err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+func (p execDNSProvider) Present(fqdn, value string) error {
+	return p.run("present", fqdn, value)
+}
+
+func (p execDNSProvider) CleanUp(fqdn, value string) error {
+	return p.run("cleanup", fqdn, value)
+}
+
+// acmeManager keeps the server's certificate for -acme-domains, getting
+// it from an ACME CA with DNS-01 challenges, which unlike HTTP-01 ones
+// can prove control of wildcard names, and renewing it before it expires.
+// The account key, certificate and key are kept in -acme-dir.
+type acmeManager struct {
+	opts     Options
+	provider DNSProvider
+	cert     *certificate
+	client   *http.Client
+	log      *slog.Logger
+}
+
+func newACMEManager(opts Options, log *slog.Logger) (*acmeManager, error) {
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		return nil, fmt.Errorf("-acme-domains can't be used with -tls-cert and -tls-key")
+	}
+	if opts.ACMEDir == "" || opts.ACMEDNS == "" {
+		return nil, fmt.Errorf("-acme-domains requires -acme-dir and -acme-dns")
+	}
+	provider, err := newDNSProvider(opts.ACMEDNS)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(opts.ACMEDir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating ACME directory: %v", err)
+	}
+
+	m := &acmeManager{
+		opts:     opts,
+		provider: provider,
+		cert: &certificate{
+			certFile: filepath.Join(opts.ACMEDir, "cert.pem"),
+			keyFile:  filepath.Join(opts.ACMEDir, "key.pem"),
+		},
+		client: &http.Client{Timeout: 30 * time.Second},
+		log:    log,
+	}
+	// A certificate from an earlier run is served until it is renewed.
+	m.cert.load()
+
+	return m, nil
+}
+
+// renewAt returns when the current certificate is due for renewal, which
+// is now if there is none or it doesn't cover every name.
+func (m *acmeManager) renewAt() time.Time {
+	cert := m.cert.current.Load()
+	if cert == nil {
+		return time.Time{}
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return time.Time{}
+	}
+	for _, name := range m.opts.ACMEDomains {
+		if !containsFold(leaf.DNSNames, name) {
+			return time.Time{}
+		}
+	}
+
+	return leaf.NotAfter.Add(-acmeRenewBefore)
+}
+
+// run gets and renews the certificate until closed is closed.
+func (m *acmeManager) run(closed <-chan struct{}) {
+	for {
+		wait := time.Until(m.renewAt())
+		if wait <= 0 {
+			m.log.Info("requesting certificate", "domains", strings.Join(m.opts.ACMEDomains, ","))
+			if err := m.obtain(); err != nil {
+				m.log.Error("error getting certificate", "err", err)
+				wait = acmeRetry
+			} else {
+				m.log.Info("certificate installed", "domains", strings.Join(m.opts.ACMEDomains, ","))
+				continue
+			}
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-closed:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// obtain orders a certificate for the domains, answers the challenges of
+// the order and installs the certificate it ends with.
+func (m *acmeManager) obtain() error {
+	accountKey, err := loadOrCreateKey(filepath.Join(m.opts.ACMEDir, "account.key"))
+	if err != nil {
+		return err
+	}
+	c := &acmeClient{http: m.client, key: accountKey}
+	if err := c.register(m.opts.ACMEDirectoryURL, m.opts.ACMEEmail); err != nil {
+		return err
+	}
+
+	order, orderURL, err := c.newOrder(m.opts.ACMEDomains)
+	if err != nil {
+		return err
+	}
+
+	var presented [][2]string
+	defer func() {
+		for _, r := range presented {
+			if err := m.provider.CleanUp(r[0], r[1]); err != nil {
+				m.log.Error("error cleaning up DNS challenge", "fqdn", r[0], "err", err)
+			}
+		}
+	}()
+	var pending []acmeChallenge
+	for _, authzURL := range order.Authorizations {
+		var authz acmeAuthorization
+		if err := c.postAsGet(authzURL, &authz); err != nil {
+			return err
+		}
+		if authz.Status == "valid" {
+			continue
+		}
+		ch, ok := authz.dns01()
+		if !ok {
+			return fmt.Errorf("no dns-01 challenge for %s", authz.Identifier.Value)
+		}
+		fqdn := "_acme-challenge." + authz.Identifier.Value + "."
+		value := c.dns01Value(ch.Token)
+		if err := m.provider.Present(fqdn, value); err != nil {
+			return fmt.Errorf("error publishing DNS challenge: %v", err)
+		}
+		presented = append(presented, [2]string{fqdn, value})
+		ch.authz = authzURL
+		pending = append(pending, ch)
+	}
+
+	// The records have to reach the DNS servers the CA asks.
+	time.Sleep(m.opts.ACMEDNSWait)
+
+	for _, ch := range pending {
+		if _, err := c.post(ch.URL, struct{}{}, nil); err != nil {
+			return err
+		}
+	}
+	for _, ch := range pending {
+		var authz acmeAuthorization
+		if err := c.poll(ch.authz, &authz, func() (bool, error) {
+			switch authz.Status {
+			case "valid":
+				return true, nil
+			case "pending", "processing":
+				return false, nil
+			}
+			return false, fmt.Errorf("authorization of %s is %s", authz.Identifier.Value, authz.Status)
+		}); err != nil {
+			return err
+		}
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.opts.ACMEDomains[0]},
+		DNSNames: m.opts.ACMEDomains,
+	}, certKey)
+	if err != nil {
+		return err
+	}
+	if _, err := c.post(order.Finalize, map[string]string{"csr": b64(csr)}, &order); err != nil {
+		return err
+	}
+	if err := c.poll(orderURL, &order, func() (bool, error) {
+		switch order.Status {
+		case "valid":
+			return true, nil
+		case "pending", "ready", "processing":
+			return false, nil
+		}
+		return false, fmt.Errorf("order is %s", order.Status)
+	}); err != nil {
+		return err
+	}
+
+	chain, err := c.post(order.Certificate, nil, nil)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(m.cert.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(m.cert.certFile, chain); err != nil {
+		return err
+	}
+
+	return m.cert.load()
+}
+
+// loadOrCreateKey reads the P-256 key at path, making it if there is none.
+func loadOrCreateKey(path string) (*ecdsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM key in %s", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+// writeFileAtomic replaces the file at path with one holding data, readable
+// by the owner only.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// acmeClient speaks the ACME protocol of RFC 8555 with an account key,
+// signing requests as JWS with ES256.
+type acmeClient struct {
+	http  *http.Client
+	key   *ecdsa.PrivateKey
+	kid   string
+	nonce string
+
+	directory struct {
+		NewNonce   string `json:"newNonce"`
+		NewAccount string `json:"newAccount"`
+		NewOrder   string `json:"newOrder"`
+	}
+}
+
+type acmeOrder struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type acmeChallenge struct {
+	Type  string `json:"type"`
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	// authz is the URL of the authorization the challenge is of.
+	authz string
+}
+
+type acmeAuthorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"`
+}
+
+func (a acmeAuthorization) dns01() (acmeChallenge, bool) {
+	for _, ch := range a.Challenges {
+		if ch.Type == "dns-01" {
+			return ch, true
+		}
+	}
+
+	return acmeChallenge{}, false
+}
+
+// acmeProblem is the error document of RFC 7807 ACME servers answer with.
+type acmeProblem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// jwk returns the account key as a JSON Web Key, its members in the
+// lexical order its thumbprint is taken in.
+func (c *acmeClient) jwk() string {
+	pub := c.key.PublicKey
+	return fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`,
+		b64(pub.X.FillBytes(make([]byte, 32))), b64(pub.Y.FillBytes(make([]byte, 32))))
+}
+
+// dns01Value is the TXT record value that answers the challenge token.
+func (c *acmeClient) dns01Value(token string) string {
+	thumb := sha256.Sum256([]byte(c.jwk()))
+	auth := sha256.Sum256([]byte(token + "." + b64(thumb[:])))
+
+	return b64(auth[:])
+}
+
+// register fetches the directory and finds or creates the account of the
+// key.
+func (c *acmeClient) register(directoryURL, email string) error {
+	resp, err := c.http.Get(directoryURL)
+	if err != nil {
+		return fmt.Errorf("error fetching ACME directory: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&c.directory); err != nil {
+		return fmt.Errorf("error reading ACME directory: %v", err)
+	}
+
+	account := map[string]any{"termsOfServiceAgreed": true}
+	if email != "" {
+		account["contact"] = []string{"mailto:" + email}
+	}
+	h, err := c.post(c.directory.NewAccount, account, nil)
+	if err != nil {
+		return err
+	}
+	c.kid = string(h)
+
+	return nil
+}
+
+func (c *acmeClient) newOrder(domains []string) (acmeOrder, string, error) {
+	var ids []map[string]string
+	for _, d := range domains {
+		ids = append(ids, map[string]string{"type": "dns", "value": d})
+	}
+
+	var order acmeOrder
+	location, err := c.post(c.directory.NewOrder, map[string]any{"identifiers": ids}, &order)
+
+	return order, string(location), err
+}
+
+func (c *acmeClient) postAsGet(url string, v any) error {
+	_, err := c.post(url, nil, v)
+	return err
+}
+
+// poll fetches url into v until done says it is done, or fails.
+func (c *acmeClient) poll(url string, v any, done func() (bool, error)) error {
+	deadline := time.Now().Add(acmePollTimeout)
+	for {
+		if err := c.postAsGet(url, v); err != nil {
+			return err
+		}
+		ok, err := done()
+		if ok || err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", url)
+		}
+		time.Sleep(acmePollInterval)
+	}
+}
+
+// post sends payload, or nothing for a POST-as-GET if it is nil, to url
+// signed with the account key, decoding the JSON answer into v if it isn't
+// nil. It returns the Location of the answer, or the body itself if v is
+// nil and there is no Location, which is how certificates come.
+func (c *acmeClient) post(url string, payload, v any) ([]byte, error) {
+	for retried := false; ; retried = true {
+		body, err := c.sign(url, payload)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.http.Post(url, "application/jose+json", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		c.nonce = resp.Header.Get("Replay-Nonce")
+
+		if resp.StatusCode >= 400 {
+			var p acmeProblem
+			json.Unmarshal(data, &p)
+			if p.Type == "urn:ietf:params:acme:error:badNonce" && !retried {
+				continue
+			}
+			return nil, fmt.Errorf("ACME %s: %d %s: %s", url, resp.StatusCode, p.Type, p.Detail)
+		}
+		if v != nil {
+			if err := json.Unmarshal(data, v); err != nil {
+				return nil, fmt.Errorf("error reading ACME %s: %v", url, err)
+			}
+		}
+		if location := resp.Header.Get("Location"); location != "" || v != nil {
+			return []byte(location), nil
+		}
+
+		return data, nil
+	}
+}
+
+// sign wraps payload in a flattened JWS for url.
+func (c *acmeClient) sign(url string, payload any) ([]byte, error) {
+	if c.nonce == "" {
+		resp, err := c.http.Head(c.directory.NewNonce)
+		if err != nil {
+			return nil, fmt.Errorf("error getting ACME nonce: %v", err)
+		}
+		resp.Body.Close()
+		c.nonce = resp.Header.Get("Replay-Nonce")
+	}
+
+	key := `"jwk":` + c.jwk()
+	if c.kid != "" {
+		key = fmt.Sprintf(`"kid":%q`, c.kid)
+	}
+	protected := b64([]byte(fmt.Sprintf(`{"alg":"ES256",%s,"nonce":%q,"url":%q}`, key, c.nonce, url)))
+	c.nonce = ""
+
+	var encoded string
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		encoded = b64(data)
+	}
+
+	digest := sha256.Sum256([]byte(protected + "." + encoded))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	return json.Marshal(map[string]string{
+		"protected": protected,
+		"payload":   encoded,
+		"signature": b64(sig),
+	})
+}
@@ -0,0 +1,310 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// fakeDNS is a DNS provider keeping the TXT records in memory.
+type fakeDNS struct {
+	mu      sync.Mutex
+	records map[string][]string
+	cleaned int
+}
+
+func (d *fakeDNS) Present(fqdn, value string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.records[fqdn] = append(d.records[fqdn], value)
+	return nil
+}
+
+func (d *fakeDNS) CleanUp(fqdn, value string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.records[fqdn] = slices.DeleteFunc(d.records[fqdn], func(v string) bool { return v == value })
+	d.cleaned++
+	return nil
+}
+
+func (d *fakeDNS) lookup(fqdn string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.records[fqdn])
+}
+
+// fakeCA is an ACME CA that checks DNS-01 challenges against a fakeDNS,
+// verifying the signature of every request on the way.
+type fakeCA struct {
+	t   *testing.T
+	dns *fakeDNS
+
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+
+	mu         sync.Mutex
+	url        string
+	accountKey *ecdsa.PublicKey
+	thumbprint string
+	domains    []string
+	authzs     []string
+	issued     []byte
+}
+
+func newFakeCA(t *testing.T, dns *fakeDNS) *fakeCA {
+	ca := &fakeCA{t: t, dns: dns}
+	ca.cert, ca.key = newCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "fake ACME CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	srv := httptest.NewServer(ca)
+	t.Cleanup(srv.Close)
+	ca.url = srv.URL
+
+	return ca
+}
+
+func (ca *fakeCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	w.Header().Set("Replay-Nonce", fmt.Sprint(time.Now().UnixNano()))
+	switch {
+	case r.URL.Path == "/dir":
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   ca.url + "/nonce",
+			"newAccount": ca.url + "/account",
+			"newOrder":   ca.url + "/new-order",
+		})
+		return
+	case r.URL.Path == "/nonce":
+		return
+	}
+
+	payload, ok := ca.verify(r)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/account":
+		w.Header().Set("Location", ca.url+"/account/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"valid"}`))
+	case r.URL.Path == "/new-order":
+		var req struct {
+			Identifiers []struct{ Value string }
+		}
+		json.Unmarshal(payload, &req)
+		ca.domains, ca.authzs = nil, nil
+		for _, id := range req.Identifiers {
+			ca.domains = append(ca.domains, id.Value)
+			ca.authzs = append(ca.authzs, "pending")
+		}
+		w.Header().Set("Location", ca.url+"/order")
+		w.WriteHeader(http.StatusCreated)
+		ca.writeOrder(w)
+	case r.URL.Path == "/order":
+		ca.writeOrder(w)
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		var i int
+		fmt.Sscan(strings.TrimPrefix(r.URL.Path, "/authz/"), &i)
+		json.NewEncoder(w).Encode(map[string]any{
+			"status":     ca.authzs[i],
+			"identifier": map[string]string{"type": "dns", "value": strings.TrimPrefix(ca.domains[i], "*.")},
+			"challenges": []map[string]string{
+				{"type": "http-01", "url": ca.url + "/unused", "token": "nope"},
+				{"type": "dns-01", "url": fmt.Sprintf("%s/challenge/%d", ca.url, i), "token": fmt.Sprint("token", i)},
+			},
+		})
+	case strings.HasPrefix(r.URL.Path, "/challenge/"):
+		var i int
+		fmt.Sscan(strings.TrimPrefix(r.URL.Path, "/challenge/"), &i)
+		auth := sha256.Sum256([]byte(fmt.Sprint("token", i) + "." + ca.thumbprint))
+		want := base64.RawURLEncoding.EncodeToString(auth[:])
+		fqdn := "_acme-challenge." + strings.TrimPrefix(ca.domains[i], "*.") + "."
+		ca.authzs[i] = "invalid"
+		if slices.Contains(ca.dns.lookup(fqdn), want) {
+			ca.authzs[i] = "valid"
+		}
+		w.Write([]byte(`{"status":"processing"}`))
+	case r.URL.Path == "/finalize":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || !slices.Equal(csr.DNSNames, ca.domains) || slices.Contains(ca.authzs, "pending") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"type":"urn:ietf:params:acme:error:unauthorized"}`))
+			return
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      csr.Subject,
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		der, err = x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+		if err != nil {
+			ca.t.Error(err)
+		}
+		ca.issued = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		ca.writeOrder(w)
+	case r.URL.Path == "/certificate":
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(ca.issued)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (ca *fakeCA) writeOrder(w http.ResponseWriter) {
+	order := map[string]any{"status": "pending", "finalize": ca.url + "/finalize"}
+	var authzs []string
+	for i := range ca.domains {
+		authzs = append(authzs, fmt.Sprintf("%s/authz/%d", ca.url, i))
+	}
+	order["authorizations"] = authzs
+	if ca.issued != nil {
+		order["status"] = "valid"
+		order["certificate"] = ca.url + "/certificate"
+	}
+	json.NewEncoder(w).Encode(order)
+}
+
+// verify checks the JWS of a request was made for its URL and signed with
+// the account key, which the account request sets, and returns its payload.
+func (ca *fakeCA) verify(r *http.Request) ([]byte, bool) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return nil, false
+	}
+	protected, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var h struct {
+		Alg string
+		URL string
+		JWK *struct{ X, Y string }
+		KID string
+	}
+	json.Unmarshal(protected, &h)
+	if h.Alg != "ES256" || h.URL != ca.url+r.URL.Path {
+		return nil, false
+	}
+
+	if h.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(h.JWK.X)
+		y, _ := base64.RawURLEncoding.DecodeString(h.JWK.Y)
+		ca.accountKey = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		thumb := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, h.JWK.X, h.JWK.Y)))
+		ca.thumbprint = base64.RawURLEncoding.EncodeToString(thumb[:])
+	} else if h.KID != ca.url+"/account/1" || ca.accountKey == nil {
+		return nil, false
+	}
+
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(ca.accountKey, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		return nil, false
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+
+	return payload, true
+}
+
+func TestACMEDNS01(t *testing.T) {
+	dns := &fakeDNS{records: make(map[string][]string)}
+	server.RegisterDNSProvider("fake", func(string) (server.DNSProvider, error) { return dns, nil })
+	ca := newFakeCA(t, dns)
+
+	opts := servertest.Options()
+	opts.ACMEDomains = []string{"example.test", "*.example.test"}
+	opts.ACMEDir = filepath.Join(t.TempDir(), "acme")
+	opts.ACMEDirectoryURL = ca.url + "/dir"
+	opts.ACMEDNS = "fake"
+	opts.ACMEDNSWait = 0
+	s := servertest.New(t, opts)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	var err error
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		raw, dialErr := s.Dial()
+		if dialErr != nil {
+			t.Fatal(dialErr)
+		}
+		conn := tls.Client(raw, &tls.Config{ServerName: "www.example.test", RootCAs: roots})
+		err = conn.Handshake()
+		conn.Close()
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("got no wildcard certificate: %v", err)
+	}
+
+	dns.mu.Lock()
+	defer dns.mu.Unlock()
+	if dns.cleaned != 2 {
+		t.Errorf("cleaned up %d DNS records, want 2", dns.cleaned)
+	}
+	if n := len(dns.records["_acme-challenge.example.test."]); n != 0 {
+		t.Errorf("%d DNS records left behind", n)
+	}
+}
+
+func TestACMEOptions(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name string
+		set  func(*server.Options)
+	}{
+		{"no provider", func(o *server.Options) { o.ACMEDir = dir }},
+		{"no directory", func(o *server.Options) { o.ACMEDNS = "exec:/bin/true" }},
+		{"unknown provider", func(o *server.Options) { o.ACMEDir, o.ACMEDNS = dir, "nope" }},
+		{"exec without command", func(o *server.Options) { o.ACMEDir, o.ACMEDNS = dir, "exec" }},
+		{"with key pair", func(o *server.Options) {
+			o.ACMEDir, o.ACMEDNS = dir, "exec:/bin/true"
+			o.TLSCert, o.TLSKey = writeKeyPair(t, dir)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := servertest.Options()
+			opts.ACMEDomains = []string{"example.test"}
+			tc.set(&opts)
+			if _, err := server.New(opts); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
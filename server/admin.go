@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const adminUnixPrefix = "unix:"
+
+// listenAdmin binds the admin API either to a Unix socket (unix:/path) or to
+// a TCP address, which must be a loopback one.
+func listenAdmin(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, adminUnixPrefix); ok {
+		// A socket left behind by an unclean exit would make the bind fail.
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		return net.Listen("unix", path)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("admin address %s is not a loopback address", addr)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+// adminAllowed reports whether req may use the admin API. Only tools on
+// the same machine are meant to, but a browser there can be made to send
+// it requests by pages from anywhere, which carry an Origin header, or
+// through a name of theirs rebound to a loopback address, which is then
+// their Host.
+func adminAllowed(req request) bool {
+	if req.headers.get("Origin") != "" {
+		return false
+	}
+	if req.host == "" {
+		return true
+	}
+	host := req.host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	ip := net.ParseIP(host)
+
+	return strings.EqualFold(host, "localhost") || ip != nil && ip.IsLoopback()
+}
+
+// ServeAdmin runs the admin API on the Admin address until the server is
+// shut down.
+func (s *Server) ServeAdmin() error {
+	l, err := listenAdmin(s.opts.Admin)
+	if err != nil {
+		return fmt.Errorf("error binding admin listener: %v", err)
+	}
+	s.track(l)
+
+	return serveConns(l, s.log, s.handleAdminConn)
+}
+
+func (s *Server) handleAdminConn(conn net.Conn) error {
+	conn = &defaultsConn{Conn: conn, srv: s}
+	req, err := parseRequest(bufio.NewReader(conn), s.opts.StrictHTTP)
+	if err != nil {
+		return err
+	}
+	if !adminAllowed(req) {
+		conn.Write(buildResponse(statusForbidden, nil))
+		return nil
+	}
+
+	switch req.path {
+	case "/stats":
+		if req.method != methodGet {
+			conn.Write(buildResponse(statusMethodNotAllowed, nil))
+			return nil
+		}
+		conn.Write(buildResponse(statusOK, jsonContent(s.statsSnapshot())))
+		return nil
+	case "/debug/vars":
+		if req.method != methodGet {
+			conn.Write(buildResponse(statusMethodNotAllowed, nil))
+			return nil
+		}
+		s.serveVars(conn)
+		return nil
+	case "/catalog":
+		if req.method != methodGet {
+			conn.Write(buildResponse(statusMethodNotAllowed, nil))
+			return nil
+		}
+		return s.serveCatalog(conn, req)
+	case "/bans":
+		return s.serveBans(conn, req)
+	case "/drain":
+		return s.serveDrain(conn, req)
+	case "/shutdown", "/reload", "/cache/flush":
+		if req.method != methodPost {
+			conn.Write(buildResponse(statusMethodNotAllowed, nil))
+			return nil
+		}
+	default:
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+
+	switch req.path {
+	case "/shutdown":
+		if s.audit != nil {
+			s.auditAdmin("shutdown", "", conn, nil)
+		}
+		conn.Write(buildResponse(statusOK, jsonContent(map[string]string{"status": "shutting down"})))
+		s.requestShutdown()
+	case "/reload":
+		err := s.reload()
+		if s.audit != nil {
+			s.auditAdmin("reload", "", conn, err)
+		}
+		if err != nil {
+			conn.Write(buildResponse(statusInternalServerError, jsonContent(map[string]string{"error": err.Error()})))
+			return fmt.Errorf("reload failed: %v\n", err)
+		}
+		conn.Write(buildResponse(statusOK, jsonContent(map[string]string{"status": "reloaded"})))
+	case "/cache/flush":
+		if s.proxy != nil && s.proxy.cache != nil {
+			s.proxy.cache.flush()
+		}
+		s.files.flush()
+		s.thumbs.flush()
+		if s.audit != nil {
+			s.auditAdmin("cache-flush", "", conn, nil)
+		}
+		conn.Write(buildResponse(statusOK, jsonContent(map[string]string{"status": "flushed"})))
+	}
+
+	return nil
+}
+
+type statsSnapshot struct {
+	UptimeSeconds     int64  `json:"uptime_seconds"`
+	ConnectionsActive int64  `json:"connections_active"`
+	ConnectionsTotal  int64  `json:"connections_total"`
+	RequestsTotal     int64  `json:"requests_total"`
+	Goroutines        int    `json:"goroutines"`
+	HeapAllocBytes    uint64 `json:"heap_alloc_bytes"`
+	CacheEntries      int    `json:"cache_entries"`
+	CacheBytes        int64  `json:"cache_bytes"`
+
+	FileCache *fileCacheStats `json:"file_cache,omitempty"`
+}
+
+func (s *Server) statsSnapshot() statsSnapshot {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	snap := statsSnapshot{
+		UptimeSeconds:     int64(time.Since(s.stats.started).Seconds()),
+		ConnectionsActive: s.stats.activeConns.Load(),
+		ConnectionsTotal:  s.stats.totalConns.Load(),
+		RequestsTotal:     s.stats.requests.Load(),
+		Goroutines:        runtime.NumGoroutine(),
+		HeapAllocBytes:    mem.HeapAlloc,
+	}
+	if s.proxy != nil && s.proxy.cache != nil {
+		snap.CacheEntries, snap.CacheBytes = s.proxy.cache.usage()
+	}
+	if s.files != nil {
+		snap.FileCache = s.files.stats()
+	}
+
+	return snap
+}
+
+func jsonContent(v any) *content {
+	body, err := json.Marshal(v)
+	if err != nil {
+		body = []byte(fmt.Sprintf(`{"error":%q}`, err.Error()))
+	}
+
+	return &content{contentType: contentTypeJSON, body: body}
+}
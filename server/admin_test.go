@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// adminDo sends the raw request to the admin API of s and reads the
+// response to it.
+func adminDo(t *testing.T, s *Server, raw string) (*http.Response, []byte) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go s.handleAdminConn(server)
+	go io.WriteString(client, raw)
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	return resp, body
+}
+
+func TestAdminLocalOnly(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Storage = storageMemory
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, raw string
+		status    int
+	}{
+		{"localhost", "GET /stats HTTP/1.1\r\nHost: localhost:9000\r\n\r\n", http.StatusOK},
+		{"IPv4 loopback", "GET /stats HTTP/1.1\r\nHost: 127.0.0.1:9000\r\n\r\n", http.StatusOK},
+		{"IPv6 loopback", "GET /stats HTTP/1.1\r\nHost: [::1]:9000\r\n\r\n", http.StatusOK},
+		{"no host", "GET /stats HTTP/1.0\r\n\r\n", http.StatusOK},
+		{"rebound name", "GET /stats HTTP/1.1\r\nHost: evil.example:9000\r\n\r\n", http.StatusForbidden},
+		{"other address", "GET /stats HTTP/1.1\r\nHost: 192.0.2.1\r\n\r\n", http.StatusForbidden},
+		{"cross-origin", "POST /reload HTTP/1.1\r\nHost: localhost\r\nOrigin: http://evil.example\r\nContent-Length: 0\r\n\r\n", http.StatusForbidden},
+		{"same origin", "POST /shutdown HTTP/1.1\r\nHost: localhost:9000\r\nOrigin: http://localhost:9000\r\nContent-Length: 0\r\n\r\n", http.StatusForbidden},
+	} {
+		if resp, _ := adminDo(t, s, tc.raw); resp.StatusCode != tc.status {
+			t.Errorf("%s: got %d, want %d", tc.name, resp.StatusCode, tc.status)
+		}
+	}
+
+	select {
+	case <-s.ShutdownRequested():
+		t.Error("shutdown requested by a browser")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAdminCacheFlush(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Storage = storageMemory
+	opts.FileCacheSize = 1 << 20
+	opts.ThumbCacheSize = 1 << 20
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.files.put(fileKey{name: "a.css"}, []byte("body{}"))
+	s.thumbs.put(thumbKey{name: "a.png", w: 16, h: 16}, &content{body: []byte("png")})
+
+	raw := "POST /cache/flush HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n"
+	if resp, _ := adminDo(t, s, raw); resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d flushing", resp.StatusCode)
+	}
+	if st := s.files.stats(); st.Entries != 0 || st.Bytes != 0 {
+		t.Errorf("got %d files of %d bytes cached after a flush", st.Entries, st.Bytes)
+	}
+	if c := s.thumbs.get(thumbKey{name: "a.png", w: 16, h: 16}); c != nil || s.thumbs.used != 0 {
+		t.Errorf("got thumbnail %v of %d bytes cached after a flush", c, s.thumbs.used)
+	}
+
+	// Without the caches there is nothing to flush.
+	opts.FileCacheSize, opts.ThumbCacheSize = 0, 0
+	if s, err = New(opts); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := adminDo(t, s, raw); resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d flushing without caches", resp.StatusCode)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// admission bounds the requests served at once. Requests over the limit
+// wait in a queue of bounded depth for a turn, and are shed once the queue
+// is full or they have waited too long, keeping latency bounded under load.
+type admission struct {
+	slots   chan struct{}
+	queued  atomic.Int64
+	depth   int64
+	timeout time.Duration
+}
+
+func newAdmission(concurrency, depth int, timeout time.Duration) *admission {
+	return &admission{
+		slots:   make(chan struct{}, concurrency),
+		depth:   int64(depth),
+		timeout: timeout,
+	}
+}
+
+// enter waits for a turn to serve a request, returning a func to call once
+// it is served, or false if the request is to be shed.
+func (a *admission) enter(closed <-chan struct{}) (func(), bool) {
+	release := func() { <-a.slots }
+
+	select {
+	case a.slots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	if a.queued.Add(1) > a.depth {
+		a.queued.Add(-1)
+		return nil, false
+	}
+	defer a.queued.Add(-1)
+
+	timer := time.NewTimer(a.timeout)
+	defer timer.Stop()
+
+	select {
+	case a.slots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+	case <-closed:
+	}
+
+	return nil, false
+}
+
+// shed answers a request turned away by admission control with 503, asking
+// the client to come back once the queue has had time to move.
+func (a *admission) shed(ex *exchangeConn) {
+	retry := max(1, int((a.timeout+time.Second-1)/time.Second))
+	h := header{"Retry-After": {strconv.Itoa(retry)}}
+	ex.Write(buildResponseHeaders(statusServiceUnavailable, h, nil))
+}
@@ -0,0 +1,51 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestAdmissionQueue(t *testing.T) {
+	opts := servertest.Options()
+	opts.MaxConcurrent = 1
+	opts.QueueDepth = 1
+	opts.QueueTimeout = 2 * time.Second
+	c := servertest.NewPipe(t, opts).Client()
+
+	busy := make(chan *servertest.Response)
+	go func() { busy <- c.Get("/delay/300ms") }()
+	time.Sleep(50 * time.Millisecond)
+	queued := make(chan *servertest.Response)
+	go func() { queued <- c.Get("/echo/queued") }()
+	time.Sleep(50 * time.Millisecond)
+
+	// The one slot is taken and the queue is full.
+	c.Get("/echo/shed").
+		AssertStatus(http.StatusServiceUnavailable).
+		AssertHeader("Retry-After", "2")
+
+	(<-busy).AssertStatus(http.StatusOK)
+	(<-queued).AssertStatus(http.StatusOK).AssertBody("queued")
+	c.Get("/echo/free").AssertStatus(http.StatusOK)
+}
+
+func TestAdmissionQueueTimeout(t *testing.T) {
+	opts := servertest.Options()
+	opts.MaxConcurrent = 1
+	opts.QueueTimeout = 50 * time.Millisecond
+	c := servertest.NewPipe(t, opts).Client()
+
+	busy := make(chan *servertest.Response)
+	go func() { busy <- c.Get("/delay/300ms") }()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	c.Get("/echo/late").AssertStatus(http.StatusServiceUnavailable).AssertHeader("Retry-After", "1")
+	if waited := time.Since(start); waited > 250*time.Millisecond {
+		t.Errorf("shed after %s, want about the queue timeout", waited)
+	}
+	(<-busy).AssertStatus(http.StatusOK)
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// altSvcHeader builds the Alt-Svc header advertising the alternatives of
+// -alt-svc, each a protocol=[host]:port like h3=:443, for clients to move
+// to for up to maxAge. The server speaks HTTP/1.1 itself, so what serves
+// them, say a QUIC terminating proxy in front for h3, is up to the setup.
+func altSvcHeader(alts []string, maxAge time.Duration) (string, error) {
+	var values []string
+	for _, alt := range alts {
+		proto, authority, ok := strings.Cut(alt, "=")
+		if !ok || proto == "" {
+			return "", fmt.Errorf("invalid alternative %q: want protocol=[host]:port", alt)
+		}
+		host, port, err := net.SplitHostPort(authority)
+		if err != nil {
+			return "", fmt.Errorf("invalid alternative %q: want protocol=[host]:port", alt)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid port in alternative %q", alt)
+		}
+		if strings.ContainsAny(host, "\"\\ ") {
+			return "", fmt.Errorf("invalid host in alternative %q", alt)
+		}
+		values = append(values, fmt.Sprintf(`%s="%s"; ma=%d`, altSvcProtocol(proto), authority, int64(maxAge.Seconds())))
+	}
+
+	return strings.Join(values, ", "), nil
+}
+
+// altSvcProtocol percent-encodes the ALPN protocol ID proto as Alt-Svc
+// wants it, so http/1.1 is sent as http%2F1.1.
+func altSvcProtocol(proto string) string {
+	var b strings.Builder
+	for i := 0; i < len(proto); i++ {
+		c := proto[i]
+		if c != '%' && isTokenChar(c) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,34 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestAltSvc(t *testing.T) {
+	opts := servertest.Options()
+	opts.AltSvc = []string{"h3=:443", "http/1.1=alt.example:8443"}
+	opts.AltSvcMaxAge = time.Hour
+	c := servertest.New(t, opts).Client()
+
+	want := `h3=":443"; ma=3600, http%2F1.1="alt.example:8443"; ma=3600`
+	c.Get("/echo/hi").AssertStatus(http.StatusOK).AssertHeader("Alt-Svc", want)
+	c.Get("/nope").AssertStatus(http.StatusNotFound).AssertHeader("Alt-Svc", want)
+
+	// Without alternatives none are advertised.
+	servertest.New(t, servertest.Options()).Client().Get("/echo/hi").AssertHeader("Alt-Svc", "")
+}
+
+func TestAltSvcInvalid(t *testing.T) {
+	for _, alt := range []string{"h3", "=:443", "h3=443", "h3=:0", `h3="x":443`} {
+		opts := servertest.Options()
+		opts.AltSvc = []string{alt}
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error for -alt-svc %q", alt)
+		}
+	}
+}
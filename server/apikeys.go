@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// The scopes an API key can carry. Keys with admin can do everything,
+// including the file manager's trash.
+const (
+	scopeRead   = "read"
+	scopeWrite  = "write"
+	scopeDelete = "delete"
+	scopeAdmin  = "admin"
+)
+
+// apiKey is what an API key may do: the scopes it carries, on the paths
+// under one of paths or anywhere if there are none.
+type apiKey struct {
+	name   string
+	scopes map[string]bool
+	paths  []string
+}
+
+// apiKeySet holds API keys by the SHA-256 of the key, so that the file
+// never holds the keys themselves.
+type apiKeySet map[[sha256.Size]byte]*apiKey
+
+// loadAPIKeys reads the API key file at path. Each line holds a name, the
+// key as sha256:<hex digest>, the comma-separated scopes and any number of
+// path prefixes the key is limited to, separated by whitespace. Blank lines
+// and lines starting with # are skipped. A key's digest is what
+//
+//	printf %s "$KEY" | sha256sum
+//
+// prints.
+func loadAPIKeys(path string) (apiKeySet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening API keys: %v", err)
+	}
+	defer f.Close()
+
+	keys := make(apiKeySet)
+	names := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s:%d: expected 'name sha256:digest scopes [/path ...]'", path, n)
+		}
+		if names[fields[0]] {
+			return nil, fmt.Errorf("%s:%d: key %q listed twice", path, n, fields[0])
+		}
+		names[fields[0]] = true
+
+		digest, ok := strings.CutPrefix(fields[1], "sha256:")
+		sum, err := hex.DecodeString(digest)
+		if !ok || err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: expected the key as sha256:<64 hex digits>", path, n)
+		}
+
+		key := &apiKey{name: fields[0], scopes: make(map[string]bool)}
+		for _, scope := range strings.Split(fields[2], ",") {
+			switch scope {
+			case scopeRead, scopeWrite, scopeDelete, scopeAdmin:
+				key.scopes[scope] = true
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown scope %q", path, n, scope)
+			}
+		}
+		for _, p := range fields[3:] {
+			if !strings.HasPrefix(p, "/") {
+				return nil, fmt.Errorf("%s:%d: path %q isn't absolute", path, n, p)
+			}
+			key.paths = append(key.paths, p)
+		}
+		keys[[sha256.Size]byte(sum)] = key
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading API keys: %v", err)
+	}
+
+	return keys, nil
+}
+
+// lookup returns the key of the X-Api-Key header of req, if it is one.
+func (set apiKeySet) lookup(req request) *apiKey {
+	given := req.headers.get("X-Api-Key")
+	if given == "" {
+		return nil
+	}
+
+	return set[sha256.Sum256([]byte(given))]
+}
+
+// allows reports whether the key may make req.
+func (k *apiKey) allows(req request) bool {
+	var needed []string
+	target := req.cleanPath()
+	switch {
+	case req.pathParts[1] == "trash" || req.pathParts[1] == "ui":
+		needed = []string{scopeAdmin}
+	case req.method == methodPost && len(req.pathParts) == 3 && req.pathParts[1] == "upload" && req.pathParts[2] == "links":
+		// A link lets anyone write where it points, so the key has to be
+		// able to write there itself.
+		needed = []string{scopeWrite}
+		target = "/" + joinName(req.query.Get("path"))
+	case req.method == methodGet:
+		needed = []string{scopeRead}
+	case req.method == methodPost || req.method == methodPut:
+		needed = []string{scopeWrite}
+	case req.method == methodDelete:
+		needed = []string{scopeDelete}
+	case req.method == methodMove:
+		// A move deletes the file where it was and writes it where it goes.
+		needed = []string{scopeDelete, scopeWrite}
+	default:
+		return false
+	}
+	for _, scope := range needed {
+		if !k.scopes[scope] && !k.scopes[scopeAdmin] {
+			return false
+		}
+	}
+	if !k.covers(target) {
+		return false
+	}
+	if req.method == methodMove {
+		to, ok := destinationName(req.headers.get("Destination"))
+		return ok && k.covers("/files/"+to)
+	}
+
+	return true
+}
+
+// covers reports whether p lies under one of the key's paths.
+func (k *apiKey) covers(p string) bool {
+	if len(k.paths) == 0 {
+		return true
+	}
+	p = path.Clean(p)
+	for _, prefix := range k.paths {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if p == prefix || strings.HasPrefix(p, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// apiKeyMiddleware lets through only requests with an X-Api-Key header
+// holding a key that may make them. Requests it lets through count as
+// authorized for the file operations of the file manager.
+func apiKeyMiddleware(s *Server, next handler) handler {
+	return func(ex *exchangeConn, body io.Reader, req request) error {
+		key := (*s.apiKeys.Load()).lookup(req)
+		if key == nil {
+			ex.Write(buildResponse(statusUnauthorized, nil))
+			return nil
+		}
+		if !key.allows(req) {
+			s.log.Info("API key not allowed", "id", req.id, "key", key.name, "method", req.method, "path", req.path)
+			ex.Write(buildResponse(statusForbidden, nil))
+			return nil
+		}
+		req.apiKey = key
+
+		return next(ex, body, req)
+	}
+}
@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func keyDigest(key string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(key)))
+}
+
+func TestAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	keys := "# name  key  scopes  paths\n" +
+		"reader " + keyDigest("r-key") + " read\n" +
+		"builds " + keyDigest("b-key") + " read,write,delete /files/builds/\n" +
+		"root   " + keyDigest("a-key") + " admin\n"
+	if err := os.WriteFile(path, []byte(keys), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := servertest.Options()
+	opts.APIKeys = path
+	opts.Middleware = []server.RouteMiddleware{
+		{Route: "/files", Middleware: []string{"apikey"}},
+		{Route: "/trash", Middleware: []string{"apikey"}},
+		{Route: "/upload", Middleware: []string{"apikey"}},
+	}
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	key := func(k string) http.Header { return http.Header{"X-Api-Key": {k}} }
+	move := func(k, to string) http.Header {
+		return http.Header{"X-Api-Key": {k}, "Destination": {to}}
+	}
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("a"), key("a-key")).AssertStatus(http.StatusCreated)
+	c.Get("/files/a.txt").AssertStatus(http.StatusUnauthorized)
+	c.Do(http.MethodGet, "/files/a.txt", nil, key("guess")).AssertStatus(http.StatusUnauthorized)
+	c.Do(http.MethodGet, "/files/a.txt", nil, key("r-key")).AssertStatus(http.StatusOK).AssertBody("a")
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("b"), key("r-key")).AssertStatus(http.StatusForbidden)
+	c.Do(http.MethodDelete, "/files/a.txt", nil, key("r-key")).AssertStatus(http.StatusForbidden)
+
+	// Keys limited to paths can't reach past them.
+	c.Do(http.MethodPut, "/files/builds/1.zip", strings.NewReader("1"), key("b-key")).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodGet, "/files/a.txt", nil, key("b-key")).AssertStatus(http.StatusForbidden)
+	c.Do(http.MethodPut, "/files/builds/../a.txt", strings.NewReader("b"), key("b-key")).AssertStatus(http.StatusForbidden)
+	c.Do(http.MethodPut, "/files/builds2/a.txt", strings.NewReader("b"), key("b-key")).AssertStatus(http.StatusForbidden)
+	// Nor by escaping the way out, as the decoded path is the one served.
+	for _, p := range []string{"/files/builds/..%2Fa.txt", "/files/builds/%2e%2e/a.txt", "/files/%62uilds/../a.txt"} {
+		c.Do(http.MethodGet, p, nil, key("b-key")).AssertStatus(http.StatusForbidden)
+		c.Do(http.MethodPut, p, strings.NewReader("b"), key("b-key")).AssertStatus(http.StatusForbidden)
+	}
+	c.Do(http.MethodGet, "/files/a.txt", nil, key("r-key")).AssertBody("a")
+	c.Do(http.MethodGet, "/files/%62uilds/1.zip", nil, key("b-key")).AssertStatus(http.StatusOK).AssertBody("1")
+	c.Do("MOVE", "/files/builds/1.zip", nil, move("b-key", "/files/builds/..%2Fa.zip")).AssertStatus(http.StatusForbidden)
+	c.Do("MOVE", "/files/builds/1.zip", nil, move("b-key", "/files/a.zip")).AssertStatus(http.StatusForbidden)
+	c.Do("MOVE", "/files/builds/1.zip", nil, move("b-key", "/files/builds/2.zip")).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodDelete, "/files/builds/2.zip", nil, key("b-key")).AssertStatus(http.StatusNoContent)
+
+	// Upload links can only be minted by keys that may write where they
+	// point.
+	c.Do(http.MethodPost, "/upload/links?path=/files/", nil, key("r-key")).AssertStatus(http.StatusForbidden)
+	c.Do(http.MethodPost, "/upload/links?path=/files/", nil, key("b-key")).AssertStatus(http.StatusForbidden)
+	c.Do(http.MethodPost, "/upload/links?path=/files/builds/../a.txt", nil, key("b-key")).AssertStatus(http.StatusForbidden)
+	c.Do(http.MethodPost, "/upload/links?path=/files/builds/", nil, key("b-key")).AssertStatus(http.StatusCreated)
+
+	// The trash takes admin.
+	c.Do(http.MethodGet, "/trash", nil, key("b-key")).AssertStatus(http.StatusForbidden)
+	c.Do(http.MethodGet, "/trash", nil, key("a-key")).AssertStatus(http.StatusOK).AssertBodyContains("builds/2.zip")
+
+	for _, bad := range []string{
+		"reader sha256:abc read\n",
+		"reader " + keyDigest("r-key") + " browse\n",
+		"reader " + keyDigest("r-key") + " read files/\n",
+		"reader " + keyDigest("r-key") + "\n",
+	} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error for %q", bad)
+		}
+	}
+
+	opts.APIKeys = ""
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for the apikey middleware without -api-keys")
+	}
+}
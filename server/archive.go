@@ -0,0 +1,172 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+)
+
+const (
+	archiveZip   = "zip"
+	archiveTarGz = "tar.gz"
+)
+
+const (
+	contentTypeZip  = "application/zip"
+	contentTypeGzip = "application/gzip"
+)
+
+// serveArchive streams the directory dir in store to the client as an archive
+// in the given format. The archive is written straight to the connection, so
+// the response is delimited by closing it rather than by a Content-Length.
+func serveArchive(conn io.Writer, store storage, dir string, format string) error {
+	info, err := store.Stat(dir)
+	if err != nil || !info.IsDir() {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+
+	var contentType string
+	switch format {
+	case archiveZip:
+		contentType = contentTypeZip
+	case archiveTarGz:
+		contentType = contentTypeGzip
+	default:
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+
+	h := header{
+		"Content-Type":        {contentType},
+		"Content-Disposition": {fmt.Sprintf("attachment; filename=%q", info.Name()+"."+format)},
+		"Connection":          {"close"},
+		"Accept-Ranges":       {"none"},
+	}
+	conn.Write(buildResponseHeaders(statusOK, h, nil))
+
+	if format == archiveZip {
+		err = writeZip(conn, store, dir)
+	} else {
+		err = writeTarGz(conn, store, dir)
+	}
+	if err != nil {
+		return fmt.Errorf("error archiving %s: %v\n", dir, err)
+	}
+
+	return nil
+}
+
+func writeZip(w io.Writer, store storage, dir string) error {
+	zw := zip.NewWriter(w)
+
+	err := walkArchive(store, dir, "", func(name string, info fs.FileInfo, f io.Reader) error {
+		fh, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		fh.Name = name
+		if info.IsDir() {
+			fh.Name += "/"
+		} else {
+			fh.Method = zip.Deflate
+		}
+
+		fw, err := zw.CreateHeader(fh)
+		if err != nil || f == nil {
+			return err
+		}
+		_, err = io.Copy(fw, f)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, store storage, dir string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	err := walkArchive(store, dir, "", func(name string, info fs.FileInfo, f io.Reader) error {
+		th, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		th.Name = name
+		if info.IsDir() {
+			th.Name += "/"
+		}
+
+		if err := tw.WriteHeader(th); err != nil || f == nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// archiveAddFunc writes one entry to an archive. f is nil for directories.
+type archiveAddFunc func(name string, info fs.FileInfo, f io.Reader) error
+
+// walkArchive calls add for every directory and regular file below dir with
+// its slash-separated name relative to the archive root, which is prefix.
+// Regular files are passed opened; anything else (symlinks, devices) is
+// skipped.
+func walkArchive(store storage, dir, prefix string, add archiveAddFunc) error {
+	infos, err := store.List(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		name := path.Join(prefix, info.Name())
+		child := joinName(dir, info.Name())
+		if reservedName(child) {
+			continue
+		}
+
+		switch {
+		case info.IsDir():
+			if err := add(name, info, nil); err != nil {
+				return err
+			}
+			if err := walkArchive(store, child, name, add); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if err := addFile(store, child, name, info, add); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func addFile(store storage, child, name string, info fs.FileInfo, add archiveAddFunc) error {
+	f, err := store.Open(child)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return add(name, info, f)
+}
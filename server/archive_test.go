@@ -0,0 +1,144 @@
+package server_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// zipEntries returns the content of every entry of a zip archive by name,
+// directories holding "".
+func zipEntries(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("error reading zip: %v", err)
+	}
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("error opening %s: %v", f.Name, err)
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("error reading %s: %v", f.Name, err)
+		}
+		entries[f.Name] = string(body)
+	}
+
+	return entries
+}
+
+// tarGzEntries returns the content of every entry of a tar.gz archive by
+// name, directories holding "".
+func tarGzEntries(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error reading gzip: %v", err)
+	}
+	tr := tar.NewReader(gr)
+	entries := make(map[string]string)
+	for {
+		th, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error reading tar: %v", err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", th.Name, err)
+		}
+		entries[th.Name] = string(body)
+	}
+
+	return entries
+}
+
+func TestArchives(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "a")
+	write("docs/b.txt", "bb")
+	write("docs/deep/c.txt", "ccc")
+	write("private/s.txt", "secret")
+	write("private/.naive-access", "auth on\n")
+	write(".versions/a.txt/1", "old a")
+	write(".trash/1/gone.txt", "gone")
+	os.MkdirAll(filepath.Join(dir, "empty"), 0o755)
+
+	opts := servertest.Options()
+	opts.Storage = "disk"
+	opts.Directory = dir
+	opts.UIAuth = "me:secret"
+	c := servertest.NewPipe(t, opts).Client()
+
+	public := map[string]string{
+		"a.txt":           "a",
+		"docs/":           "",
+		"docs/b.txt":      "bb",
+		"docs/deep/":      "",
+		"docs/deep/c.txt": "ccc",
+		"empty/":          "",
+	}
+	// Only those who may list the private directory get it.
+	withAuth := map[string]string{"private/": "", "private/s.txt": "secret"}
+	for name, body := range public {
+		withAuth[name] = body
+	}
+
+	formats := []struct {
+		format, contentType string
+		entries             func(*testing.T, []byte) map[string]string
+	}{
+		{"zip", "application/zip", zipEntries},
+		{"tar.gz", "application/gzip", tarGzEntries},
+	}
+	for _, f := range formats {
+		resp := c.Get("/files/?archive="+f.format).
+			AssertStatus(http.StatusOK).
+			AssertHeader("Content-Type", f.contentType)
+		if got := f.entries(t, resp.Body); !reflect.DeepEqual(got, public) {
+			t.Errorf("%s: got entries %q, want %q", f.format, got, public)
+		}
+
+		resp = c.Do(http.MethodGet, "/files/?archive="+f.format, nil, basicAuth("me", "secret")).AssertStatus(http.StatusOK)
+		if got := f.entries(t, resp.Body); !reflect.DeepEqual(got, withAuth) {
+			t.Errorf("%s with credentials: got entries %q, want %q", f.format, got, withAuth)
+		}
+
+		// Names are relative to the directory archived.
+		resp = c.Get("/files/docs/?archive="+f.format).
+			AssertStatus(http.StatusOK).
+			AssertHeader("Content-Disposition", `attachment; filename="docs.`+f.format+`"`)
+		want := map[string]string{"b.txt": "bb", "deep/": "", "deep/c.txt": "ccc"}
+		if got := f.entries(t, resp.Body); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s of docs: got entries %q, want %q", f.format, got, want)
+		}
+	}
+
+	c.Get("/files/docs/?archive=rar").AssertStatus(http.StatusBadRequest)
+	c.Get("/files/missing/?archive=zip").AssertStatus(http.StatusNotFound)
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The outcomes of audited operations.
+const (
+	auditOK     = "ok"
+	auditDenied = "denied"
+	auditFailed = "failed"
+)
+
+// auditEvent is a line of the audit log.
+type auditEvent struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	IP        string    `json:"ip,omitempty"`
+	Target    string    `json:"target,omitempty"`
+	To        string    `json:"to,omitempty"`
+	Status    int       `json:"status,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// auditLog records every operation changing what the server serves or how,
+// as JSON lines appended to a file of its own. Unlike the access log it is
+// never sampled, and each line is synced to disk before the next.
+type auditLog struct {
+	mu  sync.Mutex
+	f   *os.File
+	log *slog.Logger
+}
+
+func openAuditLog(path string, log *slog.Logger) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditLog{f: f, log: log}, nil
+}
+
+// record appends ev to the log, with the outcome err makes it if it has
+// none.
+func (l *auditLog) record(ev auditEvent, err error) {
+	ev.Time = time.Now().UTC()
+	if ev.Outcome == "" {
+		ev.Outcome = auditOK
+	}
+	if err != nil {
+		ev.Outcome = auditFailed
+		ev.Error = strings.TrimSpace(err.Error())
+	}
+	line, jerr := json.Marshal(ev)
+	if jerr != nil {
+		l.log.Error("error encoding audit event", "err", jerr)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		l.log.Error("error writing audit log", "err", err)
+		return
+	}
+	l.f.Sync()
+}
+
+func (l *auditLog) close() {
+	l.f.Close()
+}
+
+// auditAction names the operation req is, or returns "" if it doesn't
+// change anything.
+func auditAction(req request) string {
+	if len(req.pathParts) < 2 {
+		return ""
+	}
+
+	switch req.pathParts[1] {
+	case "files":
+		switch req.method {
+		case methodPost, methodPut:
+			if req.query.Get("restore") != "" {
+				return "restore-version"
+			}
+			return "upload"
+		case methodDelete:
+			return "delete"
+		case methodMove:
+			return "move"
+		}
+	case "upload":
+		if req.method == methodPost && len(req.pathParts) == 3 {
+			if req.pathParts[2] == "links" {
+				return "upload-link"
+			}
+			return "upload"
+		}
+	case "trash":
+		switch req.method {
+		case methodPost:
+			return "restore"
+		case methodDelete:
+			return "purge"
+		}
+	}
+
+	return ""
+}
+
+// auditRequest records the operation req made over ex, once it has been
+// answered.
+func (s *Server) auditRequest(action string, req request, ex *exchangeConn) {
+	ev := auditEvent{
+		Action:    action,
+		Actor:     s.actor(req),
+		IP:        req.clientIP,
+		Target:    req.path,
+		Status:    ex.status,
+		RequestID: req.id,
+	}
+	if action == "move" {
+		ev.To = req.headers.get("Destination")
+	}
+	switch {
+	case ex.status == statusUnauthorized || ex.status == statusForbidden:
+		ev.Outcome = auditDenied
+	case ex.status == 0 || ex.status >= statusBadRequest:
+		ev.Outcome = auditFailed
+	}
+
+	s.audit.record(ev, nil)
+}
+
+// actor names who made req by the credentials it carries: an API key, an
+// OIDC session, or the user of Basic credentials or a Bearer token. The
+// credentials aren't checked again; the outcome of the operation says
+// whether they were good.
+func (s *Server) actor(req request) string {
+	if keys := s.apiKeys.Load(); keys != nil {
+		if key := keys.lookup(req); key != nil {
+			return "apikey:" + key.name
+		}
+	}
+	if s.oidc != nil {
+		if user := s.oidc.user(req); user != "" {
+			return "oidc:" + user
+		}
+	}
+
+	scheme, credentials, _ := strings.Cut(req.headers.get("Authorization"), " ")
+	credentials = strings.TrimSpace(credentials)
+	switch {
+	case strings.EqualFold(scheme, "Basic"):
+		if given, err := base64.StdEncoding.DecodeString(credentials); err == nil {
+			user, _, _ := strings.Cut(string(given), ":")
+			return "basic:" + user
+		}
+	case strings.EqualFold(scheme, "Bearer") && s.jwt != nil:
+		if claims, err := s.jwt.verify(credentials, time.Now()); err == nil {
+			return "jwt:" + fmt.Sprint(claims["sub"])
+		}
+	}
+
+	return "-"
+}
+
+// auditAdmin records an admin API action on target, if it has one, made
+// over conn.
+func (s *Server) auditAdmin(action, target string, conn net.Conn, err error) {
+	ev := auditEvent{Action: action, Actor: "admin", IP: "unix", Target: target}
+	if addr := conn.RemoteAddr(); addr != nil && addr.Network() != "unix" {
+		ev.IP = addr.String()
+		if host, _, err := net.SplitHostPort(ev.IP); err == nil {
+			ev.IP = host
+		}
+	}
+
+	s.audit.record(ev, err)
+}
@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+type auditLine struct {
+	Action  string `json:"action"`
+	Actor   string `json:"actor"`
+	IP      string `json:"ip"`
+	Target  string `json:"target"`
+	To      string `json:"to"`
+	Status  int    `json:"status"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error"`
+}
+
+func readAudit(t *testing.T, path string) []auditLine {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []auditLine
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var l auditLine
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			t.Fatalf("bad audit line %q: %v", sc.Text(), err)
+		}
+		lines = append(lines, l)
+	}
+
+	return lines
+}
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	opts := servertest.Options()
+	opts.AuditLog = path
+	opts.UIAuth = "me:secret"
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	auth := basicAuth("me", "secret")
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("a"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/a.txt").AssertStatus(http.StatusOK)
+	c.Do(http.MethodDelete, "/files/a.txt", nil, nil).AssertStatus(http.StatusUnauthorized)
+	c.Do("MOVE", "/files/a.txt", nil, http.Header{"Authorization": auth["Authorization"], "Destination": {"/files/b.txt"}}).
+		AssertStatus(http.StatusCreated)
+	c.Do(http.MethodDelete, "/files/a.txt", nil, auth).AssertStatus(http.StatusNotFound)
+	c.Do(http.MethodDelete, "/files/b.txt", nil, auth).AssertStatus(http.StatusNoContent)
+	// Entries are only written once the exchanges are over.
+	s.Close()
+
+	want := []auditLine{
+		{Action: "upload", Actor: "-", Target: "/files/a.txt", Status: 201, Outcome: "ok"},
+		{Action: "delete", Actor: "-", Target: "/files/a.txt", Status: 401, Outcome: "denied"},
+		{Action: "move", Actor: "basic:me", Target: "/files/a.txt", To: "/files/b.txt", Status: 201, Outcome: "ok"},
+		{Action: "delete", Actor: "basic:me", Target: "/files/a.txt", Status: 404, Outcome: "failed"},
+		{Action: "delete", Actor: "basic:me", Target: "/files/b.txt", Status: 204, Outcome: "ok"},
+	}
+	got := readAudit(t, path)
+	if len(got) != len(want) {
+		t.Fatalf("got %d audit lines, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		want[i].IP = got[i].IP
+		if got[i] != want[i] {
+			t.Errorf("line %d: got %+v, want %+v", i+1, got[i], want[i])
+		}
+	}
+
+	// Reloads are audited too, failed ones included.
+	opts.RewriteRules = filepath.Join(t.TempDir(), "rules")
+	if err := os.WriteFile(opts.RewriteRules, []byte("^/a$ /b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(opts.RewriteRules, []byte("broken\n"), 0o644)
+	if err := srv.Reload(); err == nil {
+		t.Error("got no error reloading broken rules")
+	}
+	srv.Shutdown()
+
+	got = readAudit(t, path)[len(want):]
+	if len(got) != 2 || got[0].Action != "reload" || got[0].Outcome != "ok" || got[1].Outcome != "failed" || got[1].Error == "" {
+		t.Errorf("got reload lines %+v", got)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+)
+
+const authRealm = "naive-server"
+
+// authEnabled reports whether there are credentials for the file manager.
+func (s *Server) authEnabled() bool {
+	return s.opts.UIAuth != "" || s.htpasswd != nil || s.oidc != nil || s.opts.APIKeys != ""
+}
+
+// authorized reports whether the request carries the file manager's
+// credentials, or those of a user of the htpasswd file, as HTTP basic auth,
+// or the session cookie of a user logged in with OIDC, or was let in by the
+// apikey middleware with a key whose scopes and paths allow it.
+func (s *Server) authorized(req request) bool {
+	if !s.authEnabled() {
+		return false
+	}
+	if req.apiKey != nil {
+		return req.apiKey.allows(req)
+	}
+	if s.oidc != nil && s.oidc.user(req) != "" {
+		return true
+	}
+
+	scheme, encoded, _ := strings.Cut(req.headers.get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	given, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+
+	if s.opts.UIAuth != "" && subtle.ConstantTimeCompare(given, []byte(s.opts.UIAuth)) == 1 {
+		return true
+	}
+	user, password, ok := strings.Cut(string(given), ":")
+
+	return ok && s.htpasswd != nil && s.htpasswd.check(user, password)
+}
+
+// unauthorized builds the response asking the client to log in.
+func unauthorized() []byte {
+	h := make(header)
+	h.set("WWW-Authenticate", `Basic realm="`+authRealm+`", charset="UTF-8"`)
+
+	return buildResponseHeaders(statusUnauthorized, h, nil)
+}
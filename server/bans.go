@@ -0,0 +1,280 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BanConfig is how many offences of each kind a source IP may commit
+// within the ban window before it is banned; 0 doesn't count that kind.
+type BanConfig struct {
+	// ClientErrors counts responses with a 4xx status.
+	ClientErrors int
+	// AuthFailures counts 401 responses, which are also client errors.
+	AuthFailures int
+	// Malformed counts requests that couldn't be parsed.
+	Malformed int
+}
+
+func (c BanConfig) enabled() bool {
+	return c.ClientErrors > 0 || c.AuthFailures > 0 || c.Malformed > 0
+}
+
+// The kinds of offence counted towards bans.
+const (
+	offenceClientError = iota
+	offenceAuthFailure
+	offenceMalformed
+	offenceKinds
+)
+
+var offenceNames = [offenceKinds]string{"4xx", "auth", "malformed"}
+
+// banList bans source IPs that commit too many offences. Like connLimiter
+// it goes by the address of the peer: connections from banned IPs are
+// closed before anything is read from them, and trusted proxies, which
+// speak for many clients, are never banned.
+type banList struct {
+	mu      sync.Mutex
+	limits  [offenceKinds]int
+	window  time.Duration
+	base    time.Duration
+	max     time.Duration
+	trusted []netip.Prefix
+	ips     map[string]*offender
+	swept   time.Time
+	log     *slog.Logger
+}
+
+// offender is what is known about an IP that has offended.
+type offender struct {
+	windowStart time.Time
+	counts      [offenceKinds]int
+	// bans is how many times the IP has been banned; each ban lasts twice
+	// as long as the one before.
+	bans   int
+	until  time.Time
+	reason string
+	last   time.Time
+}
+
+func newBanList(c BanConfig, window, base, max time.Duration, trusted []netip.Prefix, log *slog.Logger) *banList {
+	return &banList{
+		limits:  [offenceKinds]int{c.ClientErrors, c.AuthFailures, c.Malformed},
+		window:  window,
+		base:    base,
+		max:     max,
+		trusted: trusted,
+		ips:     make(map[string]*offender),
+		swept:   time.Now(),
+		log:     log,
+	}
+}
+
+// banned reports whether the source of addr is banned.
+func (b *banList) banned(addr net.Addr) bool {
+	ip := connIP(addr)
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o := b.ips[ip]
+
+	return o != nil && now.Before(o.until)
+}
+
+// observe counts the offence, if any, of the exchange over ex with the
+// source of addr.
+func (b *banList) observe(addr net.Addr, ex *exchangeConn) {
+	switch {
+	case ex.status == statusUnauthorized:
+		b.offend(addr, offenceAuthFailure)
+		b.offend(addr, offenceClientError)
+	case ex.status >= statusBadRequest && ex.status < statusInternalServerError:
+		b.offend(addr, offenceClientError)
+	}
+}
+
+// offend counts an offence of kind by the source of addr, banning it if
+// that takes it over the limit.
+func (b *banList) offend(addr net.Addr, kind int) {
+	if b.limits[kind] == 0 {
+		return
+	}
+	ip := connIP(addr)
+	if prefixList(b.trusted).contains(ip) {
+		return
+	}
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweep(now)
+	o := b.ips[ip]
+	if o == nil {
+		o = &offender{windowStart: now}
+		b.ips[ip] = o
+	}
+	o.last = now
+	if now.Before(o.until) {
+		return
+	}
+	if now.Sub(o.windowStart) >= b.window {
+		o.windowStart = now
+		o.counts = [offenceKinds]int{}
+	}
+
+	o.counts[kind]++
+	if o.counts[kind] < b.limits[kind] {
+		return
+	}
+
+	ban := b.base << min(o.bans, 30)
+	if ban <= 0 || ban > b.max {
+		ban = b.max
+	}
+	o.bans++
+	o.until = now.Add(ban)
+	o.reason = offenceNames[kind]
+	o.counts = [offenceKinds]int{}
+	b.log.Warn("banned source IP", "ip", ip, "reason", o.reason, "for", ban.String(), "bans", o.bans)
+}
+
+// sweep forgets IPs that have behaved for as long as the longest ban
+// every so often, so that the map doesn't keep everyone who ever offended
+// and old bans stop counting towards longer ones.
+func (b *banList) sweep(now time.Time) {
+	if now.Sub(b.swept) < b.window {
+		return
+	}
+	for ip, o := range b.ips {
+		if now.After(o.until) && now.Sub(o.last) > max(b.max, b.window) {
+			delete(b.ips, ip)
+		}
+	}
+	b.swept = now
+}
+
+// unban lifts the ban on ip and forgets its offences, reporting whether it
+// was banned.
+func (b *banList) unban(ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	o := b.ips[ip]
+	delete(b.ips, ip)
+
+	return o != nil && time.Now().Before(o.until)
+}
+
+// banEntry is a ban as the admin API lists it.
+type banEntry struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Until  time.Time `json:"until"`
+	Bans   int       `json:"bans"`
+}
+
+// list returns the bans in force, soonest to end first.
+func (b *banList) list() []banEntry {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := []banEntry{}
+	for ip, o := range b.ips {
+		if now.Before(o.until) {
+			entries = append(entries, banEntry{IP: ip, Reason: o.reason, Until: o.until.UTC(), Bans: o.bans})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Until.Before(entries[j].Until) })
+
+	return entries
+}
+
+// serveBans handles /bans on the admin API: GET lists the bans in force and
+// DELETE /bans?ip=... lifts one.
+func (s *Server) serveBans(conn net.Conn, req request) error {
+	if s.bans == nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+
+	switch req.method {
+	case methodGet:
+		conn.Write(buildResponse(statusOK, jsonContent(s.bans.list())))
+	case methodDelete:
+		ip := req.query.Get("ip")
+		if _, err := netip.ParseAddr(ip); err != nil {
+			conn.Write(buildResponse(statusBadRequest, nil))
+			return nil
+		}
+		if !s.bans.unban(ip) {
+			conn.Write(buildResponse(statusNotFound, nil))
+			return nil
+		}
+		if s.audit != nil {
+			s.auditAdmin("unban", ip, conn, nil)
+		}
+		conn.Write(buildResponse(statusNoContent, nil))
+	default:
+		conn.Write(buildResponse(statusMethodNotAllowed, nil))
+	}
+
+	return nil
+}
+
+// banFlag parses -ban values like "4xx=100,auth=10,malformed=5".
+type banFlag BanConfig
+
+func (f *banFlag) String() string {
+	if f == nil || !BanConfig(*f).enabled() {
+		return ""
+	}
+
+	var parts []string
+	for i, n := range []int{f.ClientErrors, f.AuthFailures, f.Malformed} {
+		if n > 0 {
+			parts = append(parts, offenceNames[i]+"="+strconv.Itoa(n))
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func (f *banFlag) Set(value string) error {
+	var c BanConfig
+	for _, part := range strings.Split(value, ",") {
+		name, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return fmt.Errorf("invalid ban setting %q, want offence=count", part)
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid ban count %q", v)
+		}
+
+		switch name {
+		case "4xx":
+			c.ClientErrors = n
+		case "auth":
+			c.AuthFailures = n
+		case "malformed":
+			c.Malformed = n
+		default:
+			return fmt.Errorf("unknown offence %q, want 4xx, auth or malformed", name)
+		}
+	}
+	*f = banFlag(c)
+
+	return nil
+}
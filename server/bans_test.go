@@ -0,0 +1,72 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// refused reports whether the server closes a new connection without
+// answering a request on it.
+func refused(t *testing.T, s *servertest.Server) bool {
+	t.Helper()
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _ := conn.Read(make([]byte, 1))
+
+	return n == 0
+}
+
+func TestBans(t *testing.T) {
+	opts := servertest.Options()
+	opts.UIAuth = "me:secret"
+	opts.StrictHTTP = true
+	opts.Ban = server.BanConfig{AuthFailures: 3, Malformed: 2}
+	opts.BanTime = 100 * time.Millisecond
+	opts.BanMax = 150 * time.Millisecond
+	s := servertest.New(t, opts)
+	c := s.Client()
+
+	wrong := basicAuth("me", "guess")
+	for i := 0; i < 2; i++ {
+		c.Do(http.MethodGet, "/ui", nil, wrong).AssertStatus(http.StatusUnauthorized)
+	}
+	// Other client errors don't count towards auth failures.
+	c.Get("/nope").AssertStatus(http.StatusNotFound)
+	if refused(t, s) {
+		t.Fatal("banned before the limit")
+	}
+	c.Do(http.MethodGet, "/ui", nil, wrong).AssertStatus(http.StatusUnauthorized)
+	if !refused(t, s) {
+		t.Fatal("not banned after 3 auth failures")
+	}
+
+	time.Sleep(120 * time.Millisecond)
+	if refused(t, s) {
+		t.Fatal("still banned after the ban ran out")
+	}
+
+	// The next ban lasts longer, up to the maximum.
+	c.Raw("GET /\r\n\r\n").AssertStatus(http.StatusBadRequest)
+	c.Raw("GET /\r\n\r\n").AssertStatus(http.StatusBadRequest)
+	if !refused(t, s) {
+		t.Fatal("not banned after 2 malformed requests")
+	}
+	time.Sleep(120 * time.Millisecond)
+	if !refused(t, s) {
+		t.Fatal("the second ban was no longer than the first")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if refused(t, s) {
+		t.Fatal("banned for longer than -ban-max")
+	}
+}
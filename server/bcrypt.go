@@ -0,0 +1,190 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strconv"
+	"sync"
+)
+
+// The bcrypt password hash, for htpasswd entries made with htpasswd -B.
+// Blowfish is initialised from the hexadecimal digits of pi; rather than
+// carry a table of a thousand of them they are worked out the first time a
+// hash is checked.
+
+// bcryptEncoding is the base64 alphabet of bcrypt hashes.
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+var errBadBcrypt = errors.New("malformed bcrypt hash")
+
+// blowfish is the state of the Blowfish cipher: the subkeys and S-boxes.
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+var (
+	blowfishInitOnce sync.Once
+	blowfishInit     blowfish
+)
+
+// initialBlowfish returns the state Blowfish starts from: the fractional
+// part of pi, in 32-bit words, across the subkeys and then the S-boxes.
+func initialBlowfish() *blowfish {
+	blowfishInitOnce.Do(func() {
+		words := len(blowfishInit.p) + 4*256
+		digits := piFraction(32*words + 64)
+		for i := 0; i < words; i++ {
+			shift := uint(32 * (words - 1 - i))
+			w := uint32(new(big.Int).Rsh(digits, shift+64).Uint64())
+			if i < len(blowfishInit.p) {
+				blowfishInit.p[i] = w
+			} else {
+				j := i - len(blowfishInit.p)
+				blowfishInit.s[j/256][j%256] = w
+			}
+		}
+	})
+	b := blowfishInit
+
+	return &b
+}
+
+// piFraction returns the first bits binary digits of the fractional part
+// of pi, by Machin's formula: pi = 16 atan(1/5) - 4 atan(1/239).
+func piFraction(bits int) *big.Int {
+	const guard = 32
+	one := new(big.Int).Lsh(big.NewInt(1), uint(bits+guard))
+	pi := new(big.Int).Mul(arctanInv(5, one), big.NewInt(16))
+	pi.Sub(pi, new(big.Int).Mul(arctanInv(239, one), big.NewInt(4)))
+	pi.Sub(pi, new(big.Int).Mul(one, big.NewInt(3)))
+
+	return pi.Rsh(pi, guard)
+}
+
+// arctanInv returns atan(1/x) scaled by one.
+func arctanInv(x int64, one *big.Int) *big.Int {
+	sum := new(big.Int)
+	xx := big.NewInt(x * x)
+	power := new(big.Int).Div(one, big.NewInt(x))
+	term := new(big.Int)
+	for n := int64(1); power.Sign() != 0; n += 2 {
+		term.Div(power, big.NewInt(n))
+		if n%4 == 1 {
+			sum.Add(sum, term)
+		} else {
+			sum.Sub(sum, term)
+		}
+		power.Div(power, xx)
+	}
+
+	return sum
+}
+
+func (b *blowfish) f(x uint32) uint32 {
+	return ((b.s[0][x>>24] + b.s[1][x>>16&0xff]) ^ b.s[2][x>>8&0xff]) + b.s[3][x&0xff]
+}
+
+func (b *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	l ^= b.p[0]
+	for i := 1; i <= 16; i += 2 {
+		r ^= b.f(l) ^ b.p[i]
+		l ^= b.f(r) ^ b.p[i+1]
+	}
+	r ^= b.p[17]
+
+	return r, l
+}
+
+// cycleWord returns the next 32 bits of data, wrapping around at its end.
+func cycleWord(data []byte, j *int) uint32 {
+	var w uint32
+	for k := 0; k < 4; k++ {
+		w = w<<8 | uint32(data[*j])
+		*j = (*j + 1) % len(data)
+	}
+
+	return w
+}
+
+// expandKey mixes key, and salt if it isn't nil, into the state, as the
+// expensive key schedule of bcrypt does.
+func (b *blowfish) expandKey(key, salt []byte) {
+	j := 0
+	for i := range b.p {
+		b.p[i] ^= cycleWord(key, &j)
+	}
+
+	j = 0
+	var l, r uint32
+	next := func(dst []uint32) {
+		for i := 0; i < len(dst); i += 2 {
+			if salt != nil {
+				l ^= cycleWord(salt, &j)
+				r ^= cycleWord(salt, &j)
+			}
+			l, r = b.encrypt(l, r)
+			dst[i], dst[i+1] = l, r
+		}
+	}
+	next(b.p[:])
+	for i := range b.s {
+		next(b.s[i][:])
+	}
+}
+
+// bcryptMatches reports whether password hashes to hash, a $2a$, $2b$ or
+// $2y$ bcrypt hash.
+func bcryptMatches(hash, password string) (bool, error) {
+	if len(hash) != 60 || hash[0] != '$' || hash[1] != '2' || hash[3] != '$' || hash[6] != '$' {
+		return false, errBadBcrypt
+	}
+	cost, err := strconv.Atoi(hash[4:6])
+	if err != nil || cost < 4 || cost > 31 {
+		return false, errBadBcrypt
+	}
+	salt, err := bcryptEncoding.DecodeString(hash[7:29])
+	if err != nil || len(salt) != 16 {
+		return false, errBadBcrypt
+	}
+
+	sum := bcrypt(password, salt, cost)
+
+	return subtle.ConstantTimeCompare([]byte(bcryptEncoding.EncodeToString(sum)), []byte(hash[29:])) == 1, nil
+}
+
+// bcrypt returns the 23 bytes of hash bcrypt derives from password.
+func bcrypt(password string, salt []byte, cost int) []byte {
+	// The key includes the terminating NUL of a C string, and only the
+	// first 72 bytes count.
+	key := append([]byte(password), 0)
+	if len(key) > 72 {
+		key = key[:72]
+	}
+
+	b := initialBlowfish()
+	b.expandKey(key, salt)
+	for i := 0; i < 1<<cost; i++ {
+		b.expandKey(key, nil)
+		b.expandKey(salt, nil)
+	}
+
+	text := []byte("OrpheanBeholderScryDoubt")
+	var words [6]uint32
+	for i := range words {
+		words[i] = binary.BigEndian.Uint32(text[4*i:])
+	}
+	for i := 0; i < 64; i++ {
+		for j := 0; j < len(words); j += 2 {
+			words[j], words[j+1] = b.encrypt(words[j], words[j+1])
+		}
+	}
+	for i, w := range words {
+		binary.BigEndian.PutUint32(text[4*i:], w)
+	}
+
+	return text[:23]
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BenchOptions configures a load test run by Bench.
+type BenchOptions struct {
+	// Target is the URL requested over and over.
+	Target string
+	Method string
+	// Concurrency is the number of connections kept busy at once.
+	Concurrency int
+	Duration    time.Duration
+	// Timeout bounds each request; 0 means none.
+	Timeout time.Duration
+}
+
+// BenchResult is the outcome of a load test.
+type BenchResult struct {
+	Requests int
+	// Errors counts the requests that got no response at all; those that
+	// got one are counted by status in Statuses, whatever it was.
+	Errors   int
+	Statuses map[int]int
+	Elapsed  time.Duration
+	// The latencies of the requests that got a response.
+	P50, P90, P99, Max time.Duration
+	// FirstError is the first error met, to give an idea of the others.
+	FirstError error
+}
+
+// RPS returns the rate of requests that got a response.
+func (r BenchResult) RPS() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+
+	return float64(r.Requests-r.Errors) / r.Elapsed.Seconds()
+}
+
+// Bench requests opts.Target from opts.Concurrency workers, each sending
+// its next request as soon as the previous one is answered, until
+// opts.Duration is up or ctx is done. Requests still in flight then are
+// left out of the result.
+func Bench(ctx context.Context, opts BenchOptions) (BenchResult, error) {
+	if opts.Method == "" {
+		opts.Method = http.MethodGet
+	}
+	if opts.Concurrency < 1 {
+		return BenchResult{}, fmt.Errorf("concurrency must be at least 1")
+	}
+	if _, err := http.NewRequest(opts.Method, opts.Target, nil); err != nil {
+		return BenchResult{}, fmt.Errorf("invalid target: %v", err)
+	}
+
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: opts.Concurrency,
+		DisableCompression:  true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	workers := make([]benchWorker, opts.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range workers {
+		wg.Add(1)
+		go func(w *benchWorker) {
+			defer wg.Done()
+			w.run(ctx, client, opts)
+		}(&workers[i])
+	}
+	wg.Wait()
+
+	res := BenchResult{Elapsed: time.Since(start), Statuses: make(map[int]int)}
+	var latencies []time.Duration
+	for _, w := range workers {
+		res.Requests += w.requests
+		res.Errors += w.errors
+		if res.FirstError == nil {
+			res.FirstError = w.firstError
+		}
+		for status, n := range w.statuses {
+			res.Statuses[status] += n
+		}
+		latencies = append(latencies, w.latencies...)
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		at := func(p int) time.Duration { return latencies[(len(latencies)-1)*p/100] }
+		res.P50, res.P90, res.P99, res.Max = at(50), at(90), at(99), at(100)
+	}
+
+	return res, nil
+}
+
+// benchWorker sends requests one after the other, keeping its own counts so
+// that workers don't contend on shared ones.
+type benchWorker struct {
+	requests   int
+	errors     int
+	firstError error
+	statuses   map[int]int
+	latencies  []time.Duration
+}
+
+func (w *benchWorker) run(ctx context.Context, client *http.Client, opts BenchOptions) {
+	w.statuses = make(map[int]int)
+	for ctx.Err() == nil {
+		req, _ := http.NewRequestWithContext(ctx, opts.Method, opts.Target, nil)
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err == nil {
+			// The body is part of the response, so it's read before the
+			// clock stops.
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		latency := time.Since(start)
+		if ctx.Err() != nil {
+			return
+		}
+
+		w.requests++
+		if err != nil {
+			w.errors++
+			if w.firstError == nil {
+				w.firstError = err
+			}
+			continue
+		}
+		w.statuses[resp.StatusCode]++
+		w.latencies = append(w.latencies, latency)
+	}
+}
@@ -0,0 +1,51 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestBench(t *testing.T) {
+	s := servertest.New(t, servertest.Options())
+
+	res, err := server.Bench(context.Background(), server.BenchOptions{
+		Target:      s.URL + "/status/204",
+		Concurrency: 4,
+		Duration:    200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Requests == 0 || res.Errors != 0 || res.Statuses[http.StatusNoContent] != res.Requests {
+		t.Errorf("got %d requests, %d errors and statuses %v", res.Requests, res.Errors, res.Statuses)
+	}
+	if res.P50 <= 0 || res.P50 > res.P99 || res.P99 > res.Max || res.RPS() <= 0 {
+		t.Errorf("got p50 %s, p99 %s, max %s and %.1f requests/s", res.P50, res.P99, res.Max, res.RPS())
+	}
+	if res.Elapsed < 200*time.Millisecond || res.Elapsed > 2*time.Second {
+		t.Errorf("ran for %s, want about 200ms", res.Elapsed)
+	}
+
+	// Requests that get no response are counted as errors.
+	s.Close()
+	res, err = server.Bench(context.Background(), server.BenchOptions{
+		Target:      s.URL,
+		Concurrency: 1,
+		Duration:    50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Errors == 0 || res.Errors != res.Requests || res.FirstError == nil {
+		t.Errorf("got %d errors of %d requests, first %v", res.Errors, res.Requests, res.FirstError)
+	}
+
+	if _, err := server.Bench(context.Background(), server.BenchOptions{Target: "::bad", Concurrency: 1}); err == nil {
+		t.Error("got no error for an invalid target")
+	}
+}
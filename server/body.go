@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxTrailers caps the trailer fields skipped after the last chunk.
+const maxTrailers = 64
+
+var errBadChunk = errors.New("malformed chunked encoding")
+
+var errInflatedTooLarge = errors.New("decompressed body too large")
+
+// body works out how the request body is framed and returns a reader for
+// exactly that body, recording the length in contentLength (-1 when it isn't
+// known up front). A non-zero status means the framing is unusable and the
+// request must be answered with it, then the connection closed.
+func (r *request) body(rd *bufio.Reader) (io.Reader, int) {
+	r.contentLength = -1
+
+	if te := r.headers.get("Transfer-Encoding"); te != "" {
+		// A length alongside the encoding is how requests get smuggled past
+		// proxies that disagree on which one wins, so refuse both.
+		if r.headers.get("Content-Length") != "" || !strings.EqualFold(strings.TrimSpace(te), "chunked") {
+			return nil, statusBadRequest
+		}
+		r.chunked = true
+
+		return &chunkedReader{r: rd}, 0
+	}
+
+	if values := r.headers["Content-Length"]; len(values) > 0 {
+		n, err := parseContentLength(values)
+		if err != nil {
+			return nil, statusBadRequest
+		}
+		r.contentLength = int(n)
+
+		return &io.LimitedReader{R: rd, N: n}, 0
+	}
+
+	if r.method != methodPost && r.method != methodPut {
+		r.contentLength = 0
+
+		return &io.LimitedReader{R: rd, N: 0}, 0
+	}
+
+	// HTTP/1.0 clients may send a body delimited by closing their side of
+	// the connection; HTTP/1.1 ones have to say how long it is.
+	if r.httpVersion == "HTTP/1.0" {
+		return rd, 0
+	}
+
+	return nil, statusLengthRequired
+}
+
+// parseContentLength parses the Content-Length values of a request. Repeats
+// are allowed as long as they all agree.
+func parseContentLength(values []string) (int64, error) {
+	n := int64(-1)
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" || strings.Trim(s, "0123456789") != "" {
+				return 0, fmt.Errorf("invalid Content-Length %q", v)
+			}
+			m, err := strconv.ParseInt(s, 10, strconv.IntSize)
+			if err != nil {
+				return 0, fmt.Errorf("invalid Content-Length %q", v)
+			}
+			if n >= 0 && m != n {
+				return 0, fmt.Errorf("conflicting Content-Length values %q", values)
+			}
+			n = m
+		}
+	}
+
+	return n, nil
+}
+
+// chunkedReader decodes a chunked request body, stopping after the last
+// chunk and its trailers.
+type chunkedReader struct {
+	r   *bufio.Reader
+	n   int64 // bytes left in the current chunk
+	err error
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.err == nil && c.n == 0 {
+		c.n, c.err = c.nextChunk()
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	if err == nil && c.n == 0 {
+		err = c.endChunk()
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+
+	return n, err
+}
+
+// nextChunk reads a chunk size line, returning io.EOF after the last chunk
+// once its trailers have been skipped.
+func (c *chunkedReader) nextChunk() (int64, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return 0, err
+	}
+
+	// Chunk extensions carry nothing we use.
+	size, _, _ := bytes.Cut(line, []byte(";"))
+	size = bytes.TrimRight(size, " \t")
+	if len(size) == 0 || len(size) > 16 {
+		return 0, errBadChunk
+	}
+	n, err := strconv.ParseUint(string(size), 16, 63)
+	if err != nil {
+		return 0, errBadChunk
+	}
+	if n > 0 {
+		return int64(n), nil
+	}
+
+	for i := 0; ; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return 0, err
+		}
+		if len(line) == 0 {
+			return 0, io.EOF
+		}
+		if i == maxTrailers {
+			return 0, errBadChunk
+		}
+	}
+}
+
+// endChunk consumes the line break that follows the data of a chunk.
+func (c *chunkedReader) endChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if len(line) != 0 {
+		return errBadChunk
+	}
+
+	return nil
+}
+
+// readLine reads a CRLF-terminated line no longer than the reader's buffer,
+// without the CRLF.
+func (c *chunkedReader) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, errBadChunk
+	}
+
+	line, ok := bytes.CutSuffix(line, []byte("\r\n"))
+	if !ok {
+		return nil, errBadChunk
+	}
+
+	return line, nil
+}
+
+// decodeBody undoes the Content-Encoding of an uploaded body, so that what
+// is stored is the content itself. A non-zero status means the encoding is
+// unusable and the request must be answered with it.
+func (s *Server) decodeBody(body io.Reader, req request) (io.Reader, int) {
+	switch encoding := strings.ToLower(strings.TrimSpace(req.headers.get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return body, 0
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, statusBadRequest
+		}
+		if s.opts.MaxInflatedSize <= 0 {
+			return zr, 0
+		}
+
+		// A small upload can't expand into an unbounded one.
+		return &limitReader{r: zr, n: s.opts.MaxInflatedSize, err: errInflatedTooLarge}, 0
+	default:
+		return nil, statusUnsupportedMediaType
+	}
+}
+
+// limitReader fails reads past n bytes with err, n being what is left,
+// where io.LimitReader would end quietly. It reads a byte more than it
+// lets through to tell n bytes from more.
+type limitReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, l.err
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if l.n -= int64(n); l.n < 0 {
+		return 0, l.err
+	}
+
+	return n, err
+}
+
+// bodyErrorStatus returns the status to answer a failed read of an upload
+// body with.
+func bodyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errInflatedTooLarge), errors.Is(err, errLinkTooLarge), errors.Is(err, errFileTooLarge):
+		return statusPayloadTooLarge
+	case errors.Is(err, gzip.ErrChecksum), errors.Is(err, gzip.ErrHeader), errors.Is(err, errDigestMismatch):
+		return statusBadRequest
+	}
+
+	return statusInternalServerError
+}
@@ -0,0 +1,126 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestBodyFraming(t *testing.T) {
+	s := servertest.New(t, servertest.Options())
+	c := s.Client()
+
+	for _, tt := range []struct {
+		name   string
+		raw    string
+		status int
+		stored string
+	}{
+		{
+			name:   "content-length",
+			raw:    "POST /files/a HTTP/1.1\r\nContent-Length: 5\r\n\r\nhello",
+			status: http.StatusCreated,
+			stored: "hello",
+		},
+		{
+			name:   "zero content-length",
+			raw:    "POST /files/a HTTP/1.1\r\nContent-Length: 0\r\n\r\n",
+			status: http.StatusCreated,
+			stored: "",
+		},
+		{
+			name:   "repeated content-length",
+			raw:    "POST /files/a HTTP/1.1\r\nContent-Length: 5, 5\r\nContent-Length: 5\r\n\r\nhello",
+			status: http.StatusCreated,
+			stored: "hello",
+		},
+		{
+			name:   "chunked",
+			raw:    "POST /files/a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n3;ext=1\r\nhel\r\n2\r\nlo\r\n0\r\nX-Trailer: 1\r\n\r\n",
+			status: http.StatusCreated,
+			stored: "hello",
+		},
+		{
+			name:   "http/1.0 to eof",
+			raw:    "POST /files/a HTTP/1.0\r\n\r\nhello",
+			status: http.StatusCreated,
+			stored: "hello",
+		},
+		{
+			name:   "http/1.1 without length",
+			raw:    "POST /files/a HTTP/1.1\r\n\r\nhello",
+			status: http.StatusLengthRequired,
+		},
+		{
+			name:   "put without length",
+			raw:    "PUT /files/a HTTP/1.1\r\n\r\nhello",
+			status: http.StatusLengthRequired,
+		},
+		{
+			name:   "invalid content-length",
+			raw:    "POST /files/a HTTP/1.1\r\nContent-Length: +5\r\n\r\nhello",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "conflicting content-length",
+			raw:    "POST /files/a HTTP/1.1\r\nContent-Length: 5\r\nContent-Length: 4\r\n\r\nhello",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "chunked and content-length",
+			raw:    "POST /files/a HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Length: 5\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "unknown transfer-encoding",
+			raw:    "POST /files/a HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "malformed chunk",
+			raw:    "POST /files/a HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\nhello\r\n0\r\n\r\n",
+			status: http.StatusInternalServerError,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c.Raw(tt.raw).AssertStatus(tt.status)
+			if tt.status == http.StatusCreated {
+				c.Get("/files/a").AssertStatus(http.StatusOK).AssertBody(tt.stored)
+			}
+		})
+	}
+
+	c.Raw("GET /echo/abc HTTP/1.1\r\n\r\n").AssertStatus(http.StatusOK).AssertBody("abc")
+}
+
+func TestGzipUpload(t *testing.T) {
+	opts := servertest.Options()
+	opts.MaxInflatedSize = 1000
+	c := servertest.NewPipe(t, opts).Client()
+
+	gz := func(s string) *bytes.Reader {
+		var b bytes.Buffer
+		zw := gzip.NewWriter(&b)
+		zw.Write([]byte(s))
+		zw.Close()
+		return bytes.NewReader(b.Bytes())
+	}
+	encoded := func(encoding string) http.Header {
+		return http.Header{"Content-Encoding": {encoding}}
+	}
+
+	c.Do(http.MethodPut, "/files/a.txt", gz("hello"), encoded("gzip")).AssertStatus(http.StatusCreated)
+	c.Get("/files/a.txt").AssertBody("hello")
+
+	c.Do(http.MethodPut, "/files/b.txt", gz(strings.Repeat("a", 1000)), encoded("gzip")).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodPut, "/files/c.txt", gz(strings.Repeat("a", 1001)), encoded("gzip")).AssertStatus(http.StatusRequestEntityTooLarge)
+	c.Get("/files/c.txt").AssertStatus(http.StatusNotFound)
+
+	c.Do(http.MethodPut, "/files/d.txt", strings.NewReader("hello"), encoded("gzip")).AssertStatus(http.StatusBadRequest)
+	c.Do(http.MethodPut, "/files/d.txt", strings.NewReader("hello"), encoded("br")).AssertStatus(http.StatusUnsupportedMediaType)
+	c.Do(http.MethodPut, "/files/d.txt", strings.NewReader("hello"), encoded("identity")).AssertStatus(http.StatusCreated)
+}
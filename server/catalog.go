@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCatalogLimit = 100
+
+// catalogRecord is one upload as recorded in the catalog.
+type catalogRecord struct {
+	uploadEvent
+	Time time.Time `json:"time"`
+}
+
+// catalog records every upload in an append-only file of JSON lines, kept
+// in memory as well for querying. A plain file keeps the server free of
+// dependencies; uploads are rare enough next to reads that it doesn't need
+// to be a database. Only the newest max records are kept: once the file
+// holds twice that many it is compacted down to them, so neither it nor
+// the memory kept grows without bound.
+type catalog struct {
+	mu      sync.Mutex
+	path    string
+	max     int
+	f       *os.File
+	records []catalogRecord
+}
+
+// openCatalog loads the catalog at path, creating it if needed, keeping the
+// newest max records or every one if max is 0. A last line cut short by a
+// crash is skipped.
+func openCatalog(path string, max int) (*catalog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &catalog{path: path, max: max, f: f}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	lines := 0
+	for sc.Scan() {
+		lines++
+		var rec catalogRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			continue
+		}
+		c.records = append(c.records, rec)
+		if c.full() {
+			c.trim()
+		}
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error reading catalog %s: %v", path, err)
+	}
+
+	if c.max > 0 && lines > c.max {
+		c.trim()
+		if err := c.compact(); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error compacting catalog %s: %v", path, err)
+		}
+		return c, nil
+	}
+
+	// Finish off a cut short line so the next record starts on its own.
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			f.Write([]byte("\n"))
+		}
+	}
+
+	return c, nil
+}
+
+func (c *catalog) add(ev uploadEvent) error {
+	rec := catalogRecord{uploadEvent: ev, Time: time.Now().UTC()}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	c.records = append(c.records, rec)
+	if c.full() {
+		c.trim()
+		return c.compact()
+	}
+
+	return nil
+}
+
+// full reports whether twice the records kept have piled up.
+func (c *catalog) full() bool {
+	return c.max > 0 && len(c.records) >= 2*c.max
+}
+
+// trim drops all but the newest max records.
+func (c *catalog) trim() {
+	if c.max > 0 && len(c.records) > c.max {
+		c.records = append([]catalogRecord(nil), c.records[len(c.records)-c.max:]...)
+	}
+}
+
+// compact rewrites the file to hold just the records kept, replacing it in
+// one rename so a crash leaves either the old file or the new one.
+func (c *catalog) compact() error {
+	tmp := c.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range c.records {
+		if err = enc.Encode(rec); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, c.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// Appends go to the new file from here on.
+	nf, err := os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	c.f.Close()
+	c.f = nf
+
+	return nil
+}
+
+func (c *catalog) close() error {
+	return c.f.Close()
+}
+
+// catalogQuery narrows down catalog records. Zero fields match everything.
+type catalogQuery struct {
+	// Prefix matches the start of the file name.
+	Prefix   string
+	Uploader string
+	Checksum string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+func parseCatalogQuery(q url.Values) (catalogQuery, error) {
+	cq := catalogQuery{
+		Prefix:   q.Get("prefix"),
+		Uploader: q.Get("uploader"),
+		Checksum: q.Get("checksum"),
+		Limit:    defaultCatalogLimit,
+	}
+
+	for param, t := range map[string]*time.Time{"since": &cq.Since, "until": &cq.Until} {
+		if v := q.Get(param); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return cq, fmt.Errorf("invalid %s %q", param, v)
+			}
+			*t = parsed
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return cq, fmt.Errorf("invalid limit %q", v)
+		}
+		cq.Limit = n
+	}
+
+	return cq, nil
+}
+
+// find returns the newest records matching q, newest first.
+func (c *catalog) find(q catalogQuery) []catalogRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	found := []catalogRecord{}
+	for i := len(c.records) - 1; i >= 0 && len(found) < q.Limit; i-- {
+		rec := c.records[i]
+		switch {
+		case !strings.HasPrefix(rec.Filename, q.Prefix),
+			q.Uploader != "" && rec.UploaderIP != q.Uploader,
+			q.Checksum != "" && rec.Checksum != q.Checksum,
+			!q.Since.IsZero() && rec.Time.Before(q.Since),
+			!q.Until.IsZero() && !rec.Time.Before(q.Until):
+			continue
+		}
+		found = append(found, rec)
+	}
+
+	return found
+}
+
+// uploaded records a finished upload in the catalog and tells the webhook
+// about it, whichever of them are configured.
+func (s *Server) uploaded(name string, f receivedFile, ip string) {
+	if s.catalog == nil && s.opts.WebhookURL == "" {
+		return
+	}
+	ev := newUploadEvent(name, f, ip)
+
+	if s.catalog != nil {
+		if err := s.catalog.add(ev); err != nil {
+			s.log.Error("error recording upload", "file", name, "err", err)
+		}
+	}
+	if s.opts.WebhookURL != "" {
+		go s.notifyUpload(ev)
+	}
+}
+
+// serveCatalog answers the admin API's GET /catalog, which lists recorded
+// uploads filtered by the prefix, uploader, checksum, since, until and limit
+// query parameters.
+func (s *Server) serveCatalog(conn net.Conn, req request) error {
+	if s.catalog == nil {
+		conn.Write(buildResponse(statusNotFound, jsonContent(map[string]string{"error": "no catalog configured"})))
+		return nil
+	}
+
+	q, err := parseCatalogQuery(req.query)
+	if err != nil {
+		conn.Write(buildResponse(statusBadRequest, jsonContent(map[string]string{"error": err.Error()})))
+		return nil
+	}
+	conn.Write(buildResponse(statusOK, jsonContent(s.catalog.find(q))))
+
+	return nil
+}
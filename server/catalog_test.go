@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/sha256"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.jsonl")
+
+	c, err := openCatalog(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add(newUploadEvent("docs/a.txt", receivedData("hello"), "10.0.0.1"))
+	c.add(newUploadEvent("docs/b.txt", receivedData("world"), "10.0.0.2"))
+	c.add(newUploadEvent("c.txt", receivedData("hello"), "10.0.0.1"))
+	c.close()
+
+	// A write cut short must not keep the catalog from loading.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"filename":"d.t`)
+	f.Close()
+
+	c, err = openCatalog(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.add(newUploadEvent("e.txt", receivedData("!"), "10.0.0.3"))
+	c.close()
+
+	c, err = openCatalog(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"e.txt", "c.txt", "docs/b.txt", "docs/a.txt"}},
+		{"limit=2", []string{"e.txt", "c.txt"}},
+		{"prefix=docs/", []string{"docs/b.txt", "docs/a.txt"}},
+		{"uploader=10.0.0.1", []string{"c.txt", "docs/a.txt"}},
+		{"checksum=" + newUploadEvent("", receivedData("world"), "").Checksum, []string{"docs/b.txt"}},
+		{"since=2000-01-01T00:00:00Z&until=2001-01-01T00:00:00Z", nil},
+	} {
+		values, _ := url.ParseQuery(tt.query)
+		q, err := parseCatalogQuery(values)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+
+		var got []string
+		for _, rec := range c.find(q) {
+			got = append(got, rec.Filename)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%q: got %q, want %q", tt.query, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("%q: got %q, want %q", tt.query, got, tt.want)
+			}
+		}
+	}
+
+	for _, query := range []string{"limit=0", "since=yesterday"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := parseCatalogQuery(values); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
+
+func TestCatalogCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.jsonl")
+	lines := func() int {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(data), "\n")
+	}
+	names := func(c *catalog) []string {
+		var got []string
+		for _, rec := range c.find(catalogQuery{Limit: 100}) {
+			got = append(got, rec.Filename)
+		}
+		return got
+	}
+
+	c, err := openCatalog(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		c.add(newUploadEvent(strconv.Itoa(i), receivedData("x"), ""))
+	}
+	c.close()
+
+	// Opened with a smaller bound, the file is cut down to the newest.
+	c, err = openCatalog(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(c), []string{"9", "8", "7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if n := lines(); n != 3 {
+		t.Errorf("got %d lines after opening, want 3", n)
+	}
+
+	// It grows to twice the bound before being compacted again.
+	c.add(newUploadEvent("10", receivedData("x"), ""))
+	c.add(newUploadEvent("11", receivedData("x"), ""))
+	if n := lines(); n != 5 {
+		t.Errorf("got %d lines, want 5", n)
+	}
+	c.add(newUploadEvent("12", receivedData("x"), ""))
+	if n := lines(); n != 3 {
+		t.Errorf("got %d lines after compacting, want 3", n)
+	}
+	c.add(newUploadEvent("13", receivedData("x"), ""))
+	if got, want := names(c), []string{"13", "12", "11", "10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	c.close()
+
+	c, err = openCatalog(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	if got, want := names(c), []string{"13", "12", "11"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after reopening got %q, want %q", got, want)
+	}
+}
+
+// receivedData returns what receiveFile learns of an upload of data.
+func receivedData(data string) receivedFile {
+	sum := sha256.Sum256([]byte(data))
+	return receivedFile{size: int64(len(data)), sum: sum[:]}
+}
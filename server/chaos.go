@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChaosConfig sets how often each kind of fault is injected into responses,
+// as probabilities between 0 and 1. At most one fault other than latency
+// hits a request, tried in the order drop, error, truncate.
+type ChaosConfig struct {
+	// Latency delays the response by up to LatencyMax.
+	Latency    float64
+	LatencyMax time.Duration
+	// Error answers with a random 5xx instead of handling the request.
+	Error float64
+	// Truncate cuts the response short somewhere in its body.
+	Truncate float64
+	// Drop closes the connection without answering.
+	Drop float64
+}
+
+func (c ChaosConfig) enabled() bool {
+	return c.Latency > 0 || c.Error > 0 || c.Truncate > 0 || c.Drop > 0
+}
+
+// chaosFlag parses -chaos values like "latency=0.1:2s,error=0.05,drop=0.01".
+type chaosFlag ChaosConfig
+
+func (f *chaosFlag) String() string {
+	if f == nil || !ChaosConfig(*f).enabled() {
+		return ""
+	}
+
+	var parts []string
+	if f.Latency > 0 {
+		parts = append(parts, fmt.Sprintf("latency=%g:%s", f.Latency, f.LatencyMax))
+	}
+	for _, p := range []struct {
+		name string
+		v    float64
+	}{{"error", f.Error}, {"truncate", f.Truncate}, {"drop", f.Drop}} {
+		if p.v > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%g", p.name, p.v))
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func (f *chaosFlag) Set(value string) error {
+	c := ChaosConfig{LatencyMax: time.Second}
+	for _, part := range strings.Split(value, ",") {
+		name, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return fmt.Errorf("invalid chaos setting %q, want name=probability", part)
+		}
+
+		var max string
+		if name == "latency" {
+			v, max, _ = strings.Cut(v, ":")
+		}
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil || p < 0 || p > 1 {
+			return fmt.Errorf("invalid chaos probability %q", v)
+		}
+
+		switch name {
+		case "latency":
+			c.Latency = p
+			if max != "" {
+				if c.LatencyMax, err = time.ParseDuration(max); err != nil || c.LatencyMax <= 0 {
+					return fmt.Errorf("invalid chaos latency %q", max)
+				}
+			}
+		case "error":
+			c.Error = p
+		case "truncate":
+			c.Truncate = p
+		case "drop":
+			c.Drop = p
+		default:
+			return fmt.Errorf("unknown chaos fault %q", name)
+		}
+	}
+	*f = chaosFlag(c)
+
+	return nil
+}
+
+var chaosStatuses = []int{
+	statusInternalServerError,
+	statusBadGateway,
+	statusServiceUnavailable,
+	statusGatewayTimeout,
+}
+
+// chaos decides which faults to inject, from a seeded source so a run can
+// be repeated.
+type chaos struct {
+	cfg ChaosConfig
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newChaos(cfg ChaosConfig, seed int64) *chaos {
+	return &chaos{cfg: cfg, rand: rand.New(rand.NewSource(seed))}
+}
+
+func (c *chaos) roll(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rand.Float64() < p
+}
+
+func (c *chaos) intn(n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.rand.Intn(n)
+}
+
+// inject applies the faults rolled for one request. It returns the
+// connection to answer on, which may cut the response short, or nil if the
+// request has already been dealt with.
+func (c *chaos) inject(ex *exchangeConn) *exchangeConn {
+	if c.roll(c.cfg.Latency) {
+		time.Sleep(time.Duration(c.intn(int(c.cfg.LatencyMax)) + 1))
+	}
+
+	switch {
+	case c.roll(c.cfg.Drop):
+		ex.forceClose()
+		return nil
+	case c.roll(c.cfg.Error):
+		ex.Write(buildResponse(chaosStatuses[c.intn(len(chaosStatuses))], nil))
+		return nil
+	case c.roll(c.cfg.Truncate):
+		ex.forceClose()
+		ex.Conn = &truncatedConn{Conn: ex.Conn, chaos: c, left: -1}
+	}
+
+	return ex
+}
+
+// truncatedConn lets the response head through, then a random part of the
+// body, and silently drops the rest.
+type truncatedConn struct {
+	net.Conn
+	chaos *chaos
+	left  int
+}
+
+func (t *truncatedConn) Write(p []byte) (int, error) {
+	n := len(p)
+	if t.left < 0 {
+		head, body, ok := bytes.Cut(p, []byte("\r\n\r\n"))
+		if !ok {
+			return t.Conn.Write(p)
+		}
+
+		size := 4096
+		if _, framed := inspectHead(head); framed {
+			size = max(len(body), contentLength(head))
+		}
+		t.left = t.chaos.intn(max(size, 1))
+		p = p[:len(head)+4]
+		t.Conn.Write(p)
+		p = body
+	}
+
+	p = p[:min(len(p), t.left)]
+	t.left -= len(p)
+	if _, err := t.Conn.Write(p); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// contentLength returns the Content-Length of a response head, or 0.
+func contentLength(head []byte) int {
+	for _, line := range strings.Split(string(head), "\r\n")[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		if strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			n, _ := strconv.Atoi(strings.TrimSpace(value))
+			return n
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,69 @@
+package server_test
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func chaosOptions(t *testing.T, chaos string) server.Options {
+	t.Helper()
+
+	opts := servertest.Options()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-chaos", chaos, "-chaos-seed", "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return opts
+}
+
+func TestChaos(t *testing.T) {
+	c := servertest.NewPipe(t, chaosOptions(t, "error=1")).Client()
+	if status := c.Get("/echo/abc").StatusCode; status < 500 {
+		t.Errorf("got status %d, want a 5xx", status)
+	}
+
+	s := servertest.NewPipe(t, chaosOptions(t, "drop=1"))
+	conn, _ := s.Dial()
+	io.WriteString(conn, "GET /echo/abc HTTP/1.1\r\n\r\n")
+	if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err == nil {
+		t.Errorf("got a response with drop=1")
+	}
+	conn.Close()
+
+	s = servertest.NewPipe(t, chaosOptions(t, "truncate=1"))
+	conn, _ = s.Dial()
+	io.WriteString(conn, "GET /echo/abcdefghijklmnopqrstuvwxyz HTTP/1.1\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v reading a truncated body, want unexpected EOF", err)
+	}
+	conn.Close()
+}
+
+func TestChaosFlag(t *testing.T) {
+	for _, bad := range []string{"error", "error=2", "latency=0.5:soon", "explode=0.1"} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		var opts server.Options
+		opts.RegisterFlags(fs)
+		if err := fs.Parse([]string{"-chaos", bad}); err == nil {
+			t.Errorf("-chaos %q: expected an error", bad)
+		}
+	}
+
+	opts := chaosOptions(t, "latency=0.5:20ms, truncate=0.1")
+	if opts.Chaos.Latency != 0.5 || opts.Chaos.LatencyMax.Milliseconds() != 20 || opts.Chaos.Truncate != 0.1 {
+		t.Errorf("got %+v", opts.Chaos)
+	}
+}
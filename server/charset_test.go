@@ -0,0 +1,47 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestCharset(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+	c.Get("/echo/h%C3%A9").AssertHeader("Content-Type", "text/plain; charset=utf-8")
+	c.Get("/upload").AssertHeader("Content-Type", "text/html; charset=utf-8")
+	c.Do(http.MethodPut, "/files/a.json", strings.NewReader("{}"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/a.json").AssertHeader("Content-Type", "application/json")
+
+	opts := servertest.Options()
+	opts.Charset = "iso-8859-1"
+	c = servertest.NewPipe(t, opts).Client()
+	c.Get("/echo/hi").AssertHeader("Content-Type", "text/plain; charset=iso-8859-1")
+
+	opts.Charset = ""
+	c = servertest.NewPipe(t, opts).Client()
+	c.Get("/echo/hi").AssertHeader("Content-Type", "text/plain")
+}
+
+func TestDefaultsPerServer(t *testing.T) {
+	opts := servertest.Options()
+	opts.Charset = "iso-8859-1"
+	opts.ServerHeader = "first"
+	first := servertest.NewPipe(t, opts).Client()
+	opts.Charset = "utf-8"
+	opts.ServerHeader = "second"
+	second := servertest.NewPipe(t, opts).Client()
+
+	first.Get("/echo/hi").
+		AssertHeader("Content-Type", "text/plain; charset=iso-8859-1").
+		AssertHeader("Server", "first")
+	second.Get("/echo/hi").
+		AssertHeader("Content-Type", "text/plain; charset=utf-8").
+		AssertHeader("Server", "second")
+	first.Get("/files/missing.txt").
+		AssertStatus(http.StatusNotFound).
+		AssertHeader("Content-Type", "text/html; charset=iso-8859-1").
+		AssertHeader("Server", "first")
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ClientCertHeaders names the headers the details of a verified client
+// certificate are passed to the upstream in. An empty name leaves that
+// detail out.
+type ClientCertHeaders struct {
+	Subject     string
+	SAN         string
+	Fingerprint string
+}
+
+// loadClientCAs reads the PEM bundle of CAs client certificates are
+// verified against.
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+
+	return pool, nil
+}
+
+// apply sets the headers in h to the details of cert. Copies sent by the
+// client are always removed first, so only the server can vouch for a
+// certificate.
+func (c ClientCertHeaders) apply(h header, cert *x509.Certificate) {
+	for _, name := range []string{c.Subject, c.SAN, c.Fingerprint} {
+		if name != "" {
+			h.del(name)
+		}
+	}
+	if cert == nil {
+		return
+	}
+
+	if c.Subject != "" {
+		h.set(c.Subject, cert.Subject.String())
+	}
+	if san := certSANs(cert); c.SAN != "" && san != "" {
+		h.set(c.SAN, san)
+	}
+	if c.Fingerprint != "" {
+		sum := sha256.Sum256(cert.Raw)
+		h.set(c.Fingerprint, hex.EncodeToString(sum[:]))
+	}
+}
+
+// certSANs lists the subject alternative names of cert the way OpenSSL
+// prints them, e.g. "DNS:example.com, IP:192.0.2.1".
+func certSANs(cert *x509.Certificate) string {
+	var sans []string
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+
+	return strings.Join(sans, ", ")
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net"
+	"sync"
+)
+
+// connLimiter caps the connections open at once from each source IP. It
+// goes by the address of the peer, not X-Forwarded-For: connections are
+// refused before any request on them is read.
+type connLimiter struct {
+	mu    sync.Mutex
+	limit int
+	conns map[string]int
+}
+
+func newConnLimiter(limit int) *connLimiter {
+	return &connLimiter{limit: limit, conns: make(map[string]int)}
+}
+
+// acquire counts a connection from addr, reporting false if the source
+// already has as many open as it may. A successful acquire must be matched
+// by a release.
+func (l *connLimiter) acquire(addr net.Addr) bool {
+	ip := connIP(addr)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns[ip] >= l.limit {
+		return false
+	}
+	l.conns[ip]++
+
+	return true
+}
+
+func (l *connLimiter) release(addr net.Addr) {
+	ip := connIP(addr)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// connIP returns the IP part of addr, or all of it if it has no port.
+func connIP(addr net.Addr) string {
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	return ip
+}
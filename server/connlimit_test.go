@@ -0,0 +1,61 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestMaxConnsPerIP(t *testing.T) {
+	opts := servertest.Options()
+	opts.MaxConnsPerIP = 2
+	s := servertest.New(t, opts)
+
+	// open dials a connection and makes a request on it, reporting whether
+	// it was answered.
+	open := func() (net.Conn, bool) {
+		t.Helper()
+
+		conn, err := s.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		io.WriteString(conn, "GET /echo/hi HTTP/1.1\r\nHost: x\r\n\r\n")
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			return conn, false
+		}
+		resp.Body.Close()
+
+		return conn, resp.StatusCode == http.StatusOK
+	}
+
+	first, ok := open()
+	if !ok {
+		t.Fatal("first connection wasn't served")
+	}
+	if _, ok := open(); !ok {
+		t.Fatal("second connection wasn't served")
+	}
+	if _, ok := open(); ok {
+		t.Error("third connection was served over the cap")
+	}
+
+	first.Close()
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if _, ok := open(); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no connection served after one was closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
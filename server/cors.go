@@ -0,0 +1,191 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const methodOptions = "OPTIONS"
+
+// corsMaxAge is how long browsers may cache a preflight response, in seconds.
+const corsMaxAge = "600"
+
+// CORSPolicy lets pages from Origins make cross-origin requests to the paths
+// under Prefix. An origin of "*" allows any.
+type CORSPolicy struct {
+	Prefix  string
+	Origins []string
+}
+
+func (p CORSPolicy) matches(path string) bool {
+	if p.Prefix == "/" {
+		return true
+	}
+
+	return path == p.Prefix || strings.HasPrefix(path, strings.TrimSuffix(p.Prefix, "/")+"/")
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or ""
+// if the policy doesn't allow it.
+func (p CORSPolicy) allowOrigin(origin string) string {
+	for _, o := range p.Origins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+
+	return ""
+}
+
+// corsPolicy returns the policy with the longest prefix matching path.
+func (s *Server) corsPolicy(path string) (CORSPolicy, bool) {
+	var best CORSPolicy
+	found := false
+	for _, p := range s.opts.CORS {
+		if p.matches(path) && (!found || len(p.Prefix) > len(best.Prefix)) {
+			best, found = p, true
+		}
+	}
+
+	return best, found
+}
+
+// routeMethods is the route table: the methods the top-level path of req
+// answers to, or nil if there is nothing there. It backs the Allow header of
+// OPTIONS responses and CORS preflights.
+func (s *Server) routeMethods(req request) []string {
+	switch req.pathParts[1] {
+	case "", "user-agent", "ip", "headers", "status", "delay", "echo-stream", "search", "healthz", "readyz", "watch":
+		return []string{methodGet}
+	case "echo", "upload":
+		return []string{methodGet, methodPost}
+	case "files":
+		if s.authEnabled() {
+			return []string{methodGet, methodHead, methodPost, methodPut, methodDelete, methodMove}
+		}
+		return []string{methodGet, methodHead, methodPost, methodPut}
+	case "ui":
+		if s.authEnabled() {
+			return []string{methodGet}
+		}
+	case "oidc":
+		if s.oidc != nil {
+			return []string{methodGet}
+		}
+	case "trash":
+		if s.authEnabled() {
+			return []string{methodGet, methodPost, methodDelete}
+		}
+	case "_dev":
+		if s.opts.Dev {
+			return []string{methodGet}
+		}
+	}
+
+	return nil
+}
+
+// serveCORS applies the CORS policy covering req, adding its headers to the
+// response on conn. It reports whether req was a preflight and has been
+// answered.
+func (s *Server) serveCORS(conn net.Conn, req request) bool {
+	policy, ok := s.corsPolicy(req.cleanPath())
+	if !ok {
+		return false
+	}
+	// The answer depends on who is asking, so caches have to keep them apart.
+	req.varyOn("Origin")
+
+	origin := req.headers.get("Origin")
+	allowed := policy.allowOrigin(origin)
+	if origin == "" || allowed == "" {
+		return false
+	}
+
+	method := req.headers.get("Access-Control-Request-Method")
+	if req.method != methodOptions || method == "" {
+		addResponseHeader(conn, "Access-Control-Allow-Origin", allowed)
+		return false
+	}
+
+	methods := s.routeMethods(req)
+	if len(methods) == 0 {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return true
+	}
+
+	h := header{}
+	if slices.Contains(methods, method) {
+		h.set("Access-Control-Allow-Origin", allowed)
+		h.set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if requested := req.headers.get("Access-Control-Request-Headers"); requested != "" {
+			h.set("Access-Control-Allow-Headers", requested)
+		}
+		h.set("Access-Control-Max-Age", corsMaxAge)
+	}
+	conn.Write(buildResponseHeaders(statusNoContent, h, nil))
+
+	return true
+}
+
+// serveOptions answers a plain OPTIONS request with the methods the path
+// allows.
+func (s *Server) serveOptions(conn net.Conn, req request) {
+	methods := s.routeMethods(req)
+	if len(methods) == 0 {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return
+	}
+
+	h := header{"Allow": {strings.Join(append(methods, methodOptions), ", ")}}
+	conn.Write(buildResponseHeaders(statusNoContent, h, nil))
+}
+
+// corsFlag collects repeated '[/prefix=]origin,origin' flags. Without a
+// prefix the policy covers every path.
+type corsFlag []CORSPolicy
+
+func (f *corsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, p := range *f {
+		parts = append(parts, p.Prefix+"="+strings.Join(p.Origins, ","))
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, " ")
+}
+
+func (f *corsFlag) Set(value string) error {
+	prefix, origins := "/", value
+	if strings.HasPrefix(value, "/") {
+		var ok bool
+		prefix, origins, ok = strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("expected '/prefix=origin,...', got %q", value)
+		}
+	}
+
+	var p CORSPolicy
+	p.Prefix = prefix
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSuffix(strings.TrimSpace(o), "/"); o != "" {
+			p.Origins = append(p.Origins, o)
+		}
+	}
+	if len(p.Origins) == 0 {
+		return fmt.Errorf("no origins in %q", value)
+	}
+	*f = append(*f, p)
+
+	return nil
+}
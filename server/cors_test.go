@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestCORS(t *testing.T) {
+	opts := servertest.Options()
+	opts.CORS = []server.CORSPolicy{
+		{Prefix: "/", Origins: []string{"*"}},
+		{Prefix: "/files", Origins: []string{"https://app.example"}},
+	}
+	c := servertest.NewPipe(t, opts).Client()
+
+	c.Do(http.MethodGet, "/echo/hi", nil, http.Header{"Origin": {"https://other.example"}}).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Access-Control-Allow-Origin", "*").
+		AssertHeader("Vary", "Origin")
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("a"), http.Header{"Origin": {"https://app.example"}}).
+		AssertStatus(http.StatusCreated).
+		AssertHeader("Access-Control-Allow-Origin", "https://app.example")
+	c.Do(http.MethodGet, "/files/a.txt", nil, http.Header{"Origin": {"https://other.example"}}).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Access-Control-Allow-Origin", "").
+		AssertBody("a")
+	// The /files policy covers the path however the client spells it.
+	for _, p := range []string{"/%66iles/a.txt", "/files//a.txt", "/files/./a.txt", "/x/../files/a.txt"} {
+		c.Do(http.MethodGet, p, nil, http.Header{"Origin": {"https://other.example"}}).
+			AssertHeader("Access-Control-Allow-Origin", "")
+		c.Do(http.MethodGet, p, nil, http.Header{"Origin": {"https://app.example"}}).
+			AssertHeader("Access-Control-Allow-Origin", "https://app.example")
+	}
+
+	preflight := http.Header{
+		"Origin":                         {"https://app.example"},
+		"Access-Control-Request-Method":  {"PUT"},
+		"Access-Control-Request-Headers": {"content-type"},
+	}
+	c.Do(http.MethodOptions, "/files/a.txt", nil, preflight).
+		AssertStatus(http.StatusNoContent).
+		AssertHeader("Access-Control-Allow-Origin", "https://app.example").
+		AssertHeader("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT").
+		AssertHeader("Access-Control-Allow-Headers", "content-type")
+
+	preflight.Set("Access-Control-Request-Method", "DELETE")
+	c.Do(http.MethodOptions, "/files/a.txt", nil, preflight).
+		AssertStatus(http.StatusNoContent).
+		AssertHeader("Access-Control-Allow-Origin", "")
+
+	preflight.Set("Access-Control-Request-Method", "GET")
+	c.Do(http.MethodOptions, "/echo/hi", nil, preflight).
+		AssertStatus(http.StatusNoContent).
+		AssertHeader("Access-Control-Allow-Methods", "GET, POST")
+	c.Do(http.MethodOptions, "/nowhere", nil, preflight).AssertStatus(http.StatusNotFound)
+}
+
+func TestOptions(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	c.Do(http.MethodOptions, "/files/a.txt", nil, nil).
+		AssertStatus(http.StatusNoContent).
+		AssertHeader("Allow", "GET, HEAD, POST, PUT, OPTIONS").
+		AssertHeader("Access-Control-Allow-Origin", "")
+	c.Do(http.MethodOptions, "/ui", nil, nil).AssertStatus(http.StatusNotFound)
+}
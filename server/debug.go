@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wantsJSON reports whether the client asked for a JSON rendering, either via
+// ?format=json or by accepting application/json.
+func wantsJSON(req request) bool {
+	if format := req.query.Get("format"); format != "" {
+		return format == "json"
+	}
+	req.varyOn("Accept")
+
+	return strings.Contains(req.headers.get("Accept"), contentTypeJSON)
+}
+
+// headersContent renders the request headers, one per line or as a JSON
+// object. Repeated headers are joined with commas.
+func headersContent(req request) *content {
+	if wantsJSON(req) {
+		values := make(map[string]string, len(req.headers))
+		for name, v := range req.headers {
+			values[name] = strings.Join(v, ", ")
+		}
+		return jsonContent(map[string]any{"headers": values})
+	}
+
+	var b strings.Builder
+	for _, name := range req.headers.keys() {
+		fmt.Fprintf(&b, "%s: %s\n", name, strings.Join(req.headers[name], ", "))
+	}
+
+	return &content{contentType: contentTypeTextPlain, body: []byte(b.String())}
+}
+
+// ipContent renders the address of the client making the request.
+func ipContent(req request) *content {
+	if wantsJSON(req) {
+		return jsonContent(map[string]string{"origin": req.clientIP})
+	}
+
+	return &content{contentType: contentTypeTextPlain, body: []byte(req.clientIP)}
+}
+
+// serveStatus answers /status/{code} with the requested status code, after
+// an optional ?delay= and with an optional ?body=.
+func (s *Server) serveStatus(conn net.Conn, req request) error {
+	if len(req.pathParts) < 3 {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+
+	code, err := strconv.Atoi(req.pathParts[2])
+	if err != nil || code < 200 || code > 599 {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+
+	if d := req.query.Get("delay"); d != "" {
+		delay, err := parseDelay(d)
+		if err != nil {
+			conn.Write(buildResponse(statusBadRequest, nil))
+			return nil
+		}
+		if !s.sleep(req.context(), delay) {
+			return nil
+		}
+	}
+
+	var c *content
+	if body := req.query.Get("body"); body != "" && code != 204 && code != 304 {
+		c = &content{contentType: contentTypeTextPlain, body: []byte(body)}
+	}
+	conn.Write(buildResponse(code, c))
+
+	return nil
+}
+
+// serveEchoBody answers POST /echo with the request body and its
+// Content-Type, after an optional ?delay= and with the ?status= code, 200
+// by default.
+func (s *Server) serveEchoBody(conn net.Conn, body io.Reader, req request) error {
+	code := statusOK
+	if v := req.query.Get("status"); v != "" {
+		var err error
+		code, err = strconv.Atoi(v)
+		if err != nil || code < 200 || code > 599 {
+			conn.Write(buildResponse(statusBadRequest, nil))
+			return nil
+		}
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		conn.Write(buildResponse(bodyErrorStatus(err), nil))
+		return fmt.Errorf("error reading request body: %v\n", err)
+	}
+
+	if d := req.query.Get("delay"); d != "" {
+		delay, err := parseDelay(d)
+		if err != nil {
+			conn.Write(buildResponse(statusBadRequest, nil))
+			return nil
+		}
+		if !s.sleep(req.context(), delay) {
+			return nil
+		}
+	}
+
+	if !bodyAllowed(code) {
+		conn.Write(buildResponse(code, nil))
+		return nil
+	}
+	// The headers are set directly rather than from a content, and left
+	// without the charset, so the Content-Type goes back exactly as it came.
+	keepContentType(conn)
+	h := header{"Content-Length": {strconv.Itoa(len(data))}}
+	if t := req.headers.get("Content-Type"); t != "" {
+		h.set("Content-Type", t)
+	}
+	conn.Write(append(buildResponseHeaders(code, h, nil), data...))
+
+	return nil
+}
+
+// serveDelay answers /delay/{duration} once the duration, capped at the
+// configured maximum, has passed. Nothing is sent if the client gives up.
+func (s *Server) serveDelay(conn net.Conn, req request) error {
+	if len(req.pathParts) < 3 {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+
+	delay, err := parseDelay(req.pathParts[2])
+	if err != nil {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+	delay = min(delay, s.opts.MaxDelay)
+
+	if !s.sleep(req.context(), delay) {
+		return fmt.Errorf("client went away during %s delay", delay)
+	}
+
+	c := content{
+		contentType: contentTypeTextPlain,
+		body:        []byte(delay.String()),
+	}
+	conn.Write(buildResponse(statusOK, &c))
+
+	return nil
+}
+
+// maxStreamChunks caps the chunks /echo-stream sends.
+const maxStreamChunks = 1000
+
+// serveEchoStream answers /echo-stream/{count}/{interval} with count
+// numbered lines, each sent as a chunk of its own the interval after the
+// one before. The interval is cut short so the stream lasts no longer than
+// the configured maximum delay.
+func (s *Server) serveEchoStream(conn net.Conn, req request) error {
+	if len(req.pathParts) != 4 {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+
+	count, err := strconv.Atoi(req.pathParts[2])
+	if err != nil || count < 1 || count > maxStreamChunks {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+	interval, err := parseDelay(req.pathParts[3])
+	if err != nil {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+	if count > 1 {
+		interval = min(interval, s.opts.MaxDelay/time.Duration(count-1))
+	}
+
+	ctx := req.context()
+
+	h := make(header)
+	h.set("Content-Type", "text/plain; charset=utf-8")
+	h.set("Transfer-Encoding", "chunked")
+	h.set("X-Content-Type-Options", "nosniff")
+	h.set("Cache-Control", "no-cache")
+	conn.Write(buildResponseHeaders(statusOK, h, nil))
+
+	w := &chunkedWriter{w: conn}
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for n := 1; n <= count; n++ {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return fmt.Errorf("client went away after %d of %d chunks", n-1, count)
+		case <-s.closed:
+			w.Close()
+			return nil
+		}
+		if _, err := fmt.Fprintf(w, "%d\n", n); err != nil {
+			return fmt.Errorf("error writing chunk %d: %v\n", n, err)
+		}
+		t.Reset(interval)
+	}
+
+	return w.Close()
+}
+
+// sleep waits for d, capped at the configured maximum. It returns false if
+// the client disconnected in the meantime.
+func (s *Server) sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(min(d, s.opts.MaxDelay))
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// parseDelay accepts either a Go duration ("1.5s", "250ms") or a bare number
+// of seconds.
+func parseDelay(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		s = strconv.FormatFloat(secs, 'f', -1, 64) + "s"
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative delay %s", s)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path"
+	"strings"
+	"sync"
+)
+
+// objectsDir holds the content of files in deduplicating storage, each
+// under .objects/<first two hex digits>/<sha256 hex>.
+const objectsDir = ".objects"
+
+// hashPrefix starts the hashes content is known by.
+const hashPrefix = "sha256:"
+
+// keyName is the file holding the key pointers are marked with, made at
+// random when the store is first deduplicated. A file only counts as a
+// pointer if it starts with the key, so one written before deduplication
+// was turned on, or put there by other means, is never taken for one
+// however much it looks like it.
+var keyName = path.Join(objectsDir, "key")
+
+// dedupStorage stores each distinct content once, in a store of its own.
+// Files are small pointers naming the hash of their content, so identical
+// uploads, and the versions and trash kept of them, share one object. An
+// object is deleted once no file points at it. Files written before
+// deduplication was turned on are served as they are.
+type dedupStorage struct {
+	inner storage
+	log   *slog.Logger
+	// marker starts the content of every pointer: the store's key and the
+	// hash prefix.
+	marker string
+
+	mu   sync.Mutex
+	refs map[string]int
+}
+
+// newDedupStorage wraps inner, counting the references to every object
+// from the files already there.
+func newDedupStorage(inner storage, log *slog.Logger) (*dedupStorage, error) {
+	key, err := dedupKey(inner)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dedup key: %v", err)
+	}
+	d := &dedupStorage{inner: inner, log: log, marker: "naive-dedup " + key + " " + hashPrefix, refs: make(map[string]int)}
+	if err := d.count(""); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// dedupKey returns the key pointers in inner are marked with, making one
+// if there is none yet.
+func dedupKey(inner storage) (string, error) {
+	f, err := inner.Open(keyName)
+	if err == nil {
+		defer f.Close()
+		key, err := io.ReadAll(io.LimitReader(f, 64))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(key)), nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	var b [16]byte
+	rand.Read(b[:])
+	key := hex.EncodeToString(b[:])
+	if err := inner.Write(keyName, strings.NewReader(key+"\n")); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// count adds the references from the files below dir.
+func (d *dedupStorage) count(dir string) error {
+	infos, err := d.inner.List(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		name := joinName(dir, info.Name())
+		switch {
+		case name == objectsDir:
+		case info.IsDir():
+			if err := d.count(name); err != nil {
+				return err
+			}
+		default:
+			if sum := d.pointer(name, info); sum != "" {
+				d.refs[sum]++
+			}
+		}
+	}
+
+	return nil
+}
+
+func objectName(sum string) string {
+	return path.Join(objectsDir, sum[:2], sum)
+}
+
+// pointer returns the hash the file name with info points at, or "" if it
+// isn't a pointer.
+func (d *dedupStorage) pointer(name string, info fs.FileInfo) string {
+	size := len(d.marker) + 2*sha256.Size + 1
+	if info.IsDir() || info.Size() != int64(size) {
+		return ""
+	}
+	f, err := d.inner.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return ""
+	}
+	sum, ok := bytes.CutPrefix(bytes.TrimSuffix(buf, []byte("\n")), []byte(d.marker))
+	if !ok || len(sum) != 2*sha256.Size {
+		return ""
+	}
+	if _, err := hex.DecodeString(string(sum)); err != nil {
+		return ""
+	}
+
+	return string(sum)
+}
+
+// hash returns the hash of the content of name, or "" if it isn't stored
+// by its content.
+func (d *dedupStorage) hash(name string) string {
+	info, err := d.inner.Stat(name)
+	if err != nil {
+		return ""
+	}
+
+	return d.pointer(name, info)
+}
+
+func (d *dedupStorage) Open(name string) (io.ReadSeekCloser, error) {
+	if sum := d.hash(name); sum != "" {
+		return d.inner.Open(objectName(sum))
+	}
+
+	return d.inner.Open(name)
+}
+
+// Write stores the content of r as an object, unless an identical one is
+// there already, and points name at it. The content is streamed into a
+// file of its own while it is hashed, and renamed to its object once the
+// hash is known.
+func (d *dedupStorage) Write(name string, r io.Reader) error {
+	var id [16]byte
+	rand.Read(id[:])
+	staged := path.Join(objectsDir, "tmp", hex.EncodeToString(id[:]))
+	hash := sha256.New()
+	if err := d.inner.Write(staged, io.TeeReader(r, hash)); err != nil {
+		d.inner.Delete(staged)
+		return err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := d.inner.Stat(objectName(sum)); errors.Is(err, fs.ErrNotExist) {
+		if err := d.inner.Rename(staged, objectName(sum)); err != nil {
+			d.inner.Delete(staged)
+			return err
+		}
+	} else {
+		d.inner.Delete(staged)
+		if err != nil {
+			return err
+		}
+	}
+
+	old := d.hash(name)
+	d.refs[sum]++
+	if err := d.inner.Write(name, strings.NewReader(d.marker+sum+"\n")); err != nil {
+		d.release(sum)
+		return err
+	}
+	d.release(old)
+
+	return nil
+}
+
+// release drops a reference to the object sum, deleting it if it was the
+// last. It must be called with mu held.
+func (d *dedupStorage) release(sum string) {
+	if sum == "" {
+		return
+	}
+	if d.refs[sum]--; d.refs[sum] > 0 {
+		return
+	}
+	delete(d.refs, sum)
+	if err := d.inner.Delete(objectName(sum)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		d.log.Error("error deleting unreferenced object", "object", sum, "err", err)
+	}
+}
+
+func (d *dedupStorage) Stat(name string) (fs.FileInfo, error) {
+	info, err := d.inner.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.resolve(name, info), nil
+}
+
+func (d *dedupStorage) List(dir string) ([]fs.FileInfo, error) {
+	infos, err := d.inner.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, info := range infos {
+		infos[i] = d.resolve(joinName(dir, info.Name()), info)
+	}
+
+	return infos, nil
+}
+
+// resolve returns the info of the file name with info, with the size of
+// the object it points at.
+func (d *dedupStorage) resolve(name string, info fs.FileInfo) fs.FileInfo {
+	sum := d.pointer(name, info)
+	if sum == "" {
+		return info
+	}
+	obj, err := d.inner.Stat(objectName(sum))
+	if err != nil {
+		return info
+	}
+
+	return dedupFileInfo{FileInfo: info, size: obj.Size()}
+}
+
+func (d *dedupStorage) Delete(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sum := d.hash(name)
+	if err := d.inner.Delete(name); err != nil {
+		return err
+	}
+	d.release(sum)
+
+	return nil
+}
+
+func (d *dedupStorage) Rename(from, to string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	replaced := d.hash(to)
+	if err := d.inner.Rename(from, to); err != nil {
+		return err
+	}
+	d.release(replaced)
+
+	return nil
+}
+
+// dedupFileInfo is the info of a pointer file with the size of its object.
+type dedupFileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+// Size is that of the object. The modification time stays the pointer's,
+// since the object may be older than the upload that found it there.
+func (i dedupFileInfo) Size() int64 { return i.size }
+
+// contentHash returns the hash the store knows name's content by, as
+// sha256:hex, or "" if it isn't storing by content.
+func (s *Server) contentHash(name string) string {
+	w, _ := s.store.(watchedStorage)
+	d, ok := w.storage.(*dedupStorage)
+	if !ok {
+		return ""
+	}
+	if sum := d.hash(name); sum != "" {
+		return hashPrefix + sum
+	}
+
+	return ""
+}
@@ -0,0 +1,135 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// objects lists the content objects of the store at dir.
+func objects(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	filepath.WalkDir(filepath.Join(dir, ".objects"), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && d.Name() != "key" {
+			names = append(names, d.Name())
+		}
+		return nil
+	})
+
+	return names
+}
+
+func TestDedup(t *testing.T) {
+	dir := t.TempDir()
+	opts := servertest.Options()
+	opts.Storage = "disk"
+	opts.Directory = dir
+	opts.Dedup = true
+	opts.UIAuth = "me:secret"
+	opts.TrashRetention = 0
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+	auth := basicAuth("me", "secret")
+
+	text := strings.Repeat("the same again\n", 100)
+	sum := sha256.Sum256([]byte(text))
+	want := "sha256:" + hex.EncodeToString(sum[:])
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader(text), nil).
+		AssertStatus(http.StatusCreated).
+		AssertHeader("X-Content-Hash", want)
+	c.Do(http.MethodPut, "/files/sub/b.txt", strings.NewReader(text), nil).
+		AssertStatus(http.StatusCreated).
+		AssertHeader("X-Content-Hash", want)
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("file", "c.txt")
+	fw.Write([]byte(text))
+	fw, _ = mw.CreateFormFile("file", "d.txt")
+	fw.Write([]byte("different"))
+	mw.Close()
+	resp := c.Do(http.MethodPost, "/files/", &form, http.Header{"Content-Type": {mw.FormDataContentType()}}).
+		AssertStatus(http.StatusCreated).
+		AssertBody("c.txt\nd.txt\n")
+	if got := resp.Header.Values("X-Content-Hash"); len(got) != 2 || got[0] != want {
+		t.Errorf("got form hashes %q, want %s first", got, want)
+	}
+
+	if got := objects(t, dir); len(got) != 2 {
+		t.Fatalf("got objects %q, want one per distinct content", got)
+	}
+	c.Get("/files/sub/b.txt").
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Length", "1500").
+		AssertHeader("X-Content-Hash", want).
+		AssertBody(text)
+	c.Get("/files/").AssertStatus(http.StatusOK).AssertBodyContains("a.txt")
+	c.Get("/files/.objects/").AssertStatus(http.StatusNotFound)
+
+	// Objects go once nothing points at them.
+	c.Do(http.MethodDelete, "/files/d.txt", nil, auth).AssertStatus(http.StatusNoContent)
+	c.Do(http.MethodDelete, "/files/a.txt", nil, auth).AssertStatus(http.StatusNoContent)
+	c.Do(http.MethodPut, "/files/c.txt", strings.NewReader("new"), nil).AssertStatus(http.StatusCreated)
+	if got := objects(t, dir); len(got) != 2 {
+		t.Fatalf("got objects %q, want those of sub/b.txt and c.txt", got)
+	}
+
+	// References are counted again on start.
+	s.Close()
+	s = servertest.NewPipe(t, opts)
+	c = s.Client()
+	c.Get("/files/sub/b.txt").AssertStatus(http.StatusOK).AssertBody(text)
+	c.Do("MOVE", "/files/c.txt", nil, http.Header{"Authorization": auth["Authorization"], "Destination": {"/files/sub/b.txt"}}).
+		AssertStatus(http.StatusCreated)
+	if got := objects(t, dir); len(got) != 1 {
+		t.Fatalf("got objects %q, want only that of the moved c.txt", got)
+	}
+	c.Get("/files/sub/b.txt").AssertStatus(http.StatusOK).AssertBody("new")
+}
+
+func TestDedupLookalikePointers(t *testing.T) {
+	dir := t.TempDir()
+	text := "pointed at\n"
+	sum := sha256.Sum256([]byte(text))
+	lookalike := "sha256:" + hex.EncodeToString(sum[:]) + "\n"
+	// A file from before deduplication was turned on that reads like a
+	// pointer to content the store will have.
+	os.WriteFile(filepath.Join(dir, "old.txt"), []byte(lookalike), 0o644)
+
+	opts := servertest.Options()
+	opts.Storage = "disk"
+	opts.Directory = dir
+	opts.Dedup = true
+	c := servertest.NewPipe(t, opts).Client()
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader(text), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/old.txt").
+		AssertStatus(http.StatusOK).
+		AssertHeader("X-Content-Hash", "").
+		AssertBody(lookalike)
+
+	// Nor is an upload of what one of its pointers holds taken for one.
+	pointer, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"b.txt": lookalike, "c.txt": string(pointer)} {
+		uploaded := sha256.Sum256([]byte(data))
+		c.Do(http.MethodPut, "/files/"+name, strings.NewReader(data), nil).
+			AssertStatus(http.StatusCreated).
+			AssertHeader("X-Content-Hash", "sha256:"+hex.EncodeToString(uploaded[:]))
+		c.Get("/files/" + name).AssertStatus(http.StatusOK).AssertBody(data)
+	}
+	c.Get("/files/a.txt").AssertStatus(http.StatusOK).AssertBody(text)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// devPollInterval is how often the store is looked at for changed
+	// files in dev mode.
+	devPollInterval = 500 * time.Millisecond
+	// devHeartbeat keeps idle event streams from being timed out by proxies.
+	devHeartbeat = 15 * time.Second
+)
+
+const devReloadScript = `<script>
+(function () {
+  var events = new EventSource("/_dev/events");
+  events.addEventListener("reload", function () {
+    location.reload();
+  });
+})();
+</script>
+`
+
+// devReloader tells the pages open in dev mode to reload when the served
+// files change, as the server's file watcher sees them.
+type devReloader struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+func newDevReloader() *devReloader {
+	return &devReloader{subscribers: make(map[chan struct{}]struct{})}
+}
+
+func (d *devReloader) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscribers[ch] = struct{}{}
+
+	return ch
+}
+
+func (d *devReloader) unsubscribe(ch chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.subscribers, ch)
+}
+
+func (d *devReloader) notify() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for ch := range d.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// serveDevEvents answers GET /_dev/events with a server-sent event stream
+// carrying a reload event whenever the served files change.
+func (s *Server) serveDevEvents(conn net.Conn, req request) error {
+	if s.dev == nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+
+	ctx := req.context()
+	ch := s.dev.subscribe()
+	defer s.dev.unsubscribe(ch)
+
+	h := make(header)
+	h.set("Content-Type", "text/event-stream")
+	h.set("Cache-Control", "no-cache")
+	h.set("Transfer-Encoding", "chunked")
+	conn.Write(buildResponseHeaders(statusOK, h, nil))
+
+	w := &chunkedWriter{w: conn}
+	heartbeat := time.NewTicker(devHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-ch:
+			_, err = w.Write([]byte("event: reload\ndata: \n\n"))
+		case <-heartbeat.C:
+			_, err = w.Write([]byte(": heartbeat\n\n"))
+		case <-ctx.Done():
+			return nil
+		case <-s.closed:
+			w.Close()
+			return nil
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// isHTMLName reports whether a file name looks like an HTML page.
+func isHTMLName(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".html", ".htm":
+		return true
+	}
+
+	return false
+}
+
+// devInject adds the reload script to an HTML page, just before </body> if
+// it has one.
+func devInject(page []byte) []byte {
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		return append(page[:len(page):len(page)], devReloadScript...)
+	}
+
+	out := make([]byte, 0, len(page)+len(devReloadScript))
+	out = append(out, page[:i]...)
+	out = append(out, devReloadScript...)
+
+	return append(out, page[i:]...)
+}
@@ -0,0 +1,58 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestDevReload(t *testing.T) {
+	opts := servertest.Options()
+	opts.Dev = true
+	s := servertest.New(t, opts)
+	c := s.Client()
+
+	c.Do(http.MethodPut, "/files/index.html", strings.NewReader("<html><body><h1>Hi</h1></BODY></html>"), nil).
+		AssertStatus(http.StatusCreated)
+	c.Get("/files/index.html").
+		AssertHeader("Content-Type", "text/html; charset=utf-8").
+		AssertBodyContains(`new EventSource("/_dev/events")`).
+		AssertBodyContains("</script>\n</BODY></html>")
+	c.Get("/files/").AssertBodyContains("EventSource")
+	c.RawHead("GET /_dev/events HTTP/1.1\r\nHost: x\r\n\r\n").
+		AssertHeader("Transfer-Encoding", "chunked").
+		AssertHeader("Content-Length", "")
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /_dev/events HTTP/1.1\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %q", ct)
+	}
+
+	c.Do(http.MethodPut, "/files/style.css", strings.NewReader("h1 {}"), nil).AssertStatus(http.StatusCreated)
+
+	event, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || event != "event: reload\n" {
+		t.Fatalf("got %q, %v, want a reload event", event, err)
+	}
+}
+
+func TestDevOff(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	c.Do(http.MethodPut, "/files/index.html", strings.NewReader("<html></html>"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/index.html").AssertBody("<html></html>")
+	c.Get("/_dev/events").AssertStatus(http.StatusNotFound)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+	"strings"
+)
+
+// digestAlgorithms are the hashes uploads can be checked with, by their
+// lowercased names in Content-Digest, Repr-Digest and Digest headers.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+	"md5":     md5.New,
+}
+
+// uploadDigest is a digest an upload was sent with.
+type uploadDigest struct {
+	alg string
+	sum []byte
+}
+
+// contentDigests collects the digests of the content an upload was sent
+// with, as it came over the wire before its Content-Encoding is undone.
+// Algorithms the server doesn't know are left out.
+func contentDigests(req request) []uploadDigest {
+	var digests []uploadDigest
+	if v := req.headers.get("Content-MD5"); v != "" {
+		digests = addDigest(digests, "md5", v)
+	}
+	digests = addStructuredDigests(digests, req.headers["Content-Digest"])
+	// The RFC 3230 Digest field it replaces has bare base64 values.
+	for _, v := range req.headers["Digest"] {
+		for _, member := range strings.Split(v, ",") {
+			alg, value, _ := strings.Cut(member, "=")
+			digests = addDigest(digests, alg, value)
+		}
+	}
+
+	return digests
+}
+
+// reprDigests collects the digests of the representation an upload was
+// sent with, which RFC 9530 takes over the content once its
+// Content-Encoding is undone.
+func reprDigests(req request) []uploadDigest {
+	return addStructuredDigests(nil, req.headers["Repr-Digest"])
+}
+
+// addStructuredDigests adds the digests in the values of an RFC 9530 field,
+// dictionaries of byte sequences as in sha-256=:base64:. Only the hashes it
+// doesn't deprecate are honoured.
+func addStructuredDigests(digests []uploadDigest, values []string) []uploadDigest {
+	for _, v := range values {
+		for _, member := range strings.Split(v, ",") {
+			alg, value, _ := strings.Cut(member, "=")
+			if alg = strings.ToLower(strings.TrimSpace(alg)); alg == "md5" {
+				continue
+			}
+			digests = addDigest(digests, alg, strings.Trim(strings.TrimSpace(value), ":"))
+		}
+	}
+
+	return digests
+}
+
+// addDigest adds the base64 digest value by alg, unless alg isn't known.
+func addDigest(digests []uploadDigest, alg, value string) []uploadDigest {
+	alg = strings.ToLower(strings.TrimSpace(alg))
+	if _, ok := digestAlgorithms[alg]; !ok {
+		return digests
+	}
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		// An undecodable digest can't match anything.
+		sum = []byte{}
+	}
+
+	return append(digests, uploadDigest{alg: alg, sum: sum})
+}
+
+var errDigestMismatch = errors.New("body doesn't match its digest")
+
+// verifyDigests checks body against digests as it is read, so nothing is
+// held for it: the reader it returns fails with errDigestMismatch where
+// body ends if they don't match. Whatever stores the body must read it to
+// its end before keeping any of it.
+func verifyDigests(body io.Reader, digests []uploadDigest) io.Reader {
+	if len(digests) == 0 {
+		return body
+	}
+
+	d := &digestReader{r: body, digests: digests}
+	writers := make([]io.Writer, len(digests))
+	for i, digest := range digests {
+		h := digestAlgorithms[digest.alg]()
+		d.hashes = append(d.hashes, h)
+		writers[i] = h
+	}
+	d.w = io.MultiWriter(writers...)
+
+	return d
+}
+
+// digestReader hashes what is read through it, checking the hashes against
+// digests once r ends.
+type digestReader struct {
+	r       io.Reader
+	w       io.Writer
+	digests []uploadDigest
+	hashes  []hash.Hash
+	err     error
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+
+	n, err := d.r.Read(p)
+	d.w.Write(p[:n])
+	if err == io.EOF {
+		for i, digest := range d.digests {
+			if subtle.ConstantTimeCompare(d.hashes[i].Sum(nil), digest.sum) != 1 {
+				err = errDigestMismatch
+				break
+			}
+		}
+		d.err = err
+	}
+
+	return n, err
+}
@@ -0,0 +1,122 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestUploadDigests(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	md5sum := md5.Sum([]byte("hello"))
+	sha := sha256.Sum256([]byte("hello"))
+	goodMD5 := base64.StdEncoding.EncodeToString(md5sum[:])
+	goodSHA := base64.StdEncoding.EncodeToString(sha[:])
+	badSHA := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	for _, tt := range []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"content-md5", http.Header{"Content-Md5": {goodMD5}}, http.StatusCreated},
+		{"bad content-md5", http.Header{"Content-Md5": {badSHA}}, http.StatusBadRequest},
+		{"content-digest", http.Header{"Content-Digest": {"sha-256=:" + goodSHA + ":"}}, http.StatusCreated},
+		{"bad content-digest", http.Header{"Content-Digest": {"sha-256=:" + badSHA + ":"}}, http.StatusBadRequest},
+		{"one bad of two", http.Header{"Content-Digest": {"sha-256=:" + goodSHA + ":, sha-512=:AAAA:"}}, http.StatusBadRequest},
+		{"unknown algorithm", http.Header{"Content-Digest": {"crc32c=:AAAAAA==:"}}, http.StatusCreated},
+		{"repr-digest", http.Header{"Repr-Digest": {"sha-256=:" + badSHA + ":"}}, http.StatusBadRequest},
+		{"legacy digest", http.Header{"Digest": {"SHA-256=" + goodSHA}}, http.StatusCreated},
+		{"bad legacy digest", http.Header{"Digest": {"MD5=" + badSHA}}, http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c.Do(http.MethodPut, "/files/"+tt.name, strings.NewReader("hello"), tt.header).AssertStatus(tt.status)
+			if tt.status == http.StatusCreated {
+				c.Get("/files/" + tt.name).AssertBody("hello")
+			} else {
+				c.Get("/files/" + tt.name).AssertStatus(http.StatusNotFound)
+			}
+		})
+	}
+}
+
+// sha256Digest returns an RFC 9530 sha-256 digest of data.
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func TestEncodedUploadDigests(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write([]byte("hello"))
+	zw.Close()
+	coded := b.Bytes()
+
+	// Content-Digest is of the bytes sent, Repr-Digest of what they decode
+	// to.
+	for _, tt := range []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"content-digest", http.Header{"Content-Digest": {sha256Digest(coded)}}, http.StatusCreated},
+		{"content-digest of decoded", http.Header{"Content-Digest": {sha256Digest([]byte("hello"))}}, http.StatusBadRequest},
+		{"repr-digest", http.Header{"Repr-Digest": {sha256Digest([]byte("hello"))}}, http.StatusCreated},
+		{"repr-digest of coded", http.Header{"Repr-Digest": {sha256Digest(coded)}}, http.StatusBadRequest},
+		{"both", http.Header{"Content-Digest": {sha256Digest(coded)}, "Repr-Digest": {sha256Digest([]byte("hello"))}}, http.StatusCreated},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.header.Set("Content-Encoding", "gzip")
+			c.Do(http.MethodPut, "/files/"+tt.name, bytes.NewReader(coded), tt.header).AssertStatus(tt.status)
+			if tt.status == http.StatusCreated {
+				c.Get("/files/" + tt.name).AssertBody("hello")
+			} else {
+				c.Get("/files/" + tt.name).AssertStatus(http.StatusNotFound)
+			}
+		})
+	}
+}
+
+func TestDigestMismatchLeavesFile(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("old"), nil).AssertStatus(http.StatusCreated)
+
+	// Large enough that the store has most of it before the digest is
+	// known not to match.
+	data := []byte(strings.Repeat("new ", 64<<10))
+	bad := http.Header{"Content-Digest": {sha256Digest([]byte("something else"))}}
+	c.Do(http.MethodPut, "/files/a.txt", bytes.NewReader(data), bad).AssertStatus(http.StatusBadRequest)
+	c.Get("/files/a.txt").AssertBody("old")
+
+	var form bytes.Buffer
+	w := multipart.NewWriter(&form)
+	fw, _ := w.CreateFormFile("file", "a.txt")
+	fw.Write(data)
+	fw, _ = w.CreateFormFile("file", "b.txt")
+	fw.Write(data)
+	w.Close()
+	formData := form.Bytes()
+
+	bad.Set("Content-Type", w.FormDataContentType())
+	c.Do(http.MethodPost, "/files/", bytes.NewReader(formData), bad).AssertStatus(http.StatusBadRequest)
+	c.Get("/files/a.txt").AssertBody("old")
+	c.Get("/files/b.txt").AssertStatus(http.StatusNotFound)
+
+	good := http.Header{"Content-Digest": {sha256Digest(formData)}, "Content-Type": {w.FormDataContentType()}}
+	c.Do(http.MethodPost, "/files/", bytes.NewReader(formData), good).
+		AssertStatus(http.StatusCreated).
+		AssertBody("a.txt\nb.txt\n")
+	c.Get("/files/b.txt").AssertBody(string(data))
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// peerConn is a connection requests are read from that can watch for the
+// client hanging up while a request is answered, once it has been read in
+// full. Whatever the client sends meanwhile, such as its next pipelined
+// request, is kept for the next Read, so the connection can go on serving.
+type peerConn struct {
+	net.Conn
+
+	// watching is closed when the goroutine watching the connection is
+	// done, and nil when there is none.
+	watching chan struct{}
+	stopping atomic.Bool
+	// pending holds what the client sent while watched, and err what
+	// ended the watch if the client hung up.
+	pending []byte
+	err     error
+
+	// rate limits the file transfers on the connection, whichever request
+	// they are for.
+	rate *rateLimiter
+}
+
+// watch cancels cancel once the client hangs up, until stopWatching. It
+// must only be called with nothing of the connection left to read for the
+// request, and does nothing if the connection's reads can't be interrupted.
+func (c *peerConn) watch(cancel context.CancelFunc) {
+	if c.watching != nil || c.err != nil || c.Conn.SetReadDeadline(time.Time{}) != nil {
+		return
+	}
+	c.watching = make(chan struct{})
+
+	go func() {
+		defer close(c.watching)
+
+		var b [1]byte
+		n, err := c.Conn.Read(b[:])
+		if n > 0 {
+			// The client is sending more, so it is still there, but
+			// there is no telling when it leaves from here on.
+			c.pending = append(c.pending, b[0])
+			return
+		}
+		if c.stopping.Load() && errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		c.err = err
+		cancel()
+	}()
+}
+
+// stopWatching ends the watch, if there is one, once the request has been
+// answered.
+func (c *peerConn) stopWatching() {
+	if c.watching == nil {
+		return
+	}
+	c.stopping.Store(true)
+	c.Conn.SetReadDeadline(time.Unix(1, 0))
+	<-c.watching
+	c.Conn.SetReadDeadline(time.Time{})
+	c.stopping.Store(false)
+	c.watching = nil
+}
+
+func (c *peerConn) Read(p []byte) (int, error) {
+	c.stopWatching()
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.Conn.Read(p)
+}
+
+// eofReader calls onEOF once r has been read to its end.
+type eofReader struct {
+	r     io.Reader
+	onEOF func()
+}
+
+func (e *eofReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF && e.onEOF != nil {
+		e.onEOF()
+		e.onEOF = nil
+	}
+
+	return n, err
+}
+
+// context returns the context of the request, which is cancelled once the
+// client hangs up while it is answered.
+func (r request) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+
+	return r.ctx
+}
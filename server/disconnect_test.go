@@ -0,0 +1,82 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestDisconnectCancelsProxyCall(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	opts := servertest.Options()
+	opts.Proxy = upstream.URL
+	s := servertest.New(t, opts)
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET /slow HTTP/1.1\r\nHost: x\r\n\r\n")
+	<-started
+	conn.Close()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream call went on after the client hung up")
+	}
+}
+
+func TestDisconnectWatchKeepsConnection(t *testing.T) {
+	s := servertest.New(t, servertest.Options())
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// The second request arrives while the first is being answered, and
+	// is served once it is.
+	io.WriteString(conn, "GET /delay/200ms HTTP/1.1\r\nHost: x\r\n\r\n")
+	time.Sleep(50 * time.Millisecond)
+	io.WriteString(conn, "GET /echo/again HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	for _, want := range []string{"200ms", "again"} {
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatalf("reading response %q: %v", want, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != want {
+			t.Errorf("got %d %q, want 200 %q", resp.StatusCode, body, want)
+		}
+	}
+
+	// Requests answered with the client waiting leave it open.
+	io.WriteString(conn, "GET /delay/10ms HTTP/1.1\r\nHost: x\r\n\r\n")
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Close {
+		t.Error("connection closed after a delayed response")
+	}
+}
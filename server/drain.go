@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+// drain puts the server in drain mode, for load balancers to take it out of
+// rotation before it shuts down: /readyz fails and every response closes
+// its connection, idle ones on their next request, but new connections are
+// still served, for the balancers that haven't noticed yet. Once grace is
+// up, unless it is 0, shutdown is requested. Draining again starts the
+// grace window over.
+func (s *Server) drain(grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.draining.Store(true)
+	if s.drainTimer != nil {
+		s.drainTimer.Stop()
+		s.drainTimer = nil
+	}
+	if grace > 0 {
+		s.drainTimer = time.AfterFunc(grace, s.requestShutdown)
+	}
+}
+
+// undrain ends drain mode before its grace is up.
+func (s *Server) undrain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.draining.Store(false)
+	if s.drainTimer != nil {
+		s.drainTimer.Stop()
+		s.drainTimer = nil
+	}
+}
+
+// serveReady answers GET /readyz for readiness probes: 200 while the server
+// takes requests, and 503 once it is draining or shutting down.
+func (s *Server) serveReady(conn net.Conn) {
+	status, body := statusOK, "ready\n"
+	select {
+	case <-s.closed:
+		status, body = statusServiceUnavailable, "shutting down\n"
+	default:
+		if s.draining.Load() {
+			status, body = statusServiceUnavailable, "draining\n"
+		}
+	}
+
+	h := header{}
+	h.set("Cache-Control", "no-store")
+	conn.Write(buildResponseHeaders(status, h, &content{contentType: contentTypeTextPlain, body: []byte(body)}))
+}
+
+// serveDrain handles /drain on the admin API: GET tells whether the server
+// is draining, POST /drain?grace=... starts draining, for -drain-grace if
+// no grace is given, and DELETE stops it.
+func (s *Server) serveDrain(conn net.Conn, req request) error {
+	switch req.method {
+	case methodGet:
+	case methodPost:
+		grace := s.opts.DrainGrace
+		if v := req.query.Get("grace"); v != "" {
+			var err error
+			if grace, err = time.ParseDuration(v); err != nil || grace < 0 {
+				conn.Write(buildResponse(statusBadRequest, nil))
+				return nil
+			}
+		}
+		s.drain(grace)
+		if s.audit != nil {
+			s.auditAdmin("drain", grace.String(), conn, nil)
+		}
+	case methodDelete:
+		s.undrain()
+		if s.audit != nil {
+			s.auditAdmin("undrain", "", conn, nil)
+		}
+	default:
+		conn.Write(buildResponse(statusMethodNotAllowed, nil))
+		return nil
+	}
+
+	conn.Write(buildResponse(statusOK, jsonContent(map[string]bool{"draining": s.draining.Load()})))
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Storage = storageMemory
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Serve(l)
+	}()
+	defer func() {
+		s.Shutdown()
+		<-done
+	}()
+
+	admin := func(method, target string) string {
+		t.Helper()
+		client, server := net.Pipe()
+		go s.handleAdminConn(server)
+		defer client.Close()
+		io.WriteString(client, method+" "+target+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.Status + " " + string(body)
+	}
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	get := func(path string) *http.Response {
+		t.Helper()
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(resp.Body)
+		return resp
+	}
+
+	if resp := get("/readyz"); resp.StatusCode != http.StatusOK || resp.Close {
+		t.Fatalf("got %d, close %v before draining", resp.StatusCode, resp.Close)
+	}
+	if got := admin("POST", "/drain?grace=200ms"); got != `200 OK {"draining":true}` {
+		t.Errorf("POST /drain: got %q", got)
+	}
+
+	// The connection open before is told to go, and /readyz fails on it.
+	if resp := get("/readyz"); resp.StatusCode != http.StatusServiceUnavailable || !resp.Close {
+		t.Errorf("got %d, close %v while draining", resp.StatusCode, resp.Close)
+	}
+	// New connections are still taken.
+	conn2, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	io.WriteString(conn2, "GET /healthz HTTP/1.1\r\nHost: x\r\n\r\n")
+	if resp, err := http.ReadResponse(bufio.NewReader(conn2), nil); err != nil || resp.StatusCode != http.StatusOK || !resp.Close {
+		t.Errorf("got %v, %v from a new connection while draining", resp, err)
+	}
+
+	select {
+	case <-s.ShutdownRequested():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no shutdown once the grace was up")
+	}
+}
+
+func TestUndrain(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Storage = storageMemory
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.drain(50 * time.Millisecond)
+	s.undrain()
+	select {
+	case <-s.ShutdownRequested():
+		t.Error("shutdown requested after draining was stopped")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if s.draining.Load() {
+		t.Error("still draining")
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// dumpHeadLimit caps how much of a message head is kept for dumping.
+const dumpHeadLimit = 64 << 10
+
+// dumpSecretHeaders are the headers whose values are left out of dumps, as
+// they carry credentials: passwords, tokens, API keys and session cookies.
+var dumpSecretHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"x-api-key":           true,
+}
+
+// dumpSecretParams are the query parameters whose values are left out of
+// dumps: URL signatures and OpenID Connect authorization codes.
+var dumpSecretParams = []string{"signature", "code"}
+
+// dumpConn records what is read from and written to a connection so that the
+// exchange can be logged once it is over, to w.
+type dumpConn struct {
+	net.Conn
+	bodyLimit int
+	w         io.Writer
+
+	mu      sync.Mutex
+	in, out dumpBuffer
+}
+
+type dumpBuffer struct {
+	data  bytes.Buffer
+	total int
+}
+
+func newDumpConn(conn net.Conn, bodyLimit int, w io.Writer) *dumpConn {
+	return &dumpConn{Conn: conn, bodyLimit: bodyLimit, w: w}
+}
+
+func (c *dumpConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.mu.Lock()
+	c.in.record(p[:n], dumpHeadLimit+c.bodyLimit)
+	c.mu.Unlock()
+
+	return n, err
+}
+
+func (c *dumpConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.mu.Lock()
+	c.out.record(p[:n], dumpHeadLimit+c.bodyLimit)
+	c.mu.Unlock()
+
+	return n, err
+}
+
+func (b *dumpBuffer) record(p []byte, limit int) {
+	b.total += len(p)
+	if room := limit - b.data.Len(); room > 0 {
+		b.data.Write(p[:min(len(p), room)])
+	}
+}
+
+// dump prints the request and response heads, their credentials redacted,
+// followed by at most bodyLimit bytes of each body.
+func (c *dumpConn) dump() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- request from %s ---\n", c.RemoteAddr())
+	c.in.write(&b, c.bodyLimit)
+	fmt.Fprintf(&b, "--- response to %s ---\n", c.RemoteAddr())
+	c.out.write(&b, c.bodyLimit)
+	io.WriteString(c.w, b.String())
+}
+
+func (d *dumpBuffer) write(b *strings.Builder, bodyLimit int) {
+	data := d.data.Bytes()
+	head, body, found := bytes.Cut(data, []byte("\r\n\r\n"))
+	b.WriteString(redactHead(string(head)))
+	b.WriteString("\n")
+	if !found {
+		if d.total > len(data) {
+			fmt.Fprintf(b, "[head truncated, %d bytes in total]\n", d.total)
+		}
+		return
+	}
+
+	bodyLen := d.total - len(head) - 4
+	if bodyLen == 0 {
+		return
+	}
+
+	shown := body[:min(len(body), bodyLimit)]
+	b.WriteString("\n")
+	if utf8.Valid(shown) {
+		b.Write(shown)
+		b.WriteString("\n")
+	} else {
+		fmt.Fprintf(b, "[%d bytes of binary data]\n", len(shown))
+	}
+	if bodyLen > len(shown) {
+		fmt.Fprintf(b, "[body truncated, %d of %d bytes shown]\n", len(shown), bodyLen)
+	}
+}
+
+// redactHead returns a message head with its lines joined by newlines and
+// the values of the dumpSecretHeaders and dumpSecretParams left out.
+func redactHead(head string) string {
+	lines := strings.Split(head, "\r\n")
+	for i, line := range lines {
+		if i == 0 {
+			lines[i] = redactTarget(line)
+			continue
+		}
+		name, _, ok := strings.Cut(line, ":")
+		if ok && dumpSecretHeaders[strings.ToLower(strings.TrimSpace(name))] {
+			lines[i] = name + ": [redacted]"
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// redactTarget leaves the values of the dumpSecretParams out of the target
+// of a request line.
+func redactTarget(line string) string {
+	method, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return line
+	}
+	target, version, _ := strings.Cut(rest, " ")
+	path, query, ok := strings.Cut(target, "?")
+	if !ok {
+		return line
+	}
+
+	params := strings.Split(query, "&")
+	for i, p := range params {
+		name, _, _ := strings.Cut(p, "=")
+		if slices.Contains(dumpSecretParams, name) {
+			params[i] = name + "=[redacted]"
+		}
+	}
+
+	return method + " " + path + "?" + strings.Join(params, "&") + " " + version
+}
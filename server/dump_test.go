@@ -0,0 +1,61 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDebugDump(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Storage = storageMemory
+	opts.UIAuth = "me:secret"
+	opts.DebugDump = true
+	opts.DebugDumpBody = 4
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dump strings.Builder
+	s.dumps = &dump
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.handleConn(server)
+		close(done)
+	}()
+	go io.WriteString(client, "GET /echo/hello?x=1&signature=c2lnbmVk HTTP/1.1\r\n"+
+		"Host: x\r\n"+
+		"Authorization: Basic bWU6c2VjcmV0\r\n"+
+		"X-Api-Key: k-123\r\n"+
+		"Cookie: naive_session=s-456\r\n"+
+		"Connection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	client.Close()
+	<-done
+
+	out := dump.String()
+	for _, want := range []string{
+		"--- request from pipe ---\nGET /echo/hello?x=1&signature=[redacted] HTTP/1.1\nHost: x\n",
+		"Authorization: [redacted]\nX-Api-Key: [redacted]\nCookie: [redacted]\n",
+		"--- response to pipe ---\nHTTP/1.1 200 OK\n",
+		"\nhell\n[body truncated, 4 of 5 bytes shown]\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dump lacks %q:\n%s", want, out)
+		}
+	}
+	for _, secret := range []string{"bWU6c2VjcmV0", "k-123", "s-456", "c2lnbmVk"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("dump holds %q:\n%s", secret, out)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// The end-to-end tests run the server on a real loopback port and talk to
+// it the way clients out there do: through net/http, and over raw TCP for
+// what net/http won't send. They pin down current behaviour, odd corners
+// included, so that refactors show up as failures rather than surprises.
+
+// e2eServer starts a server with every optional endpoint switched on.
+func e2eServer(t *testing.T) *servertest.Server {
+	t.Helper()
+
+	opts := servertest.Options()
+	opts.UIAuth = "me:secret"
+	opts.Dev = true
+
+	return servertest.New(t, opts)
+}
+
+// rawExchange sends raw on a fresh connection, closing the write side after
+// it, and returns the status line of the answer, or "" if the connection
+// was closed without one.
+func rawExchange(t *testing.T, s *servertest.Server, raw string) string {
+	t.Helper()
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, raw)
+	conn.(*net.TCPConn).CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && err != io.EOF {
+		t.Fatalf("error reading the answer to %q: %v", raw, err)
+	}
+
+	return strings.TrimSpace(line)
+}
+
+func TestE2EEndpoints(t *testing.T) {
+	s := e2eServer(t)
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	do := func(method, path, body string, header http.Header) (*http.Response, string) {
+		t.Helper()
+
+		req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("%s %s: error reading body: %v", method, path, err)
+		}
+
+		return resp, string(data)
+	}
+
+	auth := http.Header{"Authorization": {"Basic bWU6c2VjcmV0"}}
+	do(http.MethodPut, "/files/docs/report.txt", "quarterly report", nil)
+	do(http.MethodPut, "/files/docs/to%20do.txt", "milk", nil)
+
+	for _, tc := range []struct {
+		method, path, body string
+		header             http.Header
+		status             int
+		contains           string
+	}{
+		{"GET", "/", "", nil, 200, ""},
+		{"GET", "/echo/hello", "", nil, 200, "hello"},
+		{"POST", "/echo", "posted", nil, 200, "posted"},
+		{"GET", "/upload", "", nil, 200, "<form"},
+		{"GET", "/user-agent", "", http.Header{"User-Agent": {"e2e"}}, 200, "e2e"},
+		{"GET", "/ip", "", nil, 200, "127.0.0.1"},
+		{"GET", "/ip?format=json", "", nil, 200, `"origin":"127.0.0.1"`},
+		{"GET", "/headers", "", http.Header{"X-E2e": {"yes"}}, 200, "X-E2e: yes"},
+		{"GET", "/headers?format=json", "", nil, 200, `"headers"`},
+		{"GET", "/status/418", "", nil, 418, ""},
+		{"GET", "/status/abc", "", nil, 400, ""},
+		{"GET", "/delay/10ms", "", nil, 200, "10ms"},
+		{"GET", "/files/docs/report.txt", "", nil, 200, "quarterly report"},
+		{"GET", "/files/docs/report.txt", "", http.Header{"Range": {"bytes=0-8"}}, 206, "quarterly"},
+		{"GET", "/files/docs/", "", nil, 200, "report.txt"},
+		{"GET", "/files/docs/?format=json", "", nil, 200, `"name":"report.txt"`},
+		{"GET", "/files/docs/", "", nil, 200, `href="/files/docs/to%20do.txt"`},
+		{"GET", "/files/docs/to%20do.txt", "", nil, 200, "milk"},
+		{"GET", "/search?q=to+do", "", nil, 200, `"name":"docs/to do.txt"`},
+		{"GET", "/files/missing.txt", "", nil, 404, ""},
+		{"GET", "/files", "", nil, 404, ""},
+		{"POST", "/files/docs/other.txt", "other", nil, 201, ""},
+		{"PATCH", "/files/docs/other.txt", "", nil, 405, ""},
+		{"GET", "/search?q=report", "", nil, 200, `"name":"docs/report.txt"`},
+		{"GET", "/search?q=quarterly", "", nil, 200, `"results":[]`},
+		{"GET", "/search?q=quarterly&content=true", "", nil, 200, `"name":"docs/report.txt"`},
+		{"GET", "/search", "", nil, 400, ""},
+		{"GET", "/ui", "", nil, 401, ""},
+		{"GET", "/ui", "", auth, 200, ""},
+		{"GET", "/trash", "", auth, 200, "[]"},
+		{"OPTIONS", "/files/docs/report.txt", "", nil, 204, ""},
+		{"GET", "/healthz", "", nil, 200, "ok"},
+		{"GET", "/nowhere", "", nil, 404, ""},
+	} {
+		resp, body := do(tc.method, tc.path, tc.body, tc.header)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: got %d, want %d", tc.method, tc.path, resp.StatusCode, tc.status)
+		}
+		if !strings.Contains(body, tc.contains) {
+			t.Errorf("%s %s: got body %q, want it to contain %q", tc.method, tc.path, body, tc.contains)
+		}
+	}
+
+	// The dev event stream stays open, so only its head is read.
+	resp, err := client.Get(s.URL + "/_dev/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("GET /_dev/events: got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestE2EMalformed(t *testing.T) {
+	s := e2eServer(t)
+
+	for _, tc := range []struct {
+		name, raw string
+		// status is the expected status line, "" if the connection is
+		// closed without an answer.
+		status string
+	}{
+		{"garbage", "GARBAGE\r\n\r\n", ""},
+		{"method only", "GET\r\n\r\n", ""},
+		{"space in target", "GET /echo/a b HTTP/1.1\r\nHost: x\r\n\r\n", ""},
+		{"bad escape", "GET /files/%zz HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"relative target", "GET echo HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"no host", "GET / HTTP/1.1\r\n\r\n", "HTTP/1.1 200 OK"},
+		{"unknown version", "GET / HTTP/9.9\r\nHost: x\r\n\r\n", "HTTP/1.1 200 OK"},
+		{"HTTP/1.0", "GET /echo/x HTTP/1.0\r\n\r\n", "HTTP/1.1 200 OK"},
+		{"no length", "POST /files/a.txt HTTP/1.1\r\nHost: x\r\n\r\n", "HTTP/1.1 411 Length Required"},
+		{"bad length", "POST /files/a.txt HTTP/1.1\r\nHost: x\r\nContent-Length: abc\r\n\r\n", "HTTP/1.1 400 Bad Request"},
+		{"conflicting lengths", "POST /files/a.txt HTTP/1.1\r\nHost: x\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nab", "HTTP/1.1 400 Bad Request"},
+		{"bad chunk", "POST /files/a.txt HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", "HTTP/1.1 500 Internal Server Error"},
+	} {
+		if got := rawExchange(t, s, tc.raw); got != tc.status {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.status)
+		}
+	}
+
+	// None of it hurt the server.
+	if got := rawExchange(t, s, "GET /echo/ok HTTP/1.1\r\nHost: x\r\n\r\n"); got != "HTTP/1.1 200 OK" {
+		t.Errorf("got %q after the malformed requests", got)
+	}
+}
+
+func TestE2EPartialWrites(t *testing.T) {
+	s := e2eServer(t)
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The request trickles in a few bytes at a time, split mid-line and
+	// mid-body.
+	raw := "PUT /files/slow.txt HTTP/1.1\r\nHost: x\r\nContent-Length: 11\r\n\r\nhello world"
+	for i := 0; i < len(raw); i += 3 {
+		io.WriteString(conn, raw[i:min(i+3, len(raw))])
+		time.Sleep(2 * time.Millisecond)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("got %d, want 201", resp.StatusCode)
+	}
+
+	c := s.Client()
+	c.Get("/files/slow.txt").AssertStatus(http.StatusOK).AssertBody("hello world")
+}
+
+func TestE2EEarlyDisconnect(t *testing.T) {
+	s := e2eServer(t)
+
+	for _, raw := range []string{
+		// Gone in the middle of the head.
+		"GET /echo/x HTTP/1.1\r\nHo",
+		// Gone in the middle of the body.
+		"PUT /files/cut.txt HTTP/1.1\r\nHost: x\r\nContent-Length: 100\r\n\r\nonly part",
+		// Gone while a response is being delayed.
+		"GET /delay/5s HTTP/1.1\r\nHost: x\r\n\r\n",
+	} {
+		conn, err := s.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, raw)
+		time.Sleep(20 * time.Millisecond)
+		conn.Close()
+	}
+
+	// An upload whose body is cut short is, for now, stored as far as it
+	// got.
+	c := s.Client()
+	c.Get("/files/cut.txt").AssertStatus(http.StatusOK).AssertBody("only part")
+	c.Get("/echo/still-here").AssertStatus(http.StatusOK).AssertBody("still-here")
+
+	// Nothing is left hanging: shutdown finds every connection finished.
+	if err := s.Shutdown(); err != nil {
+		t.Errorf("got %v shutting down", err)
+	}
+}
+
+func TestE2EKeepAlive(t *testing.T) {
+	s := e2eServer(t)
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Pipelined requests, sent in one write, are answered in order on the
+	// same connection.
+	io.WriteString(conn, "GET /echo/one HTTP/1.1\r\nHost: x\r\n\r\n"+
+		"POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\ntwo"+
+		"GET /ip?format=json HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"one", "two"} {
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != want || resp.Close {
+			t.Errorf("got %q with close %v, want %q kept open", body, resp.Close, want)
+		}
+	}
+
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ip struct {
+		Origin string `json:"origin"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ip); err != nil || ip.Origin != "127.0.0.1" || !resp.Close {
+		t.Errorf("got origin %q, %v with close %v", ip.Origin, err, resp.Close)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("got %v after Connection: close, want EOF", err)
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// earlyHint is a rule from -early-hints: GET requests for paths matching
+// path are sent link in a 103 Early Hints response ahead of their own, so
+// browsers can start fetching what the page needs while it is made.
+type earlyHint struct {
+	path *regexp.Regexp
+	link string
+}
+
+// loadEarlyHints reads the early hints file at path. Each line holds a path
+// pattern, as in the response header rules, and a Link header value
+// running to the end of the line, such as '</app.css>; rel=preload;
+// as=style'. Blank lines and lines starting with # are skipped.
+func loadEarlyHints(path string) ([]earlyHint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening early hints: %v", err)
+	}
+	defer f.Close()
+
+	var hints []earlyHint
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, link, ok := strings.Cut(line, " ")
+		link = strings.TrimSpace(link)
+		if !ok || !strings.HasPrefix(link, "<") || !strings.Contains(link, ">") {
+			return nil, fmt.Errorf("%s:%d: expected 'path <url>; rel=preload...'", path, n)
+		}
+
+		hint := earlyHint{link: link}
+		if hint.path, err = globPattern(pattern); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		hints = append(hints, hint)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading early hints: %v", err)
+	}
+
+	return hints, nil
+}
+
+// earlyLinks returns the links of the hints matching req.
+func earlyLinks(req request, hints []earlyHint) []string {
+	if req.method != methodGet {
+		return nil
+	}
+
+	var links []string
+	for _, h := range hints {
+		if h.path.MatchString(req.cleanPath()) {
+			links = append(links, h.link)
+		}
+	}
+
+	return links
+}
+
+// sendEarlyHints writes a 103 Early Hints response with a Link header for
+// each of links ahead of the response to req on conn. Handlers that know
+// what a page will need call it before they make the page. It does nothing
+// once the response has started, or for HTTP/1.0 clients, which don't
+// know of informational responses.
+func sendEarlyHints(conn net.Conn, req request, links []string) {
+	c, ok := conn.(*exchangeConn)
+	if !ok || c.headWritten || len(links) == 0 || req.httpVersion != "HTTP/1.1" {
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString("HTTP/1.1 103 Early Hints\r\n")
+	for _, link := range links {
+		b.WriteString("Link: " + link + "\r\n")
+	}
+	b.WriteString("\r\n")
+	c.Conn.Write(b.Bytes())
+}
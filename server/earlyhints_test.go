@@ -0,0 +1,82 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestEarlyHints(t *testing.T) {
+	hints := filepath.Join(t.TempDir(), "hints")
+	os.WriteFile(hints, []byte(`# critical assets
+/echo/* </app.css>; rel=preload; as=style
+/echo/page </app.js>; rel=preload; as=script
+`), 0o644)
+	opts := servertest.Options()
+	opts.EarlyHints = hints
+	s := servertest.New(t, opts)
+
+	send := func(raw string) *bufio.Reader {
+		t.Helper()
+		conn, err := s.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		io.WriteString(conn, raw)
+		return bufio.NewReader(conn)
+	}
+
+	r := send("GET /echo/page HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusEarlyHints {
+		t.Fatalf("got %d first, want 103", resp.StatusCode)
+	}
+	want := []string{"</app.css>; rel=preload; as=style", "</app.js>; rel=preload; as=script"}
+	if got := resp.Header.Values("Link"); !slices.Equal(got, want) {
+		t.Errorf("got links %q, want %q", got, want)
+	}
+	resp, err = http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "page" {
+		t.Errorf("got %d %q after the hints, want 200 page", resp.StatusCode, body)
+	}
+
+	// No hints for other paths and methods, or for HTTP/1.0 clients.
+	for _, raw := range []string{
+		"GET /health HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n",
+		"POST /echo/page HTTP/1.1\r\nHost: x\r\nContent-Length: 0\r\nConnection: close\r\n\r\n",
+		"GET /echo/page HTTP/1.0\r\n\r\n",
+	} {
+		resp, err := http.ReadResponse(send(raw), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode == http.StatusEarlyHints {
+			t.Errorf("got early hints for %q", raw)
+		}
+	}
+}
+
+func TestEarlyHintsInvalid(t *testing.T) {
+	hints := filepath.Join(t.TempDir(), "hints")
+	os.WriteFile(hints, []byte("/ui app.css\n"), 0o644)
+	opts := servertest.Options()
+	opts.EarlyHints = hints
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for a link without a URL in angle brackets")
+	}
+}
@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestEchoBody(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	post := func(target, contentType, body string) *servertest.Response {
+		var h http.Header
+		if contentType != "" {
+			h = http.Header{"Content-Type": {contentType}}
+		}
+		return c.Do(http.MethodPost, target, strings.NewReader(body), h)
+	}
+
+	post("/echo", "application/json", `{"a":1}`).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "application/json").
+		AssertBody(`{"a":1}`)
+	post("/echo", "text/plain", "héllo").
+		AssertHeader("Content-Type", "text/plain").
+		AssertBody("héllo")
+	post("/echo?status=418", "", "teapot").
+		AssertStatus(http.StatusTeapot).
+		AssertHeader("Content-Type", "").
+		AssertBody("teapot")
+	post("/echo?status=204", "text/plain", "gone").AssertStatus(http.StatusNoContent).AssertBody("")
+	post("/echo?status=99", "text/plain", "x").AssertStatus(http.StatusBadRequest)
+
+	start := time.Now()
+	post("/echo?delay=100ms", "text/plain", "late").AssertStatus(http.StatusOK).AssertBody("late")
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Errorf("answered after %s, want at least the delay", waited)
+	}
+
+	c.Do(http.MethodOptions, "/echo", nil, nil).AssertHeader("Allow", "GET, POST, OPTIONS")
+}
+
+func TestEchoStream(t *testing.T) {
+	s := servertest.New(t, servertest.Options())
+	c := s.Client()
+
+	c.RawHead("GET /echo-stream/1/0 HTTP/1.1\r\nHost: x\r\n\r\n").
+		AssertStatus(http.StatusOK).
+		AssertHeader("Transfer-Encoding", "chunked").
+		AssertHeader("Content-Length", "")
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /echo-stream/3/50ms HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("got status %d, transfer encoding %q", resp.StatusCode, resp.TransferEncoding)
+	}
+
+	// Each line arrives as a chunk of its own, the interval apart.
+	start := time.Now()
+	var arrived []time.Duration
+	lines := bufio.NewReader(resp.Body)
+	for n := 1; n <= 3; n++ {
+		line, err := lines.ReadString('\n')
+		if err != nil || line != strconv.Itoa(n)+"\n" {
+			t.Fatalf("got chunk %q, %v, want %d", line, err, n)
+		}
+		arrived = append(arrived, time.Since(start))
+	}
+	if rest, err := io.ReadAll(lines); err != nil || len(rest) != 0 {
+		t.Fatalf("got %q, %v after the last chunk", rest, err)
+	}
+	if arrived[0] > 40*time.Millisecond || arrived[2] < 80*time.Millisecond {
+		t.Errorf("got chunks after %v, want them 50ms apart", arrived)
+	}
+
+	// The stream is capped at -max-delay in all.
+	opts := servertest.Options()
+	opts.MaxDelay = 100 * time.Millisecond
+	capped := servertest.NewPipe(t, opts).Client()
+	start = time.Now()
+	capped.Get("/echo-stream/3/10s").AssertStatus(http.StatusOK).AssertBody("1\n2\n3\n")
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("stream took %s, want -max-delay at most", took)
+	}
+
+	for _, path := range []string{"/echo-stream/0/1s", "/echo-stream/1001/0", "/echo-stream/x/1s", "/echo-stream/3/-1s", "/echo-stream/3"} {
+		c.Get(path).AssertStatus(http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	errorFormatHTML = "html"
+	errorFormatJSON = "json"
+)
+
+//go:embed templates/error.html
+var defaultErrorPageHTML string
+
+// defaultErrorPage is the page served for the statuses in
+// defaultErrorMessages that -error-pages has no page for.
+var defaultErrorPage = template.Must(template.New("error").Parse(defaultErrorPageHTML))
+
+// defaultErrorMessages are the explanations shown on the default pages.
+var defaultErrorMessages = map[int]string{
+	statusForbidden:           "You don't have permission to access this resource.",
+	statusNotFound:            "The requested resource could not be found on this server.",
+	statusInternalServerError: "The server ran into an error and could not complete the request.",
+}
+
+// errorPageSet maps status codes to the templates rendered for them.
+type errorPageSet map[int]*template.Template
+
+// errorPageData is what error page templates are rendered with.
+type errorPageData struct {
+	Status     int
+	StatusText string
+	Message    string
+	RequestID  string
+	// Time is when the error occurred, in RFC 3339 UTC.
+	Time string
+}
+
+// loadErrorPages parses the error page templates in dir. For each status
+// code the most specific file wins: 404.html, then 40x.html, then 4xx.html.
+func loadErrorPages(dir string) (errorPageSet, error) {
+	pages := make(errorPageSet)
+	cache := make(map[string]*template.Template)
+
+	for code := 400; code <= 599; code++ {
+		s := strconv.Itoa(code)
+		for _, name := range []string{s + ".html", s[:2] + "x.html", s[:1] + "xx.html"} {
+			t, ok := cache[name]
+			if !ok {
+				var err error
+				t, err = parseErrorPage(filepath.Join(dir, name))
+				if err != nil {
+					return nil, err
+				}
+				cache[name] = t
+			}
+			if t != nil {
+				pages[code] = t
+				break
+			}
+		}
+	}
+
+	return pages, nil
+}
+
+// parseErrorPage returns nil without an error if the file doesn't exist.
+func parseErrorPage(path string) (*template.Template, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	t, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	return t, nil
+}
+
+// errorPage renders the page for code of the request id: the one from
+// -error-pages or else the default, if code has one. It returns nil if
+// there is no page for code.
+func (s *Server) errorPage(code int, id string) *content {
+	t := defaultErrorPage
+	if pages := s.errorPages.Load(); pages != nil && (*pages)[code] != nil {
+		t = (*pages)[code]
+	} else if _, ok := defaultErrorMessages[code]; !ok {
+		return nil
+	}
+
+	data := errorPageData{
+		Status:     code,
+		StatusText: statusText(code),
+		Message:    defaultErrorMessages[code],
+		RequestID:  id,
+		Time:       time.Now().UTC().Format(time.RFC3339),
+	}
+	var body bytes.Buffer
+	if err := t.Execute(&body, data); err != nil {
+		s.log.Error("error rendering error page", "status", code, "err", err)
+		return nil
+	}
+
+	return &content{contentType: contentTypeTextHTML, body: body.Bytes()}
+}
+
+// errorJSON renders the JSON body of an error for code of the request id,
+// with the message the response came with, if any.
+func errorJSON(code int, id, message string) *content {
+	body, _ := json.Marshal(struct {
+		Status    int    `json:"status"`
+		Error     string `json:"error"`
+		Message   string `json:"message,omitempty"`
+		RequestID string `json:"request_id,omitempty"`
+	}{code, statusText(code), message, id})
+
+	return &content{contentType: contentTypeJSON, body: append(body, '\n')}
+}
+
+// wantsJSONErrors reports whether errors are rendered as JSON for req: with
+// -error-format json, unless the client asks for HTML but not JSON, as
+// browsers do.
+func (s *Server) wantsJSONErrors(req request) bool {
+	if s.opts.ErrorFormat != errorFormatJSON {
+		return false
+	}
+	accept := req.headers.get("Accept")
+
+	return strings.Contains(accept, contentTypeJSON) || !strings.Contains(accept, contentTypeTextHTML)
+}
+
+// withErrorPage returns the response head and body with the error page for
+// its status in place of an empty body, or nil if it has none. Error pages
+// are filled in as the response is written, when the request ID is at hand.
+// As JSON, every error gets a body, one of plain text it came with becoming
+// its message.
+func (s *Server) withErrorPage(head, body []byte, id string, asJSON bool) []byte {
+	lines := strings.Split(string(head), "\r\n")
+	var contentType string
+	whole := len(body) == 0
+	for _, line := range lines[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		switch name {
+		case "transfer-encoding":
+			return nil
+		case "content-length":
+			whole = value == strconv.Itoa(len(body))
+		case "content-type":
+			contentType = value
+		}
+	}
+	if !whole {
+		// More of the body is on the way, so it can't be replaced.
+		return nil
+	}
+
+	var page *content
+	switch {
+	case asJSON && (len(body) == 0 || mediaType(contentType) == contentTypeTextPlain):
+		page = errorJSON(headStatus(head), id, strings.TrimSpace(string(body)))
+	case len(body) == 0:
+		page = s.errorPage(headStatus(head), id)
+	}
+	if page == nil {
+		return nil
+	}
+
+	var b bytes.Buffer
+	for i, line := range lines {
+		name, _, _ := strings.Cut(line, ":")
+		if i > 0 && (strings.EqualFold(name, "Content-Length") || strings.EqualFold(name, "Content-Type")) {
+			continue
+		}
+		b.WriteString(line + "\r\n")
+	}
+	fmt.Fprintf(&b, "Content-Type: %s\r\n", s.withCharset(page.contentType))
+	fmt.Fprintf(&b, "Content-Length: %d\r\n", len(page.body))
+	if id != "" {
+		fmt.Fprintf(&b, "X-Request-Id: %s\r\n", id)
+	}
+	b.WriteString("\r\n")
+	b.Write(page.body)
+
+	return b.Bytes()
+}
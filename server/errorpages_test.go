@@ -0,0 +1,95 @@
+package server_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestDefaultErrorPages(t *testing.T) {
+	s := servertest.NewPipe(t, servertest.Options())
+	c := s.Client()
+
+	resp := c.Get("/nowhere").
+		AssertStatus(http.StatusNotFound).
+		AssertHeader("Content-Type", "text/html; charset=utf-8").
+		AssertBodyContains("404 Not Found")
+	id := resp.Header.Get("X-Request-Id")
+	if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(id) {
+		t.Errorf("got request ID %q", id)
+	}
+	resp.AssertBodyContains(id)
+	if !regexp.MustCompile(`\d{4}-\d\d-\d\dT\d\d:\d\d:\d\dZ`).Match(resp.Body) {
+		t.Errorf("no timestamp in %s", resp.Body)
+	}
+
+	// A request ID set by a proxy in front is kept; one that isn't safe to
+	// show is replaced.
+	c.Do(http.MethodGet, "/nowhere", nil, http.Header{"X-Request-Id": {"edge-42.a"}}).
+		AssertHeader("X-Request-Id", "edge-42.a").
+		AssertBodyContains("edge-42.a")
+	if id := c.Do(http.MethodGet, "/nowhere", nil, http.Header{"X-Request-Id": {"<b>"}}).Header.Get("X-Request-Id"); id == "<b>" {
+		t.Error("got the unsafe request ID back")
+	}
+
+	// Other errors keep their empty bodies.
+	c.Get("/status/400").AssertStatus(http.StatusBadRequest).AssertBody("").AssertHeader("X-Request-Id", "")
+}
+
+func TestCustomErrorPages(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "4xx.html"), []byte("{{.Status}} for {{.RequestID}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := servertest.Options()
+	opts.ErrorPages = dir
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	c.Do(http.MethodGet, "/nowhere", nil, http.Header{"X-Request-Id": {"abc"}}).
+		AssertStatus(http.StatusNotFound).
+		AssertBody("404 for abc")
+	c.Get("/status/400").AssertBodyContains("400 for ")
+	c.Get("/status/500").AssertBodyContains("500 Internal Server Error")
+}
+
+func TestJSONErrors(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ".naive-access"), []byte("deny-extensions .exe\n"), 0o644)
+	opts := servertest.Options()
+	opts.Storage = "disk"
+	opts.Directory = dir
+	opts.ErrorFormat = "json"
+	c := servertest.NewPipe(t, opts).Client()
+
+	c.Do(http.MethodGet, "/nowhere", nil, http.Header{"X-Request-Id": {"req-1"}}).
+		AssertStatus(http.StatusNotFound).
+		AssertHeader("Content-Type", "application/json").
+		AssertHeader("Vary", "Accept").
+		AssertBody(`{"status":404,"error":"Not Found","request_id":"req-1"}` + "\n")
+	c.Do(http.MethodGet, "/status/400", nil, http.Header{"Accept": {"application/json"}}).
+		AssertStatus(http.StatusBadRequest).
+		AssertBodyContains(`{"status":400,"error":"Bad Request","request_id":"`)
+
+	// Plain text explanations become the message.
+	c.Do(http.MethodPut, "/files/run.exe", strings.NewReader("x"), http.Header{"X-Request-Id": {"req-2"}}).
+		AssertStatus(http.StatusUnsupportedMediaType).
+		AssertBody(`{"status":415,"error":"Unsupported Media Type","message":"files named run.exe can't be uploaded here","request_id":"req-2"}` + "\n")
+
+	// Browsers still get pages.
+	c.Do(http.MethodGet, "/nowhere", nil, http.Header{"Accept": {"text/html,application/xhtml+xml,*/*;q=0.8"}}).
+		AssertHeader("Content-Type", "text/html; charset=utf-8").
+		AssertBodyContains("404 Not Found")
+
+	opts.ErrorFormat = "xml"
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for an unknown format")
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"runtime"
+)
+
+type gcStats struct {
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"pause_total_ns"`
+	LastPauseNs  uint64 `json:"last_pause_ns"`
+}
+
+// serveVars answers the admin API's GET /debug/vars in the format of the
+// expvar package's handler: every published variable, which includes
+// cmdline and memstats, plus the goroutine count, a GC summary and the
+// server's own counters. They aren't published with expvar itself since
+// that is process-wide and there may be more than one Server.
+func (s *Server) serveVars(conn net.Conn) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	gc := gcStats{
+		NumGC:        mem.NumGC,
+		PauseTotalNs: mem.PauseTotalNs,
+		LastPauseNs:  mem.PauseNs[(mem.NumGC+255)%256],
+	}
+
+	var b bytes.Buffer
+	b.WriteString("{\n")
+	first := true
+	write := func(key string, value string) {
+		if !first {
+			b.WriteString(",\n")
+		}
+		first = false
+		fmt.Fprintf(&b, "%q: %s", key, value)
+	}
+	writeJSON := func(key string, v any) {
+		value, err := json.Marshal(v)
+		if err != nil {
+			value = []byte("null")
+		}
+		write(key, string(value))
+	}
+
+	expvar.Do(func(kv expvar.KeyValue) {
+		write(kv.Key, kv.Value.String())
+	})
+	writeJSON("goroutines", runtime.NumGoroutine())
+	writeJSON("gc", gc)
+	writeJSON("server", s.statsSnapshot())
+	if s.accessLog != nil {
+		writeJSON("access_log", s.accessLog.stats())
+	}
+	b.WriteString("\n}\n")
+
+	c := content{
+		contentType: contentTypeJSON + "; charset=utf-8",
+		body:        b.Bytes(),
+	}
+	conn.Write(buildResponse(statusOK, &c))
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"testing"
+)
+
+func TestDebugVars(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Storage = storageMemory
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.GC()
+
+	resp, body := adminDo(t, s, "GET /debug/vars HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Fatalf("got %d with type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var vars struct {
+		Cmdline    []string        `json:"cmdline"`
+		Memstats   json.RawMessage `json:"memstats"`
+		Goroutines int             `json:"goroutines"`
+		GC         gcStats         `json:"gc"`
+		Server     *statsSnapshot  `json:"server"`
+	}
+	if err := json.Unmarshal(body, &vars); err != nil {
+		t.Fatalf("got %v decoding %s", err, body)
+	}
+
+	if len(vars.Cmdline) == 0 || len(vars.Memstats) == 0 {
+		t.Errorf("got cmdline %q and memstats %s, want the expvar variables", vars.Cmdline, vars.Memstats)
+	}
+	if vars.Goroutines < 1 {
+		t.Errorf("got %d goroutines", vars.Goroutines)
+	}
+	if vars.GC.NumGC < 1 {
+		t.Errorf("got %d collections after one was run", vars.GC.NumGC)
+	}
+	if vars.Server == nil || vars.Server.Goroutines < 1 {
+		t.Errorf("got server counters %+v", vars.Server)
+	}
+
+	if resp, _ := adminDo(t, s, "POST /debug/vars HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0\r\n\r\n"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("got %d to POST, want 405", resp.StatusCode)
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"container/list"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fileKey identifies a version of a file: a change to it changes its
+// modification time or size, so entries of older versions are never hit.
+type fileKey struct {
+	name    string
+	modTime time.Time
+	size    int64
+}
+
+type fileEntry struct {
+	key  fileKey
+	data []byte
+}
+
+// fileCache keeps the content of recently served small files, evicting
+// the least recently used once they take up more than maxBytes, so the
+// files asked for most are served without touching the store.
+type fileCache struct {
+	mu       sync.Mutex
+	maxFile  int64
+	maxBytes int64
+	used     int64
+	lru      *list.List
+	entries  map[fileKey]*list.Element
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// fileCacheStats are the counters of the file cache in the admin API's
+// stats.
+type fileCacheStats struct {
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+	Entries int     `json:"entries"`
+	Bytes   int64   `json:"bytes"`
+}
+
+func newFileCache(maxBytes, maxFile int64) *fileCache {
+	return &fileCache{
+		maxFile:  maxFile,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[fileKey]*list.Element),
+	}
+}
+
+// cacheable reports whether the file with info is small enough to cache.
+func (c *fileCache) cacheable(info fs.FileInfo) bool {
+	return c != nil && info.Size() <= c.maxFile && info.Size() <= c.maxBytes
+}
+
+func (c *fileCache) get(key fileKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.hits.Add(1)
+	c.lru.MoveToFront(el)
+
+	return el.Value.(*fileEntry).data, true
+}
+
+func (c *fileCache) put(key fileKey, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	// The data is shared by every request served from it, so appending to
+	// it must copy it.
+	data = slices.Clip(data)
+	c.entries[key] = c.lru.PushFront(&fileEntry{key: key, data: data})
+	c.used += int64(len(data))
+
+	for c.used > c.maxBytes {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// invalidate drops the entries of the named file, or of everything below
+// it if it is a directory.
+func (c *fileCache) invalidate(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.entries {
+		if key.name == name || strings.HasPrefix(key.name, name+"/") {
+			c.removeLocked(el)
+		}
+	}
+}
+
+// flush drops every entry.
+func (c *fileCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[fileKey]*list.Element)
+	c.used = 0
+}
+
+func (c *fileCache) removeLocked(el *list.Element) {
+	e := el.Value.(*fileEntry)
+	c.lru.Remove(el)
+	delete(c.entries, e.key)
+	c.used -= int64(len(e.data))
+}
+
+func (c *fileCache) stats() *fileCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := &fileCacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: len(c.entries),
+		Bytes:   c.used,
+	}
+	if total := st.Hits + st.Misses; total > 0 {
+		st.HitRate = float64(st.Hits) / float64(total)
+	}
+
+	return st
+}
+
+// readCachedFile returns the content of name, whose info was just looked
+// up, from the file cache if it is there and small enough to be.
+func (s *Server) readCachedFile(name string, info fs.FileInfo) ([]byte, error) {
+	if !s.files.cacheable(info) {
+		return s.readFile(name)
+	}
+
+	key := fileKey{name: name, modTime: info.ModTime(), size: info.Size()}
+	if data, ok := s.files.get(key); ok {
+		return data, nil
+	}
+	data, err := s.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	// A file that changed since info was looked up is read as it is now,
+	// and isn't cached under the key of what it was.
+	if int64(len(data)) == key.size {
+		s.files.put(key, data)
+	}
+
+	return data, nil
+}
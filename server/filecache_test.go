@@ -0,0 +1,54 @@
+package server_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestFileCache(t *testing.T) {
+	dir := t.TempDir()
+	opts := servertest.Options()
+	opts.Storage = "disk"
+	opts.Directory = dir
+	opts.FileCacheSize = 1 << 20
+	opts.FileCacheMaxFile = 100
+	c := servertest.NewPipe(t, opts).Client()
+
+	small := filepath.Join(dir, "app.css")
+	large := filepath.Join(dir, "big.txt")
+	stamp := time.Now().Add(-time.Hour).Truncate(time.Second)
+	rewrite := func(path, data string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	rewrite(small, "body{}", stamp)
+	rewrite(large, strings.Repeat("a", 200), stamp)
+
+	c.Get("/files/app.css").AssertStatus(http.StatusOK).AssertBody("body{}")
+	c.Get("/files/big.txt").AssertStatus(http.StatusOK)
+
+	// A change behind the server's back that keeps the time and size is
+	// only seen for files too large to cache.
+	rewrite(small, "p{ }  ", stamp)
+	rewrite(large, strings.Repeat("b", 200), stamp)
+	c.Get("/files/app.css").AssertBody("body{}")
+	c.Get("/files/big.txt").AssertBody(strings.Repeat("b", 200))
+
+	// Any other change is a new version.
+	rewrite(small, "p{ }  ", stamp.Add(time.Second))
+	c.Get("/files/app.css").AssertBody("p{ }  ")
+
+	// As are uploads, whatever their time.
+	c.Do(http.MethodPut, "/files/app.css", strings.NewReader("h1{}  "), nil).AssertStatus(http.StatusCreated)
+	os.Chtimes(small, stamp.Add(time.Second), stamp.Add(time.Second))
+	c.Get("/files/app.css").AssertBody("h1{}  ")
+}
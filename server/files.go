@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (s *Server) getFile(conn net.Conn, req request) error {
+	if len(req.pathParts) < 3 {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+
+	name := req.fileName()
+	if req.query.Has("versions") {
+		return s.serveVersions(conn, name)
+	}
+	if format := req.query.Get("archive"); format != "" {
+		return serveArchive(s.throttleWriter(req, conn), s.listable(req), name, format)
+	}
+
+	info, err := s.store.Stat(name)
+	if err != nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return fmt.Errorf("error reading %s: %v\n", name, err)
+	}
+	if info.IsDir() {
+		return s.serveListing(conn, req, name)
+	}
+	if req.query.Get("follow") == "true" {
+		return s.followFile(conn, req, name)
+	}
+	if size := req.query.Get("thumb"); size != "" {
+		return s.serveThumbnail(conn, req, name, info, size)
+	}
+
+	h := fileValidators(info)
+	if sum := s.contentHash(name); sum != "" {
+		h.set("X-Content-Hash", sum)
+	}
+	if s.files.cacheable(info) || s.dev != nil && isHTMLName(name) {
+		data, err := s.readCachedFile(name, info)
+		if err != nil {
+			conn.Write(buildResponse(statusNotFound, nil))
+			return fmt.Errorf("error reading %s: %v\n", name, err)
+		}
+		contentType := s.contentType(name)
+		if s.dev != nil && isHTMLName(name) {
+			contentType = contentTypeTextHTML
+			data = devInject(data)
+		}
+		return s.serveContent(conn, req, contentType, bytes.NewReader(data), h)
+	}
+
+	f, err := s.store.Open(name)
+	if err != nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return fmt.Errorf("error reading %s: %v\n", name, err)
+	}
+	defer f.Close()
+
+	return s.serveContent(conn, req, s.contentType(name), f, h)
+}
+
+// serveContent answers a GET of a file with validators h whose content,
+// of contentType, is read from body, honouring conditional and Range
+// headers. Only what is sent is read, so a range of a large file costs no
+// more than the range.
+func (s *Server) serveContent(conn net.Conn, req request, contentType string, body io.ReadSeeker, h header) error {
+	s.cacheHeaders(h)
+	if notModified(req, h) {
+		conn.Write(buildResponseHeaders(statusNotModified, h, nil))
+		return nil
+	}
+
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return fmt.Errorf("error sizing response: %v\n", err)
+	}
+	w := s.throttleWriter(req, conn)
+	h.set("Accept-Ranges", "bytes")
+	if spec := req.headers.get("Range"); spec != "" && ifRangeMatches(req, h) {
+		if ok, err := writeRanges(w, body, size, contentType, h, spec); ok {
+			if err != nil {
+				return fmt.Errorf("error sending ranges: %v\n", err)
+			}
+			return nil
+		}
+	}
+
+	h.set("Content-Type", contentType)
+	h.set("Content-Length", strconv.FormatInt(size, 10))
+	w.Write(buildResponseHeaders(statusOK, h, nil))
+	if err := copyRange(w, body, byteRange{start: 0, end: size - 1}); err != nil {
+		return fmt.Errorf("error sending content: %v\n", err)
+	}
+
+	return nil
+}
+
+// cacheHeaders adds the freshness headers configured for files to h.
+func (s *Server) cacheHeaders(h header) {
+	if s.opts.CacheMaxAge <= 0 {
+		return
+	}
+	h.set("Cache-Control", "max-age="+strconv.Itoa(int(s.opts.CacheMaxAge.Seconds())))
+	if s.opts.Expires {
+		h.set("Expires", time.Now().Add(s.opts.CacheMaxAge).UTC().Format(http.TimeFormat))
+	}
+}
+
+func (s *Server) putFile(conn net.Conn, body io.Reader, req request) error {
+	name := req.fileName()
+
+	// Hold the lock for the whole upload, so a second one to the same name
+	// is refused up front rather than after sending its body.
+	unlock, ok := s.locks.tryLock(name)
+	if !ok {
+		conn.Write(buildResponse(statusConflict, nil))
+		return nil
+	}
+	defer unlock()
+
+	size := int64(req.contentLength)
+	if req.chunked {
+		size = -1
+	}
+	body, ok = s.limitUpload(conn, name, body, size)
+	if !ok {
+		return nil
+	}
+
+	received, err := s.receiveFile(name, s.throttleReader(req, body))
+	if errors.Is(err, errUnacceptedType) {
+		conn.Write(buildResponse(statusUnsupportedMediaType, nil))
+		return nil
+	}
+	if err != nil {
+		conn.Write(buildResponse(bodyErrorStatus(err), nil))
+		return fmt.Errorf("error storing %s: %v\n", name, err)
+	}
+	var h header
+	if sum := s.contentHash(name); sum != "" {
+		h = header{"X-Content-Hash": {sum}}
+	}
+	conn.Write(buildResponseHeaders(statusCreated, h, nil))
+
+	s.uploaded(name, received, req.clientIP)
+
+	return nil
+}
+
+// uploadsDir holds uploads while they arrive, so the file they replace is
+// only replaced once all of it is there.
+const uploadsDir = ".uploads"
+
+// sniffLen is how much of an upload is looked at to tell its type, all
+// that http.DetectContentType considers.
+const sniffLen = 512
+
+var (
+	errUnacceptedType = errors.New("upload type not accepted")
+	errStoringUpload  = errors.New("error storing upload")
+)
+
+// receivedFile is what was learned of an upload as it was stored.
+type receivedFile struct {
+	size int64
+	sum  []byte
+}
+
+// Write counts p, as one of the writers the upload is copied to.
+func (f *receivedFile) Write(p []byte) (int, error) {
+	f.size += int64(len(p))
+	return len(p), nil
+}
+
+// uploadReader remembers what reading an upload failed with, to tell the
+// client's failures from the store's.
+type uploadReader struct {
+	r   io.Reader
+	err error
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	if err != nil && err != io.EOF {
+		u.err = err
+	}
+
+	return n, err
+}
+
+// receiveFile streams body into the store as name, keeping what it replaces
+// as a version if versioning is on. The body goes to a file of its own
+// under uploadsDir first and is renamed over name once it has all been
+// read, so however large it is it is never held in memory, and a failed
+// upload leaves name as it was. It fails as stageFile and commitFile do.
+func (s *Server) receiveFile(name string, body io.Reader) (receivedFile, error) {
+	staged, received, err := s.stageFile(name, body)
+	if err != nil {
+		return received, err
+	}
+
+	return received, s.commitFile(staged, name)
+}
+
+// stageFile streams body into a file of its own under uploadsDir, returning
+// its name, for commitFile to store as name. It fails with
+// errUnacceptedType if the start of body shows it isn't a type that may be
+// uploaded as name, and with an error wrapping errStoringUpload if the
+// store fails; any other error is that of reading body. Nothing is left
+// staged when it fails.
+func (s *Server) stageFile(name string, body io.Reader) (string, receivedFile, error) {
+	var received receivedFile
+
+	br := bufio.NewReaderSize(body, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return "", received, err
+	}
+	if !s.acceptUpload(name, head) {
+		return "", received, errUnacceptedType
+	}
+
+	var id [16]byte
+	rand.Read(id[:])
+	staged := joinName(uploadsDir, hex.EncodeToString(id[:]))
+	hash := sha256.New()
+	src := &uploadReader{r: br}
+	if err := s.store.Write(staged, io.TeeReader(src, io.MultiWriter(hash, &received))); err != nil {
+		s.store.Delete(staged)
+		if src.err != nil {
+			return "", received, src.err
+		}
+		return "", received, fmt.Errorf("%w: %v", errStoringUpload, err)
+	}
+	received.sum = hash.Sum(nil)
+
+	return staged, received, nil
+}
+
+// commitFile renames a file stageFile staged over name, keeping what it
+// replaces as a version if versioning is on. It fails with an error
+// wrapping errStoringUpload, deleting the staged file.
+func (s *Server) commitFile(staged, name string) error {
+	if err := s.keepVersion(name); err != nil {
+		s.store.Delete(staged)
+		return fmt.Errorf("%w: error keeping version: %v", errStoringUpload, err)
+	}
+	if err := s.store.Rename(staged, name); err != nil {
+		s.store.Delete(staged)
+		return fmt.Errorf("%w: %v", errStoringUpload, err)
+	}
+
+	return nil
+}
+
+// writeFile stores data as name, keeping what it replaces as a version if
+// versioning is on.
+func (s *Server) writeFile(name string, data []byte) error {
+	if err := s.keepVersion(name); err != nil {
+		return fmt.Errorf("error keeping version: %v", err)
+	}
+
+	return s.store.Write(name, bytes.NewReader(data))
+}
+
+func (s *Server) readFile(name string) ([]byte, error) {
+	f, err := s.store.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
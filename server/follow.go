@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// followInterval is how often a followed file is checked for growth.
+const followInterval = 250 * time.Millisecond
+
+// followFile answers GET /files/name?follow=true by sending the file and then
+// whatever is appended to it, like tail -f, as a chunked response. It ends
+// when the client hangs up or the server shuts down. A file that shrinks is
+// taken to have been truncated or rotated and is followed from its start.
+func (s *Server) followFile(conn net.Conn, req request, name string) error {
+	ctx := req.context()
+
+	h := make(header)
+	h.set("Content-Type", "text/plain; charset=utf-8")
+	h.set("Transfer-Encoding", "chunked")
+	h.set("X-Content-Type-Options", "nosniff")
+	h.set("Cache-Control", "no-cache")
+	h.set("Accept-Ranges", "none")
+	conn.Write(buildResponseHeaders(statusOK, h, nil))
+
+	w := &chunkedWriter{w: conn}
+	var offset int64
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	for {
+		info, err := s.store.Stat(name)
+		if err != nil {
+			// Gone, most likely mid-rotation; keep waiting for it to return.
+			offset = 0
+		} else {
+			if info.Size() < offset {
+				offset = 0
+			}
+			if info.Size() > offset {
+				n, err := s.copyFrom(w, name, offset)
+				offset += n
+				if err != nil {
+					return fmt.Errorf("error following %s: %v\n", name, err)
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		case <-s.closed:
+			w.Close()
+			return nil
+		}
+	}
+}
+
+// copyFrom writes the content of name from offset onwards to w.
+func (s *Server) copyFrom(w io.Writer, name string, offset int64) (int64, error) {
+	f, err := s.store.Open(name)
+	if err != nil {
+		return 0, nil
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(w, f)
+}
+
+// chunkedWriter writes each Write as one chunk of a chunked body. Close
+// writes the last, empty chunk.
+type chunkedWriter struct {
+	w io.Writer
+}
+
+func (c *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(c.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	if _, err := c.w.Write(p); err != nil {
+		return 0, err
+	}
+	if _, err := io.WriteString(c.w, "\r\n"); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *chunkedWriter) Close() error {
+	_, err := io.WriteString(c.w, "0\r\n\r\n")
+
+	return err
+}
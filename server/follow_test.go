@@ -0,0 +1,65 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestFollow(t *testing.T) {
+	s := servertest.New(t, servertest.Options())
+	c := s.Client()
+
+	c.Do(http.MethodPut, "/files/app.log", strings.NewReader("one\n"), nil).AssertStatus(http.StatusCreated)
+
+	// net/http drops a Content-Length sent with chunks, so the head is
+	// looked at as sent.
+	c.RawHead("GET /files/app.log?follow=true HTTP/1.1\r\nHost: x\r\n\r\n").
+		AssertStatus(http.StatusOK).
+		AssertHeader("Transfer-Encoding", "chunked").
+		AssertHeader("Content-Length", "")
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /files/app.log?follow=true HTTP/1.1\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("got status %d, transfer encoding %q", resp.StatusCode, resp.TransferEncoding)
+	}
+
+	read := func(want string) {
+		t.Helper()
+		buf := make([]byte, len(want))
+		if _, err := io.ReadFull(resp.Body, buf); err != nil {
+			t.Fatalf("error reading %q: %v", want, err)
+		}
+		if string(buf) != want {
+			t.Fatalf("got %q, want %q", buf, want)
+		}
+	}
+
+	read("one\n")
+	c.Do(http.MethodPut, "/files/app.log", strings.NewReader("one\ntwo\n"), nil).AssertStatus(http.StatusCreated)
+	read("two\n")
+
+	// Truncation starts over from the top.
+	c.Do(http.MethodPut, "/files/app.log", strings.NewReader("new\n"), nil).AssertStatus(http.StatusCreated)
+	read("new\n")
+
+	// Shutting down ends the body cleanly.
+	s.Close()
+	if rest, err := io.ReadAll(resp.Body); err != nil || len(rest) != 0 {
+		t.Fatalf("got %q, %v after shutdown", rest, err)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// prefixList is a comma-separated list of CIDR ranges usable as a flag. Bare
+// addresses are taken as single-host ranges.
+type prefixList []netip.Prefix
+
+func (p *prefixList) String() string {
+	if p == nil {
+		return ""
+	}
+
+	parts := make([]string, len(*p))
+	for i, prefix := range *p {
+		parts[i] = prefix.String()
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func (p *prefixList) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return err
+			}
+			*p = append(*p, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return err
+		}
+		*p = append(*p, prefix.Masked())
+	}
+
+	return nil
+}
+
+func (p prefixList) contains(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyForwarded resolves the client IP, scheme and host from the
+// X-Forwarded-* headers, but only when the peer is a trusted proxy; anyone
+// else could simply make them up.
+func (r *request) applyForwarded(trusted []netip.Prefix) {
+	if !prefixList(trusted).contains(r.clientIP) {
+		return
+	}
+
+	// Walk the chain from the nearest hop backwards and stop at the first
+	// address we don't trust, which is the furthest we can vouch for.
+	hops := headerList(r.headers, "X-Forwarded-For")
+	client := len(hops)
+	for i := len(hops) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(hops[i]); err != nil {
+			break
+		}
+		r.clientIP, client = hops[i], i
+		if !prefixList(trusted).contains(hops[i]) {
+			break
+		}
+	}
+
+	// Each proxy appends to all of the headers, so the scheme and host the
+	// client asked for are those the proxy that saw it recorded, as far
+	// from the end as its address is. Entries before them are the client's
+	// own and can't be believed.
+	fromEnd := max(len(hops)-client, 1)
+	switch proto := strings.ToLower(hopValue(r.headers, "X-Forwarded-Proto", fromEnd)); proto {
+	case "http", "https":
+		r.scheme = proto
+	}
+
+	if host := hopValue(r.headers, "X-Forwarded-Host", fromEnd); host != "" {
+		r.host = host
+	}
+}
+
+// headerList returns the elements of the comma-separated values of the
+// header name, over all the lines it is given on.
+func headerList(h header, name string) []string {
+	var list []string
+	for _, v := range h[name] {
+		for _, elem := range strings.Split(v, ",") {
+			list = append(list, strings.TrimSpace(elem))
+		}
+	}
+
+	return list
+}
+
+// hopValue returns the element of the header name fromEnd elements from its
+// end, counting the last as 1, or "" if there are fewer.
+func hopValue(h header, name string, fromEnd int) string {
+	list := headerList(h, name)
+	if fromEnd > len(list) {
+		return ""
+	}
+
+	return list[len(list)-fromEnd]
+}
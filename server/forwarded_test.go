@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestApplyForwarded(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		name             string
+		peer             string
+		headers          header
+		ip, scheme, host string
+	}{
+		{
+			name:    "untrusted peer",
+			peer:    "192.0.2.1",
+			headers: header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"evil.example"}},
+			ip:      "192.0.2.1", scheme: "http", host: "naive.example",
+		},
+		{
+			name:    "one proxy",
+			peer:    "10.0.0.1",
+			headers: header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"public.example"}},
+			ip:      "198.51.100.1", scheme: "https", host: "public.example",
+		},
+		{
+			// The client sent its own X-Forwarded-* headers, which the proxy
+			// appended to.
+			name: "client made up entries",
+			peer: "10.0.0.1",
+			headers: header{
+				"X-Forwarded-For":   {"203.0.113.9, 198.51.100.1"},
+				"X-Forwarded-Proto": {"https, http"},
+				"X-Forwarded-Host":  {"evil.example, public.example"},
+			},
+			ip: "198.51.100.1", scheme: "http", host: "public.example",
+		},
+		{
+			name: "two proxies",
+			peer: "10.0.0.1",
+			headers: header{
+				"X-Forwarded-For":   {"203.0.113.9, 198.51.100.1", "10.0.0.2"},
+				"X-Forwarded-Proto": {"http, https", "http"},
+				"X-Forwarded-Host":  {"evil.example, public.example, internal.example"},
+			},
+			ip: "198.51.100.1", scheme: "https", host: "public.example",
+		},
+		{
+			name:    "proxy not forwarding the host",
+			peer:    "10.0.0.1",
+			headers: header{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"https"}},
+			ip:      "198.51.100.1", scheme: "https", host: "naive.example",
+		},
+		{
+			name:    "no chain",
+			peer:    "10.0.0.1",
+			headers: header{"X-Forwarded-Proto": {"https"}, "X-Forwarded-Host": {"public.example"}},
+			ip:      "10.0.0.1", scheme: "https", host: "public.example",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request{headers: tt.headers, host: "naive.example"}
+			req.setRemoteAddr(&net.TCPAddr{IP: net.ParseIP(tt.peer), Port: 1234})
+			req.applyForwarded(trusted)
+			if req.clientIP != tt.ip || req.scheme != tt.scheme || req.host != tt.host {
+				t.Errorf("got %s %s %s, want %s %s %s", req.clientIP, req.scheme, req.host, tt.ip, tt.scheme, tt.host)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestCacheMaxAge(t *testing.T) {
+	opts := servertest.Options()
+	opts.CacheMaxAge = time.Hour
+	opts.Expires = true
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("abc"), nil).AssertStatus(http.StatusCreated)
+	resp := c.Get("/files/a.txt").AssertStatus(http.StatusOK).AssertHeader("Cache-Control", "max-age=3600")
+	expires, err := http.ParseTime(resp.Header.Get("Expires"))
+	if err != nil || expires.Sub(time.Now()) < 59*time.Minute || expires.Sub(time.Now()) > time.Hour {
+		t.Errorf("got Expires %q, want an hour ahead", resp.Header.Get("Expires"))
+	}
+
+	// Revalidating refreshes the freshness along with the response.
+	c.Do(http.MethodGet, "/files/a.txt", nil, http.Header{"If-None-Match": {resp.Header.Get("ETag")}}).
+		AssertStatus(http.StatusNotModified).
+		AssertHeader("Cache-Control", "max-age=3600")
+
+	// Without a max-age no freshness is claimed.
+	s = servertest.NewPipe(t, servertest.Options())
+	c = s.Client()
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("abc"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/a.txt").AssertHeader("Cache-Control", "").AssertHeader("Expires", "")
+
+	opts = servertest.Options()
+	opts.Expires = true
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for -expires without -cache-max-age")
+	}
+}
+
+func TestProxyCacheAge(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Age", "10")
+		w.Write([]byte("cached"))
+	}))
+	defer upstream.Close()
+
+	opts := servertest.Options()
+	opts.Proxy = upstream.URL
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	// The age the upstream gave carries on growing while the response is
+	// served from the cache.
+	for i := 0; i < 2; i++ {
+		resp := c.Get("/a").AssertStatus(http.StatusOK).AssertBody("cached")
+		if age, err := strconv.Atoi(resp.Header.Get("Age")); err != nil || age < 10 || age > 12 {
+			t.Errorf("got Age %q, want 10", resp.Header.Get("Age"))
+		}
+	}
+}
+
+func TestProxyCacheRevalidation(t *testing.T) {
+	var mu sync.Mutex
+	version, full, validated := "v1", 0, 0
+	var conditions []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		conditions = append(conditions, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"`+version+`"`)
+		if r.Header.Get("If-None-Match") == `"`+version+`"` {
+			validated++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Write([]byte("body " + version))
+	}))
+	defer upstream.Close()
+
+	opts := servertest.Options()
+	opts.Proxy = upstream.URL
+	c := servertest.NewPipe(t, opts).Client()
+	check := func(wantFull, wantValidated int, wantCondition string) {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		if full != wantFull || validated != wantValidated {
+			t.Errorf("upstream sent %d bodies and %d 304s, want %d and %d", full, validated, wantFull, wantValidated)
+		}
+		if got := conditions[len(conditions)-1]; got != wantCondition {
+			t.Errorf("upstream got conditions %q, want %q", got, wantCondition)
+		}
+	}
+
+	c.Get("/a").AssertStatus(http.StatusOK).AssertBody("body v1")
+	check(1, 0, "|")
+
+	// Stale entries are revalidated, the body coming from the cache.
+	c.Get("/a").AssertStatus(http.StatusOK).AssertBody("body v1").AssertHeader("ETag", `"v1"`)
+	check(1, 1, `"v1"|`)
+
+	// The client's own conditions are answered by the cache, not passed on.
+	c.Do(http.MethodGet, "/a", nil, http.Header{"If-None-Match": {`"v1"`}}).
+		AssertStatus(http.StatusNotModified).
+		AssertHeader("ETag", `"v1"`).
+		AssertBody("")
+	check(1, 2, `"v1"|`)
+	c.Do(http.MethodGet, "/a", nil, http.Header{"If-None-Match": {`"v0"`}}).AssertStatus(http.StatusOK).AssertBody("body v1")
+	check(1, 3, `"v1"|`)
+
+	// A changed resource is downloaded again.
+	mu.Lock()
+	version = "v2"
+	mu.Unlock()
+	c.Do(http.MethodGet, "/a", nil, http.Header{"If-None-Match": {`"v1"`}}).AssertStatus(http.StatusOK).AssertBody("body v2")
+	check(2, 3, `"v1"|`)
+}
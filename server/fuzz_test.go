@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http/httputil"
+	"strings"
+	"testing"
+)
+
+func FuzzRequestLine(f *testing.F) {
+	for _, seed := range []string{
+		"GET / HTTP/1.1",
+		"GET /echo/abc HTTP/1.1",
+		"POST /files/a.txt HTTP/1.1",
+		"GET /files/d?archive=zip&x=%zz HTTP/1.0",
+		"GET /?? HTTP/1.1",
+		"GET",
+		"GET  /  HTTP/1.1",
+		"\x00 \xff HTTP/9.9",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		for _, strict := range []bool{false, true} {
+			req, err := parseRequest(bufio.NewReader(strings.NewReader(line+"\r\n\r\n")), strict)
+			if err != nil {
+				continue
+			}
+
+			if req.method == "" || req.httpVersion == "" {
+				t.Fatalf("parsed %q without method or version: %+v", line, req)
+			}
+			if strings.Contains(req.path, "?") {
+				t.Fatalf("path %q of %q still holds the query", req.path, line)
+			}
+			if strings.ContainsAny(req.target(), " \t\r\n") {
+				t.Fatalf("target %q of %q holds whitespace", req.target(), line)
+			}
+		}
+	})
+}
+
+func FuzzHeaders(f *testing.F) {
+	for _, seed := range []string{
+		"Host: localhost:4221",
+		"User-Agent: curl/8.0\r\nAccept: */*",
+		"Content-Length: 12",
+		"Content-Length: -1",
+		"Content-Length: nope",
+		"content-type:text/plain",
+		"X-A: 1\r\nX-A: 2",
+		"NoColon",
+		": empty name",
+		"Folded: a\r\n b",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, headers string) {
+		raw := "GET / HTTP/1.1\r\n" + headers + "\r\n\r\n"
+		req, err := parseRequest(bufio.NewReader(strings.NewReader(raw)), false)
+		if err != nil {
+			return
+		}
+
+		for name := range req.headers {
+			if strings.ContainsAny(name, "\r\n") {
+				t.Fatalf("header name %q holds a line break", name)
+			}
+		}
+	})
+}
+
+func FuzzPath(f *testing.F) {
+	for _, seed := range []string{
+		"/",
+		"/files/a.txt",
+		"/files/d/sub/y.txt",
+		"/files/../../etc/passwd",
+		"/files/./a/../../b",
+		"/files//double//slash",
+		"/files/%2e%2e/x",
+		"/files/..",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, path string) {
+		raw := "GET " + path + " HTTP/1.1\r\n\r\n"
+		req, err := parseRequest(bufio.NewReader(strings.NewReader(raw)), false)
+		if err != nil {
+			return
+		}
+
+		name := req.fileName()
+		if strings.HasPrefix(name, "/") {
+			t.Fatalf("file name %q from %q is absolute", name, path)
+		}
+		for _, part := range strings.Split(name, "/") {
+			if part == ".." {
+				t.Fatalf("file name %q from %q escapes the root", name, path)
+			}
+		}
+		if cleanName(name) != name {
+			t.Fatalf("file name %q from %q isn't clean", name, path)
+		}
+	})
+}
+
+func FuzzChunkedDecoder(f *testing.F) {
+	for _, seed := range []string{
+		"0\r\n\r\n",
+		"5\r\nhello\r\n0\r\n\r\n",
+		"3;ext=1\r\nhel\r\n2\r\nlo\r\n0\r\nTrailer: x\r\n\r\n",
+		"A \r\n0123456789\r\n0\r\n\r\n",
+		"ffffffffffffffff\r\n",
+		"-1\r\n\r\n",
+		"5\nhello\n0\n\n",
+		"5\r\nhelloXX0\r\n\r\n",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		data, err := io.ReadAll(&chunkedReader{r: bufio.NewReader(strings.NewReader(raw))})
+		if err != nil {
+			return
+		}
+		if len(data) > len(raw) {
+			t.Fatalf("decoded %d bytes from %d", len(data), len(raw))
+		}
+
+		// Whatever decodes must survive being encoded again.
+		var buf bytes.Buffer
+		w := httputil.NewChunkedWriter(&buf)
+		w.Write(data)
+		w.Close()
+		buf.WriteString("\r\n")
+
+		again, err := io.ReadAll(&chunkedReader{r: bufio.NewReader(&buf)})
+		if err != nil || !bytes.Equal(again, data) {
+			t.Fatalf("re-encoding %q decoded to %q, %v", data, again, err)
+		}
+	})
+}
+
+func FuzzMMDBDecoder(f *testing.F) {
+	for _, seed := range []string{
+		"\xe1\x41a\x41b",
+		"\xe2\x41a\xa1\x01\x41b\x01\x04\xa1\x02",
+		"\x03\x04\xa1\x01\xa1\x02\xa0",
+		// Pointers back to themselves, directly and through a map.
+		"\x20\x00",
+		"\xe1\x41a\x20\x00",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, raw string) {
+		d := mmdbDecoder{buf: []byte(raw)}
+		if _, next, err := d.decode(0, 0); err == nil && next > len(raw) {
+			t.Fatalf("decoded up to %d of %d bytes", next, len(raw))
+		}
+	})
+}
@@ -0,0 +1,501 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mmdbMetadataMarker precedes the metadata at the end of a MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// geoCacheSize caps the records a geoDB keeps decoded. Many addresses share
+// a record, so a country database fits whole.
+const geoCacheSize = 4096
+
+// geoInfo is what the GeoIP databases know about an address.
+type geoInfo struct {
+	// Country is the ISO 3166 code of the country the address is in.
+	Country string
+	// ASN is the number of the autonomous system announcing the address.
+	ASN uint32
+}
+
+// codes returns the codes of policies that info matches: the country, and
+// the ASN as AS1234.
+func (info geoInfo) codes() []string {
+	var codes []string
+	if info.Country != "" {
+		codes = append(codes, info.Country)
+	}
+	if info.ASN != 0 {
+		codes = append(codes, "AS"+strconv.FormatUint(uint64(info.ASN), 10))
+	}
+
+	return codes
+}
+
+// geoDB is a database in the MaxMind DB format, such as GeoLite2-Country or
+// GeoLite2-ASN, read whole into memory.
+type geoDB struct {
+	path       string
+	data       []byte
+	nodeCount  uint32
+	recordSize uint32
+	treeSize   int
+	// ipv4Start is the node IPv4 addresses start from in an IPv6 tree.
+	ipv4Start uint32
+
+	log *slog.Logger
+
+	mu    sync.Mutex
+	cache map[int]geoInfo
+}
+
+func openGeoDB(path string) (*geoDB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := bytes.LastIndex(data, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind DB file", path)
+	}
+	d := mmdbDecoder{buf: data[i+len(mmdbMetadataMarker):]}
+	v, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s metadata: %v", path, err)
+	}
+	meta, _ := v.(map[string]any)
+	nodeCount, _ := meta["node_count"].(uint64)
+	recordSize, _ := meta["record_size"].(uint64)
+	ipVersion, _ := meta["ip_version"].(uint64)
+	switch recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%s has unsupported record size %d", path, recordSize)
+	}
+
+	db := &geoDB{
+		path:       path,
+		data:       data[:i],
+		nodeCount:  uint32(nodeCount),
+		recordSize: uint32(recordSize),
+		treeSize:   int(nodeCount * recordSize / 4),
+		cache:      make(map[int]geoInfo),
+	}
+	if db.treeSize+16 > len(db.data) {
+		return nil, fmt.Errorf("%s is truncated", path)
+	}
+	if ipVersion == 6 {
+		for n := 0; n < 96 && db.ipv4Start < db.nodeCount; n++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (db *geoDB) record(node uint32, bit int) uint32 {
+	b := db.data[node*db.recordSize/4:]
+	switch db.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		if bit == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(b[bit*4:])
+	}
+}
+
+// lookup returns what the database knows about ip.
+func (db *geoDB) lookup(ip netip.Addr) geoInfo {
+	ip = ip.Unmap()
+	node, bits := uint32(0), 128
+	if ip.Is4() {
+		node, bits = db.ipv4Start, 32
+	}
+	addr := ip.AsSlice()
+	for i := 0; i < bits && node < db.nodeCount; i++ {
+		node = db.record(node, int(addr[i/8]>>(7-i%8)&1))
+	}
+	if node <= db.nodeCount {
+		return geoInfo{}
+	}
+
+	return db.info(int(node-db.nodeCount) - 16)
+}
+
+// info returns the fields of the data record at offset.
+func (db *geoDB) info(offset int) geoInfo {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if info, ok := db.cache[offset]; ok {
+		return info
+	}
+
+	d := mmdbDecoder{buf: db.data[db.treeSize+16:]}
+	v, _, err := d.decode(offset, 0)
+	if err != nil {
+		db.log.Error("error reading GeoIP record", "db", db.path, "err", err)
+		return geoInfo{}
+	}
+	rec, _ := v.(map[string]any)
+
+	var info geoInfo
+	for _, key := range []string{"country", "registered_country"} {
+		if c, ok := rec[key].(map[string]any); ok && info.Country == "" {
+			info.Country, _ = c["iso_code"].(string)
+		}
+	}
+	if asn, ok := rec["autonomous_system_number"].(uint64); ok {
+		info.ASN = uint32(asn)
+	}
+
+	if len(db.cache) >= geoCacheSize {
+		clear(db.cache)
+	}
+	db.cache[offset] = info
+
+	return info
+}
+
+// geoDBs are the databases from -geoip-db, a country and an ASN one say.
+type geoDBs []*geoDB
+
+func loadGeoDBs(paths []string, log *slog.Logger) (geoDBs, error) {
+	var dbs geoDBs
+	for _, path := range paths {
+		db, err := openGeoDB(path)
+		if err != nil {
+			return nil, err
+		}
+		db.log = log
+		dbs = append(dbs, db)
+	}
+
+	return dbs, nil
+}
+
+// lookup merges what the databases know about ip, the first to know a
+// field winning. Addresses that aren't IPs, or are in no database, get
+// nothing.
+func (dbs geoDBs) lookup(ip string) geoInfo {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return geoInfo{}
+	}
+
+	var info geoInfo
+	for _, db := range dbs {
+		found := db.lookup(addr)
+		if info.Country == "" {
+			info.Country = found.Country
+		}
+		if info.ASN == 0 {
+			info.ASN = found.ASN
+		}
+	}
+
+	return info
+}
+
+// The data types of the MaxMind DB format.
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEndMarker
+	mmdbBool
+	mmdbFloat
+)
+
+var errMMDBTruncated = errors.New("data runs past the end of the database")
+
+// errMMDBTooDeep fails data nesting past mmdbMaxDepth, which only a
+// malformed database's pointers looping back on themselves get near.
+var errMMDBTooDeep = errors.New("data nests too deeply")
+
+// mmdbMaxDepth is how deep maps, arrays and pointers may nest. Records of
+// real databases nest a few levels.
+const mmdbMaxDepth = 32
+
+// mmdbDecoder decodes values of the MaxMind DB data section in buf.
+type mmdbDecoder struct {
+	buf []byte
+}
+
+// decode returns the value at offset and the offset just past it, depth
+// being how deep in other values it is.
+func (d mmdbDecoder) decode(offset, depth int) (any, int, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errMMDBTooDeep
+	}
+	if offset < 0 || offset >= len(d.buf) {
+		return nil, 0, errMMDBTruncated
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := int(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		ssize := int(ctrl>>3) & 3
+		if offset+ssize+1 > len(d.buf) {
+			return nil, 0, errMMDBTruncated
+		}
+		p := 0
+		if ssize < 3 {
+			p = int(ctrl & 7)
+		}
+		for _, b := range d.buf[offset : offset+ssize+1] {
+			p = p<<8 | int(b)
+		}
+		p += [4]int{0, 2048, 526336, 0}[ssize]
+		v, _, err := d.decode(p, depth+1)
+
+		return v, offset + ssize + 1, err
+	}
+
+	if typ == mmdbExtended {
+		if offset >= len(d.buf) {
+			return nil, 0, errMMDBTruncated
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.buf) {
+			return nil, 0, errMMDBTruncated
+		}
+		size = 0
+		for _, b := range d.buf[offset : offset+n] {
+			size = size<<8 | int(b)
+		}
+		size += [4]int{0, 29, 285, 65821}[n]
+		offset += n
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]any, size)
+		for i := 0; i < size; i++ {
+			k, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key at %d is not a string", offset)
+			}
+			if m[key], offset, err = d.decode(next, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]any, size)
+		for i := range a {
+			var err error
+			if a[i], offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbContainer, mmdbEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > len(d.buf) {
+		return nil, 0, errMMDBTruncated
+	}
+	b := d.buf[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case mmdbString:
+		return string(b), offset, nil
+	case mmdbBytes:
+		return bytes.Clone(b), offset, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("double of %d bytes", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("float of %d bytes", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		if typ == mmdbInt32 {
+			return int64(int32(n)), offset, nil
+		}
+		return n, offset, nil
+	case mmdbUint128:
+		// Nothing looked up is this wide; keep the bytes.
+		return bytes.Clone(b), offset, nil
+	}
+
+	return nil, 0, fmt.Errorf("unknown data type %d", typ)
+}
+
+// GeoPolicy lets in or keeps out requests for the paths under Prefix by
+// where they come from. Codes are ISO 3166 country codes like US, or
+// autonomous system numbers like AS64496.
+type GeoPolicy struct {
+	Prefix string
+	// Allow, unless empty, lets in only requests matching a code. Addresses
+	// in no database match nothing.
+	Allow []string
+	// Deny keeps out requests matching a code.
+	Deny []string
+}
+
+func (p GeoPolicy) matches(path string) bool {
+	return CORSPolicy{Prefix: p.Prefix}.matches(path)
+}
+
+// allows reports whether the policy lets in a request from info.
+func (p GeoPolicy) allows(info geoInfo) bool {
+	codes := info.codes()
+	for _, c := range codes {
+		if containsFold(p.Deny, c) {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, c := range codes {
+		if containsFold(p.Allow, c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// geoAllowed reports whether the GeoIP policy with the longest prefix
+// matching the path of req lets it in.
+func (s *Server) geoAllowed(req request) bool {
+	var best GeoPolicy
+	found := false
+	for _, p := range s.opts.GeoPolicy {
+		if p.matches(req.cleanPath()) && (!found || len(p.Prefix) > len(best.Prefix)) {
+			best, found = p, true
+		}
+	}
+	if !found || best.allows(req.geo) {
+		return true
+	}
+	s.log.Info("geoip policy denied request", "ip", req.clientIP, "country", req.geo.Country, "asn", req.geo.ASN, "path", req.path)
+
+	return false
+}
+
+// geoPolicyFlag parses -geo-policy values like "/files=allow:US,CA" or
+// "deny:AS64496", merging those for the same prefix.
+type geoPolicyFlag []GeoPolicy
+
+func (f *geoPolicyFlag) String() string {
+	if f == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, p := range *f {
+		if len(p.Allow) > 0 {
+			parts = append(parts, p.Prefix+"=allow:"+strings.Join(p.Allow, ","))
+		}
+		if len(p.Deny) > 0 {
+			parts = append(parts, p.Prefix+"=deny:"+strings.Join(p.Deny, ","))
+		}
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, " ")
+}
+
+func (f *geoPolicyFlag) Set(value string) error {
+	prefix, rule := "/", value
+	if strings.HasPrefix(value, "/") {
+		var ok bool
+		prefix, rule, ok = strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("expected '/prefix=allow|deny:code,...', got %q", value)
+		}
+	}
+	action, list, ok := strings.Cut(rule, ":")
+	if !ok || action != "allow" && action != "deny" {
+		return fmt.Errorf("expected 'allow:code,...' or 'deny:code,...', got %q", rule)
+	}
+
+	var codes []string
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.ToUpper(strings.TrimSpace(c)); c != "" {
+			codes = append(codes, c)
+		}
+	}
+	if len(codes) == 0 {
+		return fmt.Errorf("no codes in %q", value)
+	}
+
+	i := 0
+	for i < len(*f) && (*f)[i].Prefix != prefix {
+		i++
+	}
+	if i == len(*f) {
+		*f = append(*f, GeoPolicy{Prefix: prefix})
+	}
+	if action == "allow" {
+		(*f)[i].Allow = append((*f)[i].Allow, codes...)
+	} else {
+		(*f)[i].Deny = append((*f)[i].Deny, codes...)
+	}
+
+	return nil
+}
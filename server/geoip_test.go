@@ -0,0 +1,189 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// mmdbValue appends v in the MaxMind DB data format. Only what GeoIP
+// records hold in these tests is supported.
+func mmdbValue(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		b.WriteByte(2<<5 | byte(len(v)))
+		b.WriteString(v)
+	case uint32:
+		b.WriteByte(6<<5 | 4)
+		b.Write([]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+	case map[string]any:
+		b.WriteByte(7<<5 | byte(len(v)))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			mmdbValue(b, k)
+			mmdbValue(b, v[k])
+		}
+	default:
+		panic("unsupported mmdb value")
+	}
+}
+
+// writeMMDB writes an IPv6 MaxMind DB with 24-bit records mapping IPv4
+// networks to records, the way GeoLite2 databases hold them.
+func writeMMDB(t *testing.T, networks map[string]map[string]any) string {
+	t.Helper()
+
+	const empty = ^uint32(0)
+	nodes := [][2]uint32{{empty, empty}}
+	leaves := map[[2]int]int{}
+	var data bytes.Buffer
+	for cidr, record := range networks {
+		prefix := netip.MustParsePrefix(cidr)
+		addr := netip.AddrFrom16(prefix.Addr().As16()).As16()
+		// IPv4 networks live under ::/96, not ::ffff:0:0/96.
+		addr[10], addr[11] = 0, 0
+		bits := 96 + prefix.Bits()
+
+		node := 0
+		for i := 0; i < bits-1; i++ {
+			bit := addr[i/8] >> (7 - i%8) & 1
+			if nodes[node][bit] == empty {
+				nodes = append(nodes, [2]uint32{empty, empty})
+				nodes[node][bit] = uint32(len(nodes) - 1)
+			}
+			node = int(nodes[node][bit])
+		}
+		bit := addr[(bits-1)/8] >> (7 - (bits-1)%8) & 1
+		leaves[[2]int{node, int(bit)}] = data.Len()
+		mmdbValue(&data, record)
+	}
+
+	var out bytes.Buffer
+	count := uint32(len(nodes))
+	for n, node := range nodes {
+		for bit, rec := range node {
+			if off, ok := leaves[[2]int{n, bit}]; ok {
+				rec = count + 16 + uint32(off)
+			} else if rec == empty {
+				rec = count
+			}
+			out.Write([]byte{byte(rec >> 16), byte(rec >> 8), byte(rec)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	mmdbValue(&out, map[string]any{
+		"node_count":    count,
+		"record_size":   uint32(24),
+		"ip_version":    uint32(6),
+		"database_type": "Test",
+	})
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, out.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestGeoIP(t *testing.T) {
+	db := writeMMDB(t, map[string]map[string]any{
+		"203.0.113.0/24": {
+			"country":                  map[string]any{"iso_code": "NZ"},
+			"autonomous_system_number": uint32(64496),
+		},
+		"198.51.100.0/25": {"country": map[string]any{"iso_code": "US"}},
+		"198.51.100.128/25": {
+			"registered_country":       map[string]any{"iso_code": "US"},
+			"autonomous_system_number": uint32(64511),
+		},
+	})
+	logPath := filepath.Join(t.TempDir(), "access.log")
+
+	opts := servertest.Options()
+	opts.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	opts.GeoIPDB = []string{db}
+	opts.GeoPolicy = []server.GeoPolicy{
+		{Prefix: "/", Deny: []string{"AS64511"}},
+		{Prefix: "/files", Allow: []string{"NZ"}},
+	}
+	opts.AccessLog = logPath
+	opts.AccessLogFormat = "%path %status %country %asn"
+	s := servertest.New(t, opts)
+	c := s.Client()
+
+	from := func(ip string) http.Header { return http.Header{"X-Forwarded-For": {ip}} }
+	tests := []struct {
+		path, ip string
+		want     int
+	}{
+		{"/ip", "203.0.113.7", http.StatusOK},
+		{"/ip", "198.51.100.1", http.StatusOK},
+		{"/ip", "198.51.100.200", http.StatusForbidden},
+		{"/ip", "192.0.2.1", http.StatusOK},
+		{"/files/", "203.0.113.7", http.StatusOK},
+		{"/files/", "198.51.100.1", http.StatusForbidden},
+		// Addresses in no database are kept out by allow lists.
+		{"/files/", "192.0.2.1", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		c.Do(http.MethodGet, tt.path, nil, from(tt.ip)).AssertStatus(tt.want)
+	}
+
+	// Policies hold for the path as it is routed, however it is written.
+	for _, p := range []string{"/%66iles/a.txt", "//files/a.txt", "/files/./a.txt", "/x/../files/a.txt"} {
+		c.Do(http.MethodGet, p, nil, from("198.51.100.1")).AssertStatus(http.StatusForbidden)
+	}
+	s.Close()
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{
+		"/ip 200 NZ AS64496",
+		"/ip 200 US -",
+		"/ip 403 US AS64511",
+		"/ip 200 - -",
+		"/files/ 200 NZ AS64496",
+		"/files/ 403 US -",
+		"/files/ 403 - -",
+		"/%66iles/a.txt 403 US -",
+		"//files/a.txt 403 US -",
+		"/files/./a.txt 403 US -",
+		"/x/../files/a.txt 403 US -",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got log lines %q, want %q", got, want)
+	}
+}
+
+func TestGeoIPInvalid(t *testing.T) {
+	opts := servertest.Options()
+	opts.GeoPolicy = []server.GeoPolicy{{Prefix: "/", Deny: []string{"NZ"}}}
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for -geo-policy without -geoip-db")
+	}
+
+	notDB := filepath.Join(t.TempDir(), "not.mmdb")
+	os.WriteFile(notDB, []byte("hello"), 0o644)
+	opts.GeoIPDB = []string{notDB}
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for a file that isn't a MaxMind DB")
+	}
+}
@@ -0,0 +1,69 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestHead(t *testing.T) {
+	s := servertest.New(t, servertest.Options())
+	c := s.Client()
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("hello"), nil).AssertStatus(http.StatusCreated)
+	get := c.Get("/files/a.txt")
+	c.Do(http.MethodHead, "/files/a.txt", nil, nil).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Length", "5").
+		AssertHeader("ETag", get.Header.Get("ETag")).
+		AssertBody("")
+	c.Do(http.MethodHead, "/files/missing.txt", nil, nil).
+		AssertStatus(http.StatusNotFound).
+		AssertBody("")
+	c.Do(http.MethodHead, "/echo/hi", nil, nil).AssertStatus(http.StatusMethodNotAllowed)
+
+	// Nothing of the body is sent, so the next request on the connection
+	// is read from where it starts.
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go io.WriteString(conn, "HEAD /files/a.txt HTTP/1.1\r\nHost: x\r\n\r\nGET /files/a.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodHead})
+	if err != nil || resp.StatusCode != http.StatusOK || resp.ContentLength != 5 {
+		t.Fatalf("got %v, %v to HEAD", resp, err)
+	}
+	resp, err = http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "hello" {
+		t.Errorf("got body %q after HEAD, want hello", body)
+	}
+}
+
+// TestHeadRoutes checks HEAD is turned away by the middleware of the GET
+// route it is answered as.
+func TestHeadRoutes(t *testing.T) {
+	opts := servertest.Options()
+	opts.UIAuth = "me:secret"
+	opts.Middleware = []server.RouteMiddleware{{Route: "GET /files", Middleware: []string{"auth"}}}
+	c := servertest.NewPipe(t, opts).Client()
+
+	auth := http.Header{"Authorization": {"Basic bWU6c2VjcmV0"}}
+	c.Do(http.MethodPut, "/files/secret.txt", strings.NewReader("secret"), nil).AssertStatus(http.StatusCreated)
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		resp := c.Do(method, "/files/secret.txt", nil, nil).AssertStatus(http.StatusUnauthorized)
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			t.Errorf("got ETag %s to an unauthorized %s", etag, method)
+		}
+		c.Do(method, "/files/secret.txt", nil, auth).AssertStatus(http.StatusOK).AssertHeader("Content-Length", "6")
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// withCharset adds the server's charset to contentType if it is plain text
+// or HTML without one, so browsers don't have to guess at non-ASCII text.
+func (s *Server) withCharset(contentType string) string {
+	if s.charset == "" {
+		return contentType
+	}
+	t, params, err := mime.ParseMediaType(contentType)
+	if err != nil || t != "text/plain" && t != "text/html" || params["charset"] != "" {
+		return contentType
+	}
+
+	return contentType + "; charset=" + s.charset
+}
+
+// setDefaultHeader sets a header of h, the default headers of a server, or
+// stops sending it if value is empty.
+func setDefaultHeader(h header, name, value string) {
+	if value == "" {
+		h.del(name)
+		return
+	}
+	h.set(name, value)
+}
+
+// withDefaults returns a response head with the server's default headers it
+// doesn't set itself added after its Date header and, if charset is set,
+// the server's charset added to its content type as withCharset does.
+func (s *Server) withDefaults(head []byte, charset bool) []byte {
+	lines := strings.Split(string(head), "\r\n")
+	set := make(map[string]bool)
+	for i, line := range lines[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+		set[name] = true
+		if charset && name == "Content-Type" {
+			lines[i+1] = name + ": " + s.withCharset(strings.TrimSpace(value))
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(lines[0])
+	rest := lines[1:]
+	if len(rest) > 0 && strings.HasPrefix(rest[0], "Date:") {
+		b.WriteString("\r\n" + rest[0])
+		rest = rest[1:]
+	}
+	for _, k := range s.headers.keys() {
+		if set[k] {
+			continue
+		}
+		for _, v := range s.headers[k] {
+			b.WriteString("\r\n" + k + ": " + v)
+		}
+	}
+	for _, line := range rest {
+		b.WriteString("\r\n" + line)
+	}
+
+	return b.Bytes()
+}
+
+// defaultsConn adds the server's default headers to the response written on
+// a connection that isn't an exchange, such as those of the admin API.
+type defaultsConn struct {
+	net.Conn
+
+	srv         *Server
+	headWritten bool
+}
+
+func (c *defaultsConn) Write(p []byte) (int, error) {
+	if c.headWritten {
+		return c.Conn.Write(p)
+	}
+	c.headWritten = true
+
+	head, rest, ok := bytes.Cut(p, []byte("\r\n\r\n"))
+	if !ok {
+		return c.Conn.Write(p)
+	}
+	head = c.srv.withDefaults(head, true)
+	if _, err := c.Conn.Write(append(append(head, "\r\n\r\n"...), rest...)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// header holds header fields keyed by their canonical name.
+type header map[string][]string
+
+func (h header) get(name string) string {
+	if v := h[textproto.CanonicalMIMEHeaderKey(name)]; len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+func (h header) set(name, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(name)] = []string{value}
+}
+
+func (h header) add(name, value string) {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	h[name] = append(h[name], value)
+}
+
+func (h header) del(name string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(name))
+}
+
+// validHeaderName reports whether name is a valid field name, i.e. an RFC
+// 9110 token.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTokenChar(name[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// keys returns the header names in a stable order.
+func (h header) keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// headerFlag collects repeated 'Name: value' flags into a header.
+type headerFlag struct {
+	h *header
+}
+
+func (f headerFlag) String() string {
+	if f.h == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, k := range f.h.keys() {
+		for _, v := range (*f.h)[k] {
+			parts = append(parts, k+": "+v)
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func (f headerFlag) Set(value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("expected 'Name: value', got %q", value)
+	}
+
+	if *f.h == nil {
+		*f.h = make(header)
+	}
+	f.h.add(name, strings.TrimSpace(v))
+
+	return nil
+}
@@ -0,0 +1,19 @@
+package server
+
+import "net"
+
+// serveHealth answers GET /healthz for liveness probes: 200 while the
+// server is up, and 503 once it is shutting down, so that load balancers
+// stop sending it new requests.
+func serveHealth(conn net.Conn, closed <-chan struct{}) {
+	status, body := statusOK, "ok\n"
+	select {
+	case <-closed:
+		status, body = statusServiceUnavailable, "shutting down\n"
+	default:
+	}
+
+	h := header{}
+	h.set("Cache-Control", "no-store")
+	conn.Write(buildResponseHeaders(status, h, &content{contentType: contentTypeTextPlain, body: []byte(body)}))
+}
@@ -0,0 +1,23 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestHealthz(t *testing.T) {
+	s := servertest.NewPipe(t, servertest.Options())
+	c := s.Client()
+
+	c.Get("/healthz").
+		AssertStatus(http.StatusOK).
+		AssertBody("ok\n").
+		AssertHeader("Cache-Control", "no-store")
+
+	c.Get("/readyz").
+		AssertStatus(http.StatusOK).
+		AssertBody("ready\n").
+		AssertHeader("Cache-Control", "no-store")
+}
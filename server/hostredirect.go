@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// hostRedirect returns the URL a request for one of the -host-redirect
+// hostnames is sent to, on the canonical host with the same scheme, path
+// and query, or "" if the host it asked for is to be served.
+func (s *Server) hostRedirect(req request) string {
+	host := req.host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	target, ok := s.opts.HostRedirects[strings.ToLower(host)]
+	if !ok {
+		return ""
+	}
+
+	return req.scheme + "://" + target + req.target()
+}
+
+// hostRedirectFlag collects repeated 'alias=host' flags.
+type hostRedirectFlag map[string]string
+
+func (f *hostRedirectFlag) String() string {
+	if f == nil {
+		return ""
+	}
+
+	var parts []string
+	for alias, host := range *f {
+		parts = append(parts, alias+"="+host)
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, ",")
+}
+
+func (f *hostRedirectFlag) Set(value string) error {
+	alias, host, ok := strings.Cut(value, "=")
+	alias = strings.ToLower(strings.TrimSpace(alias))
+	host = strings.TrimSpace(host)
+	if !ok || alias == "" || host == "" || strings.ContainsAny(host, "/?#") {
+		return fmt.Errorf("expected 'alias=host[:port]', got %q", value)
+	}
+
+	if *f == nil {
+		*f = make(hostRedirectFlag)
+	}
+	(*f)[alias] = host
+
+	return nil
+}
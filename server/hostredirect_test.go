@@ -0,0 +1,27 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestHostRedirect(t *testing.T) {
+	opts := servertest.Options()
+	opts.HostRedirects = map[string]string{
+		"www.example.com": "example.com",
+		"old.example":     "example.com:8443",
+	}
+	c := servertest.NewPipe(t, opts).Client()
+
+	get := func(host string) *servertest.Response {
+		return c.Raw("GET /files/a.txt?x=1 HTTP/1.1\r\nHost: " + host + "\r\nConnection: close\r\n\r\n")
+	}
+	get("www.example.com").
+		AssertStatus(http.StatusMovedPermanently).
+		AssertHeader("Location", "http://example.com/files/a.txt?x=1")
+	get("WWW.Example.com:4221").AssertHeader("Location", "http://example.com/files/a.txt?x=1")
+	get("old.example").AssertHeader("Location", "http://example.com:8443/files/a.txt?x=1")
+	get("example.com").AssertStatus(http.StatusNotFound)
+}
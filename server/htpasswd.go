@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// htpasswd holds the credentials of an Apache htpasswd file, keeping up
+// with changes to it.
+type htpasswd struct {
+	path string
+
+	mu      sync.Mutex
+	users   map[string]string
+	modTime time.Time
+	size    int64
+	// verified holds a digest of the password each user last logged in
+	// with, so that the slow hashes are only worked out once per password.
+	verified map[string][sha256.Size]byte
+	log      *slog.Logger
+}
+
+func loadHtpasswd(path string, log *slog.Logger) (*htpasswd, error) {
+	h := &htpasswd{path: path, log: log}
+	if err := h.reload(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// reload reads the file again if it has changed since it was last read.
+func (h *htpasswd) reload() error {
+	f, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	unchanged := h.users != nil && info.ModTime().Equal(h.modTime) && info.Size() == h.size
+	h.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	users, err := parseHtpasswd(f)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", h.path, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.users = users
+	h.modTime = info.ModTime()
+	h.size = info.Size()
+	h.verified = make(map[string][sha256.Size]byte)
+
+	return nil
+}
+
+// watch reloads the file every interval until closed is closed. A file
+// that fails to load leaves the credentials as they were.
+func (h *htpasswd) watch(interval time.Duration, closed <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := h.reload(); err != nil {
+				h.log.Warn("htpasswd reload failed", "err", err)
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// check reports whether password is the password of user.
+func (h *htpasswd) check(user, password string) bool {
+	h.mu.Lock()
+	hash, ok := h.users[user]
+	last, seen := h.verified[user]
+	h.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	digest := sha256.Sum256([]byte(hash + "\x00" + password))
+	if seen && subtle.ConstantTimeCompare(digest[:], last[:]) == 1 {
+		return true
+	}
+	if match, _ := htpasswdMatches(hash, password); !match {
+		return false
+	}
+
+	h.mu.Lock()
+	if h.users[user] == hash {
+		h.verified[user] = digest
+	}
+	h.mu.Unlock()
+
+	return true
+}
+
+// parseHtpasswd reads the user:hash lines of an htpasswd file. Blank lines
+// and # comments are skipped; hashes of a kind that can't be checked fail
+// the file rather than lock their users out unnoticed.
+func parseHtpasswd(r io.Reader) (map[string]string, error) {
+	users := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("line %d: expected 'user:hash'", n)
+		}
+		if _, err := htpasswdMatches(hash, ""); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		users[user] = hash
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// htpasswdMatches reports whether password matches an htpasswd hash:
+// bcrypt ($2y$), MD5-crypt ($apr1$ or $1$) or SHA-1 ({SHA}). An empty
+// password never matches a bcrypt hash; it only has the hash checked for
+// being well-formed, which is cheap, unlike working it out.
+func htpasswdMatches(hash, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		if password == "" {
+			if len(hash) != 60 {
+				return false, errBadBcrypt
+			}
+			return false, nil
+		}
+		return bcryptMatches(hash, password)
+	case strings.HasPrefix(hash, "$apr1$"), strings.HasPrefix(hash, "$1$"):
+		magic, rest, _ := strings.Cut(hash[1:], "$")
+		salt, _, ok := strings.Cut(rest, "$")
+		if !ok {
+			return false, fmt.Errorf("malformed MD5-crypt hash")
+		}
+		want := md5Crypt(password, salt, "$"+magic+"$")
+		return subtle.ConstantTimeCompare([]byte(want), []byte(hash)) == 1, nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		want := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(want), []byte(hash)) == 1, nil
+	}
+
+	return false, fmt.Errorf("unsupported hash; use bcrypt (htpasswd -B) or MD5 (htpasswd -m)")
+}
+
+// cryptAlphabet is the base64 alphabet of crypt(3) hashes.
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// md5Crypt hashes password the way of FreeBSD's MD5-crypt, which Apache
+// uses with the magic $apr1$.
+func md5Crypt(password, salt, magic string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+	ctx := md5.New()
+	io.WriteString(ctx, password+magic+salt)
+	for n := len(pw); n > 0; n -= 16 {
+		ctx.Write(alt[:min(n, 16)])
+	}
+	for i := len(pw); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	// A thousand rounds, to slow down guessing.
+	for i := 0; i < 1000; i++ {
+		ctx := md5.New()
+		if i&1 != 0 {
+			ctx.Write(pw)
+		} else {
+			ctx.Write(final)
+		}
+		if i%3 != 0 {
+			io.WriteString(ctx, salt)
+		}
+		if i%7 != 0 {
+			ctx.Write(pw)
+		}
+		if i&1 != 0 {
+			ctx.Write(final)
+		} else {
+			ctx.Write(pw)
+		}
+		final = ctx.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(magic + salt + "$")
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(cryptAlphabet[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(final[i[0]])<<16|uint32(final[i[1]])<<8|uint32(final[i[2]]), 4)
+	}
+	to64(uint32(final[11]), 2)
+
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func basicAuth(user, password string) http.Header {
+	return http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))}}
+}
+
+// replaceFile replaces the file at path in one go, as a reload could
+// otherwise catch it half written.
+func replaceFile(t *testing.T, path, content string) {
+	t.Helper()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHtpasswd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	users := "# made with htpasswd -B, -m and -s\n" +
+		"ann:$2b$04$iuwAg6BPO4dU0L3owXKxFe6fvXFAZJRzWPjiTeLjx9IWeSSv8xU86\n" +
+		"bob:$apr1$rOx9Qz1e$5/zlFudLph3PR4nYpxEyk/\n" +
+		"\n" +
+		"cat:{SHA}7ubAkIthNAIpEJjMktYaoVaMPB8=\n" +
+		"dan:$1$abcdefgh$znAnv9M.XU2pRYfmSs46h/\n"
+	if err := os.WriteFile(path, []byte(users), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := servertest.Options()
+	opts.Htpasswd = path
+	opts.HtpasswdReload = 10 * time.Millisecond
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	for _, tc := range []struct {
+		user, password string
+		status         int
+	}{
+		{"ann", "bcrypt pw", http.StatusOK},
+		// Again, now that the password has been checked once.
+		{"ann", "bcrypt pw", http.StatusOK},
+		{"ann", "bcrypt pW", http.StatusUnauthorized},
+		{"ann", "", http.StatusUnauthorized},
+		{"bob", "md5 pw", http.StatusOK},
+		{"bob", "bcrypt pw", http.StatusUnauthorized},
+		{"cat", "sha pw", http.StatusOK},
+		{"dan", "x", http.StatusOK},
+		{"eve", "x", http.StatusUnauthorized},
+	} {
+		if got := c.Do(http.MethodGet, "/ui", nil, basicAuth(tc.user, tc.password)).StatusCode; got != tc.status {
+			t.Errorf("%s:%s got %d, want %d", tc.user, tc.password, got, tc.status)
+		}
+	}
+
+	// Changes to the file are picked up; a broken file is ignored.
+	replaceFile(t, path, "cat:{SHA}7ubAkIthNAIpEJjMktYaoVaMPB8=\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Do(http.MethodGet, "/ui", nil, basicAuth("ann", "bcrypt pw")).StatusCode != http.StatusUnauthorized ||
+		c.Do(http.MethodGet, "/ui", nil, basicAuth("cat", "sha pw")).StatusCode != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("the change wasn't picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	replaceFile(t, path, "cat:plaintext\n")
+	time.Sleep(50 * time.Millisecond)
+	c.Do(http.MethodGet, "/ui", nil, basicAuth("cat", "sha pw")).AssertStatus(http.StatusOK)
+
+	for _, bad := range []string{"cat:plaintext\n", "nocolon\n", "ann:$2y$04$short\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		opts := servertest.Options()
+		opts.Htpasswd = path
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// jwtLeeway allows for the clocks of the issuer and the server being
+	// out by a little when checking exp and nbf.
+	jwtLeeway = time.Minute
+	// jwksTimeout bounds fetching the key set.
+	jwksTimeout = 10 * time.Second
+	// jwksMaxAge is how long a fetched key set is used before it is fetched
+	// again, and jwksMinAge how soon a token signed with a key it doesn't
+	// have can make it be fetched again.
+	jwksMaxAge = time.Hour
+	jwksMinAge = time.Minute
+)
+
+// jwtVerifier checks the Bearer tokens of requests: their signature, by
+// the secret or the public keys it was given, and their claims.
+type jwtVerifier struct {
+	secret   []byte
+	key      crypto.PublicKey
+	jwks     *jwks
+	issuer   string
+	audience string
+}
+
+func newJWTVerifier(opts Options, log *slog.Logger) (*jwtVerifier, error) {
+	v := &jwtVerifier{
+		secret:   []byte(opts.JWTSecret),
+		issuer:   opts.JWTIssuer,
+		audience: opts.JWTAudience,
+	}
+	if opts.JWTPublicKey != "" {
+		key, err := loadPublicKey(opts.JWTPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading JWT public key: %v", err)
+		}
+		v.key = key
+	}
+	if opts.JWTJWKS != "" {
+		if !strings.HasPrefix(opts.JWTJWKS, "https://") && !strings.HasPrefix(opts.JWTJWKS, "http://") {
+			return nil, fmt.Errorf("-jwt-jwks must be an http or https URL")
+		}
+		v.jwks = &jwks{url: opts.JWTJWKS, client: &http.Client{Timeout: jwksTimeout}, log: log}
+	}
+
+	return v, nil
+}
+
+// loadPublicKey reads an RSA or P-256 public key from a PEM file, as a
+// PUBLIC KEY block or a certificate.
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unexpected %s block in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return k, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported key in %s; use RSA or P-256", path)
+}
+
+var errNoToken = errors.New("no bearer token")
+
+// bearerToken returns the token of an Authorization: Bearer header.
+func bearerToken(req request) (string, error) {
+	scheme, token, _ := strings.Cut(req.headers.get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", errNoToken
+	}
+
+	return token, nil
+}
+
+// jwtHeader is the JOSE header of a token.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature and claims of a compact JWT, returning its
+// claims.
+func (v *jwtVerifier) verify(token string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var head jwtHeader
+	if err := decodeSegment(parts[0], &head); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	if err := v.checkSignature(head, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	if err := v.checkClaims(claims, now); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// checkSignature verifies sig over signed with the key the header calls
+// for. Each algorithm only goes with its own kind of key, so a public key
+// can never be passed off as an HMAC secret.
+func (v *jwtVerifier) checkSignature(head jwtHeader, signed string, sig []byte) error {
+	sum := sha256.Sum256([]byte(signed))
+
+	switch head.Alg {
+	case "HS256":
+		if len(v.secret) == 0 {
+			return fmt.Errorf("HS256 tokens aren't accepted")
+		}
+		mac := hmac.New(sha256.New, v.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	case "RS256":
+		key, err := v.publicKey(head)
+		if err != nil {
+			return err
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("RS256 token for a key that isn't RSA")
+		}
+		if rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, sum[:], sig) != nil {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	case "ES256":
+		key, err := v.publicKey(head)
+		if err != nil {
+			return err
+		}
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("ES256 token for a key that isn't P-256")
+		}
+		if len(sig) != 64 {
+			return fmt.Errorf("bad signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(ecKey, sum[:], r, s) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported algorithm %q", head.Alg)
+}
+
+// publicKey returns the key a token is to be verified with: the one of
+// its kid in the key set, or else the key file's.
+func (v *jwtVerifier) publicKey(head jwtHeader) (crypto.PublicKey, error) {
+	if v.jwks != nil {
+		key, err := v.jwks.key(head.Kid, head.Alg)
+		if err == nil || v.key == nil {
+			return key, err
+		}
+	}
+	if v.key == nil {
+		return nil, fmt.Errorf("%s tokens aren't accepted", head.Alg)
+	}
+
+	return v.key, nil
+}
+
+// checkClaims checks the times a token is valid between and who it was
+// issued by and for.
+func (v *jwtVerifier) checkClaims(claims map[string]any, now time.Time) error {
+	if exp, ok := claims["exp"]; ok {
+		t, ok := exp.(float64)
+		if !ok {
+			return fmt.Errorf("malformed exp claim")
+		}
+		if now.After(time.Unix(int64(t), 0).Add(jwtLeeway)) {
+			return fmt.Errorf("token expired")
+		}
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		t, ok := nbf.(float64)
+		if !ok {
+			return fmt.Errorf("malformed nbf claim")
+		}
+		if now.Add(jwtLeeway).Before(time.Unix(int64(t), 0)) {
+			return fmt.Errorf("token not valid yet")
+		}
+	}
+
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return fmt.Errorf("token issued by %v", claims["iss"])
+	}
+	if v.audience != "" && !hasAudience(claims["aud"], v.audience) {
+		return fmt.Errorf("token not meant for %s", v.audience)
+	}
+
+	return nil
+}
+
+// hasAudience reports whether an aud claim, a string or a list of them,
+// names audience.
+func hasAudience(aud any, audience string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == audience
+	case []any:
+		for _, v := range a {
+			if v == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// jwks is a JSON Web Key Set fetched from an identity provider. It is
+// fetched when first needed, again once it has grown old, and early when
+// a token names a key it doesn't have, as happens when keys are rotated.
+type jwks struct {
+	url    string
+	client *http.Client
+	log    *slog.Logger
+
+	mu      sync.Mutex
+	keys    map[string]jwk
+	fetched time.Time
+}
+
+// jwk is a key of a key set, with the algorithm it is restricted to if
+// the set says.
+type jwk struct {
+	key crypto.PublicKey
+	alg string
+}
+
+// key returns the key kid, or the one key of the set if the token names
+// none.
+func (j *jwks) key(kid, alg string) (crypto.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	k, ok := j.lookup(kid)
+	age := time.Since(j.fetched)
+	if (!ok && age > jwksMinAge) || age > jwksMaxAge {
+		if err := j.fetch(); err != nil {
+			j.log.Warn("error fetching JWKS", "url", j.url, "err", err)
+		}
+		k, ok = j.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("no key %q in the key set", kid)
+	}
+	if k.alg != "" && k.alg != alg {
+		return nil, fmt.Errorf("key %q is for %s, not %s", kid, k.alg, alg)
+	}
+
+	return k.key, nil
+}
+
+func (j *jwks) lookup(kid string) (jwk, bool) {
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k, true
+		}
+	}
+	k, ok := j.keys[kid]
+
+	return k, ok
+}
+
+// fetch replaces the keys with those the URL serves now. Keys of a kind
+// that can't be used are skipped.
+func (j *jwks) fetch() error {
+	// Failing fetches are held back for as long as successful ones.
+	j.fetched = time.Now()
+
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return err
+	}
+
+	keys := make(map[string]jwk)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			ecKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if _, err := ecKey.ECDH(); err != nil {
+				continue
+			}
+			key = ecKey
+		default:
+			continue
+		}
+		keys[k.Kid] = jwk{key: key, alg: k.Alg}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no usable keys")
+	}
+	j.keys = keys
+
+	return nil
+}
+
+// jwtMiddleware lets through only requests with a valid Bearer token,
+// handing its claims on with the request.
+func jwtMiddleware(s *Server, next handler) handler {
+	return func(ex *exchangeConn, body io.Reader, req request) error {
+		token, err := bearerToken(req)
+		if err == nil {
+			req.claims, err = s.jwt.verify(token, time.Now())
+		}
+		if err != nil {
+			s.log.Debug("bearer token rejected", "id", req.id, "err", err)
+			ex.Write(bearerChallenge(err != errNoToken))
+			return nil
+		}
+
+		return next(ex, body, req)
+	}
+}
+
+// applyClaims sets the claims header in h to claims, as base64url JSON
+// like the payload of a token. A copy sent by the client is always removed
+// first, so only the server can vouch for claims.
+func (p *proxy) applyClaims(h header, claims map[string]any) {
+	if p.claimsHeader == "" {
+		return
+	}
+	h.del(p.claimsHeader)
+	if claims == nil {
+		return
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return
+	}
+	h.set(p.claimsHeader, base64.RawURLEncoding.EncodeToString(data))
+}
+
+// bearerChallenge builds the response asking the client for a valid
+// token, as RFC 6750 has it.
+func bearerChallenge(invalid bool) []byte {
+	challenge := `Bearer realm="` + authRealm + `"`
+	if invalid {
+		challenge += `, error="invalid_token"`
+	}
+	h := make(header)
+	h.set("WWW-Authenticate", challenge)
+
+	return buildResponseHeaders(statusUnauthorized, h, nil)
+}
@@ -0,0 +1,202 @@
+package server_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// signJWT makes a compact token of claims signed with key: a []byte
+// secret for HS256, or an RSA or P-256 private key.
+func signJWT(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	head := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		head["kid"] = kid
+	}
+	signed := segment(head) + "." + segment(claims)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("a secret of sorts")
+	opts := servertest.Options()
+	opts.JWTSecret = string(secret)
+	opts.JWTIssuer = "https://idp.example"
+	opts.JWTAudience = "files"
+	opts.Middleware = []server.RouteMiddleware{{Route: "GET /headers", Middleware: []string{"jwt"}}}
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	now := time.Now().Unix()
+	valid := map[string]any{"sub": "ann", "iss": "https://idp.example", "aud": []string{"other", "files"}, "exp": now + 60}
+	with := func(name string, value any) map[string]any {
+		claims := make(map[string]any)
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[name] = value
+		return claims
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Get("/headers").
+		AssertStatus(http.StatusUnauthorized).
+		AssertHeader("WWW-Authenticate", `Bearer realm="naive-server"`)
+	c.Do(http.MethodGet, "/headers", nil, bearer(signJWT(t, "HS256", "", secret, valid))).AssertStatus(http.StatusOK)
+	c.Get("/ip").AssertStatus(http.StatusOK)
+
+	for name, token := range map[string]string{
+		"expired":          signJWT(t, "HS256", "", secret, with("exp", now-120)),
+		"not yet valid":    signJWT(t, "HS256", "", secret, with("nbf", now+120)),
+		"other issuer":     signJWT(t, "HS256", "", secret, with("iss", "https://evil.example")),
+		"other audience":   signJWT(t, "HS256", "", secret, with("aud", "admin")),
+		"other secret":     signJWT(t, "HS256", "", []byte("a guess"), valid),
+		"no key for RS256": signJWT(t, "RS256", "", rsaKey, valid),
+		"unsigned":         strings.TrimSuffix(signJWT(t, "none", "", []byte{}, valid), "."),
+		"garbage":          "not.a.token",
+	} {
+		resp := c.Do(http.MethodGet, "/headers", nil, bearer(token))
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d, want 401", name, resp.StatusCode)
+		}
+		if got := resp.Header.Get("WWW-Authenticate"); !strings.Contains(got, `error="invalid_token"`) {
+			t.Errorf("%s: got WWW-Authenticate %q", name, got)
+		}
+	}
+
+	opts = servertest.Options()
+	opts.Middleware = []server.RouteMiddleware{{Route: "GET /headers", Middleware: []string{"jwt"}}}
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for the jwt middleware without keys")
+	}
+}
+
+func TestJWTPublicKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The identity provider publishes the RSA key...
+	var jwksFetches atomic.Int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwksFetches.Add(1)
+		e := big.NewInt(int64(rsaKey.E)).Bytes()
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "k1", "use": "sig", "alg": "RS256", "n": base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()), "e": base64.RawURLEncoding.EncodeToString(e)},
+			{"kty": "oct", "kid": "k2", "k": "c2VjcmV0"},
+		}})
+	}))
+	defer idp.Close()
+
+	// ...and the EC key is in a file.
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The upstream sees the claims.
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Jwt-Claims")))
+	}))
+	defer upstream.Close()
+
+	opts := servertest.Options()
+	opts.Proxy = upstream.URL
+	opts.JWTJWKS = idp.URL
+	opts.JWTPublicKey = keyFile
+	opts.Middleware = []server.RouteMiddleware{{Route: "GET /api", Middleware: []string{"jwt"}}}
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	claims := map[string]any{"sub": "bob", "exp": time.Now().Unix() + 60}
+	resp := c.Do(http.MethodGet, "/api", nil, bearer(signJWT(t, "RS256", "k1", rsaKey, claims))).AssertStatus(http.StatusOK)
+	var got map[string]any
+	data, _ := base64.RawURLEncoding.DecodeString(string(resp.Body))
+	if err := json.Unmarshal(data, &got); err != nil || got["sub"] != "bob" {
+		t.Errorf("got claims %q upstream, want those of bob", resp.Body)
+	}
+	c.Do(http.MethodGet, "/api", nil, bearer(signJWT(t, "ES256", "", ecKey, claims))).AssertStatus(http.StatusOK)
+
+	// Only the server vouches for claims.
+	h := bearer(signJWT(t, "ES256", "", ecKey, claims))
+	h.Set("X-Jwt-Claims", "forged")
+	if resp := c.Do(http.MethodGet, "/api", nil, h); strings.Contains(string(resp.Body), "forged") {
+		t.Error("the claims header of the client went upstream")
+	}
+	c.Get("/api").AssertStatus(http.StatusUnauthorized)
+
+	// Symmetric keys of the set aren't taken for HS256 secrets.
+	c.Do(http.MethodGet, "/api", nil, bearer(signJWT(t, "HS256", "k2", []byte("secret"), claims))).AssertStatus(http.StatusUnauthorized)
+
+	// A token for a key the set doesn't have doesn't have it fetched again
+	// right away.
+	c.Do(http.MethodGet, "/api", nil, bearer(signJWT(t, "RS256", "k3", rsaKey, claims))).AssertStatus(http.StatusUnauthorized)
+	if n := jwksFetches.Load(); n != 1 {
+		t.Errorf("got %d key set fetches, want 1", n)
+	}
+}
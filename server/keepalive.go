@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDrain is the most of an unread request body that is skipped to keep a
+// connection alive; anything larger is cheaper to hang up on.
+const maxDrain = 256 << 10
+
+// exchangeConn wraps a connection for a single request/response exchange. It
+// inspects the response head as it is written to learn whether the response
+// is framed well enough for the connection to be reused, and adds a
+// Connection: close header when the connection won't be.
+type exchangeConn struct {
+	net.Conn
+
+	// srv is the server the exchange is on, which fills in error pages and
+	// default headers; replayed exchanges have none. keepType leaves the
+	// content type without the server's charset, as proxied and echoed
+	// responses are sent.
+	srv      *Server
+	keepType bool
+	// headOnly leaves the body out, the request being a HEAD one.
+	headOnly bool
+
+	// closeAfter is set before the response is written if the connection is
+	// to be closed once the exchange is over.
+	closeAfter  bool
+	headWritten bool
+	keepAlive   bool
+
+	// extra holds headers added to the response as it is written, and vary
+	// the request headers it was negotiated on.
+	extra header
+	vary  []string
+
+	// status is the status code of the response and written the number of
+	// body bytes sent, for the access log.
+	status  int
+	written int64
+
+	// requestID identifies the request, on error pages and in the access
+	// log.
+	requestID string
+	// jsonErrors renders error bodies as JSON, and errorsVary says whether
+	// that went by the Accept header, as it does with -error-format json.
+	jsonErrors bool
+	errorsVary bool
+
+	// rules are the response header rules from -response-headers, matched
+	// against path, the path of the request.
+	rules []responseRule
+	path  string
+
+	// reader is what the request was read with, for handlers taking the
+	// connection over.
+	reader *bufio.Reader
+}
+
+func (c *exchangeConn) Write(p []byte) (int, error) {
+	if c.headWritten {
+		if c.headOnly {
+			return len(p), nil
+		}
+		n, err := c.Conn.Write(p)
+		c.written += int64(n)
+		return n, err
+	}
+	c.headWritten = true
+
+	head, rest, ok := bytes.Cut(p, []byte("\r\n\r\n"))
+	if !ok {
+		// The head was split across writes, so there is no telling how the
+		// body is framed.
+		return c.Conn.Write(p)
+	}
+
+	n := len(p)
+	c.status = headStatus(head)
+	if c.status >= statusBadRequest && c.srv != nil {
+		if page := c.srv.withErrorPage(head, rest, c.requestID, c.jsonErrors); page != nil {
+			p = page
+			head, rest, _ = bytes.Cut(p, []byte("\r\n\r\n"))
+			if c.errorsVary && !hasToken(strings.Join(c.vary, ","), "Accept") {
+				c.vary = append(c.vary, "Accept")
+			}
+		}
+	}
+	if c.srv != nil {
+		head = c.srv.withDefaults(head, !c.keepType)
+		p = append(append(head[:len(head):len(head)], "\r\n\r\n"...), rest...)
+	}
+	if c.headOnly {
+		rest = nil
+		p = p[:len(head)+4]
+	}
+	c.written = int64(len(rest))
+	closes, framed := inspectHead(head)
+	c.keepAlive = !c.closeAfter && !closes && framed
+
+	if len(c.extra) == 0 && len(c.vary) == 0 && len(c.rules) == 0 && (c.keepAlive || closes) {
+		if _, err := c.Conn.Write(p); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+	if len(c.vary) > 0 {
+		head = mergeVary(head, c.vary)
+	}
+	if len(c.extra) > 0 {
+		var b bytes.Buffer
+		b.Write(head)
+		for _, k := range c.extra.keys() {
+			for _, v := range c.extra[k] {
+				b.WriteString("\r\n" + k + ": " + v)
+			}
+		}
+		head = b.Bytes()
+	}
+	if len(c.rules) > 0 {
+		// Rules go last so they see every header, but they can't change
+		// the framing ones inspectHead went by.
+		head = applyResponseRules(head, c.path, c.rules)
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(p) + 64)
+	buf.Write(head)
+	if !c.keepAlive && !closes {
+		buf.WriteString("\r\nConnection: close")
+	}
+	buf.WriteString("\r\n\r\n")
+	buf.Write(rest)
+	if _, err := c.Conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// addResponseHeader adds a header to the response about to be written on
+// conn, if conn belongs to an exchange.
+func addResponseHeader(conn net.Conn, name, value string) {
+	c, ok := conn.(*exchangeConn)
+	if !ok {
+		return
+	}
+	if c.extra == nil {
+		c.extra = header{}
+	}
+	c.extra.add(name, value)
+}
+
+// keepContentType leaves the content type of the response about to be
+// written on conn as it is set, if conn belongs to an exchange.
+func keepContentType(conn net.Conn) {
+	if c, ok := conn.(*exchangeConn); ok {
+		c.keepType = true
+	}
+}
+
+// reusable reports whether another request may follow on the connection.
+func (c *exchangeConn) reusable() bool {
+	return c.headWritten && c.keepAlive
+}
+
+// forceClose makes the connection close after this exchange. It has no
+// effect once the response head has been written.
+func (c *exchangeConn) forceClose() {
+	c.closeAfter = true
+}
+
+// inspectHead reports whether a response head asks for the connection to be
+// closed, and whether its body is framed so that the end of it can be found
+// without closing the connection.
+func inspectHead(head []byte) (closes bool, framed bool) {
+	lines := strings.Split(string(head), "\r\n")
+	framed = !bodyAllowed(headStatus(head))
+
+	for _, line := range lines[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "connection":
+			closes = closes || hasToken(value, "close")
+		case "content-length":
+			framed = true
+		case "transfer-encoding":
+			framed = framed || hasToken(value, "chunked")
+		}
+	}
+
+	return closes, framed
+}
+
+// headStatus returns the status code in the status line of a response head.
+func headStatus(head []byte) int {
+	line, _, _ := bytes.Cut(head, []byte("\r\n"))
+
+	var code int
+	if fields := strings.Fields(string(line)); len(fields) > 1 {
+		code, _ = strconv.Atoi(fields[1])
+	}
+
+	return code
+}
+
+// bodyAllowed reports whether a response with the given status may carry a
+// body.
+func bodyAllowed(code int) bool {
+	return code >= 200 && code != statusNoContent && code != statusNotModified
+}
+
+// hasToken reports whether the comma-separated header value contains token.
+func hasToken(value, token string) bool {
+	for _, v := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// keepAlive reports whether the client is willing to send further requests
+// on the connection.
+func (r request) keepAlive() bool {
+	conn := r.headers.get("Connection")
+	if hasToken(conn, "close") {
+		return false
+	}
+	if r.httpVersion == "HTTP/1.0" {
+		return hasToken(conn, "keep-alive")
+	}
+
+	return true
+}
+
+// awaitRequest waits up to the keep-alive timeout for the next request to
+// start arriving, reporting false if it doesn't or the server shuts down.
+func (s *Server) awaitRequest(conn net.Conn, r *bufio.Reader) bool {
+	// The deadline goes on before the connection counts as idle, so it can't
+	// undo the one shutdown sets to wake it.
+	if s.opts.KeepAliveTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.opts.KeepAliveTimeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	if !s.setIdle(conn, true) {
+		return false
+	}
+	defer s.setIdle(conn, false)
+
+	_, err := r.Peek(1)
+
+	return err == nil
+}
+
+// setIdle marks conn as waiting for a request or not. It reports false if
+// the server is shutting down, in which case the connection should go.
+func (s *Server) setIdle(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !idle {
+		delete(s.idle, conn)
+		return true
+	}
+	if s.closing {
+		return false
+	}
+	s.idle[conn] = struct{}{}
+
+	return true
+}
+
+// drainBody skips whatever the handler left of the request body, reporting
+// whether it managed to.
+func drainBody(body io.Reader) bool {
+	n, err := io.Copy(io.Discard, io.LimitReader(body, maxDrain+1))
+
+	return err == nil && n <= maxDrain
+}
@@ -0,0 +1,117 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestKeepAliveMaxRequests(t *testing.T) {
+	opts := servertest.Options()
+	opts.KeepAliveMaxRequests = 3
+	s := servertest.New(t, opts)
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go io.WriteString(conn, strings.Repeat("GET /echo/hi HTTP/1.1\r\nHost: x\r\n\r\n", 4))
+
+	r := bufio.NewReader(conn)
+	for i := 1; i <= 3; i++ {
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		if resp.Close != (i == 3) {
+			t.Errorf("got close %v on response %d", resp.Close, i)
+		}
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("got %v after the last request, want EOF", err)
+	}
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	opts := servertest.Options()
+	opts.KeepAliveTimeout = 100 * time.Millisecond
+	s := servertest.New(t, opts)
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /echo/hi HTTP/1.1\r\nHost: x\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	if resp.Close {
+		t.Fatal("got Connection: close, want the connection kept open")
+	}
+
+	// Left idle, the connection is closed once the timeout is up.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("got %v on an idle connection, want EOF", err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond || d > 2*time.Second {
+		t.Errorf("idle connection closed after %v, want about 100ms", d)
+	}
+}
+
+func TestShutdownClosesIdle(t *testing.T) {
+	opts := servertest.Options()
+	opts.KeepAliveTimeout = time.Minute
+	opts.ShutdownTimeout = 5 * time.Second
+	s := servertest.New(t, opts)
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /echo/hi HTTP/1.1\r\nHost: x\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	// An idle connection isn't waited on, but cut as soon as shutdown starts.
+	start := time.Now()
+	if err := s.Shutdown(); err != nil {
+		t.Errorf("got %v shutting down with only an idle connection", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("shutdown took %v, want it to close the idle connection at once", d)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("got %v after shutdown, want EOF", err)
+	}
+}
+
+func TestChunkedResponseFraming(t *testing.T) {
+	c := servertest.New(t, servertest.Options()).Client()
+
+	// A streamed body is framed by its chunks alone; a length next to them
+	// would have the client stop reading at once.
+	c.RawHead("GET /echo-stream/1/0 HTTP/1.1\r\nHost: x\r\n\r\n").
+		AssertStatus(http.StatusOK).
+		AssertHeader("Transfer-Encoding", "chunked").
+		AssertHeader("Content-Length", "")
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// listenAddr is one of the addresses -host binds.
+type listenAddr struct {
+	// network is tcp, or tcp4 or tcp6 to bind a single address family.
+	network string
+	host    string
+	port    string
+	// iface binds each address of the named interface instead of host.
+	iface string
+}
+
+// parseHost parses the comma-separated addresses of -host. Each is a
+// host:port, IPv6 addresses in brackets as in [::1]:4221, or @iface:port
+// for the addresses of a network interface, and may start with tcp4/ or
+// tcp6/ to bind only that address family: tcp6/[::]:4221 doesn't take
+// IPv4 connections, which [::]:4221 does where the system allows it.
+func parseHost(host string) ([]listenAddr, error) {
+	var addrs []listenAddr
+	for _, s := range strings.Split(host, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		a := listenAddr{network: "tcp"}
+		if network, rest, ok := strings.Cut(s, "/"); ok && (network == "tcp4" || network == "tcp6") {
+			a.network, s = network, rest
+		}
+
+		var err error
+		a.host, a.port, err = net.SplitHostPort(s)
+		if err != nil {
+			if ip := net.ParseIP(s); ip != nil || strings.Count(s, ":") > 1 {
+				return nil, fmt.Errorf("invalid address %q: IPv6 addresses go in brackets, as in [::1]:4221", s)
+			}
+			return nil, fmt.Errorf("invalid address %q: want host:port", s)
+		}
+		if n, err := strconv.Atoi(a.port); err != nil || n < 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid port in %q", s)
+		}
+		if name, ok := strings.CutPrefix(a.host, "@"); ok {
+			if name == "" {
+				return nil, fmt.Errorf("invalid address %q: no interface after @", s)
+			}
+			a.host, a.iface = "", name
+		} else if ip := net.ParseIP(a.host); ip != nil {
+			if a.network == "tcp4" && ip.To4() == nil || a.network == "tcp6" && ip.To4() != nil {
+				return nil, fmt.Errorf("invalid address %q: %s isn't an address of its family", s, a.host)
+			}
+		}
+		addrs = append(addrs, a)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address to listen on in %q", host)
+	}
+
+	return addrs, nil
+}
+
+// String returns the address as net.Listen takes it.
+func (a listenAddr) String() string {
+	return net.JoinHostPort(a.host, a.port)
+}
+
+// interfaceAddrs returns what binding a listens on: the addresses of its
+// interface of its family, or just itself.
+func (a listenAddr) interfaceAddrs() ([]listenAddr, error) {
+	if a.iface == "" {
+		return []listenAddr{a}, nil
+	}
+
+	iface, err := net.InterfaceByName(a.iface)
+	if err != nil {
+		return nil, err
+	}
+	ifAddrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []listenAddr
+	for _, ifAddr := range ifAddrs {
+		ipNet, ok := ifAddr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if a.network == "tcp4" && ip.To4() == nil || a.network == "tcp6" && ip.To4() != nil {
+			continue
+		}
+		host := ip.String()
+		// Link-local addresses are only bound with their zone.
+		if ip.IsLinkLocalUnicast() && ip.To4() == nil {
+			host += "%" + iface.Name
+		}
+		addrs = append(addrs, listenAddr{network: a.network, host: host, port: a.port})
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("interface %s has no addresses to listen on", a.iface)
+	}
+
+	return addrs, nil
+}
+
+// Listen binds every address of opts.Host, closing those it bound if one
+// of them fails.
+func Listen(opts Options) ([]net.Listener, error) {
+	addrs, err := parseHost(opts.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	var ls []net.Listener
+	fail := func(err error) ([]net.Listener, error) {
+		for _, l := range ls {
+			l.Close()
+		}
+		return nil, err
+	}
+	for _, addr := range addrs {
+		bound, err := addr.interfaceAddrs()
+		if err != nil {
+			return fail(err)
+		}
+		for _, a := range bound {
+			l, err := net.Listen(a.network, a.String())
+			if err != nil {
+				return fail(err)
+			}
+			ls = append(ls, l)
+		}
+	}
+
+	return ls, nil
+}
+
+// ServeListeners serves every listener of ls, as Listen binds them, until
+// the server is shut down, returning as soon as one of them fails.
+func (s *Server) ServeListeners(ls []net.Listener) error {
+	errCh := make(chan error, len(ls))
+	for _, l := range ls {
+		go func(l net.Listener) {
+			errCh <- s.Serve(l)
+		}(l)
+	}
+	for range ls {
+		if err := <-errCh; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package server_test
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestListen(t *testing.T) {
+	opts := servertest.Options()
+	opts.Host = "127.0.0.1:0, tcp6/[::1]:0"
+	ls, err := server.Listen(opts)
+	if err != nil {
+		t.Skipf("can't bind IPv4 and IPv6 loopback: %v", err)
+	}
+	defer func() {
+		for _, l := range ls {
+			l.Close()
+		}
+	}()
+
+	if len(ls) != 2 {
+		t.Fatalf("got %d listeners, want 2", len(ls))
+	}
+	if ip := ls[0].Addr().(*net.TCPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("first listener is on %s, want 127.0.0.1", ip)
+	}
+	if ip := ls[1].Addr().(*net.TCPAddr).IP; !ip.Equal(net.IPv6loopback) {
+		t.Errorf("second listener is on %s, want ::1", ip)
+	}
+}
+
+func TestListenInterface(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lo string
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			lo = iface.Name
+		}
+	}
+	if lo == "" {
+		t.Skip("no loopback interface")
+	}
+
+	opts := servertest.Options()
+	opts.Host = "tcp4/@" + lo + ":0"
+	ls, err := server.Listen(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range ls {
+		if ip := l.Addr().(*net.TCPAddr).IP; ip.To4() == nil || !ip.IsLoopback() {
+			t.Errorf("listening on %s, want an IPv4 loopback address", ip)
+		}
+		l.Close()
+	}
+}
+
+func TestInvalidHost(t *testing.T) {
+	for _, host := range []string{"::1:4221", "[::1]", "localhost", "tcp4/[::1]:4221", "tcp6/127.0.0.1:4221", "@:4221", ":http-alt", ":99999", ","} {
+		opts := servertest.Options()
+		opts.Host = host
+		_, err := server.New(opts)
+		if err == nil {
+			t.Errorf("got no error for -host %q", host)
+		} else if host == "::1:4221" && !strings.Contains(err.Error(), "brackets") {
+			t.Errorf("error for a bare IPv6 address doesn't say to bracket it: %v", err)
+		}
+	}
+}
+
+func TestServeListeners(t *testing.T) {
+	opts := servertest.Options()
+	opts.Host = "127.0.0.1:0,127.0.0.1:0"
+	ls, err := server.Listen(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := server.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeListeners(ls) }()
+
+	// A listener that isn't served still takes connections, so requests to
+	// it would hang without a timeout.
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, l := range ls {
+		resp, err := client.Get("http://" + l.Addr().String() + "/healthz")
+		if err != nil {
+			t.Errorf("%s: %v", l.Addr(), err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: got %d, want 200", l.Addr(), resp.StatusCode)
+		}
+	}
+
+	srv.Shutdown()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
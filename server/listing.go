@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	listingThemeMinimal = "minimal"
+	listingThemeTable   = "table"
+)
+
+const (
+	sortByName     = "name"
+	sortBySize     = "size"
+	sortByModified = "modified"
+)
+
+const (
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+//go:embed templates/listing-*.html
+var listingThemes embed.FS
+
+// listingData is what directory listing templates are executed with.
+type listingData struct {
+	// Path is the URL path of the directory being listed.
+	Path string
+	// Breadcrumbs lead from the root of /files down to the directory.
+	Breadcrumbs []breadcrumb
+	Entries     []listingEntry
+	// Sort and Order describe how Entries are ordered.
+	Sort  string
+	Order string
+}
+
+type breadcrumb struct {
+	Name string
+	URL  string
+}
+
+// listingEntry is also what the JSON listing is made of, hence the tags.
+type listingEntry struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url"`
+	IsDir   bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"`
+}
+
+// SortURL returns the query string that sorts the listing by column,
+// flipping the order if it is already sorted by it.
+func (d listingData) SortURL(column string) string {
+	order := orderAsc
+	if d.Sort == column && d.Order == orderAsc {
+		order = orderDesc
+	}
+
+	return "?sort=" + column + "&order=" + order
+}
+
+var listingFuncs = template.FuncMap{
+	"humanSize": humanSize,
+}
+
+// loadListingTemplate returns the operator's template at path if one is
+// given, or else the built-in theme.
+func loadListingTemplate(theme, path string) (*template.Template, error) {
+	if path != "" {
+		return template.New(filepath.Base(path)).Funcs(listingFuncs).ParseFiles(path)
+	}
+
+	switch theme {
+	case listingThemeMinimal, listingThemeTable:
+	default:
+		return nil, fmt.Errorf("unknown listing theme %q", theme)
+	}
+
+	name := "listing-" + theme + ".html"
+
+	return template.New(name).Funcs(listingFuncs).ParseFS(listingThemes, "templates/"+name)
+}
+
+func (s *Server) serveListing(conn net.Conn, req request, dir string) error {
+	infos, err := s.store.List(dir)
+	if err != nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return fmt.Errorf("error listing %s: %v\n", dir, err)
+	}
+
+	infos = slices.DeleteFunc(infos, func(info fs.FileInfo) bool { return reservedName(joinName(dir, info.Name())) })
+
+	data := newListingData(dir, infos, req.query.Get("sort"), req.query.Get("order"))
+	if wantsJSON(req) {
+		entries := data.Entries
+		if entries == nil {
+			entries = []listingEntry{}
+		}
+		conn.Write(buildResponse(statusOK, jsonContent(entries)))
+		return nil
+	}
+
+	var body bytes.Buffer
+	if err := s.listing.Load().Execute(&body, data); err != nil {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return fmt.Errorf("error rendering listing of %s: %v\n", dir, err)
+	}
+
+	c := content{
+		contentType: contentTypeTextHTML,
+		body:        body.Bytes(),
+	}
+	if s.dev != nil {
+		c.body = devInject(c.body)
+	}
+	conn.Write(buildResponse(statusOK, &c))
+
+	return nil
+}
+
+func newListingData(dir string, infos []fs.FileInfo, sortBy, order string) listingData {
+	switch sortBy {
+	case sortByName, sortBySize, sortByModified:
+	default:
+		sortBy = sortByName
+	}
+	if order != orderDesc {
+		order = orderAsc
+	}
+
+	base := "/files/"
+	if dir != "" {
+		base += escapePath(dir) + "/"
+	}
+
+	data := listingData{
+		Path:        base,
+		Breadcrumbs: breadcrumbs(dir),
+		Sort:        sortBy,
+		Order:       order,
+	}
+	for _, info := range infos {
+		e := listingEntry{
+			Name:    info.Name(),
+			URL:     base + url.PathEscape(info.Name()),
+			IsDir:   info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if e.IsDir {
+			e.URL += "/"
+		}
+		data.Entries = append(data.Entries, e)
+	}
+
+	sortEntries(data.Entries, sortBy, order == orderDesc)
+
+	return data
+}
+
+// sortEntries orders entries by the given column, always keeping directories
+// ahead of files.
+func sortEntries(entries []listingEntry, sortBy string, desc bool) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if desc {
+			a, b = b, a
+		}
+
+		switch sortBy {
+		case sortBySize:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case sortByModified:
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+
+		return a.Name < b.Name
+	})
+}
+
+func breadcrumbs(dir string) []breadcrumb {
+	crumbs := []breadcrumb{{Name: "files", URL: "/files/"}}
+	if dir == "" {
+		return crumbs
+	}
+
+	u := "/files/"
+	for _, part := range strings.Split(dir, "/") {
+		u += url.PathEscape(part) + "/"
+		crumbs = append(crumbs, breadcrumb{Name: part, URL: u})
+	}
+
+	return crumbs
+}
+
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+
+	return strings.Join(parts, "/")
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,95 @@
+package server_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// listingDir returns a directory of a file and a subdirectory, both
+// modified at stamp.
+func listingDir(t *testing.T, stamp time.Time) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a & b.txt"), []byte("hello"), 0o644)
+	os.WriteFile(filepath.Join(dir, "big.bin"), make([]byte, 3<<10), 0o644)
+	for _, name := range []string{"sub", "a & b.txt", "big.bin"} {
+		os.Chtimes(filepath.Join(dir, name), stamp, stamp)
+	}
+
+	return dir
+}
+
+func TestListingThemes(t *testing.T) {
+	stamp := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	for _, theme := range []string{"", "table", "minimal"} {
+		opts := servertest.Options()
+		opts.Storage = "disk"
+		opts.Directory = listingDir(t, stamp)
+		if theme != "" {
+			opts.ListingTheme = theme
+		}
+		c := servertest.NewPipe(t, opts).Client()
+
+		resp := c.Get("/files/?sort=size&order=desc").AssertStatus(http.StatusOK)
+		golden := theme
+		if golden == "" {
+			golden = "table"
+		}
+		want, err := os.ReadFile(filepath.Join("testdata", "listing-"+golden+".golden"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Times are shown in the server's zone, and the golden files have
+		// them in UTC.
+		got := strings.ReplaceAll(string(resp.Body), stamp.Local().Format("2006-01-02 15:04"), stamp.Format("2006-01-02 15:04"))
+		if got != string(want) {
+			t.Errorf("theme %q: got\n%s\nwant\n%s", theme, got, want)
+		}
+	}
+}
+
+func TestListingTemplate(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "listing.html")
+	os.WriteFile(tmpl, []byte(`<h1>{{.Path}}</h1>{{range .Entries}}<p>{{.Name}} {{humanSize .Size}} <a href="{{$.SortURL "name"}}">sort</a></p>{{end}}`), 0o644)
+
+	opts := servertest.Options()
+	opts.ListingTemplate = tmpl
+	opts.ListingTheme = "minimal"
+	c := servertest.NewPipe(t, opts).Client()
+	c.Do(http.MethodPut, "/files/d/a<b>.txt", strings.NewReader("hello"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/d/").
+		AssertStatus(http.StatusOK).
+		AssertBody(`<h1>/files/d/</h1><p>a&lt;b&gt;.txt 5 B <a href="?sort=name&amp;order=desc">sort</a></p>`)
+}
+
+func TestListingTemplateInvalid(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken.html")
+	os.WriteFile(broken, []byte(`{{range .Entries}}<p>{{.Name}}`), 0o644)
+	unknownFunc := filepath.Join(dir, "func.html")
+	os.WriteFile(unknownFunc, []byte(`{{shout .Path}}`), 0o644)
+
+	for _, tc := range []struct{ theme, template string }{
+		{"table", broken},
+		{"table", unknownFunc},
+		{"table", filepath.Join(dir, "missing.html")},
+		{"fancy", ""},
+	} {
+		opts := servertest.Options()
+		opts.ListingTheme = tc.theme
+		opts.ListingTemplate = tc.template
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error with theme %q and template %q", tc.theme, tc.template)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import "sync"
+
+// pathLocks keeps writers to the same store name from running at once.
+// Rather than queueing, a second writer is turned away so the client can
+// tell its upload didn't land.
+type pathLocks struct {
+	mu     sync.Mutex
+	locked map[string]bool
+}
+
+func newPathLocks() *pathLocks {
+	return &pathLocks{locked: make(map[string]bool)}
+}
+
+// tryLock locks all of names, or none of them if any is already locked. The
+// returned func unlocks them again.
+func (l *pathLocks) tryLock(names ...string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, name := range names {
+		name = cleanName(name)
+		if l.locked[name] {
+			return nil, false
+		}
+		names[i] = name
+	}
+	for _, name := range names {
+		l.locked[name] = true
+	}
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		for _, name := range names {
+			delete(l.locked, name)
+		}
+	}, true
+}
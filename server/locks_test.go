@@ -0,0 +1,102 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestConcurrentUploads(t *testing.T) {
+	opts := servertest.Options()
+	opts.Storage = "disk"
+	opts.Directory = t.TempDir()
+	s := servertest.New(t, opts)
+
+	// Every upload either lands whole or is refused; none get mixed up with
+	// another.
+	const uploads = 20
+	bodies := make([]string, uploads)
+	statuses := make([]int, uploads)
+	var wg sync.WaitGroup
+	for i := range bodies {
+		bodies[i] = strings.Repeat(fmt.Sprint(i%10), 256<<10)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodPut, s.URL+"/files/a.txt", strings.NewReader(bodies[i]))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Errorf("upload %d: %v", i, err)
+				return
+			}
+			resp.Body.Close()
+			statuses[i] = resp.StatusCode
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for i, status := range statuses {
+		switch status {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("upload %d: got status %d, want 201 or 409", i, status)
+		}
+	}
+	if created == 0 {
+		t.Fatalf("no upload succeeded")
+	}
+
+	got := s.Client().Get("/files/a.txt").AssertStatus(http.StatusOK).Body
+	if len(got) != 256<<10 || strings.Trim(string(got), string(got[:1])) != "" {
+		t.Errorf("file holds a mix of uploads")
+	}
+}
+
+func TestUploadInProgress(t *testing.T) {
+	s := servertest.New(t, servertest.Options())
+	c := s.Client()
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "PUT /files/a.txt HTTP/1.1\r\nContent-Length: 10\r\n\r\nhello")
+
+	// Wait for the first upload to be under way, then race it.
+	for i := 0; ; i++ {
+		status := c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("other"), nil).StatusCode
+		if status == http.StatusConflict {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("second upload got status %d, want 409", status)
+		}
+	}
+	c.Do(http.MethodPut, "/files/b.txt", strings.NewReader("other"), nil).AssertStatus(http.StatusCreated)
+
+	io.WriteString(conn, "world")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("first upload got status %d, want 201", resp.StatusCode)
+	}
+
+	body := c.Get("/files/a.txt").Body
+	if !bytes.Equal(body, []byte("helloworld")) {
+		t.Errorf("got %q, want helloworld", body)
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NewLogger returns the logger the options call for, writing to standard
+// output: key=value lines by default, or with LogPretty coloured lines
+// for reading in a terminal. Quiet leaves out everything below warnings.
+func NewLogger(opts Options) *slog.Logger {
+	level := slog.LevelInfo
+	if opts.Quiet {
+		level = slog.LevelWarn
+	}
+
+	if opts.LogPretty {
+		return slog.New(newPrettyHandler(os.Stdout, level, os.Getenv("NO_COLOR") == ""))
+	}
+
+	return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+// ANSI escapes for the pretty log.
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiGrey   = "\x1b[90m"
+)
+
+// prettyHandler writes a log line per record as the time, a level tag and
+// the message, followed by the attributes. Multi-line values such as stack
+// traces go on the lines below, indented.
+type prettyHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+	color bool
+	// attrs are the attributes added with WithAttrs, already formatted, and
+	// group the prefix of the keys of those to come.
+	attrs []byte
+	group string
+}
+
+func newPrettyHandler(w io.Writer, level slog.Leveler, color bool) *prettyHandler {
+	return &prettyHandler{mu: &sync.Mutex{}, w: w, level: level, color: color}
+}
+
+func (h *prettyHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *prettyHandler) Handle(_ context.Context, r slog.Record) error {
+	var b, more bytes.Buffer
+	if !r.Time.IsZero() {
+		h.paint(&b, ansiDim, r.Time.Format("15:04:05.000"))
+		b.WriteByte(' ')
+	}
+	h.paint(&b, levelColor(r.Level), fmt.Sprintf("%-5s", r.Level.String()))
+	b.WriteByte(' ')
+	b.WriteString(r.Message)
+	b.Write(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		h.writeAttr(&b, &more, h.group, a)
+		return true
+	})
+	b.WriteByte('\n')
+	b.Write(more.Bytes())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := h.w.Write(b.Bytes())
+
+	return err
+}
+
+func (h *prettyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	var b bytes.Buffer
+	b.Write(h.attrs)
+	for _, a := range attrs {
+		h.writeAttr(&b, nil, h.group, a)
+	}
+	h2.attrs = b.Bytes()
+
+	return &h2
+}
+
+func (h *prettyHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group += name + "."
+
+	return &h2
+}
+
+// writeAttr writes a as key=value to b, or to more below the line if its
+// value spans lines and more isn't nil.
+func (h *prettyHandler) writeAttr(b, more *bytes.Buffer, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			h.writeAttr(b, more, prefix+a.Key+".", ga)
+		}
+		return
+	}
+
+	value := a.Value.String()
+	if strings.Contains(value, "\n") && more != nil {
+		h.paint(more, ansiGrey, "    "+prefix+a.Key+":")
+		more.WriteByte('\n')
+		for _, line := range strings.Split(strings.TrimRight(value, "\n"), "\n") {
+			more.WriteString("    " + line + "\n")
+		}
+		return
+	}
+	if value == "" || strings.ContainsAny(value, " \"=\n") {
+		value = strconv.Quote(value)
+	}
+	b.WriteByte(' ')
+	h.paint(b, ansiGrey, prefix+a.Key+"=")
+	b.WriteString(value)
+}
+
+// paint writes s to b in the colour code, if colours are on.
+func (h *prettyHandler) paint(b *bytes.Buffer, code, s string) {
+	if !h.color {
+		b.WriteString(s)
+		return
+	}
+	b.WriteString(code + s + ansiReset)
+}
+
+func levelColor(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return ansiRed
+	case level >= slog.LevelWarn:
+		return ansiYellow
+	case level >= slog.LevelInfo:
+		return ansiBlue
+	}
+
+	return ansiGrey
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"regexp"
+	"testing"
+)
+
+func TestPrettyHandler(t *testing.T) {
+	var b bytes.Buffer
+	log := slog.New(newPrettyHandler(&b, slog.LevelInfo, false)).With("conn", 7)
+
+	log.Debug("hidden")
+	log.Info("request failed", "err", "error reading a.txt: no such file", "status", 404)
+	log.WithGroup("req").Error("panic", "path", "/x", "stack", "goroutine 1:\n\tmain.go:10\n")
+
+	want := regexp.MustCompile(`^\d\d:\d\d:\d\d\.\d{3} INFO  request failed conn=7 err="error reading a.txt: no such file" status=404
+\d\d:\d\d:\d\d\.\d{3} ERROR panic conn=7 req.path=/x
+    req.stack:
+    goroutine 1:
+    	main.go:10
+$`)
+	if !want.Match(b.Bytes()) {
+		t.Errorf("got log\n%s", b.Bytes())
+	}
+}
+
+func TestPrettyHandlerColor(t *testing.T) {
+	var b bytes.Buffer
+	slog.New(newPrettyHandler(&b, slog.LevelInfo, true)).Warn("careful")
+
+	if !bytes.Contains(b.Bytes(), []byte(ansiYellow+"WARN "+ansiReset+" careful")) {
+		t.Errorf("got log %q", b.Bytes())
+	}
+}
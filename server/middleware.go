@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// minGzipSize is the smallest body worth compressing by default.
+	minGzipSize = 256
+	// maxGzipSize is the most of a body held back to be compressed; larger
+	// ones are sent as they are.
+	maxGzipSize = 8 << 20
+)
+
+// RouteMiddleware attaches middleware to a route: "METHOD /path" for one
+// method of a top-level path, "/path" for all of them, or "@group" for one
+// of the route groups, "writes" for the uploads, deletes and moves of
+// /files and "text" for the routes answering with text.
+type RouteMiddleware struct {
+	Route      string
+	Middleware []string
+}
+
+// routeGroups are the routes the @group names of RouteMiddleware stand for.
+var routeGroups = map[string][]string{
+	"writes": {"POST /files", "PUT /files", "DELETE /files", "MOVE /files"},
+	"text":   {"GET /", "GET /echo", "POST /echo", "GET /user-agent", "GET /ip", "GET /headers", "GET /search", "GET /ui"},
+}
+
+// handler serves a request on an exchange, as serveRequest does.
+type handler func(ex *exchangeConn, body io.Reader, req request) error
+
+// middlewares are the middleware that can be attached to routes, by name.
+// Each wraps the handler of a route in one that runs before it.
+var middlewares = map[string]func(s *Server, next handler) handler{
+	"auth":   authMiddleware,
+	"gzip":   gzipMiddleware,
+	"jwt":    jwtMiddleware,
+	"apikey": apiKeyMiddleware,
+	"signed": signedMiddleware,
+}
+
+// buildChains composes the handler of every route middleware is attached
+// to, keyed by "METHOD /path", so that requests cost a lookup and nothing
+// more. Middleware listed first runs first.
+func (s *Server) buildChains(routes []RouteMiddleware) (map[string]handler, error) {
+	attached := make(map[string][]string)
+	var keys []string
+	for _, rm := range routes {
+		expanded, err := s.expandRoute(rm.Route)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range rm.Middleware {
+			if middlewares[name] == nil {
+				return nil, fmt.Errorf("unknown middleware %q", name)
+			}
+			if name == "auth" && !s.authEnabled() {
+				return nil, fmt.Errorf("the auth middleware requires -ui-auth or -htpasswd credentials")
+			}
+			if name == "jwt" && s.jwt == nil {
+				return nil, fmt.Errorf("the jwt middleware requires -jwt-secret, -jwt-public-key or -jwt-jwks")
+			}
+			if name == "apikey" && s.opts.APIKeys == "" {
+				return nil, fmt.Errorf("the apikey middleware requires -api-keys")
+			}
+			if name == "signed" && s.opts.SignedURLSecret == "" {
+				return nil, fmt.Errorf("the signed middleware requires -signed-url-secret")
+			}
+		}
+		for _, key := range expanded {
+			if attached[key] == nil {
+				keys = append(keys, key)
+			}
+			attached[key] = append(attached[key], rm.Middleware...)
+		}
+	}
+
+	chains := make(map[string]handler, len(keys))
+	for _, key := range keys {
+		h := handler(func(ex *exchangeConn, body io.Reader, req request) error {
+			return s.serveRequest(ex, body, req)
+		})
+		names := attached[key]
+		for i := len(names) - 1; i >= 0; i-- {
+			h = middlewares[names[i]](s, h)
+		}
+		chains[key] = h
+	}
+
+	return chains, nil
+}
+
+// expandRoute returns the "METHOD /path" keys a route of RouteMiddleware
+// stands for.
+func (s *Server) expandRoute(route string) ([]string, error) {
+	if group, ok := strings.CutPrefix(route, "@"); ok {
+		keys, ok := routeGroups[group]
+		if !ok {
+			return nil, fmt.Errorf("unknown route group %q", route)
+		}
+		return keys, nil
+	}
+
+	method, path, ok := strings.Cut(route, " ")
+	if !ok {
+		method, path = "", route
+	}
+	if !strings.HasPrefix(path, "/") || strings.Contains(path[1:], "/") {
+		return nil, fmt.Errorf("invalid route %q, want '[METHOD ]/path' with a top-level path", route)
+	}
+	if method != "" {
+		return []string{strings.ToUpper(method) + " " + path}, nil
+	}
+
+	methods := s.routeMethods(request{pathParts: []string{"", path[1:]}})
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no route %q", route)
+	}
+	keys := make([]string, len(methods))
+	for i, m := range methods {
+		keys[i] = m + " " + path
+	}
+
+	return keys, nil
+}
+
+// route returns the handler composed for the route of req, or nil if no
+// middleware is attached to it.
+func (s *Server) route(req request) handler {
+	if len(s.chains) == 0 || len(req.pathParts) < 2 {
+		return nil
+	}
+
+	return s.chains[req.method+" /"+req.pathParts[1]]
+}
+
+// authMiddleware lets through only requests carrying the -ui-auth or
+// -htpasswd credentials.
+func authMiddleware(s *Server, next handler) handler {
+	return func(ex *exchangeConn, body io.Reader, req request) error {
+		if !s.authorized(req) {
+			ex.Write(unauthorized())
+			return nil
+		}
+
+		return next(ex, body, req)
+	}
+}
+
+// gzipMiddleware compresses textual responses for clients accepting gzip,
+// or those of the -gzip-types. Responses streamed without a Content-Length
+// are sent as they are.
+func gzipMiddleware(s *Server, next handler) handler {
+	return func(ex *exchangeConn, body io.Reader, req request) error {
+		req.varyOn("Accept-Encoding")
+		if !acceptsGzip(req.headers.get("Accept-Encoding")) {
+			return next(ex, body, req)
+		}
+
+		gz := &gzipConn{Conn: ex.Conn, opts: &s.opts}
+		ex.Conn = gz
+		err := next(ex, body, req)
+		ex.Conn = gz.Conn
+		if ferr := gz.flush(); err == nil {
+			err = ferr
+		}
+
+		return err
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding value allows gzip.
+func acceptsGzip(value string) bool {
+	for _, v := range strings.Split(value, ",") {
+		coding, params, _ := strings.Cut(v, ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !ok {
+			return true
+		}
+		f, err := strconv.ParseFloat(q, 64)
+		return err == nil && f > 0
+	}
+
+	return false
+}
+
+// compressible reports whether a content type is worth compressing: one of
+// types, or if there are none a textual one, and not one of exclude.
+func compressible(contentType string, types, exclude []string) bool {
+	if typeAllowed(contentType, exclude) {
+		return false
+	}
+	if len(types) > 0 {
+		return typeAllowed(contentType, types)
+	}
+
+	t := mediaType(contentType)
+	switch {
+	case strings.HasPrefix(t, "text/") && t != "text/event-stream":
+		return true
+	case strings.HasSuffix(t, "+json"), strings.HasSuffix(t, "+xml"):
+		return true
+	}
+
+	return t == contentTypeJSON || t == "application/javascript" || t == "application/xml"
+}
+
+// gzipConn holds a response back until it is complete, to send it
+// compressed. Responses it can't or needn't compress pass through as soon
+// as their head shows it.
+type gzipConn struct {
+	net.Conn
+	opts    *Options
+	buf     bytes.Buffer
+	through bool
+}
+
+func (c *gzipConn) Write(p []byte) (int, error) {
+	if c.through {
+		return c.Conn.Write(p)
+	}
+	c.buf.Write(p)
+
+	head, body, ok := bytes.Cut(c.buf.Bytes(), []byte("\r\n\r\n"))
+	if !ok {
+		return len(p), nil
+	}
+	if !c.compresses(head) || len(body) > maxGzipSize {
+		c.through = true
+		if _, err := c.Conn.Write(c.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		c.buf.Reset()
+	}
+
+	return len(p), nil
+}
+
+// compresses reports whether the response with head is to be compressed.
+func (c *gzipConn) compresses(head []byte) bool {
+	if headStatus(head) != statusOK {
+		return false
+	}
+
+	var contentType string
+	length := -1
+	for _, line := range strings.Split(string(head), "\r\n")[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "content-type":
+			contentType = value
+		case "content-length":
+			length, _ = strconv.Atoi(value)
+		case "content-encoding", "transfer-encoding", "content-range":
+			return false
+		}
+	}
+
+	return length >= c.opts.GzipMinSize && length <= maxGzipSize &&
+		compressible(contentType, c.opts.GzipTypes, c.opts.GzipExcludeTypes)
+}
+
+// flush sends what is held back, compressed if it is a whole response.
+func (c *gzipConn) flush() error {
+	if c.through || c.buf.Len() == 0 {
+		return nil
+	}
+	defer c.buf.Reset()
+
+	head, body, _ := bytes.Cut(c.buf.Bytes(), []byte("\r\n\r\n"))
+	if len(body) != contentLength(head) {
+		// Cut short, so it goes out as it was.
+		_, err := c.Conn.Write(c.buf.Bytes())
+		return err
+	}
+
+	var zbuf bytes.Buffer
+	// The level was checked by New.
+	zw, _ := gzip.NewWriterLevel(&zbuf, c.opts.GzipLevel)
+	zw.Write(body)
+	zw.Close()
+
+	lines := strings.Split(string(head), "\r\n")
+	h := make(header)
+	for _, line := range lines[1:] {
+		name, value, _ := strings.Cut(line, ":")
+		h.add(name, strings.TrimSpace(value))
+	}
+	h.set("Content-Encoding", "gzip")
+	h.set("Content-Length", strconv.Itoa(zbuf.Len()))
+	if etag := h.get("ETag"); strings.HasSuffix(etag, `"`) {
+		// The compressed body is another representation.
+		h.set("ETag", strings.TrimSuffix(etag, `"`)+`-gzip"`)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(lines[0])
+	for _, k := range h.keys() {
+		for _, v := range h[k] {
+			out.WriteString("\r\n" + k + ": " + v)
+		}
+	}
+	out.WriteString("\r\n\r\n")
+	out.Write(zbuf.Bytes())
+	_, err := c.Conn.Write(out.Bytes())
+
+	return err
+}
+
+// middlewareFlag collects repeated 'route=middleware,...' flags.
+type middlewareFlag []RouteMiddleware
+
+func (f *middlewareFlag) String() string {
+	if f == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, rm := range *f {
+		parts = append(parts, rm.Route+"="+strings.Join(rm.Middleware, ","))
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, " ")
+}
+
+func (f *middlewareFlag) Set(value string) error {
+	route, names, ok := strings.Cut(value, "=")
+	route = strings.TrimSpace(route)
+	if !ok || route == "" {
+		return fmt.Errorf("expected 'route=middleware,...', got %q", value)
+	}
+
+	rm := RouteMiddleware{Route: route}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			rm.Middleware = append(rm.Middleware, name)
+		}
+	}
+	if len(rm.Middleware) == 0 {
+		return fmt.Errorf("no middleware in %q", value)
+	}
+	*f = append(*f, rm)
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestMiddleware(t *testing.T) {
+	opts := servertest.Options()
+	opts.UIAuth = "me:secret"
+	opts.Middleware = []server.RouteMiddleware{
+		{Route: "@writes", Middleware: []string{"auth"}},
+		{Route: "GET /files", Middleware: []string{"gzip"}},
+	}
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	text := strings.Repeat("all work and no play\n", 100)
+	auth := http.Header{"Authorization": {"Basic bWU6c2VjcmV0"}}
+
+	// Auth guards the writes only.
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader(text), nil).AssertStatus(http.StatusUnauthorized)
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader(text), auth).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodPut, "/files/small.txt", strings.NewReader("small"), auth).AssertStatus(http.StatusCreated)
+	c.Get("/echo/open").AssertStatus(http.StatusOK).AssertBody("open")
+
+	gz := http.Header{"Accept-Encoding": {"gzip"}}
+	resp := c.Do(http.MethodGet, "/files/a.txt", nil, gz).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Encoding", "gzip").
+		AssertHeader("Vary", "Accept-Encoding")
+	zr, err := gzip.NewReader(bytes.NewReader(resp.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(zr); string(got) != text {
+		t.Errorf("got %d bytes decompressed, want the %d uploaded", len(got), len(text))
+	}
+	if len(resp.Body) >= len(text) {
+		t.Errorf("got %d bytes compressed from %d", len(resp.Body), len(text))
+	}
+
+	// Clients that don't take gzip, small bodies and ranges go uncompressed.
+	c.Get("/files/a.txt").AssertHeader("Content-Encoding", "").AssertBody(text)
+	c.Do(http.MethodGet, "/files/a.txt", nil, http.Header{"Accept-Encoding": {"gzip;q=0"}}).AssertHeader("Content-Encoding", "")
+	c.Do(http.MethodGet, "/files/small.txt", nil, gz).AssertHeader("Content-Encoding", "").AssertBody("small")
+	c.Do(http.MethodGet, "/files/a.txt", nil, http.Header{"Accept-Encoding": {"gzip"}, "Range": {"bytes=0-2"}}).
+		AssertStatus(http.StatusPartialContent).
+		AssertHeader("Content-Encoding", "").
+		AssertBody("all")
+
+	for _, rm := range []server.RouteMiddleware{
+		{Route: "/files", Middleware: []string{"nope"}},
+		{Route: "@nope", Middleware: []string{"gzip"}},
+		{Route: "/nowhere", Middleware: []string{"gzip"}},
+		{Route: "GET /files/deeper", Middleware: []string{"gzip"}},
+	} {
+		opts := servertest.Options()
+		opts.Middleware = []server.RouteMiddleware{rm}
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error for %+v", rm)
+		}
+	}
+
+	opts = servertest.Options()
+	opts.Middleware = []server.RouteMiddleware{{Route: "@writes", Middleware: []string{"auth"}}}
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for auth without -ui-auth")
+	}
+}
+
+func TestGzipTuning(t *testing.T) {
+	opts := servertest.Options()
+	opts.Middleware = []server.RouteMiddleware{{Route: "GET /files", Middleware: []string{"gzip"}}}
+	opts.GzipLevel = 9
+	opts.GzipMinSize = 10
+	opts.GzipTypes = []string{"text/*", "image/*"}
+	opts.GzipExcludeTypes = []string{"text/csv", "image/jpeg"}
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	text := strings.Repeat("all work and no play\n", 100)
+	for _, name := range []string{"a.txt", "a.svg", "a.jpg", "a.csv", "a.json"} {
+		c.Do(http.MethodPut, "/files/"+name, strings.NewReader(text), nil).AssertStatus(http.StatusCreated)
+	}
+	c.Do(http.MethodPut, "/files/small.txt", strings.NewReader("not so small"), nil).AssertStatus(http.StatusCreated)
+
+	gz := http.Header{"Accept-Encoding": {"gzip"}}
+	for name, want := range map[string]string{
+		"a.txt":     "gzip",
+		"a.svg":     "gzip",
+		"small.txt": "gzip",
+		"a.jpg":     "",
+		"a.csv":     "",
+		// Types outside -gzip-types aren't compressed, textual or not.
+		"a.json": "",
+	} {
+		c.Do(http.MethodGet, "/files/"+name, nil, gz).AssertStatus(http.StatusOK).AssertHeader("Content-Encoding", want)
+	}
+
+	for _, level := range []int{0, 10, -2} {
+		opts := servertest.Options()
+		opts.GzipLevel = level
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error for -gzip-level %d", level)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// mimeTypes maps lowercased file extensions, dot included, to content types.
+type mimeTypes map[string]string
+
+// builtinTypes fills in types the mime package doesn't know on every
+// platform.
+var builtinTypes = mimeTypes{
+	".avif":        "image/avif",
+	".md":          "text/markdown; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".wasm":        "application/wasm",
+	".webmanifest": "application/manifest+json",
+	".yaml":        "application/yaml",
+	".yml":         "application/yaml",
+}
+
+// loadMIMETypes reads a mapping file in the mime.types format of Apache: a
+// content type followed by its extensions on each line, with # starting
+// comments.
+func loadMIMETypes(file string) (mimeTypes, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("error opening MIME types: %v", err)
+	}
+	defer f.Close()
+
+	types := make(mimeTypes)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || !strings.Contains(fields[0], "/") {
+			return nil, fmt.Errorf("%s:%d: expected 'type ext...'", file, n)
+		}
+		for _, ext := range fields[1:] {
+			types["."+strings.ToLower(strings.TrimPrefix(ext, "."))] = fields[0]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading MIME types: %v", err)
+	}
+
+	return types, nil
+}
+
+// contentType returns the type a file is served as: the -mime-type
+// overrides come first, then the -mime-types file, then the built-in table.
+func (s *Server) contentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return contentTypeOctetStream
+	}
+
+	if t, ok := s.opts.MIMETypes[ext]; ok {
+		return t
+	}
+	if types := s.mimeTypes.Load(); types != nil {
+		if t, ok := (*types)[ext]; ok {
+			return t
+		}
+	}
+	if t, ok := builtinTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+
+	return contentTypeOctetStream
+}
+
+// mimeFlag collects repeated '.ext=type' flags.
+type mimeFlag map[string]string
+
+func (f *mimeFlag) String() string {
+	if f == nil {
+		return ""
+	}
+
+	var parts []string
+	for ext, t := range *f {
+		parts = append(parts, ext+"="+t)
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, ",")
+}
+
+func (f *mimeFlag) Set(value string) error {
+	ext, t, ok := strings.Cut(value, "=")
+	ext = strings.ToLower(strings.TrimSpace(ext))
+	t = strings.TrimSpace(t)
+	if !ok || ext == "" || !strings.Contains(t, "/") {
+		return fmt.Errorf("expected '.ext=type', got %q", value)
+	}
+
+	if *f == nil {
+		*f = make(mimeFlag)
+	}
+	(*f)["."+strings.TrimPrefix(ext, ".")] = t
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package server_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestMIMETypes(t *testing.T) {
+	types := filepath.Join(t.TempDir(), "mime.types")
+	os.WriteFile(types, []byte(`# local types
+application/x-widget	wgt widget
+text/x-special		txt
+`), 0o644)
+
+	opts := servertest.Options()
+	opts.MIMETypesFile = types
+	opts.MIMETypes = map[string]string{".widget": "application/x-override"}
+	c := servertest.NewPipe(t, opts).Client()
+
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"a.wgt", "application/x-widget"},
+		{"a.WGT", "application/x-widget"},
+		{"a.widget", "application/x-override"},
+		{"a.txt", "text/x-special"},
+		{"a.wasm", "application/wasm"},
+		{"a.png", "image/png"},
+		{"a.unknownext", "application/octet-stream"},
+		{"noext", "application/octet-stream"},
+	} {
+		c.Do(http.MethodPut, "/files/"+tt.name, strings.NewReader("x"), nil).AssertStatus(http.StatusCreated)
+		c.Get("/files/"+tt.name).AssertHeader("Content-Type", tt.want)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"maps"
+	"math/rand"
+)
+
+// maxMirrorsInFlight bounds the requests being sent to the shadow upstream
+// at once, so a slow one can't pile them up; requests beyond it aren't
+// mirrored.
+const maxMirrorsInFlight = 64
+
+// mirror sends a share of the proxied requests to a shadow upstream as
+// well, throwing its responses away, so a new version of the upstream can
+// be tried on real traffic without clients seeing what it does.
+type mirror struct {
+	shadow   *proxy
+	percent  float64
+	inFlight chan struct{}
+	log      *slog.Logger
+}
+
+func newMirror(shadow *proxy, percent float64, log *slog.Logger) *mirror {
+	return &mirror{shadow: shadow, percent: percent, inFlight: make(chan struct{}, maxMirrorsInFlight), log: log}
+}
+
+// send mirrors req with its body to the shadow upstream in the background,
+// if it falls in the share mirrored.
+func (m *mirror) send(req request, body []byte) {
+	if m == nil || rand.Float64()*100 >= m.percent {
+		return
+	}
+	select {
+	case m.inFlight <- struct{}{}:
+	default:
+		m.log.Debug("shadow upstream busy, request not mirrored", "id", req.id)
+		return
+	}
+
+	// The request goes on being served while it is mirrored, and the
+	// mirror isn't cut short by the client leaving.
+	req.headers = maps.Clone(req.headers)
+	req.ctx = nil
+	go func() {
+		defer func() { <-m.inFlight }()
+
+		resp, err := m.shadow.roundTrip(req, body, nil)
+		if err != nil {
+			m.log.Debug("error mirroring request", "id", req.id, "err", err)
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+}
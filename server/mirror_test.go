@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestProxyMirror(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer upstream.Close()
+	mirrored := make(chan string, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored <- r.Method + " " + r.URL.RequestURI() + " " + string(body)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("shadow"))
+	}))
+	defer shadow.Close()
+
+	opts := servertest.Options()
+	opts.Proxy = upstream.URL
+	opts.ProxyMirror = shadow.URL
+	c := servertest.New(t, opts).Client()
+
+	c.Get("/a?b=1").AssertStatus(http.StatusOK).AssertBody("primary")
+	c.Do("POST", "/form", strings.NewReader("x=1"), nil).AssertStatus(http.StatusOK).AssertBody("primary")
+
+	for _, want := range []string{"GET /a?b=1 ", "POST /form x=1"} {
+		select {
+		case got := <-mirrored:
+			if got != want {
+				t.Errorf("shadow got %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("shadow never got %q", want)
+		}
+	}
+}
+
+func TestProxyMirrorOptions(t *testing.T) {
+	for i, set := range []func(*server.Options){
+		func(o *server.Options) { o.ProxyMirror = "http://127.0.0.1:1" },
+		func(o *server.Options) {
+			o.Proxy, o.ProxyMirror, o.ProxyMirrorPercent = "http://127.0.0.1:1", "http://127.0.0.1:2", 0
+		},
+		func(o *server.Options) { o.Proxy, o.ProxyMirror = "http://127.0.0.1:1", "ftp://shadow" },
+	} {
+		opts := servertest.Options()
+		set(&opts)
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("case %d: got no error", i)
+		}
+	}
+}
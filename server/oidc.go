@@ -0,0 +1,420 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcSessionCookie = "naive_session"
+	oidcStateCookie   = "naive_oidc_state"
+	oidcCallbackPath  = "/oidc/callback"
+	// oidcLoginTimeout is how long a user has to log in with the provider.
+	oidcLoginTimeout = 10 * time.Minute
+	oidcTimeout      = 10 * time.Second
+)
+
+// oidc logs users in to the file manager with an OpenID Connect provider,
+// by the authorization code flow with PKCE, and keeps their sessions.
+// Session cookies are SameSite=Lax, so other sites can't make browsers use
+// them for the file operations, which are never GETs.
+type oidc struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	allow        map[string]bool
+	sessionTTL   time.Duration
+	client       *http.Client
+	log          *slog.Logger
+
+	// discoverMu is held while the provider's configuration is fetched.
+	discoverMu sync.Mutex
+	provider   *oidcProvider
+
+	mu sync.Mutex
+	// logins are the logins under way, by their state parameter.
+	logins map[string]oidcLogin
+	// sessions are the users logged in, by session cookie.
+	sessions map[string]oidcSession
+}
+
+// oidcProvider is what the provider's discovery document says about it.
+type oidcProvider struct {
+	Issuer   string `json:"issuer"`
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	JWKSURL  string `json:"jwks_uri"`
+
+	verifier *jwtVerifier
+}
+
+type oidcLogin struct {
+	nonce    string
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+type oidcSession struct {
+	user    string
+	expires time.Time
+}
+
+func newOIDC(opts Options, log *slog.Logger) (*oidc, error) {
+	if opts.OIDCClientID == "" || opts.OIDCRedirectURL == "" {
+		return nil, fmt.Errorf("-oidc-issuer requires -oidc-client-id and -oidc-redirect-url")
+	}
+	u, err := url.Parse(opts.OIDCRedirectURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.Path != oidcCallbackPath {
+		return nil, fmt.Errorf("-oidc-redirect-url must be the absolute URL of %s on this server", oidcCallbackPath)
+	}
+
+	o := &oidc{
+		issuer:       strings.TrimSuffix(opts.OIDCIssuer, "/"),
+		clientID:     opts.OIDCClientID,
+		clientSecret: opts.OIDCClientSecret,
+		redirectURL:  opts.OIDCRedirectURL,
+		sessionTTL:   opts.OIDCSessionTTL,
+		client:       &http.Client{Timeout: oidcTimeout},
+		log:          log,
+		logins:       make(map[string]oidcLogin),
+		sessions:     make(map[string]oidcSession),
+	}
+	if len(opts.OIDCAllow) > 0 {
+		o.allow = make(map[string]bool)
+		for _, user := range opts.OIDCAllow {
+			o.allow[user] = true
+		}
+	}
+
+	return o, nil
+}
+
+// discover returns the provider's configuration, fetching it the first
+// time it is needed.
+func (o *oidc) discover() (*oidcProvider, error) {
+	o.discoverMu.Lock()
+	defer o.discoverMu.Unlock()
+	if o.provider != nil {
+		return o.provider, nil
+	}
+
+	resp, err := o.client.Get(o.issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var p oidcProvider
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&p); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != o.issuer {
+		return nil, fmt.Errorf("provider claims to be issuer %q", p.Issuer)
+	}
+	if p.AuthURL == "" || p.TokenURL == "" || p.JWKSURL == "" {
+		return nil, fmt.Errorf("incomplete provider configuration")
+	}
+	p.verifier = &jwtVerifier{
+		jwks:     &jwks{url: p.JWKSURL, client: o.client, log: o.log},
+		issuer:   p.Issuer,
+		audience: o.clientID,
+	}
+	o.provider = &p
+
+	return o.provider, nil
+}
+
+// serveOIDC handles /oidc/login, which sends the user to the provider,
+// /oidc/callback, where they come back logged in, and /oidc/logout.
+func (s *Server) serveOIDC(conn net.Conn, req request) error {
+	if s.oidc == nil || len(req.pathParts) != 3 {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+
+	switch req.pathParts[2] {
+	case "login":
+		return s.oidc.login(conn, req)
+	case "callback":
+		return s.oidc.callback(conn, req)
+	case "logout":
+		s.oidc.logout(conn, req)
+		return nil
+	}
+	conn.Write(buildResponse(statusNotFound, nil))
+
+	return nil
+}
+
+func (o *oidc) login(conn net.Conn, req request) error {
+	p, err := o.discover()
+	if err != nil {
+		conn.Write(buildResponse(statusBadGateway, nil))
+		return fmt.Errorf("error discovering OIDC provider %s: %v\n", o.issuer, err)
+	}
+
+	next := req.query.Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		next = "/ui"
+	}
+	state, nonce, verifier := randomToken(), randomToken(), randomToken()
+	challenge := sha256.Sum256([]byte(verifier))
+
+	o.mu.Lock()
+	now := time.Now()
+	for k, l := range o.logins {
+		if now.After(l.expires) {
+			delete(o.logins, k)
+		}
+	}
+	o.logins[state] = oidcLogin{nonce: nonce, verifier: verifier, next: next, expires: now.Add(oidcLoginTimeout)}
+	o.mu.Unlock()
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.clientID},
+		"redirect_uri":          {o.redirectURL},
+		"scope":                 {"openid email profile"},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+
+	h := make(header)
+	h.set("Location", p.AuthURL+sep+q.Encode())
+	h.set("Cache-Control", "no-store")
+	// The state is tied to the browser that started the login, so that
+	// nobody can have someone else finish a login of theirs.
+	h.add("Set-Cookie", o.cookie(req, oidcStateCookie, state, "/oidc", oidcLoginTimeout))
+	conn.Write(buildResponseHeaders(statusFound, h, nil))
+
+	return nil
+}
+
+func (o *oidc) callback(conn net.Conn, req request) error {
+	state := req.query.Get("state")
+	o.mu.Lock()
+	l, ok := o.logins[state]
+	delete(o.logins, state)
+	o.mu.Unlock()
+	if !ok || time.Now().After(l.expires) || cookieValue(req, oidcStateCookie) != state {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+	if e := req.query.Get("error"); e != "" {
+		o.log.Info("OIDC login refused by the provider", "id", req.id, "error", e)
+		conn.Write(buildResponse(statusForbidden, nil))
+		return nil
+	}
+
+	p, err := o.discover()
+	if err != nil {
+		conn.Write(buildResponse(statusBadGateway, nil))
+		return fmt.Errorf("error discovering OIDC provider %s: %v\n", o.issuer, err)
+	}
+	idToken, err := o.exchange(p, req.query.Get("code"), l.verifier)
+	if err != nil {
+		conn.Write(buildResponse(statusBadGateway, nil))
+		return fmt.Errorf("error redeeming OIDC code: %v\n", err)
+	}
+	claims, err := p.verifier.verify(idToken, time.Now())
+	if err == nil && claims["nonce"] != l.nonce {
+		err = fmt.Errorf("nonce mismatch")
+	}
+	if err != nil {
+		conn.Write(buildResponse(statusBadGateway, nil))
+		return fmt.Errorf("error verifying OIDC ID token: %v\n", err)
+	}
+
+	user := oidcUser(claims)
+	if o.allow != nil && !o.allow[user] && !o.allow[fmt.Sprint(claims["sub"])] {
+		o.log.Info("OIDC user not allowed", "id", req.id, "user", user)
+		conn.Write(buildResponse(statusForbidden, nil))
+		return nil
+	}
+
+	id := randomToken()
+	o.mu.Lock()
+	now := time.Now()
+	for k, sess := range o.sessions {
+		if now.After(sess.expires) {
+			delete(o.sessions, k)
+		}
+	}
+	o.sessions[id] = oidcSession{user: user, expires: now.Add(o.sessionTTL)}
+	o.mu.Unlock()
+	o.log.Info("OIDC login", "id", req.id, "user", user)
+
+	h := make(header)
+	h.set("Location", l.next)
+	h.add("Set-Cookie", o.cookie(req, oidcSessionCookie, id, "/", o.sessionTTL))
+	h.add("Set-Cookie", o.cookie(req, oidcStateCookie, "", "/oidc", -1))
+	conn.Write(buildResponseHeaders(statusSeeOther, h, nil))
+
+	return nil
+}
+
+// exchange redeems an authorization code for the ID token at the token
+// endpoint.
+func (o *oidc) exchange(p *oidcProvider, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.redirectURL},
+		"code_verifier": {verifier},
+	}
+	if o.clientSecret == "" {
+		form.Set("client_id", o.clientID)
+	}
+	r, err := http.NewRequest(http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if o.clientSecret != "" {
+		r.SetBasicAuth(url.QueryEscape(o.clientID), url.QueryEscape(o.clientSecret))
+	}
+
+	resp, err := o.client.Do(r)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("status %s: %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return "", fmt.Errorf("status %s: %s", resp.Status, tokens.Error)
+	}
+
+	return tokens.IDToken, nil
+}
+
+func (o *oidc) logout(conn net.Conn, req request) {
+	if id := cookieValue(req, oidcSessionCookie); id != "" {
+		o.mu.Lock()
+		delete(o.sessions, id)
+		o.mu.Unlock()
+	}
+
+	h := make(header)
+	h.set("Location", "/")
+	h.add("Set-Cookie", o.cookie(req, oidcSessionCookie, "", "/", -1))
+	conn.Write(buildResponseHeaders(statusSeeOther, h, nil))
+}
+
+// user returns the user the session cookie of req is for, or "" if it has
+// none or it has run out.
+func (o *oidc) user(req request) string {
+	id := cookieValue(req, oidcSessionCookie)
+	if id == "" {
+		return ""
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	sess, ok := o.sessions[id]
+	if !ok || time.Now().After(sess.expires) {
+		return ""
+	}
+
+	return sess.user
+}
+
+// cookie builds a Set-Cookie value; a negative maxAge removes the cookie.
+func (o *oidc) cookie(req request, name, value, path string, maxAge time.Duration) string {
+	c := name + "=" + value + "; Path=" + path + "; HttpOnly; SameSite=Lax"
+	if maxAge < 0 {
+		c += "; Max-Age=0"
+	} else {
+		c += "; Max-Age=" + strconv.Itoa(int(maxAge.Seconds()))
+	}
+	if req.scheme == "https" || strings.HasPrefix(o.redirectURL, "https:") {
+		c += "; Secure"
+	}
+
+	return c
+}
+
+// oidcUser names the user of an ID token by their email, or their subject
+// if the provider shares no verified email.
+func oidcUser(claims map[string]any) string {
+	if email, ok := claims["email"].(string); ok && email != "" && claims["email_verified"] != false {
+		return email
+	}
+
+	return fmt.Sprint(claims["sub"])
+}
+
+// cookieValue returns the value of the cookie name of req.
+func cookieValue(req request, name string) string {
+	for _, line := range req.headers["Cookie"] {
+		for _, c := range strings.Split(line, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(c), "=")
+			if k == name {
+				return v
+			}
+		}
+	}
+
+	return ""
+}
+
+// randomToken returns 32 random bytes in hex, for unguessable states and
+// session IDs.
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// stringList is a comma-separated list of strings.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = nil
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+
+	return nil
+}
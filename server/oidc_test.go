@@ -0,0 +1,172 @@
+package server_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// fakeIdP is an OpenID Connect provider that logs in whoever it is told
+// to.
+type fakeIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	email     string
+	nonce     string
+	challenge string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{key: key, email: "ann@example.com"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		user, password, _ := r.BasicAuth()
+		if r.FormValue("code") != "the-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge ||
+			user != "naive" || password != "shh" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signJWT(t, "RS256", "k1", key, map[string]any{
+			"iss":   idp.URL,
+			"aud":   "naive",
+			"sub":   "12345",
+			"email": idp.email,
+			"nonce": idp.nonce,
+			"exp":   time.Now().Unix() + 60,
+		})})
+	})
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+// login goes through the login flow as a browser would, returning the
+// response to the callback.
+func (idp *fakeIdP) login(t *testing.T, c *servertest.Client) *servertest.Response {
+	t.Helper()
+	resp := c.Raw("GET /oidc/login?next=/ui HTTP/1.1\r\nHost: files.example\r\nConnection: close\r\n\r\n").
+		AssertStatus(http.StatusFound)
+	to, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(to.String(), idp.URL+"/authorize?") {
+		t.Fatalf("got sent to %q, want the provider", resp.Header.Get("Location"))
+	}
+	q := to.Query()
+	idp.mu.Lock()
+	idp.nonce, idp.challenge = q.Get("nonce"), q.Get("code_challenge")
+	idp.mu.Unlock()
+
+	state := q.Get("state")
+	cookie := cookieOf(resp, "naive_oidc_state")
+	if cookie != state {
+		t.Fatalf("got state cookie %q for state %q", cookie, state)
+	}
+
+	return c.Raw("GET /oidc/callback?code=the-code&state=" + state + " HTTP/1.1\r\nHost: files.example\r\n" +
+		"Cookie: naive_oidc_state=" + cookie + "\r\nConnection: close\r\n\r\n")
+}
+
+// cookieOf returns the value of the cookie name a response sets.
+func cookieOf(resp *servertest.Response, name string) string {
+	for _, c := range resp.Header.Values("Set-Cookie") {
+		if v, ok := strings.CutPrefix(c, name+"="); ok {
+			v, _, _ = strings.Cut(v, ";")
+			return v
+		}
+	}
+
+	return ""
+}
+
+func TestOIDC(t *testing.T) {
+	idp := newFakeIdP(t)
+	opts := servertest.Options()
+	opts.OIDCIssuer = idp.URL
+	opts.OIDCClientID = "naive"
+	opts.OIDCClientSecret = "shh"
+	opts.OIDCRedirectURL = "https://files.example/oidc/callback"
+	opts.OIDCAllow = []string{"ann@example.com"}
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	// People are sent to log in; scripts are told they can't.
+	c.Raw("GET /ui HTTP/1.1\r\nHost: files.example\r\nConnection: close\r\n\r\n").
+		AssertStatus(http.StatusFound).
+		AssertHeader("Location", "/oidc/login?next=/ui")
+	c.Get("/trash").AssertStatus(http.StatusUnauthorized)
+
+	resp := idp.login(t, c).AssertStatus(http.StatusSeeOther).AssertHeader("Location", "/ui")
+	session := cookieOf(resp, "naive_session")
+	if session == "" {
+		t.Fatal("got no session cookie")
+	}
+	if sc := resp.Header.Values("Set-Cookie"); !strings.Contains(strings.Join(sc, "\n"), "HttpOnly; SameSite=Lax; Max-Age=43200; Secure") {
+		t.Errorf("got cookies %q", sc)
+	}
+	loggedIn := http.Header{"Cookie": {"naive_session=" + session}}
+	c.Do(http.MethodGet, "/ui", nil, loggedIn).AssertStatus(http.StatusOK)
+	c.Do(http.MethodGet, "/trash", nil, loggedIn).AssertStatus(http.StatusOK)
+	c.Do(http.MethodGet, "/trash", nil, http.Header{"Cookie": {"naive_session=guess"}}).AssertStatus(http.StatusUnauthorized)
+
+	// A login can't be finished unless this browser started it.
+	c.Raw("GET /oidc/callback?code=the-code&state=x HTTP/1.1\r\nHost: files.example\r\nCookie: naive_oidc_state=x\r\nConnection: close\r\n\r\n").
+		AssertStatus(http.StatusBadRequest)
+
+	// Only the users allowed get in.
+	idp.mu.Lock()
+	idp.email = "eve@example.com"
+	idp.mu.Unlock()
+	idp.login(t, c).AssertStatus(http.StatusForbidden)
+
+	c.Raw("GET /oidc/logout HTTP/1.1\r\nHost: files.example\r\nCookie: naive_session=" + session + "\r\nConnection: close\r\n\r\n").
+		AssertStatus(http.StatusSeeOther)
+	c.Do(http.MethodGet, "/trash", nil, loggedIn).AssertStatus(http.StatusUnauthorized)
+
+	opts = servertest.Options()
+	opts.OIDCIssuer = idp.URL
+	opts.OIDCClientID = "naive"
+	opts.OIDCRedirectURL = "https://files.example/login"
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for a redirect URL other than /oidc/callback")
+	}
+}
@@ -0,0 +1,474 @@
+package server
+
+import (
+	"compress/gzip"
+	"flag"
+	"net/netip"
+	"time"
+)
+
+// Options configures a Server. The zero value is not useful on its own; start
+// from DefaultOptions.
+type Options struct {
+	// Directory is the directory /files is served from by the disk storage.
+	Directory string
+	// Storage selects the /files backend: "disk" or "memory".
+	Storage string
+	// Dedup stores files by the hash of their content, so that identical
+	// ones take up space once.
+	Dedup bool
+	// File, if set, is the one file served, at /, in place of everything
+	// else.
+	File string
+	// Host is the comma-separated host:port addresses the main listeners
+	// are bound to, as parsed by parseHost.
+	Host string
+
+	// Stdio serves one connection over standard input and output instead of
+	// listening on Host, for running under inetd or per-connection systemd
+	// sockets.
+	Stdio bool
+
+	// TLSCert and TLSKey name the key pair files; setting them enables HTTPS.
+	TLSCert string
+	TLSKey  string
+	// TLSClientCA is a PEM bundle of the CAs client certificates are
+	// verified against. In proxy mode the details of verified ones are
+	// passed upstream in the ClientCertHeaders.
+	TLSClientCA       string
+	ClientCertHeaders ClientCertHeaders
+	// TLSSessionTickets lets clients resume TLS sessions with tickets. Their
+	// keys are rotated every TLSTicketRotation, or on the schedule of the
+	// crypto/tls package if it is 0.
+	TLSSessionTickets bool
+	TLSTicketRotation time.Duration
+	// TLSKeyLog is a file TLS session keys are logged to, for decrypting
+	// captured traffic while debugging. Never set it in production.
+	TLSKeyLog string
+	// HTTPRedirect is the address of a plain listener redirecting to HTTPS.
+	HTTPRedirect string
+	// ACMEWebroot is where HTTP-01 challenges are served from on the
+	// redirect listener.
+	ACMEWebroot string
+	// ACMEDomains are the names, wildcards included, the server gets a
+	// certificate for itself from the ACME CA at ACMEDirectoryURL, proving
+	// control of them with DNS-01 challenges published by the ACMEDNS
+	// provider, given ACMEDNSWait to propagate. The account and certificate
+	// are kept in ACMEDir.
+	ACMEDomains      []string
+	ACMEDir          string
+	ACMEDirectoryURL string
+	ACMEEmail        string
+	ACMEDNS          string
+	ACMEDNSWait      time.Duration
+	// AltSvc are the protocol=[host]:port alternatives advertised in the
+	// Alt-Svc header of every response, for AltSvcMaxAge.
+	AltSvc       []string
+	AltSvcMaxAge time.Duration
+
+	// WebhookURL receives a JSON event after every successful upload, in
+	// at most WebhookTries attempts, which must be at least 1.
+	WebhookURL   string
+	WebhookTries int
+
+	// HostRedirects maps alternate hostnames, lowercased and without a port,
+	// to the canonical host[:port] requests for them are redirected to.
+	HostRedirects map[string]string
+
+	// Proxy is the upstream every request is forwarded to, if set.
+	Proxy string
+	// ProxyCacheSize bounds the proxy's response cache in bytes; 0 disables it.
+	ProxyCacheSize int64
+	// ProxyTLSSessionCache is how many TLS sessions with an https upstream
+	// are kept for resuming; 0 disables resumption.
+	ProxyTLSSessionCache int
+	// ProxyMirror is a shadow upstream ProxyMirrorPercent percent of the
+	// proxied requests are sent to as well, its responses discarded.
+	ProxyMirror        string
+	ProxyMirrorPercent float64
+	// ProxyHeaders is a file of rules setting, appending to or removing
+	// headers of requests forwarded upstream, in values templated from the
+	// request.
+	ProxyHeaders string
+
+	// MaxDelay caps the delays of the test endpoints.
+	MaxDelay time.Duration
+	// TrustedProxies are the peers whose X-Forwarded-* headers are honoured.
+	TrustedProxies []netip.Prefix
+
+	// ServerHeader is sent as the Server header; empty hides it.
+	ServerHeader string
+	// Charset is named in text/plain and text/html content types that
+	// don't name one; empty leaves it out.
+	Charset string
+	// Headers are added to every response.
+	Headers map[string][]string
+
+	// ResponseHeaders is a file of rules setting, adding or removing
+	// response headers by path, status and content type.
+	ResponseHeaders string
+	// EarlyHints is a file of path patterns and the Link headers sent for
+	// them in a 103 Early Hints response.
+	EarlyHints string
+
+	// CORS lists the origins allowed to make cross-origin requests, per
+	// path prefix. The policy with the longest matching prefix applies.
+	CORS []CORSPolicy
+
+	// Middleware attaches auth or gzip to routes or groups of them.
+	Middleware []RouteMiddleware
+
+	// GzipLevel is the compression level of the gzip middleware, from 1
+	// (fastest) to 9 (smallest), or -1 for the default. Bodies smaller than
+	// GzipMinSize aren't compressed, nor are those of GzipExcludeTypes.
+	// GzipTypes, if set, replaces the textual types compressed by default.
+	GzipLevel        int
+	GzipMinSize      int
+	GzipTypes        []string
+	GzipExcludeTypes []string
+
+	// RewriteRules is a file of rules rewriting request paths internally
+	// before they are served.
+	RewriteRules string
+
+	// MIMETypesFile is a mime.types file of content types by extension, and
+	// MIMETypes overrides it; both take precedence over the built-in table.
+	MIMETypesFile string
+	MIMETypes     map[string]string
+
+	// ErrorPages is the directory holding error page templates, rendered
+	// with .Status, .StatusText, .RequestID and .Time. They take the place
+	// of the built-in 403, 404 and 500 pages.
+	ErrorPages string
+	// ErrorFormat is html, or json to answer errors with a JSON object of
+	// their status, message and request ID instead, unless the client asks
+	// for HTML.
+	ErrorFormat string
+	// ListingTheme names the built-in directory listing theme, unless
+	// ListingTemplate points at a template file.
+	ListingTheme    string
+	ListingTemplate string
+
+	// Dev reloads HTML pages open in a browser whenever the served files
+	// change.
+	Dev bool
+
+	// Record is the file every exchange is recorded to, for replaying with
+	// a Replayer; empty disables recording.
+	Record string
+
+	// Chaos injects faults into responses for testing clients against.
+	// ChaosSeed seeds the choice of faults; 0 picks a seed at random.
+	Chaos     ChaosConfig
+	ChaosSeed int64
+
+	// Catalog is the file uploads are recorded in, for querying through the
+	// admin API; empty disables it. CatalogMaxRecords is how many of the
+	// newest uploads it keeps; 0 keeps them all.
+	Catalog           string
+	CatalogMaxRecords int
+
+	// Versions is how many prior versions of an overwritten file are kept
+	// under .versions; 0 turns versioning off.
+	Versions int
+
+	// TrashRetention is how long deleted files stay restorable under .trash;
+	// 0 deletes them right away.
+	TrashRetention time.Duration
+
+	// ThumbCacheSize bounds the memory kept by generated ?thumb= images in
+	// bytes; 0 disables caching them.
+	ThumbCacheSize int64
+
+	// FileCacheSize bounds the memory kept by the content of files served
+	// in bytes, and FileCacheMaxFile the size of the files kept; a
+	// FileCacheSize of 0 disables caching them.
+	FileCacheSize    int64
+	FileCacheMaxFile int64
+
+	// CacheMaxAge is how long clients and caches may reuse the files served,
+	// sent as Cache-Control: max-age; 0 leaves the header out. Expires also
+	// sends an Expires header that far ahead, for HTTP/1.0 caches.
+	CacheMaxAge time.Duration
+	Expires     bool
+
+	// UploadTypes, if set, are the media types, or type/* wildcards, files
+	// may be uploaded as, going by the type their names are served with.
+	// UploadSniff also rejects uploads whose content looks like another kind
+	// of file than their names say, HTML under any other name in
+	// particular.
+	UploadTypes []string
+	UploadSniff bool
+
+	// MaxInflatedSize caps the decompressed size of a gzip-encoded upload in
+	// bytes; 0 is unlimited.
+	MaxInflatedSize int64
+
+	// UIAuth is the user:password guarding the /ui file manager and the file
+	// operations it uses; neither is served unless it is set.
+	UIAuth string
+	// Htpasswd is an Apache htpasswd file of further users, with bcrypt or
+	// MD5 hashes, checked for changes every HtpasswdReload; 0 only reloads
+	// it with the rest of the configuration.
+	Htpasswd       string
+	HtpasswdReload time.Duration
+	// OIDCIssuer is an OpenID Connect provider people log in to the file
+	// manager with, as the client OIDCClientID with OIDCClientSecret. The
+	// provider sends them back to OIDCRedirectURL, the URL of
+	// /oidc/callback on this server. OIDCAllow, if set, are the emails or
+	// subjects of the users let in; sessions last OIDCSessionTTL.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCAllow        []string
+	OIDCSessionTTL   time.Duration
+
+	// AuditLog is the file every operation changing the files served or the
+	// configuration is recorded in, with who did it and how it went.
+	AuditLog string
+
+	// APIKeys is a file of API keys for the apikey middleware, each with
+	// the scopes it carries and the paths it is limited to.
+	APIKeys string
+
+	// JWTSecret, the PEM file JWTPublicKey and the JSON Web Key Set at the
+	// URL JWTJWKS are what the jwt middleware verifies HS256, RS256 and
+	// ES256 Bearer tokens with. Tokens must be issued by JWTIssuer and for
+	// JWTAudience where those are set. In proxy mode the claims of verified
+	// tokens are passed upstream in JWTClaimsHeader.
+	JWTSecret       string
+	JWTPublicKey    string
+	JWTJWKS         string
+	JWTIssuer       string
+	JWTAudience     string
+	JWTClaimsHeader string
+
+	// SignedURLSecret is the secret the URLs let through by the signed
+	// middleware are signed with, as SignURL and the sign command do.
+	SignedURLSecret string
+
+	// Admin is the loopback address or unix:/path socket of the admin API.
+	// Requests to it with an Origin header, or a Host that isn't loopback,
+	// are refused, as only browsers send those.
+	Admin string
+	// DrainGrace is how long the server goes on taking connections once the
+	// admin API's POST /drain has it fail /readyz, before shutting down; 0
+	// leaves it draining until shut down otherwise.
+	DrainGrace time.Duration
+
+	// MaxRate and MaxRatePerConn limit file transfers, in bytes per second,
+	// across the server and per connection. 0 means unlimited.
+	MaxRate        int64
+	MaxRatePerConn int64
+
+	// RequestLimit is how many requests a client may make per
+	// RequestLimitWindow before being answered with 429; 0 is unlimited.
+	RequestLimit       int
+	RequestLimitWindow time.Duration
+
+	// MaxConnsPerIP is how many connections one source IP may have open at
+	// once; further ones are closed right away. 0 is unlimited.
+	MaxConnsPerIP int
+
+	// Ban bans source IPs that run up too many offences of a kind within
+	// BanWindow, closing their connections on accept. The first ban lasts
+	// BanTime and each one after twice as long as the last, up to BanMax.
+	// The admin API lists and lifts bans at /bans.
+	Ban       BanConfig
+	BanWindow time.Duration
+	BanTime   time.Duration
+	BanMax    time.Duration
+
+	// GeoIPDB are MaxMind DB files, a country and an ASN database say, that
+	// source addresses are looked up in for GeoPolicy and the %country and
+	// %asn access log variables.
+	GeoIPDB []string
+	// GeoPolicy lets in or keeps out requests by country or ASN, per path
+	// prefix. The policy with the longest matching prefix applies.
+	GeoPolicy []GeoPolicy
+
+	// MaxConcurrent is how many requests are served at once, long-running
+	// ones like ?follow included; 0 is unlimited. Requests beyond it wait
+	// in a queue of QueueDepth for up to QueueTimeout, and are answered
+	// with 503 if the queue is full or the wait runs out.
+	MaxConcurrent int
+	QueueDepth    int
+	QueueTimeout  time.Duration
+
+	// HandlerTimeout is how long a request may take to start being answered
+	// before it is given up on with 503, or 504 when proxying, and
+	// RouteTimeouts set it for routes; 0 is unlimited. Unlike the socket
+	// deadlines it bounds the server's own work, say on a slow disk or
+	// upstream.
+	HandlerTimeout time.Duration
+	RouteTimeouts  []RouteTimeout
+
+	// ShutdownTimeout is how long shutdown waits for connections to finish
+	// before closing them; 0 waits for as long as they take.
+	ShutdownTimeout time.Duration
+
+	// KeepAliveMaxRequests is how many requests a connection may carry; 0
+	// means no limit and 1 disables keep-alive. KeepAliveTimeout is how long
+	// an idle connection is kept open waiting for the next request.
+	KeepAliveMaxRequests int
+	KeepAliveTimeout     time.Duration
+
+	// StrictHTTP rejects requests that bend the HTTP/1.1 syntax with 400
+	// rather than making the best of them.
+	StrictHTTP bool
+
+	// AccessLog is the file, or - for standard output, a line is logged to
+	// for every request, laid out by AccessLogFormat.
+	AccessLog       string
+	AccessLogFormat string
+	// AccessLogSample logs only one in so many successful requests quicker
+	// than AccessLogSlow; errors and slow requests are always logged.
+	AccessLogSample int
+	AccessLogSlow   time.Duration
+
+	// LogPretty logs coloured, human-readable lines instead of key=value
+	// ones, and Quiet logs only warnings and errors.
+	LogPretty bool
+	Quiet     bool
+
+	// DebugDump logs every exchange with up to DebugDumpBody body bytes,
+	// leaving credentials out of the heads.
+	DebugDump     bool
+	DebugDumpBody int
+}
+
+// RegisterFlags defines a command-line flag for every option on fs.
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Directory, "directory", "./", "the directory to serve files from")
+	fs.StringVar(&o.Storage, "storage", storageDisk, "the storage backend for /files: disk or memory")
+	fs.BoolVar(&o.Dedup, "dedup", false, "store /files by content hash, so identical files take up space once; the hash is sent as X-Content-Hash")
+	fs.StringVar(&o.File, "file", "", "a single file to serve at / instead of the directory, e.g. to share one build artifact")
+	fs.StringVar(&o.Host, "host", "0.0.0.0:4221", "comma-separated host:port addresses to run on, IPv6 ones in brackets; tcp4/ or tcp6/ before one binds only that family and @iface:port the addresses of an interface, e.g. tcp6/[::]:4221,@eth0:8080")
+	fs.BoolVar(&o.Stdio, "stdio", false, "serve a single connection over standard input and output, as under inetd, and log to standard error")
+	fs.StringVar(&o.TLSCert, "tls-cert", "", "the TLS certificate file; enables HTTPS together with -tls-key")
+	fs.StringVar(&o.TLSKey, "tls-key", "", "the TLS private key file")
+	fs.StringVar(&o.TLSClientCA, "tls-client-ca", "", "a PEM file of CAs to verify client certificates against; enables mutual TLS")
+	fs.StringVar(&o.ClientCertHeaders.Subject, "client-cert-subject-header", "X-Client-Cert-Subject", "the header passing the subject of a verified client certificate upstream in proxy mode; empty leaves it out")
+	fs.StringVar(&o.ClientCertHeaders.SAN, "client-cert-san-header", "X-Client-Cert-San", "the header passing the subject alternative names of a verified client certificate upstream in proxy mode; empty leaves it out")
+	fs.StringVar(&o.ClientCertHeaders.Fingerprint, "client-cert-fingerprint-header", "X-Client-Cert-Fingerprint", "the header passing the SHA-256 fingerprint of a verified client certificate upstream in proxy mode; empty leaves it out")
+	fs.BoolVar(&o.TLSSessionTickets, "tls-session-tickets", true, "let clients resume TLS sessions with session tickets, saving full handshakes")
+	fs.DurationVar(&o.TLSTicketRotation, "tls-ticket-rotation", 0, "how often the session ticket key is replaced, a ticket lasting two rotations at most; 0 rotates daily and accepts tickets for a week")
+	fs.StringVar(&o.TLSKeyLog, "tls-key-log", "", "the file to log TLS session keys to for decrypting captures, e.g. in Wireshark; for debugging only")
+	fs.StringVar(&o.HTTPRedirect, "http-redirect", "", "the host and port of a plain HTTP listener redirecting to HTTPS (requires TLS)")
+	fs.StringVar(&o.ACMEWebroot, "acme-webroot", "", "the directory to serve ACME HTTP-01 challenges from on the redirect listener")
+	fs.Var((*stringList)(&o.ACMEDomains), "acme-domains", "comma-separated names, e.g. example.com,*.example.com, to get a certificate for over ACME with DNS-01 challenges; enables HTTPS")
+	fs.StringVar(&o.ACMEDir, "acme-dir", "", "the directory the ACME account key and certificate are kept in")
+	fs.StringVar(&o.ACMEDirectoryURL, "acme-directory-url", "https://acme-v02.api.letsencrypt.org/directory", "the directory URL of the ACME CA")
+	fs.StringVar(&o.ACMEEmail, "acme-email", "", "the contact email of the ACME account")
+	fs.StringVar(&o.ACMEDNS, "acme-dns", "", "the 'provider:config' publishing DNS-01 challenges, e.g. exec:/path/to/script, run as 'script present|cleanup fqdn value'")
+	fs.DurationVar(&o.ACMEDNSWait, "acme-dns-wait", 30*time.Second, "how long published DNS-01 records are given to propagate before the CA is asked to check them")
+	fs.Var((*stringList)(&o.AltSvc), "alt-svc", "comma-separated protocol=[host]:port alternatives to advertise in Alt-Svc, e.g. h3=:443 for HTTP/3 served by a proxy in front")
+	fs.DurationVar(&o.AltSvcMaxAge, "alt-svc-max-age", 24*time.Hour, "how long clients may keep using the -alt-svc alternatives")
+	fs.StringVar(&o.WebhookURL, "webhook-url", "", "the URL to POST a JSON event to after each successful upload")
+	fs.IntVar(&o.WebhookTries, "webhook-tries", 5, "the number of attempts made to deliver an upload event")
+	fs.Var((*hostRedirectFlag)(&o.HostRedirects), "host-redirect", "an 'alias=host[:port]' pair permanently redirecting requests for the alias hostname to the canonical host, path and query preserved; may be repeated")
+	fs.StringVar(&o.Proxy, "proxy", "", "the upstream URL to reverse proxy every request to")
+	fs.Int64Var(&o.ProxyCacheSize, "proxy-cache-size", 64<<20, "the number of bytes of upstream responses to cache in proxy mode; 0 disables caching")
+	fs.IntVar(&o.ProxyTLSSessionCache, "proxy-tls-session-cache", 64, "the number of TLS sessions with an https upstream kept for resuming; 0 disables resumption")
+	fs.StringVar(&o.ProxyMirror, "proxy-mirror", "", "a shadow upstream URL to send a copy of proxied requests to, discarding its responses, e.g. to try a new version of the upstream")
+	fs.Float64Var(&o.ProxyMirrorPercent, "proxy-mirror-percent", 100, "the percentage of proxied requests mirrored to -proxy-mirror")
+	fs.StringVar(&o.ProxyHeaders, "proxy-headers", "", "a file of 'set|append Name value' and 'remove Name' rules for the headers sent upstream, values expanding {client_ip}, {remote_addr}, {scheme}, {host}, {method}, {path}, {query}, {id}, {country}, {asn} and {header:Name}; reloaded on SIGHUP")
+	fs.DurationVar(&o.MaxDelay, "max-delay", 10*time.Second, "the longest delay the test endpoints will honour")
+	fs.Var((*prefixList)(&o.TrustedProxies), "trusted-proxies", "comma-separated CIDR ranges of proxies whose X-Forwarded-* headers are honoured")
+	fs.StringVar(&o.ServerHeader, "server-header", serverName+" v"+serverVersion, "the Server header sent on responses; empty hides it")
+	fs.StringVar(&o.Charset, "charset", "utf-8", "the charset added to text/plain and text/html content types without one; empty leaves it out")
+	fs.Var(headerFlag{(*header)(&o.Headers)}, "header", "a 'Name: value' header to add to every response; may be repeated")
+	fs.Var((*middlewareFlag)(&o.Middleware), "middleware", "a 'route=middleware,...' attachment, route being 'METHOD /path', '/path', '@writes' or '@text' and middleware auth (-ui-auth or -htpasswd credentials), jwt (-jwt-* Bearer tokens), apikey (-api-keys X-Api-Key headers), signed (URLs signed with -signed-url-secret) or gzip; may be repeated")
+	fs.StringVar(&o.EarlyHints, "early-hints", "", "a file of 'path link' rules sending GET requests for the path a 103 Early Hints response with the Link header, e.g. '/ui </static/app.css>; rel=preload; as=style'; reloaded on SIGHUP")
+	fs.StringVar(&o.ResponseHeaders, "response-headers", "", "a file of 'path status type set|add|remove Name [value]' rules for response headers, e.g. '/assets/* 2xx * set Cache-Control max-age=86400' or '* * text/html set X-Frame-Options DENY'; reloaded on SIGHUP")
+	fs.IntVar(&o.GzipLevel, "gzip-level", gzip.DefaultCompression, "the gzip middleware compression level, from 1 (fastest) to 9 (smallest), or -1 for the default")
+	fs.IntVar(&o.GzipMinSize, "gzip-min-size", minGzipSize, "the smallest response body in bytes the gzip middleware compresses")
+	fs.Var((*typeList)(&o.GzipTypes), "gzip-types", "comma-separated media types, or type/* wildcards, the gzip middleware compresses instead of text, JSON, JavaScript and XML")
+	fs.Var((*typeList)(&o.GzipExcludeTypes), "gzip-exclude-types", "comma-separated media types, or type/* wildcards, the gzip middleware never compresses, e.g. image/jpeg,application/zip")
+	fs.Var((*corsFlag)(&o.CORS), "cors", "the origins allowed cross-origin access as '[/prefix=]origin,...', '*' for any; may be repeated per path prefix")
+	fs.StringVar(&o.RewriteRules, "rewrite-rules", "", "a file of 'pattern target [last|continue]' lines rewriting request paths before they are served; reloaded on SIGHUP")
+	fs.StringVar(&o.MIMETypesFile, "mime-types", "", "a mime.types file mapping extensions to the content types files are served with; reloaded on SIGHUP")
+	fs.Var((*mimeFlag)(&o.MIMETypes), "mime-type", "a '.ext=type' content type for files with the extension, overriding -mime-types; may be repeated")
+	fs.StringVar(&o.ErrorPages, "error-pages", "", "the directory holding error page templates such as 404.html or 50x.html")
+	fs.StringVar(&o.ErrorFormat, "error-format", errorFormatHTML, "how error responses are rendered: html, or json for API clients")
+	fs.StringVar(&o.ListingTheme, "listing-theme", listingThemeTable, "the built-in directory listing theme: minimal or table")
+	fs.StringVar(&o.ListingTemplate, "listing-template", "", "an html/template file to render directory listings with instead of the theme")
+	fs.BoolVar(&o.Dev, "dev", false, "live reload HTML pages when the served files change, for front-end development")
+	fs.StringVar(&o.Record, "record", "", "the file to record every request and response to, for the replay subcommand")
+	fs.Var((*chaosFlag)(&o.Chaos), "chaos", "the faults to inject with their probabilities, e.g. latency=0.2:2s,error=0.05,truncate=0.05,drop=0.01")
+	fs.Int64Var(&o.ChaosSeed, "chaos-seed", 0, "the seed for choosing chaos faults, to repeat a run; 0 picks one at random")
+	fs.StringVar(&o.Catalog, "catalog", "", "the file to record uploads in, queryable at /catalog on the admin API")
+	fs.IntVar(&o.CatalogMaxRecords, "catalog-max-records", 100000, "the number of the newest uploads -catalog keeps, older ones being compacted away; 0 keeps them all")
+	fs.IntVar(&o.Versions, "versions", 0, "the number of prior versions of overwritten files to keep; 0 disables versioning")
+	fs.DurationVar(&o.TrashRetention, "trash-retention", 7*24*time.Hour, "how long deleted files can be restored from the trash; 0 deletes them right away")
+	fs.Int64Var(&o.ThumbCacheSize, "thumb-cache-size", 16<<20, "the number of bytes of image thumbnails to cache; 0 disables caching")
+	fs.Int64Var(&o.FileCacheSize, "file-cache-size", 0, "the number of bytes of small files to keep in memory, so the ones served most don't touch the disk; 0 disables caching")
+	fs.Int64Var(&o.FileCacheMaxFile, "file-cache-max-file", 64<<10, "the size in bytes of the largest file kept by -file-cache-size")
+	fs.DurationVar(&o.CacheMaxAge, "cache-max-age", 0, "how long clients may cache the files served, sent as Cache-Control: max-age; 0 sends none")
+	fs.BoolVar(&o.Expires, "expires", false, "also send an Expires header computed from -cache-max-age, for HTTP/1.0 caches")
+	fs.Var((*typeList)(&o.UploadTypes), "upload-types", "comma-separated media types, or type/* wildcards, uploads may be stored as going by their names; others get 415")
+	fs.BoolVar(&o.UploadSniff, "upload-sniff", false, "reject uploads whose content looks like another kind of file than their name says, such as HTML named .png, with 415")
+	fs.Int64Var(&o.MaxInflatedSize, "max-inflated-size", 1<<30, "the most bytes a gzip-encoded upload may decompress to; 0 is unlimited")
+	fs.StringVar(&o.UIAuth, "ui-auth", "", "the user:password for the /ui file manager, which also enables deleting and renaming files")
+	fs.StringVar(&o.Htpasswd, "htpasswd", "", "an Apache htpasswd file of users for the file manager, hashed with bcrypt (htpasswd -B) or MD5 (htpasswd -m); enables it like -ui-auth")
+	fs.DurationVar(&o.HtpasswdReload, "htpasswd-reload", 30*time.Second, "how often the -htpasswd file is checked for changes; 0 only reloads it on SIGHUP")
+	fs.StringVar(&o.OIDCIssuer, "oidc-issuer", "", "the URL of an OpenID Connect provider to log in to the file manager with, e.g. https://accounts.google.com")
+	fs.StringVar(&o.OIDCClientID, "oidc-client-id", "", "the client ID registered with the -oidc-issuer provider")
+	fs.StringVar(&o.OIDCClientSecret, "oidc-client-secret", "", "the client secret registered with the -oidc-issuer provider; empty for a public client")
+	fs.StringVar(&o.OIDCRedirectURL, "oidc-redirect-url", "", "the URL of /oidc/callback on this server, as registered with the provider, e.g. https://files.example.com/oidc/callback")
+	fs.Var((*stringList)(&o.OIDCAllow), "oidc-allow", "comma-separated emails or subjects of the users let in with OIDC; empty lets in everyone the provider does")
+	fs.DurationVar(&o.OIDCSessionTTL, "oidc-session-ttl", 12*time.Hour, "how long an OIDC login lasts")
+	fs.StringVar(&o.AuditLog, "audit-log", "", "the file to append a JSON line to for every upload, delete, move, restore, reload and admin action")
+	fs.StringVar(&o.APIKeys, "api-keys", "", "a file of 'name sha256:digest scopes [/path ...]' API keys for the apikey middleware, scopes being read, write, delete and admin; reloaded on SIGHUP")
+	fs.StringVar(&o.JWTSecret, "jwt-secret", "", "the secret HS256 tokens are signed with, for the jwt middleware")
+	fs.StringVar(&o.JWTPublicKey, "jwt-public-key", "", "a PEM file of the RSA or P-256 public key RS256 and ES256 tokens are signed with, for the jwt middleware")
+	fs.StringVar(&o.JWTJWKS, "jwt-jwks", "", "the URL of the JSON Web Key Set of an identity provider, whose RS256 and ES256 tokens the jwt middleware accepts")
+	fs.StringVar(&o.JWTIssuer, "jwt-issuer", "", "the iss claim tokens must have; empty accepts any issuer")
+	fs.StringVar(&o.JWTAudience, "jwt-audience", "", "the aud claim tokens must include; empty accepts any audience")
+	fs.StringVar(&o.JWTClaimsHeader, "jwt-claims-header", "X-Jwt-Claims", "the header passing the claims of a verified token upstream in proxy mode, as base64url JSON; empty leaves it out")
+	fs.StringVar(&o.SignedURLSecret, "signed-url-secret", "", "the secret URLs are signed with, for the signed middleware; see the sign command")
+	fs.StringVar(&o.Admin, "admin", "", "the localhost host and port, or unix:/path socket, to serve the admin API on")
+	fs.DurationVar(&o.DrainGrace, "drain-grace", 30*time.Second, "how long the server keeps taking connections after a POST /drain on the admin API before shutting down; 0 drains until shut down otherwise")
+	fs.Var((*byteRate)(&o.MaxRate), "max-rate", "the combined transfer rate for file downloads and uploads, e.g. 10MB/s; 0 is unlimited")
+	fs.Var((*byteRate)(&o.MaxRatePerConn), "max-rate-per-conn", "the transfer rate for file downloads and uploads per connection, e.g. 1MB/s; 0 is unlimited")
+	fs.IntVar(&o.RequestLimit, "request-limit", 0, "the number of requests a client may make per -request-limit-window before getting 429; 0 is unlimited")
+	fs.DurationVar(&o.RequestLimitWindow, "request-limit-window", time.Minute, "the window -request-limit counts requests over")
+	fs.IntVar(&o.MaxConnsPerIP, "max-conns-per-ip", 0, "the number of connections one source IP may have open at once before more are closed on connect; 0 is unlimited")
+	fs.Var((*banFlag)(&o.Ban), "ban", "the offences a source IP may commit per -ban-window before it is banned, e.g. 4xx=100,auth=10,malformed=5")
+	fs.DurationVar(&o.BanWindow, "ban-window", time.Minute, "the window -ban counts offences over")
+	fs.DurationVar(&o.BanTime, "ban-time", time.Minute, "how long a first ban lasts; each further ban of the same IP lasts twice as long")
+	fs.DurationVar(&o.BanMax, "ban-max", 24*time.Hour, "the longest a ban lasts")
+	fs.Var((*stringList)(&o.GeoIPDB), "geoip-db", "comma-separated MaxMind DB files to look client addresses up in, e.g. GeoLite2-Country.mmdb,GeoLite2-ASN.mmdb; reloaded on SIGHUP")
+	fs.Var((*geoPolicyFlag)(&o.GeoPolicy), "geo-policy", "a '[/prefix=]allow:code,...' or '[/prefix=]deny:code,...' rule letting in or keeping out clients by country (US) or ASN (AS64496), answering others with 403; may be repeated per path prefix")
+	fs.IntVar(&o.MaxConcurrent, "max-concurrent", 0, "the number of requests served at once before others are queued; 0 is unlimited")
+	fs.IntVar(&o.QueueDepth, "queue-depth", 100, "the number of requests that may wait for a turn under -max-concurrent before more are shed with 503")
+	fs.DurationVar(&o.QueueTimeout, "queue-timeout", 5*time.Second, "how long a queued request waits for a turn under -max-concurrent before being shed with 503")
+	fs.DurationVar(&o.HandlerTimeout, "handler-timeout", 0, "how long a request may take to start being answered before it is given up on with 503, or 504 when proxying; 0 is unlimited")
+	fs.Var((*routeTimeoutFlag)(&o.RouteTimeouts), "route-timeout", "a 'route=duration' handler timeout, route being as for -middleware, overriding -handler-timeout; 0 turns it off, as for long-lived streams; may be repeated")
+	fs.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "how long shutdown waits for active connections before closing them; 0 waits indefinitely")
+	fs.IntVar(&o.KeepAliveMaxRequests, "keepalive-max-requests", 100, "the number of requests served on one connection before it is closed; 0 is unlimited, 1 disables keep-alive")
+	fs.DurationVar(&o.KeepAliveTimeout, "keepalive-timeout", 15*time.Second, "how long an idle keep-alive connection waits for the next request")
+	fs.BoolVar(&o.StrictHTTP, "strict-http", false, "reject requests with bare LF line endings, malformed header lines and other syntax errors with 400")
+	fs.StringVar(&o.AccessLog, "access-log", "", "the file to log every request to, or - for standard output")
+	fs.StringVar(&o.AccessLogFormat, "access-log-format", defaultLogFormat, "the access log line, with variables %remote, %method, %path, %target, %query, %proto, %host, %id (the request ID), %status, %bytes, %duration (ms), %time, %country, %asn and %{Header}i")
+	fs.IntVar(&o.AccessLogSample, "access-log-sample", 1, "log one in this many successful requests, with errors and those slower than -access-log-slow always logged")
+	fs.DurationVar(&o.AccessLogSlow, "access-log-slow", time.Second, "how long a request takes to always be logged under -access-log-sample; 0 exempts none")
+	fs.BoolVar(&o.LogPretty, "log-pretty", false, "log coloured, human-readable lines for reading in a terminal; NO_COLOR turns the colours off")
+	fs.BoolVar(&o.Quiet, "quiet", false, "log only warnings and errors")
+	fs.BoolVar(&o.DebugDump, "debug-dump", false, "log the raw request and response of every exchange")
+	fs.IntVar(&o.DebugDumpBody, "debug-dump-body", 256, "the number of body bytes shown per message by -debug-dump")
+}
+
+// DefaultOptions returns the options a server runs with when no flags are
+// given.
+func DefaultOptions() Options {
+	var o Options
+	o.RegisterFlags(flag.NewFlagSet("defaults", flag.ContinueOnError))
+
+	return o
+}
+
+func (o Options) tlsEnabled() bool {
+	return o.TLSCert != "" || o.TLSKey != "" || len(o.ACMEDomains) > 0
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// progressLinger is how long the final progress of an upload is kept
+	// for clients that only start watching once it is over.
+	progressLinger = 30 * time.Second
+	// maxProgressID bounds the upload IDs clients may pick.
+	maxProgressID = 64
+)
+
+// uploadProgress tracks the bytes received by uploads made with a
+// ?progress=ID of the client's choosing, for GET /upload/progress/ID to
+// stream to a UI. IDs should be random: anyone who knows one can watch.
+type uploadProgress struct {
+	mu      sync.Mutex
+	uploads map[string]*progressEntry
+}
+
+// progressEntry is the state of one upload and of the clients watching it.
+type progressEntry struct {
+	received int64
+	// total is the Content-Length of the upload, or -1 if it isn't known.
+	total    int64
+	started  bool
+	done     bool
+	watchers map[chan struct{}]struct{}
+}
+
+// progressEvent is the data of the events streamed to watchers.
+type progressEvent struct {
+	Received int64 `json:"received"`
+	Total    int64 `json:"total"`
+}
+
+func newUploadProgress() *uploadProgress {
+	return &uploadProgress{uploads: make(map[string]*progressEntry)}
+}
+
+// validProgressID reports whether id is usable as an upload ID: up to
+// maxProgressID letters, digits, dashes and underscores.
+func validProgressID(id string) bool {
+	if id == "" || len(id) > maxProgressID {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// entry returns the entry for id, creating it if need be. p.mu must be
+// held.
+func (p *uploadProgress) entry(id string) *progressEntry {
+	e := p.uploads[id]
+	if e == nil {
+		e = &progressEntry{total: -1, watchers: make(map[chan struct{}]struct{})}
+		p.uploads[id] = e
+	}
+
+	return e
+}
+
+// notify wakes the watchers of e. p.mu must be held.
+func (e *progressEntry) notify() {
+	for ch := range e.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// track counts the bytes read from body as the upload id of size bytes, -1
+// if unknown. It returns the body to read instead, and a function to call
+// once the upload is over. An upload under an ID already in use isn't
+// tracked.
+func (p *uploadProgress) track(id string, body io.Reader, size int64) (io.Reader, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := p.entry(id)
+	if e.started {
+		return body, func() {}
+	}
+	e.started = true
+	e.total = size
+	e.notify()
+
+	r := &progressReader{r: body, p: p, e: e}
+	done := func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		e.done = true
+		e.notify()
+		time.AfterFunc(progressLinger, func() { p.forget(id, e) })
+	}
+
+	return r, done
+}
+
+// forget drops the entry of a finished upload.
+func (p *uploadProgress) forget(id string, e *progressEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.uploads[id] == e {
+		delete(p.uploads, id)
+	}
+}
+
+// watch subscribes to the progress of upload id, which need not have
+// started yet. The returned function reads its current state.
+func (p *uploadProgress) watch(id string) (chan struct{}, func() (progressEvent, bool)) {
+	ch := make(chan struct{}, 1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := p.entry(id)
+	e.watchers[ch] = struct{}{}
+	if e.started {
+		ch <- struct{}{}
+	}
+	state := func() (progressEvent, bool) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		return progressEvent{Received: e.received, Total: e.total}, e.done
+	}
+
+	return ch, state
+}
+
+// unwatch ends a subscription. An entry left with no upload and no
+// watchers is dropped.
+func (p *uploadProgress) unwatch(id string, ch chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	e := p.uploads[id]
+	if e == nil {
+		return
+	}
+	delete(e.watchers, ch)
+	if !e.started && len(e.watchers) == 0 {
+		delete(p.uploads, id)
+	}
+}
+
+// progressReader counts the bytes read through it into an entry.
+type progressReader struct {
+	r io.Reader
+	p *uploadProgress
+	e *progressEntry
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.p.mu.Lock()
+		r.e.received += int64(n)
+		r.e.notify()
+		r.p.mu.Unlock()
+	}
+
+	return n, err
+}
+
+// serveUploadProgress answers GET /upload/progress/ID with a server-sent
+// event stream of progress events as the upload is received, and a done
+// event once it is over.
+func (s *Server) serveUploadProgress(conn net.Conn, req request, id string) error {
+	if !validProgressID(id) {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+
+	ctx := req.context()
+	ch, state := s.progress.watch(id)
+	defer s.progress.unwatch(id, ch)
+
+	h := make(header)
+	h.set("Content-Type", "text/event-stream")
+	h.set("Cache-Control", "no-cache")
+	h.set("Transfer-Encoding", "chunked")
+	conn.Write(buildResponseHeaders(statusOK, h, nil))
+
+	w := &chunkedWriter{w: conn}
+	heartbeat := time.NewTicker(devHeartbeat)
+	defer heartbeat.Stop()
+
+	var last progressEvent
+	for {
+		var err error
+		select {
+		case <-ch:
+			ev, done := state()
+			data, _ := json.Marshal(ev)
+			if done {
+				w.Write([]byte("event: done\ndata: " + string(data) + "\n\n"))
+				w.Close()
+				return nil
+			}
+			if ev != last {
+				last = ev
+				_, err = w.Write([]byte("event: progress\ndata: " + string(data) + "\n\n"))
+			}
+		case <-heartbeat.C:
+			_, err = w.Write([]byte(": heartbeat\n\n"))
+		case <-ctx.Done():
+			return nil
+		case <-s.closed:
+			w.Close()
+			return nil
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
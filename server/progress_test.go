@@ -0,0 +1,36 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestUploadProgress(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	events := make(chan *servertest.Response)
+	go func() { events <- c.Get("/upload/progress/up-1") }()
+	time.Sleep(50 * time.Millisecond)
+
+	body := strings.Repeat("x", 100000)
+	c.Do(http.MethodPut, "/files/big.txt?progress=up-1", strings.NewReader(body), nil).AssertStatus(http.StatusCreated)
+
+	(<-events).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "text/event-stream").
+		AssertBodyContains("event: progress\ndata: {\"received\":").
+		AssertBodyContains("event: done\ndata: {\"received\":100000,\"total\":100000}\n\n")
+
+	// Watching a finished upload gets its final state straight away.
+	c.Get("/upload/progress/up-1").AssertBodyContains("event: done\ndata: {\"received\":100000,\"total\":100000}")
+	c.RawHead("GET /upload/progress/up-1 HTTP/1.1\r\nHost: x\r\n\r\n").
+		AssertHeader("Transfer-Encoding", "chunked").
+		AssertHeader("Content-Length", "")
+
+	c.Get("/upload/progress/not%20valid").AssertStatus(http.StatusBadRequest)
+	c.Do(http.MethodPut, "/files/a.txt?progress=../x", strings.NewReader("a"), nil).AssertStatus(http.StatusBadRequest)
+}
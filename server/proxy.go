@@ -0,0 +1,283 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const proxyTimeout = 30 * time.Second
+
+// hopHeaders are meaningful only for a single connection and must not be
+// forwarded by a proxy.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// proxy forwards every request to a single upstream, answering from its cache
+// where the upstream allows it.
+type proxy struct {
+	upstream *url.URL
+	client   *http.Client
+	cache    *responseCache
+
+	// certHeaders carry the client certificate details to the upstream,
+	// and claimsHeader the claims of a verified Bearer token.
+	certHeaders  ClientCertHeaders
+	claimsHeader string
+	// headerRules, from -proxy-headers, change the headers sent upstream.
+	headerRules *atomic.Pointer[[]headerRule]
+	// mirror, if set, sends requests to a shadow upstream too.
+	mirror *mirror
+}
+
+func newProxy(upstream string, cacheSize int64, sessionCache int) (*proxy, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported upstream scheme %q", u.Scheme)
+	}
+
+	p := &proxy{
+		upstream: u,
+		client: &http.Client{
+			Timeout:   proxyTimeout,
+			Transport: &http.Transport{DisableCompression: true},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	if cacheSize > 0 {
+		p.cache = newResponseCache(cacheSize)
+	}
+	if sessionCache > 0 {
+		// Resuming TLS sessions saves a full handshake on each new
+		// connection to an https upstream.
+		p.client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{
+			ClientSessionCache: tls.NewLRUClientSessionCache(sessionCache),
+		}
+	}
+
+	return p, nil
+}
+
+func (p *proxy) serve(conn net.Conn, body io.Reader, req request) error {
+	var reqBody []byte
+	if req.contentLength != 0 {
+		var err error
+		if reqBody, err = io.ReadAll(body); err != nil {
+			conn.Write(buildResponse(statusBadRequest, nil))
+			return fmt.Errorf("error reading request body: %v\n", err)
+		}
+	}
+	p.mirror.send(req, reqBody)
+
+	// Responses to clients identified by a certificate or a token may be
+	// meant for them alone.
+	if p.cache == nil || !cacheableRequest(req) || req.clientCert != nil || req.claims != nil {
+		resp, err := p.roundTrip(req, reqBody, nil)
+		if err != nil {
+			conn.Write(buildResponse(statusBadGateway, nil))
+			return fmt.Errorf("error proxying %s: %v\n", req.target(), err)
+		}
+		defer resp.Body.Close()
+
+		return p.stream(conn, resp)
+	}
+
+	return p.serveCached(conn, req)
+}
+
+// serveCached answers a GET from the cache, going to the upstream on a
+// miss and revalidating stale entries with a conditional request.
+func (p *proxy) serveCached(conn net.Conn, req request) error {
+	key := cacheKey(req)
+	now := time.Now()
+
+	entry := p.cache.get(key, req)
+	if entry != nil && entry.fresh(now) && !noCacheRequest(req) {
+		conn.Write(entry.answer(req, now))
+		return nil
+	}
+
+	// The cache validates what it holds itself, and wants whole responses
+	// to store, so the client's own conditions aren't passed on; they are
+	// checked against what the cache ends up with instead.
+	upstreamReq := req
+	upstreamReq.headers = make(header, len(req.headers))
+	for name, values := range req.headers {
+		upstreamReq.headers[name] = values
+	}
+	upstreamReq.headers.del("If-None-Match")
+	upstreamReq.headers.del("If-Modified-Since")
+	var conditional header
+	if entry != nil {
+		conditional = entry.validators()
+	}
+
+	sent := time.Now()
+	resp, err := p.roundTrip(upstreamReq, nil, conditional)
+	if err != nil {
+		if entry != nil && entry.staleIfError() {
+			conn.Write(entry.answer(req, now))
+			return nil
+		}
+		conn.Write(buildResponse(statusBadGateway, nil))
+		return fmt.Errorf("error proxying %s: %v\n", req.target(), err)
+	}
+	defer resp.Body.Close()
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		entry = p.cache.refresh(key, entry, resp, sent)
+		conn.Write(entry.answer(req, time.Now()))
+		return nil
+	}
+
+	if !cacheableResponse(resp) || resp.ContentLength > p.cache.maxObject() {
+		p.cache.remove(key)
+		return p.stream(conn, resp)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, p.cache.maxObject()+1))
+	if err != nil {
+		conn.Write(buildResponse(statusBadGateway, nil))
+		return fmt.Errorf("error reading upstream body: %v\n", err)
+	}
+	if int64(len(body)) > p.cache.maxObject() {
+		p.cache.remove(key)
+		return p.streamWithPrefix(conn, resp, body)
+	}
+
+	entry = newCacheEntry(req, resp, body, sent, time.Now())
+	p.cache.put(key, entry)
+	conn.Write(entry.answer(req, time.Now()))
+
+	return nil
+}
+
+func (p *proxy) roundTrip(req request, body []byte, extra header) (*http.Response, error) {
+	u := *p.upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + req.path
+	u.RawQuery = req.rawQuery
+
+	out, err := http.NewRequestWithContext(req.context(), req.method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		out.Body = nil
+	}
+
+	for name, values := range req.headers {
+		for _, v := range values {
+			out.Header.Add(name, v)
+		}
+	}
+	stripHopHeaders(header(out.Header))
+	out.Header.Del("Host")
+	p.certHeaders.apply(header(out.Header), req.clientCert)
+	p.applyClaims(header(out.Header), req.claims)
+	if p.headerRules != nil {
+		if host := applyHeaderRules(header(out.Header), req, *p.headerRules.Load()); host != "" {
+			out.Host = host
+		}
+	}
+	for name, values := range extra {
+		out.Header[name] = values
+	}
+
+	return p.client.Do(out)
+}
+
+// stream relays an upstream response to the client without buffering it.
+func (p *proxy) stream(conn net.Conn, resp *http.Response) error {
+	return p.streamWithPrefix(conn, resp, nil)
+}
+
+// streamWithPrefix relays an upstream response whose first bytes have already
+// been read into prefix.
+func (p *proxy) streamWithPrefix(conn net.Conn, resp *http.Response, prefix []byte) error {
+	h := upstreamHeader(resp.Header)
+	if resp.ContentLength >= 0 {
+		h.set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	} else {
+		h.set("Connection", "close")
+	}
+
+	conn.Write(buildResponseHeaders(resp.StatusCode, h, nil))
+	conn.Write(prefix)
+	if _, err := io.Copy(conn, resp.Body); err != nil {
+		return fmt.Errorf("error relaying upstream body: %v\n", err)
+	}
+
+	return nil
+}
+
+// upstreamHeader copies the end-to-end headers of an upstream response. Date
+// and Server are dropped since buildResponse always sets its own.
+func upstreamHeader(src http.Header) header {
+	h := make(header, len(src))
+	for name, values := range src {
+		h[name] = append([]string(nil), values...)
+	}
+	stripHopHeaders(h)
+	h.del("Date")
+	h.del("Server")
+
+	return h
+}
+
+// stripHopHeaders removes the hop-by-hop headers from h, those named in
+// Connection included, however many Connection lines there are. A TE of
+// trailers is kept, since it tells the upstream the client can take them.
+func stripHopHeaders(h header) {
+	for _, value := range h["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.del(name)
+			}
+		}
+	}
+	trailers := false
+	for _, value := range h["Te"] {
+		for _, coding := range strings.Split(value, ",") {
+			coding, _, _ = strings.Cut(coding, ";")
+			trailers = trailers || strings.EqualFold(strings.TrimSpace(coding), "trailers")
+		}
+	}
+	for _, name := range hopHeaders {
+		h.del(name)
+	}
+	if trailers {
+		h.set("Te", "trailers")
+	}
+}
+
+func isHopHeader(name string) bool {
+	for _, hop := range hopHeaders {
+		if strings.EqualFold(name, hop) {
+			return true
+		}
+	}
+
+	return false
+}
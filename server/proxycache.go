@@ -0,0 +1,359 @@
+package server
+
+import (
+	"container/list"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// heuristicFraction of the time since Last-Modified is used as lifetime
+	// when the upstream gives no explicit freshness, as RFC 9111 suggests.
+	heuristicFraction = 10
+	heuristicMax      = 24 * time.Hour
+)
+
+// cacheableStatus holds the status codes that may be cached without explicit
+// freshness information (RFC 9110 section 15.1).
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+type cacheEntry struct {
+	status       int
+	header       header
+	body         []byte
+	vary         map[string]string
+	requestTime  time.Time
+	responseTime time.Time
+	initialAge   time.Duration
+	lifetime     time.Duration
+}
+
+func newCacheEntry(req request, resp *http.Response, body []byte, requestTime, responseTime time.Time) *cacheEntry {
+	e := &cacheEntry{
+		status:       resp.StatusCode,
+		header:       upstreamHeader(resp.Header),
+		body:         body,
+		vary:         make(map[string]string),
+		requestTime:  requestTime,
+		responseTime: responseTime,
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		e.vary[name] = req.headers.get(name)
+	}
+	e.update(resp.Header)
+
+	return e
+}
+
+// update recomputes the entry's age and lifetime from a set of upstream
+// response headers, following RFC 9111 section 4.2.
+func (e *cacheEntry) update(h http.Header) {
+	date, err := http.ParseTime(h.Get("Date"))
+	if err != nil {
+		date = e.responseTime
+	}
+
+	apparentAge := max(0, e.responseTime.Sub(date))
+	ageValue, _ := strconv.Atoi(h.Get("Age"))
+	correctedAge := time.Duration(ageValue)*time.Second + e.responseTime.Sub(e.requestTime)
+	e.initialAge = max(apparentAge, correctedAge)
+
+	cc := parseCacheControl(h.Get("Cache-Control"))
+	switch {
+	case cc.has("no-cache"):
+		e.lifetime = 0
+	case cc.has("s-maxage"):
+		e.lifetime = cc.seconds("s-maxage")
+	case cc.has("max-age"):
+		e.lifetime = cc.seconds("max-age")
+	case h.Get("Expires") != "":
+		expires, err := http.ParseTime(h.Get("Expires"))
+		e.lifetime = 0
+		if err == nil {
+			e.lifetime = max(0, expires.Sub(date))
+		}
+	case h.Get("Last-Modified") != "":
+		modified, err := http.ParseTime(h.Get("Last-Modified"))
+		e.lifetime = 0
+		if err == nil {
+			e.lifetime = min(max(0, date.Sub(modified))/heuristicFraction, heuristicMax)
+		}
+	default:
+		e.lifetime = 0
+	}
+}
+
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.initialAge + now.Sub(e.responseTime)
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return e.lifetime > e.age(now)
+}
+
+func (e *cacheEntry) staleIfError() bool {
+	return !parseCacheControl(e.header.get("Cache-Control")).has("must-revalidate")
+}
+
+// validators returns the conditional request headers needed to revalidate
+// the entry, or nil if it has none.
+func (e *cacheEntry) validators() header {
+	h := make(header)
+	if etag := e.header.get("ETag"); etag != "" {
+		h.set("If-None-Match", etag)
+	}
+	if modified := e.header.get("Last-Modified"); modified != "" {
+		h.set("If-Modified-Since", modified)
+	}
+	if len(h) == 0 {
+		return nil
+	}
+
+	return h
+}
+
+// matches reports whether the entry was stored for a request with the same
+// values for the headers the response varies on.
+func (e *cacheEntry) matches(req request) bool {
+	for name, value := range e.vary {
+		if req.headers.get(name) != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e *cacheEntry) response(now time.Time) []byte {
+	h := make(header, len(e.header)+2)
+	for name, values := range e.header {
+		h[name] = values
+	}
+	h.set("Age", strconv.Itoa(int(e.age(now).Seconds())))
+	h.set("Content-Length", strconv.Itoa(len(e.body)))
+
+	return append(buildResponseHeaders(e.status, h, nil), e.body...)
+}
+
+// answer returns the response to req from the entry: 304 Not Modified if
+// the client holds what the entry does already, the whole of it if not.
+func (e *cacheEntry) answer(req request, now time.Time) []byte {
+	if e.status != statusOK || !notModified(req, e.header) {
+		return e.response(now)
+	}
+
+	h := make(header)
+	for _, name := range []string{"Cache-Control", "Content-Location", "ETag", "Expires", "Last-Modified", "Vary"} {
+		if values, ok := e.header[textproto.CanonicalMIMEHeaderKey(name)]; ok {
+			h[textproto.CanonicalMIMEHeaderKey(name)] = values
+		}
+	}
+	h.set("Age", strconv.Itoa(int(e.age(now).Seconds())))
+
+	return buildResponseHeaders(statusNotModified, h, nil)
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.body))
+}
+
+// responseCache is an in-memory LRU of upstream responses bounded by the total
+// size of their bodies.
+type responseCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type cacheItem struct {
+	key   string
+	entry *cacheEntry
+}
+
+func newResponseCache(maxBytes int64) *responseCache {
+	return &responseCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// maxObject is the largest body the cache will hold, so that one response
+// can't flush everything else out.
+func (c *responseCache) maxObject() int64 {
+	return c.maxBytes / 8
+}
+
+func (c *responseCache) get(key string, req request) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*cacheItem).entry
+	if !entry.matches(req) {
+		return nil
+	}
+	c.order.MoveToFront(el)
+
+	return entry
+}
+
+func (c *responseCache) put(key string, entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeLocked(key)
+	c.entries[key] = c.order.PushFront(&cacheItem{key: key, entry: entry})
+	c.used += entry.size()
+
+	for c.used > c.maxBytes {
+		c.removeLocked(c.order.Back().Value.(*cacheItem).key)
+	}
+}
+
+// refresh stores a copy of entry updated with the headers of a 304 response.
+func (c *responseCache) refresh(key string, entry *cacheEntry, resp *http.Response, requestTime time.Time) *cacheEntry {
+	updated := *entry
+	updated.header = make(header, len(entry.header))
+	for name, values := range entry.header {
+		updated.header[name] = values
+	}
+	for name, values := range upstreamHeader(resp.Header) {
+		if name != "Content-Length" {
+			updated.header[name] = values
+		}
+	}
+	updated.requestTime = requestTime
+	updated.responseTime = time.Now()
+	updated.update(resp.Header)
+
+	c.put(key, &updated)
+
+	return &updated
+}
+
+// flush drops every entry.
+func (c *responseCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.used = 0
+}
+
+// usage returns the number of entries and the bytes they hold.
+func (c *responseCache) usage() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries), c.used
+}
+
+func (c *responseCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeLocked(key)
+}
+
+func (c *responseCache) removeLocked(key string) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.used -= el.Value.(*cacheItem).entry.size()
+	c.order.Remove(el)
+	delete(c.entries, key)
+}
+
+func cacheKey(req request) string {
+	return req.target()
+}
+
+func cacheableRequest(req request) bool {
+	return req.method == methodGet && req.headers.get("Authorization") == ""
+}
+
+func noCacheRequest(req request) bool {
+	cc := parseCacheControl(req.headers.get("Cache-Control"))
+	if cc.has("no-cache") || (cc.has("max-age") && cc.seconds("max-age") == 0) {
+		return true
+	}
+
+	return req.headers.get("Pragma") == "no-cache"
+}
+
+func cacheableResponse(resp *http.Response) bool {
+	if !cacheableStatus[resp.StatusCode] {
+		return false
+	}
+
+	cc := parseCacheControl(resp.Header.Get("Cache-Control"))
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	return names
+}
+
+// cacheControl holds the directives of a Cache-Control header. Directives
+// without an argument map to "".
+type cacheControl map[string]string
+
+func parseCacheControl(value string) cacheControl {
+	cc := make(cacheControl)
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if name == "" {
+			continue
+		}
+		cc[strings.ToLower(name)] = strings.Trim(arg, `"`)
+	}
+
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+func (cc cacheControl) seconds(directive string) time.Duration {
+	n, err := strconv.Atoi(cc[directive])
+	if err != nil || n < 0 {
+		return 0
+	}
+
+	return time.Duration(n) * time.Second
+}
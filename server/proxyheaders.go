@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The actions of header rules. Proxy header rules append to lists and
+// response header rules add lines.
+const (
+	headerSet    = "set"
+	headerAppend = "append"
+	headerAdd    = "add"
+	headerRemove = "remove"
+)
+
+// headerRule changes a header of requests forwarded upstream.
+type headerRule struct {
+	action string
+	name   string
+	value  headerTemplate
+}
+
+// headerTemplate is a header value in which {variable}s expand to
+// attributes of the request being forwarded.
+type headerTemplate []func(b *strings.Builder, req request)
+
+// headerVariables are the {name} variables of header templates, besides
+// {header:Name} for a request header.
+var headerVariables = map[string]func(b *strings.Builder, req request){
+	"client_ip":   func(b *strings.Builder, req request) { b.WriteString(req.clientIP) },
+	"remote_addr": func(b *strings.Builder, req request) { b.WriteString(req.remoteAddr) },
+	"scheme":      func(b *strings.Builder, req request) { b.WriteString(req.scheme) },
+	"host":        func(b *strings.Builder, req request) { b.WriteString(req.host) },
+	"method":      func(b *strings.Builder, req request) { b.WriteString(req.method) },
+	"path":        func(b *strings.Builder, req request) { b.WriteString(req.path) },
+	"query":       func(b *strings.Builder, req request) { b.WriteString(req.rawQuery) },
+	"id":          func(b *strings.Builder, req request) { b.WriteString(req.id) },
+	"country":     func(b *strings.Builder, req request) { b.WriteString(req.geo.Country) },
+	"asn": func(b *strings.Builder, req request) {
+		if req.geo.ASN != 0 {
+			b.WriteString("AS" + strconv.FormatUint(uint64(req.geo.ASN), 10))
+		}
+	},
+}
+
+// parseHeaderTemplate compiles a header value. Variables are written as
+// {name}; {{ is a literal {.
+func parseHeaderTemplate(s string) (headerTemplate, error) {
+	var t headerTemplate
+	literal := func(s string) {
+		if s != "" {
+			t = append(t, func(b *strings.Builder, _ request) { b.WriteString(s) })
+		}
+	}
+
+	for {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			literal(s)
+			return t, nil
+		}
+		literal(s[:i])
+		s = s[i+1:]
+		if strings.HasPrefix(s, "{") {
+			literal("{")
+			s = s[1:]
+			continue
+		}
+
+		name, rest, ok := strings.Cut(s, "}")
+		if !ok {
+			return nil, fmt.Errorf("unterminated variable at %q", "{"+s)
+		}
+		s = rest
+		if h, ok := strings.CutPrefix(name, "header:"); ok && validHeaderName(h) {
+			t = append(t, func(b *strings.Builder, req request) { b.WriteString(req.headers.get(h)) })
+			continue
+		}
+		v, ok := headerVariables[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable %q", "{"+name+"}")
+		}
+		t = append(t, v)
+	}
+}
+
+func (t headerTemplate) expand(req request) string {
+	var b strings.Builder
+	for _, part := range t {
+		part(&b, req)
+	}
+
+	return b.String()
+}
+
+// loadHeaderRules reads the proxy header rules file at path. Each line holds
+// an action, a header name and, for set and append, a value running to the
+// end of the line. Blank lines and lines starting with # are skipped.
+func loadHeaderRules(path string) ([]headerRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening proxy header rules: %v", err)
+	}
+	defer f.Close()
+
+	var rules []headerRule
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		action, rest, _ := strings.Cut(line, " ")
+		name, value, _ := strings.Cut(strings.TrimSpace(rest), " ")
+		value = strings.TrimSpace(value)
+
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("%s:%d: invalid header name %q", path, n, name)
+		}
+		if isHopHeader(name) {
+			return nil, fmt.Errorf("%s:%d: %s is a hop-by-hop header", path, n, name)
+		}
+		rule := headerRule{action: action, name: name}
+		switch action {
+		case headerSet, headerAppend:
+			if rule.value, err = parseHeaderTemplate(value); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+			}
+		case headerRemove:
+			if value != "" {
+				return nil, fmt.Errorf("%s:%d: remove takes no value", path, n)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: expected 'set|append Name value' or 'remove Name'", path, n)
+		}
+		rules = append(rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading proxy header rules: %v", err)
+	}
+
+	return rules, nil
+}
+
+// applyHeaderRules applies the rules in order to h, the headers of req on
+// its way upstream, returning the Host to send if a rule sets it. Values
+// that expand to nothing aren't sent.
+func applyHeaderRules(h header, req request, rules []headerRule) string {
+	var host string
+	for _, rule := range rules {
+		if strings.EqualFold(rule.name, "Host") {
+			switch rule.action {
+			case headerSet, headerAppend:
+				host = rule.value.expand(req)
+			case headerRemove:
+				host = ""
+			}
+			continue
+		}
+
+		switch rule.action {
+		case headerSet:
+			if v := rule.value.expand(req); v != "" {
+				h.set(rule.name, v)
+			} else {
+				h.del(rule.name)
+			}
+		case headerAppend:
+			// Appending to a list like X-Forwarded-For folds it into one
+			// line, since not every upstream reads more than the first.
+			if v := rule.value.expand(req); v != "" {
+				if old := h[textproto.CanonicalMIMEHeaderKey(rule.name)]; len(old) > 0 {
+					v = strings.Join(old, ", ") + ", " + v
+				}
+				h.set(rule.name, v)
+			}
+		case headerRemove:
+			h.del(rule.name)
+		}
+	}
+
+	return host
+}
@@ -0,0 +1,75 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestProxyHeaders(t *testing.T) {
+	var got *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer upstream.Close()
+
+	rules := filepath.Join(t.TempDir(), "headers")
+	os.WriteFile(rules, []byte(`# headers for the backend
+set X-Real-Ip {client_ip}
+append X-Forwarded-For {client_ip}
+set X-Origin {scheme}://{host}{path}?{query} {{literal}
+set X-Agent agent={header:User-Agent}
+remove Cookie
+set X-Empty {header:X-Missing}
+set Host backend.internal
+`), 0o644)
+
+	opts := servertest.Options()
+	opts.Proxy = upstream.URL
+	opts.ProxyHeaders = rules
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	c.Raw("GET /a?b=1 HTTP/1.1\r\nHost: example.com\r\nUser-Agent: tester\r\n" +
+		"X-Forwarded-For: 192.0.2.1\r\nCookie: session=1\r\nX-Empty: client\r\n" +
+		"Connection: close, X-Secret\r\nConnection: X-Other\r\nX-Secret: 1\r\nX-Other: 2\r\nTe: trailers, deflate\r\n\r\n").
+		AssertStatus(http.StatusOK)
+
+	for name, want := range map[string]string{
+		"X-Real-Ip":       "pipe",
+		"X-Forwarded-For": "192.0.2.1, pipe",
+		"X-Origin":        "http://example.com/a?b=1 {literal}",
+		"X-Agent":         "agent=tester",
+		"Cookie":          "",
+		"X-Empty":         "",
+		"X-Secret":        "",
+		"X-Other":         "",
+		"Te":              "trailers",
+	} {
+		if v := got.Header.Get(name); v != want {
+			t.Errorf("upstream got %s %q, want %q", name, v, want)
+		}
+	}
+	if got.Host != "backend.internal" {
+		t.Errorf("upstream got Host %q, want backend.internal", got.Host)
+	}
+
+	for _, bad := range []string{
+		"set X-A {nope}\n",
+		"set X-A {client_ip\n",
+		"remove X-A value\n",
+		"replace X-A b\n",
+		"set Connection close\n",
+		"set Bad:Name a\n",
+	} {
+		os.WriteFile(rules, []byte(bad), 0o644)
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error for rules %q", bad)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// maxRanges caps the ranges served from one request; asking for more gets
+// the whole file.
+const maxRanges = 32
+
+var errUnsatisfiable = errors.New("no satisfiable range")
+
+// byteRange is the inclusive span [start, end] of a file.
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// parseRanges parses a Range header against a file of size bytes. Ranges
+// beyond the end are dropped, and errUnsatisfiable is returned if none is
+// left. Any other error means the header should be ignored.
+func parseRanges(spec string, size int64) ([]byteRange, error) {
+	unit, set, ok := strings.Cut(spec, "=")
+	if !ok || strings.TrimSpace(unit) != "bytes" {
+		return nil, fmt.Errorf("unsupported range %q", spec)
+	}
+
+	var ranges []byteRange
+	specs := strings.Split(set, ",")
+	if len(specs) > maxRanges {
+		return nil, fmt.Errorf("too many ranges in %q", spec)
+	}
+	for _, s := range specs {
+		s = strings.TrimSpace(s)
+		first, last, ok := strings.Cut(s, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q", s)
+		}
+
+		var r byteRange
+		if first == "" {
+			// A suffix: the last n bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid range %q", s)
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			r = byteRange{start: max(size-n, 0), end: size - 1}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("invalid range %q", s)
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, fmt.Errorf("invalid range %q", s)
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, end: min(end, size-1)}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiable
+	}
+
+	return ranges, nil
+}
+
+// writeRanges answers a request for ranges of body, size bytes of
+// contentType, on w, adding headers h. A single range is sent as is,
+// several as a multipart/byteranges body. Only the ranges are read, each
+// from where it starts. It reports false, having written nothing, if the
+// Range header spec is to be ignored and the whole of body sent instead.
+func writeRanges(w io.Writer, body io.ReadSeeker, size int64, contentType string, h header, spec string) (bool, error) {
+	ranges, err := parseRanges(spec, size)
+	if errors.Is(err, errUnsatisfiable) {
+		h := header{"Content-Range": {fmt.Sprintf("bytes */%d", size)}}
+		_, err := w.Write(buildResponseHeaders(statusRangeNotSatisfiable, h, nil))
+		return true, err
+	}
+	if err != nil {
+		return false, nil
+	}
+	if h == nil {
+		h = header{}
+	}
+
+	if len(ranges) == 1 {
+		r := ranges[0]
+		h.set("Content-Range", r.contentRange(size))
+		h.set("Content-Type", contentType)
+		h.set("Content-Length", strconv.FormatInt(r.length(), 10))
+		if _, err := w.Write(buildResponseHeaders(statusPartialContent, h, nil)); err != nil {
+			return true, err
+		}
+		return true, copyRange(w, body, r)
+	}
+
+	// The boundaries and part headers are laid out first, so the length of
+	// the body is known before any of the file is read.
+	var frame bytes.Buffer
+	mw := multipart.NewWriter(&frame)
+	heads := make([]int, len(ranges))
+	length := int64(0)
+	for i, r := range ranges {
+		mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {contentType},
+			"Content-Range": {r.contentRange(size)},
+		})
+		heads[i] = frame.Len()
+		length += r.length()
+	}
+	mw.Close()
+	length += int64(frame.Len())
+
+	h.set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	h.set("Content-Length", strconv.FormatInt(length, 10))
+	if _, err := w.Write(buildResponseHeaders(statusPartialContent, h, nil)); err != nil {
+		return true, err
+	}
+	from := 0
+	for i, r := range ranges {
+		if _, err := w.Write(frame.Bytes()[from:heads[i]]); err != nil {
+			return true, err
+		}
+		if err := copyRange(w, body, r); err != nil {
+			return true, err
+		}
+		from = heads[i]
+	}
+	_, err = w.Write(frame.Bytes()[from:])
+
+	return true, err
+}
+
+// copyRange copies the span r of body to w.
+func copyRange(w io.Writer, body io.ReadSeeker, r byteRange) error {
+	if _, err := body.Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(w, body, r.length())
+
+	return err
+}
+
+// fileValidators returns the ETag and Last-Modified headers of a file.
+func fileValidators(info fs.FileInfo) header {
+	return header{
+		"Etag":          {fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())},
+		"Last-Modified": {info.ModTime().UTC().Format(http.TimeFormat)},
+	}
+}
+
+// ifRangeMatches reports whether the If-Range condition of req holds for a
+// file with the validators h, so that its Range header may be honoured.
+// Only a strong match counts: a weak ETag or a date other than the exact
+// modification time means the client's copy is stale.
+func ifRangeMatches(req request, h header) bool {
+	cond := strings.TrimSpace(req.headers.get("If-Range"))
+	if cond == "" {
+		return true
+	}
+	if strings.HasPrefix(cond, `"`) || strings.HasPrefix(cond, "W/") {
+		return cond == h.get("Etag")
+	}
+
+	t, err := http.ParseTime(cond)
+	if err != nil {
+		return false
+	}
+
+	return h.get("Last-Modified") == t.UTC().Format(http.TimeFormat)
+}
+
+// notModified reports whether the conditional headers of req show the
+// client already holds the version of a file with the validators h.
+// If-None-Match uses weak comparison and, when present, overrides
+// If-Modified-Since.
+func notModified(req request, h header) bool {
+	if cond := req.headers.get("If-None-Match"); cond != "" {
+		etag := strings.TrimPrefix(h.get("Etag"), "W/")
+		for _, tag := range strings.Split(cond, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(req.headers.get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(h.get("Last-Modified"))
+
+	return err == nil && !modified.After(since)
+}
@@ -0,0 +1,144 @@
+package server_test
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestRanges(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("0123456789"), nil).AssertStatus(http.StatusCreated)
+
+	get := func(spec string) *servertest.Response {
+		return c.Do(http.MethodGet, "/files/a.txt", nil, http.Header{"Range": {spec}})
+	}
+
+	c.Get("/files/a.txt").AssertHeader("Accept-Ranges", "bytes")
+	c.Get("/files/?archive=zip").AssertStatus(http.StatusOK).AssertHeader("Accept-Ranges", "none")
+
+	get("bytes=2-4").
+		AssertStatus(http.StatusPartialContent).
+		AssertHeader("Content-Range", "bytes 2-4/10").
+		AssertBody("234")
+	get("bytes=-3").AssertHeader("Content-Range", "bytes 7-9/10").AssertBody("789")
+	get("bytes=8-").AssertBody("89")
+	get("bytes=8-100").AssertHeader("Content-Range", "bytes 8-9/10").AssertBody("89")
+	get("bytes=20-").
+		AssertStatus(http.StatusRequestedRangeNotSatisfiable).
+		AssertHeader("Content-Range", "bytes */10")
+	get("lines=1-2").AssertStatus(http.StatusOK).AssertBody("0123456789")
+	get("bytes=4-2").AssertStatus(http.StatusOK)
+
+	resp := get("bytes=0-1, 20-30, 5-6").AssertStatus(http.StatusPartialContent)
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("got Content-Type %q", resp.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(strings.NewReader(string(resp.Body)), params["boundary"])
+	want := []struct{ contentRange, body string }{
+		{"bytes 0-1/10", "01"},
+		{"bytes 5-6/10", "56"},
+	}
+	for _, w := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("error reading part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		if got := part.Header.Get("Content-Range"); got != w.contentRange || string(body) != w.body {
+			t.Errorf("got part %q %q, want %q %q", got, body, w.contentRange, w.body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("got %v after the last part, want EOF", err)
+	}
+	if got, want := resp.Header.Get("Content-Length"), strconv.Itoa(len(resp.Body)); got != want {
+		t.Errorf("got Content-Length %s for a body of %s bytes", got, want)
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("0123456789"), nil).AssertStatus(http.StatusCreated)
+
+	full := c.Get("/files/a.txt").AssertStatus(http.StatusOK)
+	etag, modified := full.Header.Get("ETag"), full.Header.Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("got ETag %q and Last-Modified %q", etag, modified)
+	}
+
+	get := func(cond string) *servertest.Response {
+		return c.Do(http.MethodGet, "/files/a.txt", nil, http.Header{"Range": {"bytes=0-1"}, "If-Range": {cond}})
+	}
+	get(etag).AssertStatus(http.StatusPartialContent).AssertBody("01")
+	get(modified).AssertStatus(http.StatusPartialContent).AssertBody("01")
+	get("W/" + etag).AssertStatus(http.StatusOK).AssertBody("0123456789")
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("abcdefghijk"), nil).AssertStatus(http.StatusCreated)
+	get(etag).AssertStatus(http.StatusOK).AssertBody("abcdefghijk")
+	get("Mon, 02 Jan 2006 15:04:05 GMT").AssertStatus(http.StatusOK)
+}
+
+func TestConditionalGet(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("0123456789"), nil).AssertStatus(http.StatusCreated)
+
+	full := c.Get("/files/a.txt").AssertStatus(http.StatusOK)
+	etag, modified := full.Header.Get("ETag"), full.Header.Get("Last-Modified")
+
+	get := func(name, value string) *servertest.Response {
+		return c.Do(http.MethodGet, "/files/a.txt", nil, http.Header{name: {value}})
+	}
+	get("If-None-Match", etag).AssertStatus(http.StatusNotModified).AssertHeader("ETag", etag).AssertBody("")
+	get("If-None-Match", `"other", W/`+etag).AssertStatus(http.StatusNotModified)
+	get("If-None-Match", "*").AssertStatus(http.StatusNotModified)
+	get("If-None-Match", `"other"`).AssertStatus(http.StatusOK).AssertBody("0123456789")
+	get("If-Modified-Since", modified).AssertStatus(http.StatusNotModified)
+	get("If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT").AssertStatus(http.StatusOK)
+	c.Do(http.MethodGet, "/files/a.txt", nil, http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {modified}}).
+		AssertStatus(http.StatusOK)
+}
+
+func TestSingleFile(t *testing.T) {
+	opts := servertest.Options()
+	opts.File = filepath.Join(t.TempDir(), "build.tar.gz")
+	if err := os.WriteFile(opts.File, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := servertest.NewPipe(t, opts).Client()
+
+	full := c.Get("/").
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "application/gzip").
+		AssertHeader("Content-Disposition", `inline; filename=build.tar.gz`).
+		AssertHeader("Accept-Ranges", "bytes").
+		AssertBody("0123456789")
+	c.Do(http.MethodGet, "/", nil, http.Header{"Range": {"bytes=2-4"}}).
+		AssertStatus(http.StatusPartialContent).
+		AssertBody("234")
+	c.Do(http.MethodGet, "/", nil, http.Header{"If-None-Match": {full.Header.Get("ETag")}}).
+		AssertStatus(http.StatusNotModified)
+
+	c.Get("/files/build.tar.gz").AssertStatus(http.StatusNotFound)
+	c.Do(http.MethodHead, "/", nil, nil).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Length", "10").
+		AssertBody("")
+	c.Do(http.MethodPut, "/", strings.NewReader("x"), nil).
+		AssertStatus(http.StatusMethodNotAllowed).
+		AssertHeader("Allow", "GET, HEAD")
+
+	if _, err := server.New(server.Options{File: t.TempDir()}); err == nil {
+		t.Error("got no error serving a directory as -file")
+	}
+}
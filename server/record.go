@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxRecorded bounds how much of each request and response body is kept in
+// a recording.
+const maxRecorded = 16 << 20
+
+// exchangeRecord is one request and the raw response it got, as stored one
+// per line in a recording.
+type exchangeRecord struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Target string    `json:"target"`
+	Header header    `json:"header"`
+	Body   []byte    `json:"body,omitempty"`
+	// Response holds the response as it went out on the wire.
+	Response []byte `json:"response"`
+	// Truncated is set if a body went over maxRecorded and was cut short.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// recorder appends exchanges to a recording file.
+type recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	log *slog.Logger
+}
+
+func newRecorder(path string, log *slog.Logger) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recorder{f: f, log: log}, nil
+}
+
+// record starts recording an exchange. It returns the body and connection to
+// go on with, which copy what passes through them into the record, and a
+// func to call once the response is done.
+func (r *recorder) record(req request, body io.Reader, ex *exchangeConn) (io.Reader, func()) {
+	rec := &exchangeRecord{
+		Time:   time.Now().UTC(),
+		Method: req.method,
+		Target: req.target(),
+		Header: req.headers,
+	}
+	reqBody := &cappedBuffer{truncated: &rec.Truncated}
+	resp := &cappedBuffer{truncated: &rec.Truncated}
+	ex.Conn = &teeConn{Conn: ex.Conn, w: resp}
+
+	return io.TeeReader(body, reqBody), func() {
+		rec.Body = reqBody.Bytes()
+		rec.Response = resp.Bytes()
+		if err := r.write(rec); err != nil {
+			r.log.Error("error recording exchange", "method", rec.Method, "target", rec.Target, "err", err)
+		}
+	}
+}
+
+func (r *recorder) write(rec *exchangeRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = r.f.Write(append(line, '\n'))
+
+	return err
+}
+
+func (r *recorder) close() error {
+	return r.f.Close()
+}
+
+// cappedBuffer keeps the first maxRecorded bytes written to it.
+type cappedBuffer struct {
+	bytes.Buffer
+	truncated *bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := maxRecorded - b.Len(); len(p) > room {
+		*b.truncated = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+
+	return b.Buffer.Write(p)
+}
+
+// teeConn copies everything written to the connection to w.
+type teeConn struct {
+	net.Conn
+	w io.Writer
+}
+
+func (c *teeConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.w.Write(p[:n])
+
+	return n, err
+}
+
+// Replayer serves the responses of a recording back. Requests are matched on
+// method and target; when a recording holds several exchanges for the same
+// request their responses are served in turn, starting over after the last.
+type Replayer struct {
+	log *slog.Logger
+
+	mu        sync.Mutex
+	exchanges map[string][]*exchangeRecord
+	next      map[string]int
+}
+
+// NewReplayer loads the recording at path.
+func NewReplayer(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &Replayer{
+		log:       slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		exchanges: make(map[string][]*exchangeRecord),
+		next:      make(map[string]int),
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 4*maxRecorded)
+	for n := 1; sc.Scan(); n++ {
+		var rec exchangeRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("error reading %s line %d: %v", path, n, err)
+		}
+		key := rec.Method + " " + rec.Target
+		r.exchanges[key] = append(r.exchanges[key], &rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	return r, nil
+}
+
+// Serve answers requests on l from the recording until l is closed.
+func (r *Replayer) Serve(l net.Listener) error {
+	return serveConns(l, r.log, r.handleConn)
+}
+
+func (r *Replayer) lookup(req request) *exchangeRecord {
+	key := req.method + " " + req.target()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recs := r.exchanges[key]
+	if len(recs) == 0 {
+		return nil
+	}
+	rec := recs[r.next[key]%len(recs)]
+	r.next[key]++
+
+	return rec
+}
+
+func (r *Replayer) handleConn(conn net.Conn) error {
+	reqReader := bufio.NewReader(conn)
+	req, err := parseRequest(reqReader, false)
+	if err != nil {
+		return err
+	}
+	if body, status := req.body(reqReader); status == 0 {
+		drainBody(body)
+	}
+
+	// Recorded responses may have kept their connection alive, which
+	// replaying one per connection can't honour.
+	ex := &exchangeConn{Conn: conn, closeAfter: true}
+
+	rec := r.lookup(req)
+	if rec == nil {
+		c := content{
+			contentType: contentTypeTextPlain,
+			body:        []byte("no recorded response for " + req.method + " " + req.target() + "\n"),
+		}
+		ex.Write(buildResponse(statusNotFound, &c))
+		return nil
+	}
+	ex.Write(rec.Response)
+
+	return nil
+}
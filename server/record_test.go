@@ -0,0 +1,62 @@
+package server_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+
+	opts := servertest.Options()
+	opts.Record = path
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+	c.Get("/echo/abc").AssertBody("abc")
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("one"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/a.txt").AssertBody("one")
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("two"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/a.txt").AssertBody("two")
+	s.Close()
+
+	r, err := server.NewReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go r.Serve(l)
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get("http://" + l.Addr().String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	for _, want := range []string{"one", "two", "one"} {
+		if status, body := get("/files/a.txt"); status != http.StatusOK || body != want {
+			t.Errorf("got %d %q, want 200 %q", status, body, want)
+		}
+	}
+	if _, body := get("/echo/abc"); body != "abc" {
+		t.Errorf("got %q, want abc", body)
+	}
+	if status, _ := get("/echo/other"); status != http.StatusNotFound {
+		t.Errorf("got status %d for an unrecorded request, want 404", status)
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// serveRecovered serves req like serveRequest, through the middleware
+// attached to its route, turning a panic in a handler into a 500, if the
+// response hasn't been started, and an error. The panic is logged with the
+// request and the stack. The connection is closed after a panic since what
+// is left of the exchange on it can't be trusted.
+func (s *Server) serveRecovered(ex *exchangeConn, body io.Reader, req request) (err error) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+
+		ex.forceClose()
+		if !ex.headWritten {
+			ex.Write(buildResponse(statusInternalServerError, nil))
+		}
+		s.log.Error("panic serving request", "method", req.method, "target", req.target(), "client", req.clientIP, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+		err = fmt.Errorf("panic serving %s %s for %s: %v", req.method, req.target(), req.clientIP, v)
+	}()
+
+	if h := s.route(req); h != nil {
+		return h(ex, body, req)
+	}
+
+	return s.serveRequest(ex, body, req)
+}
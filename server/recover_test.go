@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// panicStorage panics on every Stat, standing in for a handler bug.
+type panicStorage struct {
+	storage
+}
+
+func (panicStorage) Stat(string) (fs.FileInfo, error) {
+	panic("boom")
+}
+
+func TestPanicRecovery(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Storage = storageMemory
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.store = panicStorage{s.store}
+
+	get := func(path string) (*http.Response, error) {
+		client, conn := net.Pipe()
+		defer client.Close()
+
+		done := make(chan error, 1)
+		go func() { done <- s.ServeConn(conn) }()
+
+		io.WriteString(client, "GET "+path+" HTTP/1.1\r\nHost: x\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(resp.Body)
+		if resp.Close {
+			return resp, <-done
+		}
+
+		return resp, nil
+	}
+
+	resp, err := get("/files/a.txt")
+	if resp.StatusCode != http.StatusInternalServerError || !resp.Close {
+		t.Errorf("got %d with close %v, want 500 closing the connection", resp.StatusCode, resp.Close)
+	}
+	if err == nil || !strings.Contains(err.Error(), "panic serving GET /files/a.txt") || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("got error %v, want one naming the request and panic", err)
+	}
+
+	if resp, _ := get("/echo/hi"); resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d after the panic, want 200", resp.StatusCode)
+	}
+}
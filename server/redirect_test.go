@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// redirectDo sends the raw request to the redirect listener as configured
+// by opts and reads the response to it.
+func redirectDo(t *testing.T, opts Options, raw string) (*http.Response, []byte) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		handleRedirectConn(server, opts)
+		server.Close()
+	}()
+	go io.WriteString(client, raw)
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+
+	return resp, body
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	opts := DefaultOptions()
+	for _, tc := range []struct {
+		name, tlsHost, raw, location string
+	}{
+		{"port kept", "0.0.0.0:8443", "GET /files/a.txt?x=1&y=%20 HTTP/1.1\r\nHost: example.com\r\n\r\n", "https://example.com:8443/files/a.txt?x=1&y=%20"},
+		{"plain port replaced", "0.0.0.0:8443", "GET /files/ HTTP/1.1\r\nHost: example.com:80\r\n\r\n", "https://example.com:8443/files/"},
+		{"default port", "0.0.0.0:443", "GET /?q=a HTTP/1.1\r\nHost: example.com:8080\r\n\r\n", "https://example.com/?q=a"},
+		{"IPv6", "[::]:8443", "GET / HTTP/1.1\r\nHost: [::1]:8080\r\n\r\n", "https://[::1]:8443/"},
+	} {
+		opts.Host = tc.tlsHost
+		resp, _ := redirectDo(t, opts, tc.raw)
+		if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != tc.location {
+			t.Errorf("%s: got %d to %q, want 301 to %q", tc.name, resp.StatusCode, resp.Header.Get("Location"), tc.location)
+		}
+	}
+
+	if resp, _ := redirectDo(t, opts, "GET / HTTP/1.0\r\n\r\n"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %d without a Host, want 400", resp.StatusCode)
+	}
+}
+
+func TestACMEWebroot(t *testing.T) {
+	dir := t.TempDir()
+	challenges := filepath.Join(dir, ".well-known", "acme-challenge")
+	os.MkdirAll(challenges, 0o755)
+	os.WriteFile(filepath.Join(challenges, "tok-EN_1"), []byte("tok-EN_1.thumbprint"), 0o644)
+	os.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0o644)
+
+	opts := DefaultOptions()
+	opts.ACMEWebroot = dir
+	get := func(target string) (*http.Response, []byte) {
+		return redirectDo(t, opts, "GET "+target+" HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	}
+
+	resp, body := get("/.well-known/acme-challenge/tok-EN_1")
+	if resp.StatusCode != http.StatusOK || string(body) != "tok-EN_1.thumbprint" {
+		t.Errorf("got %d %q for the token", resp.StatusCode, body)
+	}
+	for _, target := range []string{
+		"/.well-known/acme-challenge/missing",
+		"/.well-known/acme-challenge/../../secret",
+		"/.well-known/acme-challenge/..%2F..%2Fsecret",
+		"/.well-known/acme-challenge/",
+		"/.well-known/acme-challenge/tok.EN",
+	} {
+		if resp, body := get(target); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: got %d %q, want 404", target, resp.StatusCode, body)
+		}
+	}
+
+	// Everything else is still sent to HTTPS.
+	if resp, _ := get("/files/"); resp.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("got %d outside the challenges, want 301", resp.StatusCode)
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// maxRequestID bounds the X-Request-Id values taken from clients.
+const maxRequestID = 64
+
+// requestID returns the ID of a request: the X-Request-Id it came with, as
+// set by a proxy in front, if that is short and plain enough to be shown
+// and logged as it is, or else a random one.
+func requestID(h header) string {
+	if id := h.get("X-Request-Id"); id != "" && len(id) <= maxRequestID {
+		plain := true
+		for _, c := range id {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				plain = false
+				break
+			}
+		}
+		if plain {
+			return id
+		}
+	}
+
+	var b [8]byte
+	rand.Read(b[:])
+
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// requestLimiter caps the requests each client may make per window. Windows
+// are fixed, starting with a client's first request, which makes the
+// RateLimit-Reset advertised to clients exact.
+type requestLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	clients map[string]*clientWindow
+	swept   time.Time
+}
+
+type clientWindow struct {
+	start time.Time
+	count int
+}
+
+func newRequestLimiter(limit int, window time.Duration) *requestLimiter {
+	return &requestLimiter{
+		limit:   limit,
+		window:  window,
+		clients: make(map[string]*clientWindow),
+		swept:   time.Now(),
+	}
+}
+
+// take counts a request from client. It returns the requests left in the
+// window and the time until it resets, and whether the request is allowed.
+func (l *requestLimiter) take(client string, now time.Time) (int, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget clients whose windows are over every so often, so the map
+	// doesn't keep everyone who ever made a request.
+	if now.Sub(l.swept) > l.window {
+		for c, w := range l.clients {
+			if now.Sub(w.start) >= l.window {
+				delete(l.clients, c)
+			}
+		}
+		l.swept = now
+	}
+
+	w := l.clients[client]
+	if w == nil || now.Sub(w.start) >= l.window {
+		w = &clientWindow{start: now}
+		l.clients[client] = w
+	}
+	reset := w.start.Add(l.window).Sub(now)
+
+	if w.count >= l.limit {
+		return 0, reset, false
+	}
+	w.count++
+
+	return l.limit - w.count, reset, true
+}
+
+// limitRequest applies the request limit to req, adding the RateLimit
+// headers to the response on ex. It reports whether the request may go
+// ahead; if not, it has been answered with 429.
+func (s *Server) limitRequest(ex *exchangeConn, req request) bool {
+	remaining, reset, ok := s.limits.take(req.clientIP, time.Now())
+	// Round up, so clients waiting that long are sure to find a new window.
+	resetSecs := strconv.Itoa(int((reset + time.Second - 1) / time.Second))
+
+	addResponseHeader(ex, "RateLimit-Limit", strconv.Itoa(s.limits.limit))
+	addResponseHeader(ex, "RateLimit-Remaining", strconv.Itoa(remaining))
+	addResponseHeader(ex, "RateLimit-Reset", resetSecs)
+	if ok {
+		return true
+	}
+
+	h := header{"Retry-After": {resetSecs}}
+	ex.Write(buildResponseHeaders(statusTooManyRequests, h, nil))
+
+	return false
+}
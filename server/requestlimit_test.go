@@ -0,0 +1,46 @@
+package server_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestRequestLimit(t *testing.T) {
+	opts := servertest.Options()
+	opts.RequestLimit = 2
+	opts.RequestLimitWindow = time.Hour
+	c := servertest.NewPipe(t, opts).Client()
+
+	c.Get("/echo/a").
+		AssertStatus(http.StatusOK).
+		AssertHeader("RateLimit-Limit", "2").
+		AssertHeader("RateLimit-Remaining", "1")
+	c.Get("/echo/b").AssertStatus(http.StatusOK).AssertHeader("RateLimit-Remaining", "0")
+
+	resp := c.Get("/echo/c").
+		AssertStatus(http.StatusTooManyRequests).
+		AssertHeader("RateLimit-Remaining", "0")
+	retry, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || retry < 3590 || retry > 3600 {
+		t.Errorf("got Retry-After %q, want about an hour", resp.Header.Get("Retry-After"))
+	}
+	if resp.Header.Get("RateLimit-Reset") != resp.Header.Get("Retry-After") {
+		t.Errorf("got RateLimit-Reset %q, want it to match Retry-After", resp.Header.Get("RateLimit-Reset"))
+	}
+}
+
+func TestRequestLimitWindow(t *testing.T) {
+	opts := servertest.Options()
+	opts.RequestLimit = 1
+	opts.RequestLimitWindow = 50 * time.Millisecond
+	c := servertest.NewPipe(t, opts).Client()
+
+	c.Get("/").AssertStatus(http.StatusOK)
+	c.Get("/").AssertStatus(http.StatusTooManyRequests)
+	time.Sleep(60 * time.Millisecond)
+	c.Get("/").AssertStatus(http.StatusOK)
+}
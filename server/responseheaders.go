@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"mime"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// responseRule changes a header of the responses to paths matching path
+// whose status and content type match too.
+type responseRule struct {
+	path *regexp.Regexp
+	// statuses are the codes matched, a multiple of 100 standing for its
+	// whole class; empty matches any.
+	statuses []int
+	// mediaType is the content type matched, as type/subtype or type/*; ""
+	// matches any, responses without one included.
+	mediaType string
+	action    string
+	name      string
+	value     string
+}
+
+// globPattern compiles a path pattern in which * matches any run of
+// characters, slashes included, and ? any one.
+func globPattern(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+// parseStatuses parses a status pattern: * for any, or a comma-separated
+// list of codes like 200 and classes like 4xx.
+func parseStatuses(s string) ([]int, error) {
+	if s == "*" {
+		return nil, nil
+	}
+
+	var codes []int
+	for _, c := range strings.Split(s, ",") {
+		class, ok := strings.CutSuffix(strings.ToLower(c), "xx")
+		if ok && len(class) == 1 && class >= "1" && class <= "5" {
+			codes = append(codes, int(class[0]-'0')*100)
+			continue
+		}
+		code, err := strconv.Atoi(c)
+		if err != nil || code < 100 || code > 599 || code%100 == 0 {
+			return nil, fmt.Errorf("invalid status %q", c)
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// loadResponseRules reads the response header rules file at path. Each line
+// holds a path pattern, a status pattern, a content type pattern (* for
+// any of them), an action (set, add or remove), a header name and, unless
+// removing, a value running to the end of the line. Blank lines and lines
+// starting with # are skipped.
+func loadResponseRules(path string) ([]responseRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening response header rules: %v", err)
+	}
+	defer f.Close()
+
+	var rules []responseRule
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("%s:%d: expected 'path status type set|add|remove Name [value]'", path, n)
+		}
+
+		var rule responseRule
+		if rule.path, err = globPattern(fields[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if rule.statuses, err = parseStatuses(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if fields[2] != "*" {
+			if t, sub, ok := strings.Cut(fields[2], "/"); !ok || t == "" || sub == "" {
+				return nil, fmt.Errorf("%s:%d: invalid content type %q", path, n, fields[2])
+			}
+			rule.mediaType = strings.ToLower(fields[2])
+		}
+		rule.action, rule.name = fields[3], fields[4]
+		if !validHeaderName(rule.name) {
+			return nil, fmt.Errorf("%s:%d: invalid header name %q", path, n, rule.name)
+		}
+		if isHopHeader(rule.name) || strings.EqualFold(rule.name, "Content-Length") {
+			return nil, fmt.Errorf("%s:%d: %s frames the response and can't be changed", path, n, rule.name)
+		}
+
+		// The value is the rest of the line, spaces and all.
+		for _, field := range fields[:5] {
+			line = strings.TrimSpace(strings.TrimPrefix(line, field))
+		}
+		rule.value = line
+		switch rule.action {
+		case headerSet, headerAdd:
+			if rule.value == "" {
+				return nil, fmt.Errorf("%s:%d: %s needs a value", path, n, rule.action)
+			}
+		case headerRemove:
+			if rule.value != "" {
+				return nil, fmt.Errorf("%s:%d: remove takes no value", path, n)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown action %q, want set, add or remove", path, n, rule.action)
+		}
+		rules = append(rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading response header rules: %v", err)
+	}
+
+	return rules, nil
+}
+
+func (r responseRule) matches(path string, status int, contentType string) bool {
+	if !r.path.MatchString(path) {
+		return false
+	}
+
+	if len(r.statuses) > 0 {
+		found := false
+		for _, s := range r.statuses {
+			found = found || s == status || s%100 == 0 && s == status/100*100
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if r.mediaType != "" {
+		t, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return false
+		}
+		if class, ok := strings.CutSuffix(r.mediaType, "/*"); ok {
+			return strings.HasPrefix(t, class+"/")
+		}
+		return t == r.mediaType
+	}
+
+	return true
+}
+
+// applyResponseRules returns the response head with the rules matching it
+// and path applied in order.
+func applyResponseRules(head []byte, path string, rules []responseRule) []byte {
+	lines := bytes.Split(head, []byte("\r\n"))
+	status := headStatus(head)
+	h := map[string][]string{}
+	var names []string
+	for _, line := range lines[1:] {
+		name, value, _ := bytes.Cut(line, []byte(":"))
+		k := string(bytes.TrimSpace(name))
+		if k == "" {
+			continue
+		}
+		if _, ok := h[k]; !ok {
+			names = append(names, k)
+		}
+		h[k] = append(h[k], string(bytes.TrimSpace(value)))
+	}
+	get := func(name string) []string {
+		for _, k := range names {
+			if strings.EqualFold(k, name) {
+				return h[k]
+			}
+		}
+		return nil
+	}
+
+	changed := false
+	for _, rule := range rules {
+		var contentType string
+		if v := get("Content-Type"); len(v) > 0 {
+			contentType = v[0]
+		}
+		if !rule.matches(path, status, contentType) {
+			continue
+		}
+		changed = true
+
+		// Change the header under the name the head already has it by,
+		// whatever its case.
+		k := rule.name
+		for _, n := range names {
+			if strings.EqualFold(n, rule.name) {
+				k = n
+			}
+		}
+		if _, ok := h[k]; !ok && rule.action != headerRemove {
+			names = append(names, k)
+		}
+		switch rule.action {
+		case headerSet:
+			h[k] = []string{rule.value}
+		case headerAdd:
+			h[k] = append(h[k], rule.value)
+		case headerRemove:
+			delete(h, k)
+			names = slices.DeleteFunc(names, func(n string) bool { return n == k })
+		}
+	}
+	if !changed {
+		return head
+	}
+
+	var out bytes.Buffer
+	out.Write(lines[0])
+	for _, k := range names {
+		for _, v := range h[k] {
+			out.WriteString("\r\n" + k + ": " + v)
+		}
+	}
+
+	return out.Bytes()
+}
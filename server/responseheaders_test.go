@@ -0,0 +1,75 @@
+package server_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestResponseHeaders(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "headers")
+	os.WriteFile(rules, []byte(`# cache assets for a day, but not errors
+/files/assets/*  2xx,304  *          set    Cache-Control public, max-age=86400
+*                *        text/html  set    Content-Security-Policy default-src 'self'
+*                4xx      *          add    X-Error yes
+*                4xx      *          add    X-Error again
+/files/*.txt     *        text/*     remove Server
+`), 0o644)
+
+	opts := servertest.Options()
+	opts.ResponseHeaders = rules
+	s := servertest.NewPipe(t, opts)
+	c := s.Client()
+
+	c.Do(http.MethodPut, "/files/assets/app.js", strings.NewReader("x"), nil).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodPut, "/files/page.html", strings.NewReader("<p>hi</p>"), nil).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("a"), nil).AssertStatus(http.StatusCreated)
+
+	c.Get("/files/assets/app.js").
+		AssertStatus(http.StatusOK).
+		AssertHeader("Cache-Control", "public, max-age=86400").
+		AssertHeader("Content-Security-Policy", "").
+		AssertHeader("Content-Length", "1")
+	for _, p := range []string{"/files/%61ssets/app.js", "/files//assets/app.js", "/files/x/../assets/app.js"} {
+		c.Get(p).
+			AssertStatus(http.StatusOK).
+			AssertHeader("Cache-Control", "public, max-age=86400")
+	}
+	resp := c.Get("/files/assets/missing.js").
+		AssertStatus(http.StatusNotFound).
+		AssertHeader("Cache-Control", "")
+	if got := resp.Header.Values("X-Error"); len(got) != 2 || got[0] != "yes" || got[1] != "again" {
+		t.Errorf("got X-Error %q, want yes and again", got)
+	}
+	resp = c.Get("/files/page.html").
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Security-Policy", "default-src 'self'")
+	if resp.Header.Get("Server") == "" {
+		t.Error("a rule for text files removed the Server header of an HTML page")
+	}
+	c.Get("/files/a.txt").
+		AssertStatus(http.StatusOK).
+		AssertBody("a").
+		AssertHeader("Server", "")
+
+	for _, bad := range []string{
+		"/x * * set X-A\n",
+		"/x 600 * set X-A b\n",
+		"/x * text set X-A b\n",
+		"/x * * replace X-A b\n",
+		"/x * * remove X-A b\n",
+		"/x * * set Content-Length 1\n",
+		"/x * * set Transfer-Encoding chunked\n",
+		"/x *\n",
+	} {
+		os.WriteFile(rules, []byte(bad), 0o644)
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error for rules %q", bad)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// rewriteRule maps request paths matching pattern onto target, in which $1,
+// ${name} and so on expand to the groups of the match. A last rule ends the
+// rewriting; otherwise the rewritten path carries on down the list.
+type rewriteRule struct {
+	pattern *regexp.Regexp
+	target  string
+	last    bool
+}
+
+// loadRewriteRules reads the rules file at path. Each line holds a pattern,
+// a target and an optional flag, last or continue (the default), separated
+// by whitespace. Blank lines and lines starting with # are skipped.
+func loadRewriteRules(path string) ([]rewriteRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening rewrite rules: %v", err)
+	}
+	defer f.Close()
+
+	var rules []rewriteRule
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected 'pattern target [last|continue]'", path, n)
+		}
+
+		re, err := regexp.Compile(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		if !strings.HasPrefix(fields[1], "/") {
+			return nil, fmt.Errorf("%s:%d: target %q isn't an absolute path", path, n, fields[1])
+		}
+
+		rule := rewriteRule{pattern: re, target: fields[1]}
+		if len(fields) == 3 {
+			switch fields[2] {
+			case "last":
+				rule.last = true
+			case "continue":
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown flag %q", path, n, fields[2])
+			}
+		}
+		rules = append(rules, rule)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("error reading rewrite rules: %v", err)
+	}
+
+	return rules, nil
+}
+
+// rewrite applies the rules to the path of req in order. Rules see the
+// cleaned path, escaped again, so the targets they expand to are decoded
+// once more like any request path. The whole path is replaced by the
+// expanded target of each matching rule; a query in the target goes in
+// front of the one the client sent.
+func rewrite(req *request, rules []rewriteRule) {
+	for _, rule := range rules {
+		p := (&url.URL{Path: req.cleanPath()}).EscapedPath()
+		m := rule.pattern.FindStringSubmatchIndex(p)
+		if m == nil {
+			continue
+		}
+
+		target := string(rule.pattern.ExpandString(nil, rule.target, p, m))
+		path, query, _ := strings.Cut(target, "?")
+		if query != "" && req.rawQuery != "" {
+			query += "&" + req.rawQuery
+		} else if query == "" {
+			query = req.rawQuery
+		}
+
+		req.path = path
+		req.pathParts, _ = splitPath(path)
+		req.rawQuery = query
+		req.query, _ = url.ParseQuery(query)
+
+		if rule.last {
+			return
+		}
+	}
+}
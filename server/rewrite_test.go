@@ -0,0 +1,48 @@
+package server_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestRewriteRules(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rewrites")
+	os.WriteFile(rules, []byte(`# pretty URLs for the blog
+^/blog/([a-z]+)$   /files/posts/$1.html   last
+^/old/(.*)$        /new/$1
+^/new/(.*)$        /echo/$1?via=new
+`), 0o644)
+
+	opts := servertest.Options()
+	opts.RewriteRules = rules
+	c := servertest.NewPipe(t, opts).Client()
+
+	c.Do(http.MethodPut, "/files/posts/hello.html", strings.NewReader("<p>hi</p>"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/blog/hello").AssertStatus(http.StatusOK).AssertBody("<p>hi</p>")
+	c.Get("/blog/Hello").AssertStatus(http.StatusNotFound)
+	// Rules see the path as it is routed, however the client wrote it.
+	for _, p := range []string{"/%62log/hello", "//blog/hello", "/blog/./hello", "/x/../blog/hello"} {
+		c.Get(p).AssertStatus(http.StatusOK).AssertBody("<p>hi</p>")
+	}
+
+	// The second rule carries on into the third.
+	c.Get("/old/x/y").AssertStatus(http.StatusOK).AssertBody("x/y")
+	c.Get("/new/z?q=1").AssertBody("z")
+}
+
+func TestRewriteRulesInvalid(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rewrites")
+	os.WriteFile(rules, []byte("^/a$ /b sometimes\n"), 0o644)
+
+	opts := servertest.Options()
+	opts.RewriteRules = rules
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for an unknown flag")
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 1000
+	// maxGrepSize bounds the files whose contents are searched.
+	maxGrepSize = 1 << 20
+)
+
+var errSearchDone = errors.New("search done")
+
+type searchResult struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	// Line and Match are set for matches in the file's contents.
+	Line  int    `json:"line,omitempty"`
+	Match string `json:"match,omitempty"`
+}
+
+type searchPage struct {
+	Query   string         `json:"query"`
+	Results []searchResult `json:"results"`
+	// Next is the offset of the following page, or 0 on the last one.
+	Next int `json:"next,omitempty"`
+}
+
+// serveSearch answers GET /search?q=..., matching file names below the root
+// case-insensitively. With content=true the contents of small text files are
+// searched too. Results come in pages of limit, starting at offset.
+func (s *Server) serveSearch(conn net.Conn, req request) error {
+	q := strings.ToLower(req.query.Get("q"))
+	offset, err1 := queryInt(req, "offset", 0)
+	limit, err2 := queryInt(req, "limit", defaultSearchLimit)
+	if q == "" || err1 != nil || err2 != nil || offset < 0 || limit < 1 {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+	limit = min(limit, maxSearchLimit)
+	grep := req.query.Get("content") == "true"
+
+	page := searchPage{Query: req.query.Get("q"), Results: []searchResult{}}
+	skipped := 0
+	err := walkFiles(s.listable(req), "", func(name string, info fs.FileInfo) error {
+		r := searchResult{
+			Name:     name,
+			URL:      "/files/" + escapePath(name),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		}
+		if !strings.Contains(strings.ToLower(name), q) {
+			if !grep || info.Size() > maxGrepSize {
+				return nil
+			}
+			var ok bool
+			if r.Line, r.Match, ok = s.grepFile(name, q); !ok {
+				return nil
+			}
+		}
+
+		if skipped < offset {
+			skipped++
+			return nil
+		}
+		if len(page.Results) == limit {
+			page.Next = offset + limit
+			return errSearchDone
+		}
+		page.Results = append(page.Results, r)
+
+		return nil
+	})
+	if err != nil && err != errSearchDone {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return fmt.Errorf("error searching for %q: %v\n", q, err)
+	}
+	conn.Write(buildResponse(statusOK, jsonContent(page)))
+
+	return nil
+}
+
+// grepFile looks for q in the lines of a text file, returning the first
+// matching line and its number.
+func (s *Server) grepFile(name, q string) (int, string, bool) {
+	data, err := s.readFile(name)
+	if err != nil || !strings.HasPrefix(http.DetectContentType(data), "text/") {
+		return 0, "", false
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, maxGrepSize)
+	for n := 1; sc.Scan(); n++ {
+		if strings.Contains(strings.ToLower(sc.Text()), q) {
+			return n, sc.Text(), true
+		}
+	}
+
+	return 0, "", false
+}
+
+// walkFiles calls fn for every file below dir in name order, leaving out
+// the directories the server keeps for itself.
+func walkFiles(store storage, dir string, fn func(name string, info fs.FileInfo) error) error {
+	infos, err := store.List(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		name := joinName(dir, info.Name())
+		if reservedName(name) {
+			continue
+		}
+
+		if info.IsDir() {
+			err = walkFiles(store, name, fn)
+		} else {
+			err = fn(name, info)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func queryInt(req request, param string, def int) (int, error) {
+	v := req.query.Get(param)
+	if v == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(v)
+}
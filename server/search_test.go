@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestSearch(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	for name, body := range map[string]string{
+		"notes/Report-2024.txt": "quarterly numbers",
+		"notes/todo.txt":        "buy milk\nfile the report\n",
+		"img/report.png":        "\x89PNG\r\n\x1a\nreport",
+		"report.md":             "# Report",
+		"other.txt":             "nothing here",
+	} {
+		c.Do(http.MethodPut, "/files/"+name, strings.NewReader(body), nil).AssertStatus(http.StatusCreated)
+	}
+
+	type page struct {
+		Results []struct {
+			Name  string `json:"name"`
+			URL   string `json:"url"`
+			Line  int    `json:"line"`
+			Match string `json:"match"`
+		} `json:"results"`
+		Next int `json:"next"`
+	}
+	search := func(query string) page {
+		t.Helper()
+		var p page
+		resp := c.Get("/search?" + query).AssertStatus(http.StatusOK)
+		if err := json.Unmarshal(resp.Body, &p); err != nil {
+			t.Fatalf("error decoding %q: %v", resp.Body, err)
+		}
+		return p
+	}
+	names := func(p page) string {
+		var names []string
+		for _, r := range p.Results {
+			names = append(names, r.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if got := names(search("q=REPORT")); got != "img/report.png,notes/Report-2024.txt,report.md" {
+		t.Errorf("name search got %s", got)
+	}
+
+	p := search("q=report&content=true")
+	if got := names(p); got != "img/report.png,notes/Report-2024.txt,notes/todo.txt,report.md" {
+		t.Errorf("content search got %s", got)
+	}
+	if r := p.Results[2]; r.Line != 2 || r.Match != "file the report" || r.URL != "/files/notes/todo.txt" {
+		t.Errorf("got content match %+v", r)
+	}
+
+	p = search("q=report&limit=2")
+	if got := names(p); got != "img/report.png,notes/Report-2024.txt" || p.Next != 2 {
+		t.Errorf("first page got %s, next %d", got, p.Next)
+	}
+	p = search("q=report&limit=2&offset=2")
+	if got := names(p); got != "report.md" || p.Next != 0 {
+		t.Errorf("last page got %s, next %d", got, p.Next)
+	}
+
+	c.Get("/search").AssertStatus(http.StatusBadRequest)
+	c.Get("/search?q=x&limit=0").AssertStatus(http.StatusBadRequest)
+}
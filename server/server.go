@@ -0,0 +1,1377 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	serverVersion = "0.1"
+	serverName    = "naive-server¯\\_(ツ)_/¯"
+)
+
+const (
+	statusOK                   = 200
+	statusCreated              = 201
+	statusNoContent            = 204
+	statusPartialContent       = 206
+	statusMovedPermanently     = 301
+	statusFound                = 302
+	statusSeeOther             = 303
+	statusNotModified          = 304
+	statusInternalServerError  = 500
+	statusNotFound             = 404
+	statusBadRequest           = 400
+	statusUnauthorized         = 401
+	statusForbidden            = 403
+	statusMethodNotAllowed     = 405
+	statusConflict             = 409
+	statusLengthRequired       = 411
+	statusPayloadTooLarge      = 413
+	statusUnsupportedMediaType = 415
+	statusRangeNotSatisfiable  = 416
+	statusUpgradeRequired      = 426
+	statusTooManyRequests      = 429
+	statusBadGateway           = 502
+	statusServiceUnavailable   = 503
+	statusGatewayTimeout       = 504
+)
+
+const (
+	methodGet    = "GET"
+	methodHead   = "HEAD"
+	methodPost   = "POST"
+	methodPut    = "PUT"
+	methodDelete = "DELETE"
+	methodMove   = "MOVE"
+)
+
+const (
+	textStatusOK                   = "OK"
+	textStatusCreated              = "Created"
+	textStatusNoContent            = "No Content"
+	textStatusPartialContent       = "Partial Content"
+	textStatusMovedPermanently     = "Moved Permanently"
+	textStatusFound                = "Found"
+	textStatusSeeOther             = "See Other"
+	textStatusInternal             = "Internal Server Error"
+	textStatusNotFound             = "Not Found"
+	textStatusBadRequest           = "Bad Request"
+	textStatusUnauthorized         = "Unauthorized"
+	textStatusForbidden            = "Forbidden"
+	textStatusMethodNotAllowed     = "Method Not Allowed"
+	textStatusConflict             = "Conflict"
+	textStatusLengthRequired       = "Length Required"
+	textStatusPayloadTooLarge      = "Content Too Large"
+	textStatusUnsupportedMediaType = "Unsupported Media Type"
+	textStatusRangeNotSatisfiable  = "Range Not Satisfiable"
+	textStatusTooManyRequests      = "Too Many Requests"
+	textStatusBadGateway           = "Bad Gateway"
+	textStatusUnavailable          = "Service Unavailable"
+	textStatusGatewayTimeout       = "Gateway Timeout"
+)
+
+const (
+	contentTypeTextPlain   = "text/plain"
+	contentTypeTextHTML    = "text/html"
+	contentTypeOctetStream = "application/octet-stream"
+	contentTypeJSON        = "application/json"
+)
+
+// serveConns accepts connections on l until it is closed, handling each one
+// on its own goroutine and logging the errors it fails with to log.
+func serveConns(l net.Listener, log *slog.Logger, handle func(net.Conn) error) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("error accepting connection: %v", err)
+		}
+
+		go func() {
+			err := handle(conn)
+			if err != nil {
+				log.Info("request failed", "remote", conn.RemoteAddr().String(), "err", strings.TrimSpace(err.Error()))
+			}
+			conn.Close()
+		}()
+	}
+}
+
+type request struct {
+	method        string
+	httpVersion   string
+	host          string
+	userAgent     string
+	path          string
+	pathParts     []string
+	rawQuery      string
+	query         url.Values
+	contentLength int
+	chunked       bool
+	headers       header
+	remoteAddr    string
+	clientIP      string
+	scheme        string
+
+	// targetPath and targetQuery are the path and query the client asked
+	// for, before any rewrite rule changed them.
+	targetPath  string
+	targetQuery url.Values
+	// vary collects the request headers the response is negotiated on.
+	vary *[]string
+	// clientCert is the verified certificate the client presented, if any.
+	clientCert *x509.Certificate
+	// redirect is where the client is sent instead, if the host it asked
+	// for isn't the canonical one.
+	redirect string
+	// id identifies the request on error pages and in the access log.
+	id string
+	// claims are those of the Bearer token the jwt middleware verified.
+	claims map[string]any
+	// apiKey is the API key the apikey middleware let the request in with.
+	apiKey *apiKey
+	// geo is where the client is, as far as -geoip-db knows.
+	geo geoInfo
+	// connRate limits the file transfers on the connection the request
+	// came on, if -max-rate-per-conn is set.
+	connRate *rateLimiter
+	// ctx is cancelled once the client hangs up; use context.
+	ctx context.Context
+}
+
+// setRemoteAddr records the address of the peer the request came from.
+func (r *request) setRemoteAddr(addr net.Addr) {
+	r.scheme = "http"
+	r.remoteAddr = addr.String()
+	r.clientIP = r.remoteAddr
+	if host, _, err := net.SplitHostPort(r.remoteAddr); err == nil {
+		r.clientIP = host
+	}
+}
+
+// fileName returns the store name addressed by a /files/... request.
+func (r request) fileName() string {
+	if len(r.pathParts) < 3 {
+		return ""
+	}
+
+	return joinName(r.pathParts[2:]...)
+}
+
+// cleanPath returns the path req is routed by: percent-decoded, with empty
+// and dot segments resolved, keeping a trailing slash. Anything matching
+// requests by path looks at this rather than the raw path, so an escaped or
+// doubled form of a path can't get around what is set for it.
+func (r request) cleanPath() string {
+	if len(r.pathParts) < 2 {
+		return "/"
+	}
+	p := "/" + joinName(r.pathParts[1:]...)
+	if p != "/" && strings.HasSuffix(r.path, "/") {
+		p += "/"
+	}
+
+	return p
+}
+
+// target returns the request path including any query string.
+func (r request) target() string {
+	if r.rawQuery == "" {
+		return r.path
+	}
+
+	return r.path + "?" + r.rawQuery
+}
+
+type content struct {
+	contentType string
+	body        []byte
+}
+
+// Server is a naive HTTP server. Create one with New.
+type Server struct {
+	opts Options
+	log  *slog.Logger
+	// dumps is where -debug-dump writes the exchanges.
+	dumps   io.Writer
+	store   storage
+	proxy   *proxy
+	cert    *certificate
+	acme    *acmeManager
+	tlsCfg  *tls.Config
+	rate    *rateLimiter
+	limits  *requestLimiter
+	perIP   *connLimiter
+	bans    *banList
+	admit   *admission
+	thumbs  *thumbCache
+	files   *fileCache
+	locks   *pathLocks
+	catalog *catalog
+	chaos   *chaos
+	record  *recorder
+	dev     *devReloader
+	watcher *fileWatcher
+	chains  map[string]handler
+	listing atomic.Pointer[template.Template]
+	stats   serverStats
+
+	// timeouts are the handler timeouts of routes, keyed as chains.
+	timeouts map[string]time.Duration
+
+	// headers are sent on every response that doesn't set them itself,
+	// and charset is added to text/plain and text/html content types that
+	// don't name one; empty leaves them be.
+	headers header
+	charset string
+
+	// rewrites holds the rules loaded from -rewrite-rules, proxyHeaders
+	// and responseHeaders those from -proxy-headers and -response-headers,
+	// earlyHints those from -early-hints, mimeTypes the mapping from
+	// -mime-types, apiKeys the keys from -api-keys and geo the databases
+	// from -geoip-db.
+	rewrites        atomic.Pointer[[]rewriteRule]
+	proxyHeaders    atomic.Pointer[[]headerRule]
+	responseHeaders atomic.Pointer[[]responseRule]
+	earlyHints      atomic.Pointer[[]earlyHint]
+	mimeTypes       atomic.Pointer[mimeTypes]
+	apiKeys         atomic.Pointer[apiKeySet]
+	geo             atomic.Pointer[geoDBs]
+	// errorPages holds the templates from -error-pages used in place of
+	// empty bodies on error responses.
+	errorPages atomic.Pointer[errorPageSet]
+
+	accessLog *accessLog
+	audit     *auditLog
+	progress  *uploadProgress
+	keyLog    *os.File
+	clientCAs *x509.CertPool
+	htpasswd  *htpasswd
+	jwt       *jwtVerifier
+	oidc      *oidc
+	// uploadLinks are the links minted for uploading without credentials.
+	uploadLinks *uploadLinks
+	// access holds the .naive-access files of the directories served.
+	access *accessCache
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	// upgrades holds the handlers registered for Upgrade protocols.
+	upgrades map[string]UpgradeHandler
+	// idle holds keep-alive connections waiting for their next request, for
+	// shutdown to cut short. Once closing is set no more are let in.
+	idle    map[net.Conn]struct{}
+	closing bool
+	// active holds every connection being handled, for shutdown to close
+	// once its timeout is up.
+	active map[net.Conn]struct{}
+	// closed is closed on shutdown, to end responses that would otherwise
+	// run for as long as the client stays.
+	closed chan struct{}
+	// conns tracks connections being handled so shutdown can wait for them.
+	conns             sync.WaitGroup
+	shutdownRequested chan struct{}
+	shutdownOnce      sync.Once
+	// draining is set in drain mode, which drainTimer ends in a shutdown
+	// request.
+	draining   atomic.Bool
+	drainTimer *time.Timer
+}
+
+type serverStats struct {
+	started     time.Time
+	activeConns atomic.Int64
+	totalConns  atomic.Int64
+	requests    atomic.Int64
+}
+
+// New sets up a server with the given options, loading everything it needs
+// from disk.
+func New(opts Options) (*Server, error) {
+	log := NewLogger(opts)
+	headers := header{}
+	setDefaultHeader(headers, "Server", opts.ServerHeader)
+	for name, values := range opts.Headers {
+		headers[textproto.CanonicalMIMEHeaderKey(name)] = values
+	}
+	altSvc, err := altSvcHeader(opts.AltSvc, opts.AltSvcMaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -alt-svc: %v", err)
+	}
+	setDefaultHeader(headers, "Alt-Svc", altSvc)
+	if opts.ErrorFormat != errorFormatHTML && opts.ErrorFormat != errorFormatJSON {
+		return nil, fmt.Errorf("invalid -error-format %q: want html or json", opts.ErrorFormat)
+	}
+
+	if opts.File != "" {
+		if err := checkSingleFile(opts.File); err != nil {
+			return nil, fmt.Errorf("error opening file to serve: %v", err)
+		}
+	}
+
+	store, err := newStorage(opts.Storage, opts.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up storage: %v", err)
+	}
+	if opts.Dedup {
+		if store, err = newDedupStorage(store, log); err != nil {
+			return nil, fmt.Errorf("error setting up storage: %v", err)
+		}
+	}
+
+	s := &Server{
+		opts:              opts,
+		log:               log,
+		headers:           headers,
+		charset:           opts.Charset,
+		locks:             newPathLocks(),
+		idle:              make(map[net.Conn]struct{}),
+		active:            make(map[net.Conn]struct{}),
+		closed:            make(chan struct{}),
+		shutdownRequested: make(chan struct{}),
+		progress:          newUploadProgress(),
+		uploadLinks:       newUploadLinks(),
+		access:            newAccessCache(log),
+		dumps:             os.Stdout,
+	}
+	s.store = watchedStorage{storage: store, changed: s.filesChanged}
+	watchEvery := watchPollInterval
+	if opts.Dev {
+		watchEvery = devPollInterval
+	}
+	s.watcher = newFileWatcher(s.store, watchEvery, s.closed)
+	s.stats.started = time.Now()
+	if opts.MaxRate > 0 {
+		s.rate = newRateLimiter(opts.MaxRate)
+	}
+	if opts.RequestLimit > 0 {
+		s.limits = newRequestLimiter(opts.RequestLimit, opts.RequestLimitWindow)
+	}
+	if opts.MaxConnsPerIP > 0 {
+		s.perIP = newConnLimiter(opts.MaxConnsPerIP)
+	}
+	if opts.Ban.enabled() {
+		if opts.BanTime <= 0 || opts.BanMax < opts.BanTime {
+			return nil, fmt.Errorf("-ban-max must be at least -ban-time, which must be positive")
+		}
+		s.bans = newBanList(opts.Ban, opts.BanWindow, opts.BanTime, opts.BanMax, opts.TrustedProxies, log)
+	}
+	if opts.GzipLevel != gzip.DefaultCompression && (opts.GzipLevel < gzip.BestSpeed || opts.GzipLevel > gzip.BestCompression) {
+		return nil, fmt.Errorf("-gzip-level must be from 1 to 9, or -1")
+	}
+	if len(opts.GeoPolicy) > 0 && len(opts.GeoIPDB) == 0 {
+		return nil, fmt.Errorf("-geo-policy requires -geoip-db")
+	}
+	if opts.WebhookURL != "" && opts.WebhookTries < 1 {
+		return nil, fmt.Errorf("-webhook-tries must be at least 1")
+	}
+	if opts.MaxConcurrent > 0 {
+		s.admit = newAdmission(opts.MaxConcurrent, opts.QueueDepth, opts.QueueTimeout)
+	}
+	if opts.ThumbCacheSize > 0 {
+		s.thumbs = newThumbCache(opts.ThumbCacheSize)
+	}
+	if opts.FileCacheSize > 0 {
+		s.files = newFileCache(opts.FileCacheSize, opts.FileCacheMaxFile)
+	}
+	if opts.Htpasswd != "" {
+		if s.htpasswd, err = loadHtpasswd(opts.Htpasswd, log); err != nil {
+			return nil, err
+		}
+		if opts.HtpasswdReload > 0 {
+			go s.htpasswd.watch(opts.HtpasswdReload, s.closed)
+		}
+	}
+	if opts.OIDCIssuer != "" {
+		if s.oidc, err = newOIDC(opts, log); err != nil {
+			return nil, err
+		}
+	}
+	if opts.JWTSecret != "" || opts.JWTPublicKey != "" || opts.JWTJWKS != "" {
+		if s.jwt, err = newJWTVerifier(opts, log); err != nil {
+			return nil, err
+		}
+	}
+	if s.chains, err = s.buildChains(opts.Middleware); err != nil {
+		return nil, err
+	}
+	if s.timeouts, err = s.buildTimeouts(opts.RouteTimeouts); err != nil {
+		return nil, err
+	}
+
+	if len(opts.ACMEDomains) > 0 {
+		if s.acme, err = newACMEManager(opts, log); err != nil {
+			return nil, err
+		}
+		s.cert = s.acme.cert
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	if opts.Chaos.enabled() {
+		seed := opts.ChaosSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		s.log.Info("chaos mode on", "faults", (*chaosFlag)(&opts.Chaos).String(), "seed", seed)
+		s.chaos = newChaos(opts.Chaos, seed)
+	}
+
+	if opts.Dev {
+		s.dev = newDevReloader()
+		s.watcher.listen(func([]fileEvent) { s.dev.notify() })
+	}
+
+	if opts.TLSClientCA != "" {
+		if !opts.tlsEnabled() {
+			return nil, fmt.Errorf("verifying client certificates requires TLS")
+		}
+		s.clientCAs, err = loadClientCAs(opts.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client CAs: %v", err)
+		}
+	}
+	if opts.TLSKeyLog != "" {
+		if !opts.tlsEnabled() {
+			return nil, fmt.Errorf("logging TLS keys requires TLS")
+		}
+		s.keyLog, err = openKeyLog(opts.TLSKeyLog, log)
+		if err != nil {
+			return nil, fmt.Errorf("error opening TLS key log: %v", err)
+		}
+	}
+	if !opts.Stdio {
+		if _, err := parseHost(opts.Host); err != nil {
+			return nil, fmt.Errorf("invalid -host: %v", err)
+		}
+	}
+	if opts.Expires && opts.CacheMaxAge <= 0 {
+		return nil, fmt.Errorf("-expires requires a -cache-max-age")
+	}
+	if opts.TLSTicketRotation > 0 && !opts.tlsEnabled() {
+		return nil, fmt.Errorf("rotating session ticket keys requires TLS")
+	}
+	if s.cert != nil {
+		s.tlsCfg = s.tlsConfig()
+	}
+	if opts.AccessLog != "" {
+		s.accessLog, err = openAccessLog(opts.AccessLog, opts.AccessLogFormat, opts.AccessLogSample, opts.AccessLogSlow)
+		if err != nil {
+			return nil, fmt.Errorf("error opening access log: %v", err)
+		}
+	}
+	if opts.AuditLog != "" {
+		s.audit, err = openAuditLog(opts.AuditLog, log)
+		if err != nil {
+			return nil, fmt.Errorf("error opening audit log: %v", err)
+		}
+	}
+	if opts.Record != "" {
+		s.record, err = newRecorder(opts.Record, log)
+		if err != nil {
+			return nil, fmt.Errorf("error opening recording: %v", err)
+		}
+	}
+
+	if opts.Catalog != "" {
+		s.catalog, err = openCatalog(opts.Catalog, opts.CatalogMaxRecords)
+		if err != nil {
+			return nil, fmt.Errorf("error opening catalog: %v", err)
+		}
+	}
+
+	if opts.Proxy != "" {
+		s.proxy, err = newProxy(opts.Proxy, opts.ProxyCacheSize, opts.ProxyTLSSessionCache)
+		if err != nil {
+			return nil, fmt.Errorf("error setting up proxy: %v", err)
+		}
+		s.proxy.certHeaders = opts.ClientCertHeaders
+		s.proxy.claimsHeader = opts.JWTClaimsHeader
+		s.proxy.headerRules = &s.proxyHeaders
+		if opts.ProxyMirror != "" {
+			if opts.ProxyMirrorPercent <= 0 || opts.ProxyMirrorPercent > 100 {
+				return nil, fmt.Errorf("-proxy-mirror-percent must be above 0 and at most 100")
+			}
+			shadow, err := newProxy(opts.ProxyMirror, 0, opts.ProxyTLSSessionCache)
+			if err != nil {
+				return nil, fmt.Errorf("error setting up proxy mirror: %v", err)
+			}
+			shadow.certHeaders = s.proxy.certHeaders
+			shadow.claimsHeader = s.proxy.claimsHeader
+			shadow.headerRules = s.proxy.headerRules
+			s.proxy.mirror = newMirror(shadow, opts.ProxyMirrorPercent, log)
+		}
+	} else if opts.ProxyMirror != "" {
+		return nil, fmt.Errorf("-proxy-mirror requires -proxy")
+	}
+
+	if s.acme != nil {
+		go s.acme.run(s.closed)
+	}
+
+	return s, nil
+}
+
+// Serve accepts connections on l, wrapping it in TLS if a certificate is
+// configured, until the server is shut down.
+func (s *Server) Serve(l net.Listener) error {
+	if s.tlsCfg != nil {
+		l = tls.NewListener(l, s.tlsCfg)
+	}
+	s.track(l)
+
+	return serveConns(l, s.log, s.handleConn)
+}
+
+// ServeConn serves the requests arriving on the single connection conn,
+// wrapping it in TLS if a certificate is configured, until it is closed or
+// the server is shut down. conn is closed when it returns.
+func (s *Server) ServeConn(conn net.Conn) error {
+	if s.tlsCfg != nil {
+		conn = tls.Server(conn, s.tlsCfg)
+	}
+	defer conn.Close()
+
+	return s.handleConn(conn)
+}
+
+// Shutdown stops all listeners and waits for the connections being handled
+// to finish. Connections still busy after the ShutdownTimeout are closed,
+// and an error reports how many were cut short.
+func (s *Server) Shutdown() error {
+	s.mu.Lock()
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	s.listeners = nil
+	if !s.closing {
+		close(s.closed)
+	}
+	s.closing = true
+	for conn := range s.idle {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(drained)
+	}()
+
+	var err error
+	var timeout <-chan time.Time
+	if s.opts.ShutdownTimeout > 0 {
+		timer := time.NewTimer(s.opts.ShutdownTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-drained:
+	case <-timeout:
+		s.mu.Lock()
+		for conn := range s.active {
+			conn.Close()
+		}
+		err = fmt.Errorf("closed %d connections still active after %s", len(s.active), s.opts.ShutdownTimeout)
+		s.mu.Unlock()
+		<-drained
+	}
+
+	if s.catalog != nil {
+		s.catalog.close()
+	}
+	if s.record != nil {
+		s.record.close()
+	}
+	if s.accessLog != nil {
+		s.accessLog.close()
+	}
+	if s.audit != nil {
+		s.audit.close()
+	}
+	if s.keyLog != nil {
+		s.keyLog.Close()
+	}
+
+	return err
+}
+
+// ShutdownRequested is closed once a shutdown has been asked for through the
+// admin API.
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.shutdownRequested
+}
+
+func (s *Server) track(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners = append(s.listeners, l)
+}
+
+// Reload (re)reads everything the server loads from disk: the TLS
+// certificate, error pages, the listing template, the rewrite rules and the
+// MIME types. Nothing is swapped in unless all of it loads. It is what
+// SIGHUP does, and goes in the audit log as a reload by a signal.
+func (s *Server) Reload() error {
+	err := s.reload()
+	if s.audit != nil {
+		s.audit.record(auditEvent{Action: "reload", Actor: "signal"}, err)
+	}
+
+	return err
+}
+
+func (s *Server) reload() error {
+	var pages errorPageSet
+	if s.opts.ErrorPages != "" {
+		var err error
+		pages, err = loadErrorPages(s.opts.ErrorPages)
+		if err != nil {
+			return err
+		}
+	}
+
+	listing, err := loadListingTemplate(s.opts.ListingTheme, s.opts.ListingTemplate)
+	if err != nil {
+		return err
+	}
+
+	var rewrites []rewriteRule
+	if s.opts.RewriteRules != "" {
+		rewrites, err = loadRewriteRules(s.opts.RewriteRules)
+		if err != nil {
+			return err
+		}
+	}
+
+	var headerRules []headerRule
+	if s.opts.ProxyHeaders != "" {
+		headerRules, err = loadHeaderRules(s.opts.ProxyHeaders)
+		if err != nil {
+			return err
+		}
+	}
+
+	var responseRules []responseRule
+	if s.opts.ResponseHeaders != "" {
+		responseRules, err = loadResponseRules(s.opts.ResponseHeaders)
+		if err != nil {
+			return err
+		}
+	}
+
+	var hints []earlyHint
+	if s.opts.EarlyHints != "" {
+		hints, err = loadEarlyHints(s.opts.EarlyHints)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.htpasswd != nil {
+		if err := s.htpasswd.reload(); err != nil {
+			return err
+		}
+	}
+
+	var keys apiKeySet
+	if s.opts.APIKeys != "" {
+		keys, err = loadAPIKeys(s.opts.APIKeys)
+		if err != nil {
+			return err
+		}
+	}
+
+	geo, err := loadGeoDBs(s.opts.GeoIPDB, s.log)
+	if err != nil {
+		return err
+	}
+
+	var types mimeTypes
+	if s.opts.MIMETypesFile != "" {
+		types, err = loadMIMETypes(s.opts.MIMETypesFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	// ACME certificates are renewed, not reloaded.
+	if s.opts.tlsEnabled() && s.acme == nil {
+		if s.cert == nil {
+			s.cert, err = newCertificate(s.opts.TLSCert, s.opts.TLSKey)
+		} else {
+			err = s.cert.load()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s.errorPages.Store(&pages)
+	s.listing.Store(listing)
+	s.rewrites.Store(&rewrites)
+	s.proxyHeaders.Store(&headerRules)
+	s.responseHeaders.Store(&responseRules)
+	s.earlyHints.Store(&hints)
+	s.apiKeys.Store(&keys)
+	s.geo.Store(&geo)
+	s.mimeTypes.Store(&types)
+
+	return nil
+}
+
+// requestShutdown asks the owner of the server to shut it down gracefully.
+func (s *Server) requestShutdown() {
+	s.shutdownOnce.Do(func() { close(s.shutdownRequested) })
+}
+
+// setActive records conn as being handled, or no longer.
+func (s *Server) setActive(conn net.Conn, active bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if active {
+		s.active[conn] = struct{}{}
+	} else {
+		delete(s.active, conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) error {
+	if s.bans != nil && s.bans.banned(conn.RemoteAddr()) {
+		return nil
+	}
+	if s.perIP != nil {
+		if !s.perIP.acquire(conn.RemoteAddr()) {
+			return nil
+		}
+		defer s.perIP.release(conn.RemoteAddr())
+	}
+
+	s.conns.Add(1)
+	defer s.conns.Done()
+	s.stats.activeConns.Add(1)
+	defer s.stats.activeConns.Add(-1)
+	s.stats.totalConns.Add(1)
+	s.setActive(conn, true)
+	defer s.setActive(conn, false)
+
+	tlsConn, _ := conn.(*tls.Conn)
+	if s.opts.DebugDump {
+		d := newDumpConn(conn, s.opts.DebugDumpBody, s.dumps)
+		defer d.dump()
+		conn = d
+	}
+
+	pc := &peerConn{Conn: conn}
+	if s.opts.MaxRatePerConn > 0 {
+		pc.rate = newRateLimiter(s.opts.MaxRatePerConn)
+	}
+	conn = pc
+	reqReader := bufio.NewReader(pc)
+	for n := 1; ; n++ {
+		if n > 1 && !s.awaitRequest(conn, reqReader) {
+			return nil
+		}
+		if n > 1 && s.bans != nil && s.bans.banned(conn.RemoteAddr()) {
+			return nil
+		}
+
+		reusable, err := s.handleRequest(pc, reqReader, tlsConn, n)
+		if err != nil || !reusable {
+			return err
+		}
+	}
+}
+
+// handleRequest reads and answers the nth request on a connection, which
+// tlsConn is the TLS side of if it isn't plain. It reports whether the
+// connection can be used for another request.
+func (s *Server) handleRequest(conn *peerConn, reqReader *bufio.Reader, tlsConn *tls.Conn, n int) (bool, error) {
+	req, err := parseRequest(reqReader, s.opts.StrictHTTP)
+	if errors.Is(err, errMalformedRequest) {
+		if s.bans != nil {
+			s.bans.offend(conn.RemoteAddr(), offenceMalformed)
+		}
+		(&defaultsConn{Conn: conn, srv: s}).Write(buildResponse(statusBadRequest, nil))
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+	req.setRemoteAddr(conn.RemoteAddr())
+	req.connRate = conn.rate
+	if tlsConn != nil {
+		req.scheme = "https"
+		if state := tlsConn.ConnectionState(); len(state.VerifiedChains) > 0 {
+			req.clientCert = state.PeerCertificates[0]
+		}
+	}
+	req.applyForwarded(s.opts.TrustedProxies)
+	if req.redirect = s.hostRedirect(req); req.redirect == "" {
+		rewrite(&req, *s.rewrites.Load())
+	}
+	s.stats.requests.Add(1)
+
+	req.id = requestID(req.headers)
+	if geo := *s.geo.Load(); len(geo) > 0 {
+		req.geo = geo.lookup(req.clientIP)
+	}
+	ex := &exchangeConn{Conn: conn, srv: s, reader: reqReader, requestID: req.id, rules: *s.responseHeaders.Load(), path: req.cleanPath()}
+	ex.jsonErrors, ex.errorsVary = s.wantsJSONErrors(req), s.opts.ErrorFormat == errorFormatJSON
+	ex.keepType = s.proxy != nil && req.redirect == ""
+	ex.headOnly = req.method == methodHead
+	if s.accessLog != nil {
+		defer s.accessLog.log(req, ex, time.Now())
+	}
+	if s.bans != nil {
+		defer s.bans.observe(conn.RemoteAddr(), ex)
+	}
+	if s.audit != nil {
+		if action := auditAction(req); action != "" {
+			defer s.auditRequest(action, req, ex)
+		}
+	}
+	ex.closeAfter = !req.keepAlive() || n == s.opts.KeepAliveMaxRequests || s.draining.Load()
+	req.vary = &ex.vary
+
+	body, status := req.body(reqReader)
+	if status != 0 {
+		ex.forceClose()
+		ex.Write(buildResponse(status, nil))
+		return false, nil
+	}
+	if req.contentLength < 0 && !req.chunked {
+		// The body runs to the end of the connection.
+		ex.forceClose()
+	}
+
+	if s.record != nil {
+		var done func()
+		body, done = s.record.record(req, body, ex)
+		defer done()
+	}
+	if s.chaos != nil {
+		if ex = s.chaos.inject(ex); ex == nil {
+			return false, nil
+		}
+	}
+	if s.limits != nil && !s.limitRequest(ex, req) {
+		return ex.reusable() && drainBody(body), nil
+	}
+	if !s.geoAllowed(req) {
+		ex.Write(buildResponse(statusForbidden, nil))
+		return ex.reusable() && drainBody(body), nil
+	}
+	if s.admit != nil {
+		release, ok := s.admit.enter(s.closed)
+		if !ok {
+			s.admit.shed(ex)
+			return ex.reusable() && drainBody(body), nil
+		}
+		defer release()
+	}
+
+	if h, token := s.upgradeHandler(req); h != nil {
+		upgrade(ex, reqReader, req, h, token)
+		return false, nil
+	}
+
+	// Once the request has been read in full, the client hanging up
+	// cancels its context, so what is being done for it can stop.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req.ctx = ctx
+	watch := func() {
+		if reqReader.Buffered() == 0 {
+			conn.watch(cancel)
+		}
+	}
+	switch {
+	case req.contentLength < 0 && !req.chunked:
+	case req.contentLength == 0 && !req.chunked:
+		watch()
+	default:
+		body = &eofReader{r: body, onEOF: watch}
+	}
+	defer conn.stopWatching()
+
+	if req.redirect == "" {
+		sendEarlyHints(ex, req, earlyLinks(req, *s.earlyHints.Load()))
+	}
+	if s.headAsGet(req) {
+		// Done before the route and timeout are looked up, so HEAD gets
+		// through no middleware that GET doesn't.
+		req.method = methodGet
+	}
+	if err := s.serveTimed(ex, body, req); err != nil {
+		return false, err
+	}
+	if ctx.Err() != nil {
+		return false, nil
+	}
+
+	return ex.reusable() && drainBody(body), nil
+}
+
+// headAsGet reports whether req is a HEAD request answered as the GET one
+// is, the exchange leaving the body out: one for a file, unless it is
+// proxied.
+func (s *Server) headAsGet(req request) bool {
+	if req.method != methodHead || s.proxy != nil || req.redirect != "" {
+		return false
+	}
+
+	return s.opts.File != "" || len(req.pathParts) >= 2 && req.pathParts[1] == "files"
+}
+
+func (s *Server) serveRequest(conn net.Conn, body io.Reader, req request) error {
+	if req.redirect != "" {
+		conn.Write(buildResponseHeaders(statusMovedPermanently, header{"Location": {req.redirect}}, nil))
+		return nil
+	}
+	if s.proxy != nil {
+		return s.proxy.serve(conn, body, req)
+	}
+	if s.opts.File != "" {
+		return s.serveSingleFile(conn, req)
+	}
+
+	if len(req.pathParts) < 2 {
+		conn.Write(buildResponse(statusBadRequest, nil))
+
+		return nil
+	}
+
+	if s.serveCORS(conn, req) {
+		return nil
+	}
+	if req.method == methodOptions {
+		s.serveOptions(conn, req)
+		return nil
+	}
+
+	if req.pathParts[1] == "trash" {
+		return s.serveTrash(conn, req)
+	}
+	if req.pathParts[1] == "files" && reservedName(req.fileName()) {
+		conn.Write(buildResponse(statusNotFound, nil))
+
+		return nil
+	}
+	if req.pathParts[1] == "files" && !s.checkAccess(conn, req) {
+		return nil
+	}
+
+	// Handle POST and PUT requests
+	if req.method == methodPost || req.method == methodPut {
+		if id := req.query.Get("progress"); id != "" && req.pathParts[1] == "files" {
+			if !validProgressID(id) {
+				conn.Write(buildResponse(statusBadRequest, nil))
+				return nil
+			}
+			size := int64(req.contentLength)
+			if req.chunked || size < 0 {
+				size = -1
+			}
+			var done func()
+			body, done = s.progress.track(id, body, size)
+			defer done()
+		}
+		body, status := s.decodeBody(verifyDigests(body, contentDigests(req)), req)
+		if status != 0 {
+			conn.Write(buildResponse(status, nil))
+			return nil
+		}
+		body = verifyDigests(body, reprDigests(req))
+		if req.pathParts[1] == "echo" && req.method == methodPost {
+			return s.serveEchoBody(conn, body, req)
+		}
+		if req.pathParts[1] == "upload" && req.method == methodPost && len(req.pathParts) == 3 {
+			if req.pathParts[2] == "links" {
+				return s.mintUploadLink(conn, req)
+			}
+			return s.uploadThroughLink(conn, body, req, req.pathParts[2])
+		}
+		if version := req.query.Get("restore"); version != "" && req.method == methodPost && req.pathParts[1] == "files" {
+			return s.restoreVersion(conn, req.fileName(), version)
+		}
+		if boundary, ok := isMultipartForm(req); ok && req.method == methodPost && req.pathParts[1] == "files" {
+			return s.postForm(conn, body, req, boundary)
+		}
+		if req.pathParts[1] == "files" && len(req.pathParts) > 2 {
+			return s.putFile(conn, body, req)
+		}
+
+		conn.Write(buildResponse(statusNotFound, nil))
+
+		return nil
+	}
+
+	// Handle GET requests
+	if req.method == methodGet {
+		switch req.pathParts[1] {
+		case "":
+			conn.Write(buildResponse(statusOK, nil))
+		case "echo":
+			c := content{
+				contentType: contentTypeTextPlain,
+				body:        []byte(strings.Join(req.pathParts[2:], "/")),
+			}
+			conn.Write(buildResponse(statusOK, &c))
+		case "upload":
+			if len(req.pathParts) == 4 && req.pathParts[2] == "progress" {
+				return s.serveUploadProgress(conn, req, req.pathParts[3])
+			}
+			if len(req.pathParts) == 3 && req.pathParts[2] != "" {
+				s.serveUploadLinkPage(conn, req.pathParts[2])
+				return nil
+			}
+			serveUploadPage(conn, "/files/")
+		case "user-agent":
+			c := content{
+				contentType: contentTypeTextPlain,
+				body:        []byte(req.userAgent),
+			}
+			conn.Write(buildResponse(statusOK, &c))
+		case "ip":
+			conn.Write(buildResponse(statusOK, ipContent(req)))
+		case "headers":
+			conn.Write(buildResponse(statusOK, headersContent(req)))
+		case "status":
+			return s.serveStatus(conn, req)
+		case "delay":
+			return s.serveDelay(conn, req)
+		case "echo-stream":
+			return s.serveEchoStream(conn, req)
+		case "files":
+			return s.getFile(conn, req)
+		case "_dev":
+			if len(req.pathParts) == 3 && req.pathParts[2] == "events" {
+				return s.serveDevEvents(conn, req)
+			}
+			conn.Write(buildResponse(statusNotFound, nil))
+		case "search":
+			return s.serveSearch(conn, req)
+		case "ui":
+			return s.serveUI(conn, req)
+		case "oidc":
+			return s.serveOIDC(conn, req)
+		case "healthz":
+			serveHealth(conn, s.closed)
+		case "readyz":
+			s.serveReady(conn)
+		case "watch":
+			return s.serveWatch(conn, req)
+		default:
+			conn.Write(buildResponse(statusNotFound, nil))
+		}
+
+		return nil
+	}
+
+	// Handle the file operations of the file manager
+	if (req.method == methodDelete || req.method == methodMove) && s.authEnabled() {
+		if req.pathParts[1] != "files" || len(req.pathParts) < 3 {
+			conn.Write(buildResponse(statusNotFound, nil))
+			return nil
+		}
+		if !s.authorized(req) {
+			conn.Write(unauthorized())
+			return nil
+		}
+		if req.method == methodDelete {
+			return s.deleteFile(conn, req)
+		}
+
+		return s.moveFile(conn, req)
+	}
+
+	conn.Write(buildResponse(statusMethodNotAllowed, nil))
+
+	return nil
+}
+
+// splitPath splits a request path into its segments, percent-decoding each,
+// so names escaped in links are found. A segment with an invalid escape is
+// left as it is, and the error is returned.
+func splitPath(p string) ([]string, error) {
+	var err error
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		if s, uerr := url.PathUnescape(part); uerr == nil {
+			parts[i] = s
+		} else {
+			err = fmt.Errorf("%w: invalid escape in path %q", errMalformedRequest, p)
+		}
+	}
+
+	return parts, err
+}
+
+// parseRequest reads a request line and headers from reqReader. In strict
+// mode anything RFC 9112 doesn't allow, like bare LF line endings or
+// whitespace before the colon of a header, fails the request with an error
+// wrapping errMalformedRequest; otherwise it is tolerated as far as it can be.
+func parseRequest(reqReader *bufio.Reader, strict bool) (request, error) {
+	var req request
+
+	reqStr, err := reqReader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return req, fmt.Errorf("error reading request line bytes: %v\n", err)
+	}
+	if strict {
+		if err := checkRequestLine(reqStr); err != nil {
+			return req, err
+		}
+	}
+
+	n, err := fmt.Sscanf(string(reqStr), "%s %s %s\r\n", &req.method, &req.path, &req.httpVersion)
+	if err != nil {
+		return req, fmt.Errorf("error reading request string: %v\n", err)
+	}
+	if n != 3 {
+		return req, fmt.Errorf("error reading request string: expected 3 parts")
+	}
+
+	// Split off the query string
+	req.path, req.rawQuery, _ = strings.Cut(req.path, "?")
+	req.query, _ = url.ParseQuery(req.rawQuery)
+	req.targetPath, req.targetQuery = req.path, req.query
+
+	// Parse path parts
+	if req.pathParts, err = splitPath(strings.Trim(req.path, "\r\n ")); err != nil {
+		return req, err
+	}
+
+	// Parse headers
+	var last string
+	for {
+		headerStr, err := reqReader.ReadBytes('\n')
+		if err != nil {
+			return req, fmt.Errorf("error reading header line bytes: %v\n", err)
+		}
+
+		if strict && !bytes.HasSuffix(headerStr, []byte("\r\n")) {
+			return req, fmt.Errorf("%w: header line not ending in CRLF", errMalformedRequest)
+		}
+		if len(bytes.TrimRight(headerStr, "\r\n")) == 0 {
+			break
+		}
+
+		if headerStr[0] == ' ' || headerStr[0] == '\t' {
+			// An obsolete line folding continues the previous header.
+			if strict {
+				return req, fmt.Errorf("%w: folded header line", errMalformedRequest)
+			}
+			unfoldHeader(&req, last, headerStr)
+			continue
+		}
+
+		last, err = parseHeader(headerStr, &req, strict)
+		if err != nil {
+			return req, err
+		}
+	}
+
+	return req, nil
+}
+
+// errMalformedRequest is wrapped by the parse errors of requests that are
+// answered with 400 rather than just dropped.
+var errMalformedRequest = errors.New("malformed request")
+
+// checkRequestLine enforces the request line syntax of RFC 9112: a method
+// token, a target and an HTTP version separated by single spaces and ended
+// with CRLF.
+func checkRequestLine(line []byte) error {
+	rest, ok := bytes.CutSuffix(line, []byte("\r\n"))
+	if !ok {
+		return fmt.Errorf("%w: request line not ending in CRLF", errMalformedRequest)
+	}
+
+	parts := strings.Split(string(rest), " ")
+	if len(parts) != 3 || !validHeaderName(parts[0]) || parts[1] == "" || strings.ContainsAny(parts[1], "\t\x00\x7f") {
+		return fmt.Errorf("%w: invalid request line %q", errMalformedRequest, rest)
+	}
+	version := parts[2]
+	if len(version) != 8 || !strings.HasPrefix(version, "HTTP/") || !isDigit(version[5]) || version[6] != '.' || !isDigit(version[7]) {
+		return fmt.Errorf("%w: invalid HTTP version %q", errMalformedRequest, version)
+	}
+
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parseHeader adds the header on line to req, returning its name, or "" if
+// the line was dropped.
+func parseHeader(line []byte, req *request, strict bool) (string, error) {
+	name, value, ok := strings.Cut(string(line), ":")
+	if strict && (!ok || !validHeaderName(name)) {
+		return "", fmt.Errorf("%w: invalid header line %q", errMalformedRequest, line)
+	}
+	name = strings.Trim(name, "\n\r ")
+	if !validHeaderName(name) {
+		// Lenient parsing drops what it can't make sense of.
+		return "", nil
+	}
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	value = strings.Trim(value, "\r\n ")
+
+	if req.headers == nil {
+		req.headers = make(header)
+	}
+	req.headers.add(name, value)
+	req.setHeaderField(name, value)
+
+	return name, nil
+}
+
+// unfoldHeader joins an obs-fold continuation line onto the last value of
+// the header name with a space, as RFC 9112 asks of servers that don't
+// reject them. Continuations of a dropped header are dropped as well.
+func unfoldHeader(req *request, name string, line []byte) {
+	values := req.headers[name]
+	if len(values) == 0 {
+		return
+	}
+
+	v := &values[len(values)-1]
+	if cont := strings.Trim(string(line), "\r\n \t"); cont != "" {
+		if *v != "" {
+			*v += " "
+		}
+		*v += cont
+	}
+	req.setHeaderField(name, *v)
+}
+
+// setHeaderField keeps the request fields mirroring headers up to date.
+func (r *request) setHeaderField(name, value string) {
+	switch name {
+	case "Host":
+		r.host = value
+	case "User-Agent":
+		r.userAgent = value
+	}
+}
+
+// buildResponse builds a response with the given content. Error responses
+// without content get their error page as they are written, if they have
+// one, and every response the server's default headers; see withErrorPage
+// and withDefaults.
+func buildResponse(respType int, content *content) []byte {
+	return buildResponseHeaders(respType, nil, content)
+}
+
+func buildResponseHeaders(respType int, headers header, content *content) []byte {
+	var resp bytes.Buffer
+
+	// Add return status
+	resp.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", respType, statusText(respType)))
+
+	// Add headers
+	resp.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format("Mon, 02 Jan 2006 15:04:05 MST")))
+	for _, k := range headers.keys() {
+		for _, v := range headers[k] {
+			resp.WriteString(fmt.Sprintf("%s: %s\r\n", k, v))
+		}
+	}
+	if content != nil {
+		resp.WriteString(fmt.Sprintf("Content-Type: %s\r\n", content.contentType))
+		resp.WriteString(fmt.Sprintf("Content-Length: %d\r\n", len(content.body)))
+	} else if bodyAllowed(respType) && headers.get("Content-Length") == "" && headers.get("Transfer-Encoding") == "" &&
+		!hasToken(headers.get("Connection"), "close") {
+		// Mark the empty body so the connection can be kept alive. A body
+		// framed by its transfer coding mustn't carry a length as well.
+		resp.WriteString("Content-Length: 0\r\n")
+	}
+	resp.WriteString("\r\n")
+
+	if content != nil {
+		// Add content
+		resp.Write(content.body)
+	}
+
+	return resp.Bytes()
+}
+
+func statusText(code int) string {
+	switch code {
+	case statusOK:
+		return textStatusOK
+	case statusCreated:
+		return textStatusCreated
+	case statusNoContent:
+		return textStatusNoContent
+	case statusPartialContent:
+		return textStatusPartialContent
+	case statusMovedPermanently:
+		return textStatusMovedPermanently
+	case statusFound:
+		return textStatusFound
+	case statusSeeOther:
+		return textStatusSeeOther
+	case statusBadRequest:
+		return textStatusBadRequest
+	case statusUnauthorized:
+		return textStatusUnauthorized
+	case statusForbidden:
+		return textStatusForbidden
+	case statusNotFound:
+		return textStatusNotFound
+	case statusMethodNotAllowed:
+		return textStatusMethodNotAllowed
+	case statusConflict:
+		return textStatusConflict
+	case statusLengthRequired:
+		return textStatusLengthRequired
+	case statusPayloadTooLarge:
+		return textStatusPayloadTooLarge
+	case statusUnsupportedMediaType:
+		return textStatusUnsupportedMediaType
+	case statusRangeNotSatisfiable:
+		return textStatusRangeNotSatisfiable
+	case statusTooManyRequests:
+		return textStatusTooManyRequests
+	case statusInternalServerError:
+		return textStatusInternal
+	case statusBadGateway:
+		return textStatusBadGateway
+	case statusServiceUnavailable:
+		return textStatusUnavailable
+	case statusGatewayTimeout:
+		return textStatusGatewayTimeout
+	}
+
+	// Fall back to the standard text for codes passed through from elsewhere,
+	// e.g. an upstream when proxying.
+	return http.StatusText(code)
+}
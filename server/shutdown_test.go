@@ -0,0 +1,42 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestShutdownTimeout(t *testing.T) {
+	opts := servertest.Options()
+	opts.ShutdownTimeout = 100 * time.Millisecond
+	s := servertest.New(t, opts)
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /delay/5s HTTP/1.1\r\n\r\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := s.Shutdown(); err == nil {
+		t.Error("got no error with a connection cut short")
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("shutdown took %s, want about the timeout", took)
+	}
+}
+
+func TestShutdownDrained(t *testing.T) {
+	opts := servertest.Options()
+	opts.ShutdownTimeout = time.Second
+	s := servertest.New(t, opts)
+
+	s.Client().Get("/echo/hi").AssertStatus(http.StatusOK)
+	if err := s.Shutdown(); err != nil {
+		t.Errorf("got error %v with nothing left to drain", err)
+	}
+}
@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestSignedURLs(t *testing.T) {
+	opts := servertest.Options()
+	opts.SignedURLSecret = "s3cret"
+	opts.UIAuth = "me:secret"
+	opts.Middleware = []server.RouteMiddleware{{Route: "GET /files", Middleware: []string{"signed"}}}
+	s := servertest.New(t, opts)
+	c := s.Client()
+
+	c.Do(http.MethodPut, "/files/private/a b.txt", strings.NewReader("shh"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/private/a%20b.txt").AssertStatus(http.StatusUnauthorized)
+	c.Do(http.MethodGet, "/files/private/a%20b.txt", nil, basicAuth("me", "secret")).AssertStatus(http.StatusOK)
+
+	hour := time.Now().Add(time.Hour)
+	c.Get(server.SignURL("s3cret", "/files/private/a%20b.txt", hour, "")).AssertStatus(http.StatusOK).AssertBody("shh")
+	c.Get(server.SignURL("s3cret", "/files/private/a%20b.txt", hour, "127.0.0.1")).AssertStatus(http.StatusOK)
+
+	for _, target := range []string{
+		server.SignURL("s3cret", "/files/private/a%20b.txt", time.Now().Add(-time.Second), ""),
+		server.SignURL("s3cret", "/files/private/a%20b.txt", hour, "192.0.2.1"),
+		server.SignURL("guess", "/files/private/a%20b.txt", hour, ""),
+		strings.Replace(server.SignURL("s3cret", "/files/private/other.txt", hour, ""), "other.txt", "a%20b.txt", 1),
+		strings.Replace(server.SignURL("s3cret", "/files/private/a%20b.txt", hour, "192.0.2.1"), "192.0.2.1", "127.0.0.1", 1),
+	} {
+		c.Get(target).AssertStatus(http.StatusForbidden)
+	}
+
+	opts.SignedURLSecret = ""
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error for the signed middleware without a secret")
+	}
+}
+
+func TestSignedURLsRewritten(t *testing.T) {
+	opts := servertest.Options()
+	opts.SignedURLSecret = "s3cret"
+	opts.Middleware = []server.RouteMiddleware{{Route: "GET /files", Middleware: []string{"signed"}}}
+	opts.RewriteRules = filepath.Join(t.TempDir(), "rules")
+	rules := "^/share/(.*)$ /files/private/$1\n^/leak$ /files/private/a.txt?expires=9999999999\n"
+	if err := os.WriteFile(opts.RewriteRules, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := servertest.New(t, opts).Client()
+
+	c.Do(http.MethodPut, "/files/private/a.txt", strings.NewReader("shh"), nil).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodPut, "/files/private/b.txt", strings.NewReader("other"), nil).AssertStatus(http.StatusCreated)
+
+	// A signature is for the URL the client asks for, not what it is
+	// rewritten to.
+	hour := time.Now().Add(time.Hour)
+	c.Get(server.SignURL("s3cret", "/share/a.txt", hour, "")).AssertStatus(http.StatusOK).AssertBody("shh")
+	c.Get(server.SignURL("s3cret", "/files/private/a.txt", hour, "")).AssertStatus(http.StatusOK).AssertBody("shh")
+	for _, target := range []string{
+		strings.Replace(server.SignURL("s3cret", "/files/private/a.txt", hour, ""), "/files/private/", "/share/", 1),
+		strings.Replace(server.SignURL("s3cret", "/share/a.txt", hour, ""), "a.txt", "b.txt", 1),
+		// The query a rule adds vouches for nothing.
+		"/leak?signature=" + strings.Split(server.SignURL("s3cret", "/files/private/a.txt", time.Unix(9999999999, 0), ""), "signature=")[1],
+	} {
+		c.Get(target).AssertStatus(http.StatusForbidden)
+	}
+}
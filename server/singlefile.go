@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// checkSingleFile makes sure the -file to serve is a regular file, so a
+// typo fails at startup rather than on the first request.
+func checkSingleFile(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s isn't a regular file", name)
+	}
+
+	return nil
+}
+
+// serveSingleFile answers every request in -file mode: the file is served
+// at / and nothing else exists. It is read from disk on every request, so a
+// rebuilt artifact is picked up without a restart.
+func (s *Server) serveSingleFile(conn net.Conn, req request) error {
+	if req.path != "/" {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+	if req.method != methodGet {
+		conn.Write(buildResponseHeaders(statusMethodNotAllowed, header{"Allow": {methodGet + ", " + methodHead}}, nil))
+		return nil
+	}
+
+	name := s.opts.File
+	f, err := os.Open(name)
+	if err != nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return fmt.Errorf("error reading %s: %v\n", name, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return fmt.Errorf("error reading %s: %v\n", name, err)
+	}
+
+	h := fileValidators(info)
+	// Saving the page keeps the file's own name rather than the index of /.
+	h.set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": filepath.Base(name)}))
+
+	return s.serveContent(conn, req, s.contentType(name), f, h)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// acceptUpload reports whether a file may be stored under name with the
+// given content. The type it would be served as must be in the
+// -upload-types allowlist, if there is one, and with -upload-sniff its
+// content must not look like something else.
+func (s *Server) acceptUpload(name string, data []byte) bool {
+	served := s.contentType(name)
+	if len(s.opts.UploadTypes) > 0 && !typeAllowed(served, s.opts.UploadTypes) {
+		return false
+	}
+	if s.opts.UploadSniff && sniffConflicts(http.DetectContentType(data), served) {
+		return false
+	}
+
+	return true
+}
+
+// typeAllowed reports whether contentType matches one of the patterns, which
+// are media types or type/* wildcards.
+func typeAllowed(contentType string, patterns []string) bool {
+	t := mediaType(contentType)
+	for _, p := range patterns {
+		if p == t || strings.HasSuffix(p, "/*") && strings.HasPrefix(t, p[:len(p)-1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sniffConflicts reports whether content sniffed as sniffed contradicts the
+// type served is expected from its name. The sniffer tells few types apart,
+// so only a specific type of a different kind counts: HTML anywhere but an
+// HTML name above all, as browsers may render it whatever it is served as.
+func sniffConflicts(sniffed, served string) bool {
+	got, want := mediaType(sniffed), mediaType(served)
+	switch {
+	case got == want:
+		return false
+	case got == "text/html":
+		return want != "application/xhtml+xml"
+	case got == "text/plain" || got == "application/octet-stream":
+		// Nothing more specific was recognised.
+		return false
+	case got == "text/xml":
+		return want != "application/xml" && !strings.HasSuffix(want, "+xml")
+	}
+
+	return mediaKind(got) != mediaKind(want)
+}
+
+// mediaKind is the top-level type of a media type, with audio and video
+// counted as one since their containers are shared.
+func mediaKind(t string) string {
+	kind, _, _ := strings.Cut(t, "/")
+	if kind == "audio" {
+		return "video"
+	}
+
+	return kind
+}
+
+// mediaType strips the parameters from a content type.
+func mediaType(contentType string) string {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(contentType)
+	}
+
+	return t
+}
+
+// typeList is a comma-separated list of media type patterns.
+type typeList []string
+
+func (l *typeList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return strings.Join(*l, ",")
+}
+
+func (l *typeList) Set(value string) error {
+	*l = nil
+	for _, p := range strings.Split(value, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if kind, sub, ok := strings.Cut(p, "/"); !ok || kind == "" || sub == "" {
+			return fmt.Errorf("expected a type like text/plain or image/*, got %q", p)
+		}
+		*l = append(*l, p)
+	}
+
+	return nil
+}
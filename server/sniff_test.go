@@ -0,0 +1,51 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestUploadSniff(t *testing.T) {
+	opts := servertest.Options()
+	opts.UploadSniff = true
+	c := servertest.NewPipe(t, opts).Client()
+
+	put := func(name, body string) *servertest.Response {
+		return c.Do(http.MethodPut, "/files/"+name, strings.NewReader(body), nil)
+	}
+	page := "<!DOCTYPE html><html><script>alert(1)</script></html>"
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+	put("page.html", page).AssertStatus(http.StatusCreated)
+	put("page.txt", page).AssertStatus(http.StatusUnsupportedMediaType)
+	put("image.png", page).AssertStatus(http.StatusUnsupportedMediaType)
+	put("image.png", png).AssertStatus(http.StatusCreated)
+	put("image.jpg", png).AssertStatus(http.StatusCreated)
+	put("doc.pdf", png).AssertStatus(http.StatusUnsupportedMediaType)
+	put("app.js", "alert(1)").AssertStatus(http.StatusCreated)
+	put("icon.svg", `<?xml version="1.0"?><svg/>`).AssertStatus(http.StatusCreated)
+	put("notes.txt", `<?xml version="1.0"?><a/>`).AssertStatus(http.StatusUnsupportedMediaType)
+	c.Get("/files/page.txt").AssertStatus(http.StatusNotFound)
+}
+
+func TestUploadTypes(t *testing.T) {
+	opts := servertest.Options()
+	opts.UploadTypes = []string{"image/*", "text/plain"}
+	c := servertest.NewPipe(t, opts).Client()
+
+	put := func(name string) *servertest.Response {
+		return c.Do(http.MethodPut, "/files/"+name, strings.NewReader("x"), nil)
+	}
+	put("a.png").AssertStatus(http.StatusCreated)
+	put("a.txt").AssertStatus(http.StatusCreated)
+	put("a.html").AssertStatus(http.StatusUnsupportedMediaType)
+	put("a.js").AssertStatus(http.StatusUnsupportedMediaType)
+	put("a").AssertStatus(http.StatusUnsupportedMediaType)
+
+	form := "--b\r\nContent-Disposition: form-data; name=\"f\"; filename=\"x.html\"\r\n\r\n<p>\r\n--b--\r\n"
+	c.Do(http.MethodPost, "/files/", strings.NewReader(form), http.Header{"Content-Type": {"multipart/form-data; boundary=b"}}).
+		AssertStatus(http.StatusUnsupportedMediaType)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+// StdioConn returns the connection a client reaches the server over when it
+// is started per connection, as by inetd or a systemd socket with
+// Accept=yes. If in is the accepted socket it is used directly; otherwise,
+// as when requests are piped in by a script, in and out are the two halves
+// of the connection.
+func StdioConn(in, out *os.File) net.Conn {
+	if conn, err := net.FileConn(in); err == nil {
+		return conn
+	}
+
+	return &stdioConn{in: in, out: out}
+}
+
+// stdioConn is a connection over a pair of files.
+type stdioConn struct {
+	in, out *os.File
+}
+
+func (c *stdioConn) Read(b []byte) (int, error)  { return c.in.Read(b) }
+func (c *stdioConn) Write(b []byte) (int, error) { return c.out.Write(b) }
+func (c *stdioConn) LocalAddr() net.Addr         { return stdioAddr{} }
+func (c *stdioConn) RemoteAddr() net.Addr        { return stdioAddr{} }
+
+func (c *stdioConn) Close() error {
+	return errors.Join(c.in.Close(), c.out.Close())
+}
+
+// The deadlines only take on files that can be polled, such as pipes; on
+// others keep-alive waits for as long as the client takes.
+func (c *stdioConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.SetReadDeadline(t), c.SetWriteDeadline(t))
+}
+
+func (c *stdioConn) SetReadDeadline(t time.Time) error  { return c.in.SetReadDeadline(t) }
+func (c *stdioConn) SetWriteDeadline(t time.Time) error { return c.out.SetWriteDeadline(t) }
+
+type stdioAddr struct{}
+
+func (stdioAddr) Network() string { return "stdio" }
+func (stdioAddr) String() string  { return "stdio" }
@@ -0,0 +1,53 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestStdio(t *testing.T) {
+	srv, err := server.New(servertest.Options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Shutdown() })
+
+	reqR, reqW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	respR, respW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer respR.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeConn(server.StdioConn(reqR, respW)) }()
+
+	io.WriteString(reqW, "GET /echo/one HTTP/1.1\r\nHost: x\r\n\r\nGET /echo/two HTTP/1.1\r\nHost: x\r\n\r\n")
+	reqW.Close()
+
+	r := bufio.NewReader(respR)
+	for _, want := range []string{"one", "two"} {
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatalf("error reading response: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != want {
+			t.Errorf("got %d %q, want 200 %q", resp.StatusCode, body, want)
+		}
+	}
+
+	<-done
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("got %v after the session, want EOF", err)
+	}
+}
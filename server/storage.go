@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	storageDisk   = "disk"
+	storageMemory = "memory"
+)
+
+// storage is what the /files handlers read from and write to. Names are
+// slash-separated and relative to the root of the store; "" and "." name the
+// root itself. Missing entries are reported with fs.ErrNotExist.
+type storage interface {
+	Open(name string) (io.ReadSeekCloser, error)
+	Write(name string, r io.Reader) error
+	Stat(name string) (fs.FileInfo, error)
+	List(dir string) ([]fs.FileInfo, error)
+	Delete(name string) error
+	Rename(from, to string) error
+}
+
+func newStorage(kind string, directory string) (storage, error) {
+	switch kind {
+	case storageDisk:
+		return diskStorage{root: directory}, nil
+	case storageMemory:
+		return newMemStorage(), nil
+	}
+
+	return nil, fmt.Errorf("unknown storage backend %q", kind)
+}
+
+// cleanName normalises name and makes sure it can't climb out of the root.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// joinName joins slash-separated name elements into a store name.
+func joinName(elem ...string) string {
+	return cleanName(path.Join(elem...))
+}
+
+type diskStorage struct {
+	root string
+}
+
+func (d diskStorage) path(name string) string {
+	return filepath.Join(d.root, filepath.FromSlash(cleanName(name)))
+}
+
+func (d diskStorage) Open(name string) (io.ReadSeekCloser, error) {
+	return os.Open(d.path(name))
+}
+
+func (d diskStorage) Write(name string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(d.path(name)), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(d.path(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func (d diskStorage) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(d.path(name))
+}
+
+func (d diskStorage) List(dir string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(d.path(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (d diskStorage) Delete(name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d diskStorage) Rename(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(d.path(to)), 0o755); err != nil {
+		return err
+	}
+
+	return os.Rename(d.path(from), d.path(to))
+}
+
+// memStorage keeps files in memory. Directories aren't stored; they exist as
+// long as some file lives below them.
+type memStorage struct {
+	mu    sync.RWMutex
+	files map[string]memFile
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{files: make(map[string]memFile)}
+}
+
+func (m *memStorage) Open(name string) (io.ReadSeekCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	f, ok := m.files[cleanName(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return nopCloser{bytes.NewReader(f.data)}, nil
+}
+
+func (m *memStorage) Write(name string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.files[cleanName(name)] = memFile{data: data, modTime: time.Now()}
+
+	return nil
+}
+
+func (m *memStorage) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	name = cleanName(name)
+	if f, ok := m.files[name]; ok {
+		return memFileInfo{name: path.Base(name), size: int64(len(f.data)), modTime: f.modTime}, nil
+	}
+	if name == "" || m.hasChildren(name) {
+		return memFileInfo{name: path.Base("/" + name), isDir: true}, nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *memStorage) List(dir string) ([]fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dir = cleanName(dir)
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	seen := make(map[string]bool)
+	var infos []fs.FileInfo
+	for name, f := range m.files {
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+
+		child, _, isDir := strings.Cut(rest, "/")
+		if seen[child] {
+			continue
+		}
+		seen[child] = true
+
+		if isDir {
+			infos = append(infos, memFileInfo{name: child, isDir: true})
+		} else {
+			infos = append(infos, memFileInfo{name: child, size: int64(len(f.data)), modTime: f.modTime})
+		}
+	}
+	if infos == nil && dir != "" {
+		return nil, &fs.PathError{Op: "list", Path: dir, Err: fs.ErrNotExist}
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	return infos, nil
+}
+
+func (m *memStorage) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = cleanName(name)
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "delete", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+
+	return nil
+}
+
+// Rename moves a file, or every file below a directory.
+func (m *memStorage) Rename(from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from, to = cleanName(from), cleanName(to)
+	if f, ok := m.files[from]; ok {
+		delete(m.files, from)
+		m.files[to] = f
+		return nil
+	}
+	if from == "" || !m.hasChildren(from) {
+		return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrNotExist}
+	}
+
+	for name, f := range m.files {
+		if rest, ok := strings.CutPrefix(name, from+"/"); ok {
+			delete(m.files, name)
+			m.files[joinName(to, rest)] = f
+		}
+	}
+
+	return nil
+}
+
+func (m *memStorage) hasChildren(dir string) bool {
+	for name := range m.files {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.isDir }
+func (i memFileInfo) Sys() any           { return nil }
+
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | 0o755
+	}
+
+	return 0o644
+}
@@ -0,0 +1,52 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestStrictHTTP(t *testing.T) {
+	lenient := servertest.New(t, servertest.Options()).Client()
+	opts := servertest.Options()
+	opts.StrictHTTP = true
+	strict := servertest.New(t, opts).Client()
+
+	for _, tt := range []struct {
+		name    string
+		raw     string
+		lenient int
+	}{
+		{"bare LF", "GET /echo/hi HTTP/1.1\nHost: x\n\n", http.StatusOK},
+		{"bare LF headers", "GET /echo/hi HTTP/1.1\r\nHost: x\n\n", http.StatusOK},
+		{"space before colon", "GET /echo/hi HTTP/1.1\r\nHost : x\r\n\r\n", http.StatusOK},
+		{"space in name", "GET /echo/hi HTTP/1.1\r\nX Y: z\r\n\r\n", http.StatusOK},
+		{"no colon", "GET /echo/hi HTTP/1.1\r\nNoColon\r\n\r\n", http.StatusOK},
+		{"double space", "GET  /echo/hi HTTP/1.1\r\n\r\n", http.StatusOK},
+		{"bad version", "GET /echo/hi HTTP/one\r\n\r\n", http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			lenient.Raw(tt.raw).AssertStatus(tt.lenient)
+			strict.Raw(tt.raw).AssertStatus(http.StatusBadRequest)
+		})
+	}
+
+	strict.Raw("GET /echo/hi HTTP/1.1\r\nHost: x\r\n\r\n").AssertStatus(http.StatusOK).AssertBody("hi")
+}
+
+func TestObsFold(t *testing.T) {
+	lenient := servertest.New(t, servertest.Options()).Client()
+	opts := servertest.Options()
+	opts.StrictHTTP = true
+	strict := servertest.New(t, opts).Client()
+
+	raw := "GET /headers HTTP/1.1\r\nX-Folded: a\r\n b\r\n\t c\r\nX-Next: d\r\n\r\n"
+	lenient.Raw(raw).
+		AssertStatus(http.StatusOK).
+		AssertBodyContains("X-Folded: a b c\n").
+		AssertBodyContains("X-Next: d\n")
+	strict.Raw(raw).AssertStatus(http.StatusBadRequest)
+
+	lenient.Raw("GET /user-agent HTTP/1.1\r\nUser-Agent: curl\r\n  (folded)\r\n\r\n").AssertBody("curl (folded)")
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ActivationListener returns the listening socket passed by systemd when the
+// server is started by a socket unit with Accept=no, or nil if it wasn't.
+func ActivationListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	// Children such as CGI scripts mustn't take the sockets for theirs.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n > 1 {
+		return nil, fmt.Errorf("got %d sockets from systemd, want 1", n)
+	}
+
+	// The passed sockets start at file descriptor 3.
+	f := os.NewFile(3, "systemd socket")
+	defer f.Close()
+
+	return net.FileListener(f)
+}
+
+// SystemdOptions describes the units SystemdUnits generates.
+type SystemdOptions struct {
+	// Name is the name of the units, without the .service suffix.
+	Name string
+	// Exec is the absolute path of the server binary.
+	Exec string
+	// Args are the server flags to run it with.
+	Args []string
+	// User runs the server as an existing user; if empty a dynamic one is
+	// allocated on every start.
+	User string
+	// Socket adds a socket unit listening on -host that starts the server
+	// on the first connection.
+	Socket bool
+}
+
+// SystemdUnits returns a service unit running the server with o.Args under
+// systemd's sandboxing, and with o.Socket a socket unit for it, otherwise
+// "". Without -directory the files are kept in /var/lib/NAME; either way
+// the directory is the only one the server may write to, so other files it
+// writes, such as an access log, need a ReadWritePaths= of their own.
+func SystemdUnits(o SystemdOptions) (service, socket string, err error) {
+	if o.Name == "" || strings.ContainsAny(o.Name, "/ ") {
+		return "", "", fmt.Errorf("invalid unit name %q", o.Name)
+	}
+	if !filepath.IsAbs(o.Exec) {
+		return "", "", fmt.Errorf("the server binary %q isn't an absolute path", o.Exec)
+	}
+
+	var opts Options
+	fs := flag.NewFlagSet(o.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts.RegisterFlags(fs)
+	if err := fs.Parse(o.Args); err != nil {
+		return "", "", fmt.Errorf("invalid server flags: %v", err)
+	}
+	if fs.NArg() != 0 {
+		return "", "", fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if opts.Stdio {
+		return "", "", fmt.Errorf("-stdio is for a socket with Accept=yes, not a service of its own")
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var b strings.Builder
+	line := func(format string, a ...any) { fmt.Fprintf(&b, format+"\n", a...) }
+
+	line("[Unit]")
+	line("Description=naive-server (%s)", o.Name)
+	line("After=network.target")
+	if o.Socket {
+		line("Requires=%s.socket", o.Name)
+		line("After=%s.socket", o.Name)
+	}
+	line("")
+	line("[Service]")
+	line("Type=simple")
+	if o.User != "" {
+		line("User=%s", o.User)
+	} else {
+		line("DynamicUser=yes")
+	}
+
+	// The service runs in /, so paths are made absolute; a flag given again
+	// at the end overrides the earlier one.
+	args := append([]string(nil), o.Args...)
+	if opts.File != "" {
+		file, err := filepath.Abs(opts.File)
+		if err != nil {
+			return "", "", err
+		}
+		args = append(args, "-file", file)
+	} else if opts.Storage == storageDisk && !set["directory"] {
+		line("StateDirectory=%s", o.Name)
+		args = append(args, "-directory", "/var/lib/"+o.Name)
+	} else if opts.Storage == storageDisk {
+		dir, err := filepath.Abs(opts.Directory)
+		if err != nil {
+			return "", "", err
+		}
+		line("ReadWritePaths=%s", dir)
+		args = append(args, "-directory", dir)
+	}
+	exec := []string{o.Exec}
+	for _, arg := range args {
+		exec = append(exec, systemdQuote(arg))
+	}
+	line("ExecStart=%s", strings.Join(exec, " "))
+	line("ExecReload=/bin/kill -HUP $MAINPID")
+	line("Restart=on-failure")
+	line("")
+	line("# Sandboxing")
+	line("NoNewPrivileges=yes")
+	line("ProtectSystem=strict")
+	line("ProtectHome=yes")
+	line("PrivateTmp=yes")
+	line("PrivateDevices=yes")
+	line("ProtectKernelTunables=yes")
+	line("ProtectKernelModules=yes")
+	line("ProtectKernelLogs=yes")
+	line("ProtectControlGroups=yes")
+	line("ProtectClock=yes")
+	line("ProtectHostname=yes")
+	line("RestrictAddressFamilies=AF_INET AF_INET6 AF_UNIX")
+	line("RestrictNamespaces=yes")
+	line("RestrictRealtime=yes")
+	line("RestrictSUIDSGID=yes")
+	line("LockPersonality=yes")
+	line("MemoryDenyWriteExecute=yes")
+	line("SystemCallArchitectures=native")
+	line("SystemCallFilter=@system-service")
+	// Binding a low port takes a capability, unless systemd binds it.
+	addrs, err := parseHost(opts.Host)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid -host: %v", err)
+	}
+	lowPort := slices.ContainsFunc(addrs, func(a listenAddr) bool {
+		n, _ := strconv.Atoi(a.port)
+		return n < 1024
+	})
+	if !o.Socket && lowPort {
+		line("AmbientCapabilities=CAP_NET_BIND_SERVICE")
+		line("CapabilityBoundingSet=CAP_NET_BIND_SERVICE")
+	} else {
+		line("CapabilityBoundingSet=")
+	}
+	line("")
+	line("[Install]")
+	line("WantedBy=multi-user.target")
+	service = b.String()
+
+	if o.Socket {
+		// The server takes over a single socket from systemd.
+		if len(addrs) > 1 {
+			return "", "", fmt.Errorf("a socket unit listens on a single -host address")
+		}
+		a := addrs[0]
+		listen := a.String()
+		if a.host == "" {
+			// A bare port listens on every address, IPv6 included.
+			listen = a.port
+		}
+		var bind string
+		switch {
+		case a.network == "tcp6":
+			bind += "BindIPv6Only=ipv6-only\n"
+		case a.network == "tcp4" && a.host == "":
+			listen = "0.0.0.0:" + a.port
+		}
+		if a.iface != "" {
+			bind += "BindToDevice=" + a.iface + "\n"
+		}
+		socket = fmt.Sprintf("[Unit]\nDescription=naive-server socket (%s)\n\n"+
+			"[Socket]\nListenStream=%s\n%sNoDelay=yes\n\n"+
+			"[Install]\nWantedBy=sockets.target\n", o.Name, listen, bind)
+	}
+
+	return service, socket, nil
+}
+
+// systemdQuote quotes arg for a unit file command line if it needs it, and
+// escapes the specifiers and variables systemd would otherwise expand.
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
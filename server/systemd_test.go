@@ -0,0 +1,78 @@
+package server_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+)
+
+func TestSystemdUnits(t *testing.T) {
+	service, socket, err := server.SystemdUnits(server.SystemdOptions{
+		Name: "files",
+		Exec: "/usr/local/bin/naive-server",
+		Args: []string{"-host", "127.0.0.1:80", "-tls-cert", "/etc/certs/a b.pem", "-charset", "100%"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"DynamicUser=yes\n",
+		"StateDirectory=files\n",
+		`ExecStart=/usr/local/bin/naive-server -host 127.0.0.1:80 -tls-cert "/etc/certs/a b.pem" -charset 100%% -directory /var/lib/files` + "\n",
+		"ExecReload=/bin/kill -HUP $MAINPID\n",
+		"ProtectSystem=strict\n",
+		"AmbientCapabilities=CAP_NET_BIND_SERVICE\n",
+	} {
+		if !strings.Contains(service, want) {
+			t.Errorf("service unit lacks %q:\n%s", want, service)
+		}
+	}
+	if socket != "" {
+		t.Errorf("got a socket unit without Socket:\n%s", socket)
+	}
+
+	service, socket, err = server.SystemdUnits(server.SystemdOptions{
+		Name:   "files",
+		Exec:   "/usr/local/bin/naive-server",
+		Args:   []string{"-host", ":8080", "-directory", "/srv/files"},
+		User:   "www",
+		Socket: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Requires=files.socket\n", "User=www\n", "ReadWritePaths=/srv/files\n", "CapabilityBoundingSet=\n"} {
+		if !strings.Contains(service, want) {
+			t.Errorf("service unit lacks %q:\n%s", want, service)
+		}
+	}
+	if strings.Contains(service, "DynamicUser") || strings.Contains(service, "StateDirectory") {
+		t.Errorf("got a dynamic user or state directory:\n%s", service)
+	}
+	if !strings.Contains(socket, "ListenStream=8080\n") {
+		t.Errorf("socket unit doesn't listen on 8080:\n%s", socket)
+	}
+
+	for _, o := range []server.SystemdOptions{
+		{Name: "files", Exec: "naive-server"},
+		{Name: "a/b", Exec: "/bin/naive-server"},
+		{Name: "files", Exec: "/bin/naive-server", Args: []string{"-no-such-flag"}},
+		{Name: "files", Exec: "/bin/naive-server", Args: []string{"-stdio"}},
+		{Name: "files", Exec: "/bin/naive-server", Args: []string{"-host", "::1:80"}},
+		{Name: "files", Exec: "/bin/naive-server", Args: []string{"-host", ":80,:8080"}, Socket: true},
+	} {
+		if _, _, err := server.SystemdUnits(o); err == nil {
+			t.Errorf("got no error for %+v", o)
+		}
+	}
+}
+
+func TestActivationListenerUnset(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+
+	if l, err := server.ActivationListener(); l != nil || err != nil {
+		t.Errorf("got %v, %v without socket activation", l, err)
+	}
+}
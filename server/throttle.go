@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the most handed to the underlying reader or writer at once,
+// so that limits are applied smoothly rather than in one large burst.
+const throttleChunk = 32 << 10
+
+// rateLimiter is a token bucket metering bytes. Waiters reserve their tokens
+// up front, letting the bucket go negative, and then sleep off the debt.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	rate := float64(bytesPerSec)
+
+	return &rateLimiter{
+		rate:   rate,
+		burst:  rate,
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes may be transferred, or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	debt := -l.tokens
+	l.mu.Unlock()
+
+	if debt <= 0 {
+		return nil
+	}
+	t := time.NewTimer(time.Duration(debt / l.rate * float64(time.Second)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunk returns how much may be transferred in one go under all limiters.
+func chunk(limiters []*rateLimiter, n int) int {
+	n = min(n, throttleChunk)
+	for _, l := range limiters {
+		n = min(n, max(1, int(l.burst)))
+	}
+
+	return n
+}
+
+type throttledWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*rateLimiter
+}
+
+func (t throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := chunk(t.limiters, len(p))
+		for _, l := range t.limiters {
+			if err := l.wait(t.ctx, n); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := t.w.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+type throttledReader struct {
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p[:chunk(t.limiters, len(p))])
+	for _, l := range t.limiters {
+		l.wait(context.Background(), n)
+	}
+
+	return n, err
+}
+
+// transferLimiters returns the limiters a file transfer for req is subject
+// to: the server-wide one and that of its connection, whichever are set.
+// Requests following each other on a connection share its limiter.
+func (s *Server) transferLimiters(req request) []*rateLimiter {
+	var limiters []*rateLimiter
+	if s.rate != nil {
+		limiters = append(limiters, s.rate)
+	}
+	if req.connRate != nil {
+		limiters = append(limiters, req.connRate)
+	}
+
+	return limiters
+}
+
+// throttleWriter limits how fast a file transfer for req may be written to
+// w, giving up once the request's context is done.
+func (s *Server) throttleWriter(req request, w io.Writer) io.Writer {
+	limiters := s.transferLimiters(req)
+	if len(limiters) == 0 {
+		return w
+	}
+
+	return throttledWriter{ctx: req.context(), w: w, limiters: limiters}
+}
+
+// throttleReader limits how fast a file transfer for req may be read from r.
+func (s *Server) throttleReader(req request, r io.Reader) io.Reader {
+	limiters := s.transferLimiters(req)
+	if len(limiters) == 0 {
+		return r
+	}
+
+	return throttledReader{r: r, limiters: limiters}
+}
+
+// byteRate is a transfer rate flag such as "10MB/s", "512KiB/s" or a plain
+// number of bytes per second. 0 means unlimited.
+type byteRate int64
+
+var rateUnits = []struct {
+	suffix string
+	scale  int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+	{"kb", 1000}, {"mb", 1000 * 1000}, {"gb", 1000 * 1000 * 1000},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+func (r *byteRate) String() string {
+	if r == nil {
+		return "0"
+	}
+
+	return strconv.FormatInt(int64(*r), 10) + "B/s"
+}
+
+func (r *byteRate) Set(value string) error {
+	s := strings.ToLower(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "/s")
+
+	scale := int64(1)
+	for _, u := range rateUnits {
+		if rest, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, scale = strings.TrimSpace(rest), u.scale
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid rate %q", value)
+	}
+	*r = byteRate(n * float64(scale))
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package server_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestThrottledDownload(t *testing.T) {
+	const rate = 32 << 10
+
+	opts := servertest.Options()
+	opts.MaxRatePerConn = rate
+	s := servertest.New(t, opts)
+	c := s.Client()
+
+	// The limiter starts with a second's worth of bytes, so twice that
+	// takes a second to send.
+	data := bytes.Repeat([]byte("x"), 2*rate)
+	c.Do(http.MethodPut, "/files/big.bin", bytes.NewReader(data), nil).AssertStatus(http.StatusCreated)
+
+	start := time.Now()
+	c.Get("/files/big.bin").AssertStatus(http.StatusOK).AssertBody(string(data))
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("download took %v, want at least 1s", d)
+	}
+
+	// Requests on the same connection share its limiter, so the second
+	// download has no second's worth left to start with.
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	start = time.Now()
+	go io.WriteString(conn, "GET /files/big.bin HTTP/1.1\r\nHost: x\r\n\r\nGET /files/big.bin HTTP/1.1\r\nHost: x\r\n\r\n")
+	r := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := io.Copy(io.Discard, resp.Body); n != int64(len(data)) {
+			t.Fatalf("got %d bytes, want %d", n, len(data))
+		}
+	}
+	if d := time.Since(start); d < 2900*time.Millisecond {
+		t.Errorf("two downloads on one connection took %v, want at least 3s", d)
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxThumbSide bounds each side of a requested thumbnail.
+	maxThumbSide = 1024
+	// maxThumbSource bounds the pixels of an image we are willing to decode,
+	// so a small file can't unpack into gigabytes.
+	maxThumbSource = 50 << 20
+)
+
+var errNotImage = errors.New("not a supported image")
+
+// parseThumbSize parses a ?thumb=WxH value.
+func parseThumbSize(s string) (int, int, error) {
+	ws, hs, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid thumbnail size %q", s)
+	}
+	w, err := strconv.Atoi(ws)
+	if err != nil || w < 1 || w > maxThumbSide {
+		return 0, 0, fmt.Errorf("invalid thumbnail width %q", ws)
+	}
+	h, err := strconv.Atoi(hs)
+	if err != nil || h < 1 || h > maxThumbSide {
+		return 0, 0, fmt.Errorf("invalid thumbnail height %q", hs)
+	}
+
+	return w, h, nil
+}
+
+// serveThumbnail answers a ?thumb=WxH request for an image file with a copy
+// scaled to fit within WxH.
+func (s *Server) serveThumbnail(conn net.Conn, req request, name string, info fs.FileInfo, size string) error {
+	w, h, err := parseThumbSize(size)
+	if err != nil {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+
+	key := thumbKey{name: name, w: w, h: h, modTime: info.ModTime(), size: info.Size()}
+	c := s.thumbs.get(key)
+	if c == nil {
+		f, err := s.store.Open(name)
+		if err != nil {
+			conn.Write(buildResponse(statusNotFound, nil))
+			return fmt.Errorf("error reading %s: %v\n", name, err)
+		}
+		c, err = thumbnail(f, w, h)
+		f.Close()
+		if errors.Is(err, errNotImage) {
+			conn.Write(buildResponse(statusBadRequest, nil))
+			return nil
+		}
+		if err != nil {
+			conn.Write(buildResponse(statusInternalServerError, nil))
+			return fmt.Errorf("error making thumbnail of %s: %v\n", name, err)
+		}
+		s.thumbs.put(key, c)
+	}
+	rh := header{"Accept-Ranges": {"none"}}
+	s.cacheHeaders(rh)
+	resp := buildResponseHeaders(statusOK, rh, c)
+	s.throttleWriter(req, conn).Write(resp)
+
+	return nil
+}
+
+// thumbnail decodes an image and scales it down to fit within w by h,
+// encoding it back in its own format (GIFs come back as PNGs).
+func thumbnail(r io.ReadSeeker, w, h int) (*content, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, errNotImage
+	}
+	if cfg.Width*cfg.Height > maxThumbSource {
+		return nil, fmt.Errorf("image is %dx%d, too large to scale", cfg.Width, cfg.Height)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	dst := scaleDown(src, w, h)
+
+	var buf bytes.Buffer
+	c := &content{}
+	if format == "jpeg" {
+		c.contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	} else {
+		c.contentType = "image/png"
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.body = buf.Bytes()
+
+	return c, nil
+}
+
+// scaleDown fits src within w by h keeping its aspect ratio, averaging the
+// source pixels that fall under each destination pixel. Images that already
+// fit are returned as they are.
+func scaleDown(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= w && sh <= h {
+		return src
+	}
+
+	if sw*h > sh*w {
+		h = max(1, sh*w/sw)
+	} else {
+		w = max(1, sw*h/sh)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*sh/h, b.Min.Y+max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*sw/w, b.Min.X+max((x+1)*sw/w, x*sw/w+1)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+
+	return dst
+}
+
+// thumbKey identifies a thumbnail. The modification time and size of the
+// source make edits to it miss the cache.
+type thumbKey struct {
+	name    string
+	w, h    int
+	modTime time.Time
+	size    int64
+}
+
+type thumbEntry struct {
+	key thumbKey
+	c   *content
+}
+
+// thumbCache keeps recently made thumbnails, evicting the least recently
+// used once they take up more than maxBytes.
+type thumbCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	lru      *list.List
+	entries  map[thumbKey]*list.Element
+}
+
+func newThumbCache(maxBytes int64) *thumbCache {
+	return &thumbCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[thumbKey]*list.Element),
+	}
+}
+
+func (c *thumbCache) get(key thumbKey) *content {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+
+	return el.Value.(*thumbEntry).c
+}
+
+// invalidate drops the thumbnails of the named file. A prefix of a directory
+// name drops those of everything below it, which renames need.
+func (c *thumbCache) invalidate(name string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.entries {
+		if key.name == name || strings.HasPrefix(key.name, name+"/") {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+			c.used -= int64(len(el.Value.(*thumbEntry).c.body))
+		}
+	}
+}
+
+// flush drops every thumbnail.
+func (c *thumbCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	c.entries = make(map[thumbKey]*list.Element)
+	c.used = 0
+}
+
+func (c *thumbCache) put(key thumbKey, content *content) {
+	size := int64(len(content.body))
+	if c == nil || size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&thumbEntry{key: key, c: content})
+	c.used += size
+
+	for c.used > c.maxBytes {
+		el := c.lru.Back()
+		e := el.Value.(*thumbEntry)
+		c.lru.Remove(el)
+		delete(c.entries, e.key)
+		c.used -= int64(len(e.c.body))
+	}
+}
@@ -0,0 +1,59 @@
+package server_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestThumbnail(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 200; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), A: 255})
+		}
+	}
+	var pngData, jpegData bytes.Buffer
+	png.Encode(&pngData, img)
+	jpeg.Encode(&jpegData, img, nil)
+
+	c.Do(http.MethodPut, "/files/a.png", &pngData, nil).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodPut, "/files/a.jpg", &jpegData, nil).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("hello"), nil).AssertStatus(http.StatusCreated)
+
+	for _, tt := range []struct {
+		path        string
+		contentType string
+		w, h        int
+	}{
+		{"/files/a.png?thumb=100x100", "image/png", 100, 50},
+		{"/files/a.png?thumb=100x100", "image/png", 100, 50},
+		{"/files/a.jpg?thumb=200x20", "image/jpeg", 40, 20},
+		{"/files/a.png?thumb=1000x1000", "image/png", 400, 200},
+	} {
+		resp := c.Get(tt.path).
+			AssertStatus(http.StatusOK).
+			AssertHeader("Content-Type", tt.contentType)
+
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(resp.Body))
+		if err != nil {
+			t.Fatalf("%s: error decoding thumbnail: %v", tt.path, err)
+		}
+		if cfg.Width != tt.w || cfg.Height != tt.h {
+			t.Errorf("%s: got %dx%d, want %dx%d", tt.path, cfg.Width, cfg.Height, tt.w, tt.h)
+		}
+	}
+
+	c.Get("/files/a.txt?thumb=100x100").AssertStatus(http.StatusBadRequest)
+	c.Get("/files/a.png?thumb=100").AssertStatus(http.StatusBadRequest)
+	c.Get("/files/a.png?thumb=0x100").AssertStatus(http.StatusBadRequest)
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errHandlerTimeout fails what a handler reads or writes once it has been
+// given up on.
+var errHandlerTimeout = errors.New("handler timed out")
+
+// RouteTimeout sets the handler timeout of a route, given as for
+// RouteMiddleware; 0 turns it off.
+type RouteTimeout struct {
+	Route   string
+	Timeout time.Duration
+}
+
+// buildTimeouts returns the handler timeouts of RouteTimeouts keyed by
+// "METHOD /path", as route looks chains up.
+func (s *Server) buildTimeouts(routes []RouteTimeout) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, rt := range routes {
+		if rt.Timeout < 0 {
+			return nil, fmt.Errorf("invalid timeout %s for %q", rt.Timeout, rt.Route)
+		}
+		keys, err := s.expandRoute(rt.Route)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			timeouts[key] = rt.Timeout
+		}
+	}
+
+	return timeouts, nil
+}
+
+// handlerTimeout returns how long the handler of req has to start answering
+// it, or 0 if it has as long as it takes.
+func (s *Server) handlerTimeout(req request) time.Duration {
+	if len(req.pathParts) >= 2 {
+		if d, ok := s.timeouts[req.method+" /"+req.pathParts[1]]; ok {
+			return d
+		}
+	}
+
+	return s.opts.HandlerTimeout
+}
+
+// serveTimed serves a request as serveRecovered does, giving up on it with
+// 503, or 504 when proxying, if its handler hasn't started answering
+// within its timeout. The handler is left running, but its context is
+// cancelled and what it reads or writes from then on fails, so whatever it
+// is stuck on holds a goroutine rather than the connection and its turn.
+// Once a response has begun it is let run to its end.
+func (s *Server) serveTimed(ex *exchangeConn, body io.Reader, req request) error {
+	timeout := s.handlerTimeout(req)
+	if timeout <= 0 {
+		return s.serveRecovered(ex, body, req)
+	}
+
+	ctx, cancel := context.WithCancel(req.context())
+	defer cancel()
+	req.ctx = ctx
+
+	// The handler answers on a copy of the exchange, so it is never
+	// written by both.
+	guard := &timeoutConn{Conn: ex.Conn}
+	inner := *ex
+	inner.Conn = guard
+	req.vary = &inner.vary
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serveRecovered(&inner, &timeoutBody{r: body, guard: guard}, req)
+	}()
+	finish := func(err error) error {
+		conn := ex.Conn
+		*ex = inner
+		ex.Conn = conn
+		return err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return finish(err)
+	case <-timer.C:
+	}
+	if !guard.expire() {
+		return finish(<-done)
+	}
+
+	cancel()
+	ex.forceClose()
+	status := statusServiceUnavailable
+	if s.proxy != nil {
+		status = statusGatewayTimeout
+	}
+	ex.Write(buildResponse(status, nil))
+	s.log.Warn("handler timed out", "method", req.method, "target", req.target(), "client", req.clientIP, "timeout", timeout.String())
+
+	return nil
+}
+
+// timeoutConn passes what a handler writes on until it is given up on, and
+// keeps it from being given up on once it has started to answer.
+type timeoutConn struct {
+	net.Conn
+
+	mu      sync.Mutex
+	wrote   bool
+	expired bool
+	// reading is set while the handler is reading the request body.
+	reading bool
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.expired {
+		return 0, errHandlerTimeout
+	}
+	c.wrote = true
+
+	return c.Conn.Write(p)
+}
+
+// expire gives up on the handler, unless it has started to answer, and
+// reports whether it did.
+func (c *timeoutConn) expire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.wrote {
+		return false
+	}
+	c.expired = true
+	if c.reading {
+		// Wake the read, which would otherwise wait on the client.
+		c.Conn.SetReadDeadline(time.Now())
+	}
+
+	return true
+}
+
+// timeoutBody is the request body as a handler reads it. Giving up on the
+// handler cuts a read in progress short, and fails those after it.
+type timeoutBody struct {
+	r     io.Reader
+	guard *timeoutConn
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	g := b.guard
+	g.mu.Lock()
+	if g.expired {
+		g.mu.Unlock()
+		return 0, errHandlerTimeout
+	}
+	g.reading = true
+	g.mu.Unlock()
+
+	n, err := b.r.Read(p)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reading = false
+	if g.expired {
+		return 0, errHandlerTimeout
+	}
+
+	return n, err
+}
+
+// routeTimeoutFlag collects repeated 'route=duration' flags.
+type routeTimeoutFlag []RouteTimeout
+
+func (f *routeTimeoutFlag) String() string {
+	if f == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, rt := range *f {
+		parts = append(parts, rt.Route+"="+rt.Timeout.String())
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, " ")
+}
+
+func (f *routeTimeoutFlag) Set(value string) error {
+	route, timeout, ok := strings.Cut(value, "=")
+	route = strings.TrimSpace(route)
+	if !ok || route == "" {
+		return fmt.Errorf("expected 'route=duration', got %q", value)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(timeout))
+	if err != nil || d < 0 {
+		return fmt.Errorf("invalid timeout in %q", value)
+	}
+	*f = append(*f, RouteTimeout{Route: route, Timeout: d})
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestHandlerTimeout(t *testing.T) {
+	opts := servertest.Options()
+	opts.HandlerTimeout = 100 * time.Millisecond
+	opts.RouteTimeouts = []server.RouteTimeout{{Route: "GET /ip", Timeout: 0}}
+	opts.MaxDelay = 10 * time.Second
+	c := servertest.New(t, opts).Client()
+
+	start := time.Now()
+	c.Get("/delay/5").AssertStatus(http.StatusServiceUnavailable)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("got the 503 after %s", elapsed)
+	}
+	c.Get("/delay/0").AssertStatus(http.StatusOK)
+	c.Get("/ip").AssertStatus(http.StatusOK)
+}
+
+func TestHandlerTimeoutReading(t *testing.T) {
+	opts := servertest.Options()
+	opts.HandlerTimeout = 100 * time.Millisecond
+	s := servertest.New(t, opts)
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The body never arrives in full, so the handler is stuck reading it
+	// when the timeout is up.
+	io.WriteString(conn, "POST /echo HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\nab")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("got %v waiting for the 503", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || !resp.Close {
+		t.Errorf("got %d with close %v, want 503 closing", resp.StatusCode, resp.Close)
+	}
+}
+
+func TestHandlerTimeoutProxied(t *testing.T) {
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	opts := servertest.Options()
+	opts.Proxy = upstream.URL
+	opts.RouteTimeouts = []server.RouteTimeout{{Route: "GET /slow", Timeout: 100 * time.Millisecond}}
+	c := servertest.New(t, opts).Client()
+
+	c.Get("/slow").AssertStatus(http.StatusGatewayTimeout)
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream call went on after the timeout")
+	}
+}
+
+func TestRouteTimeoutOptions(t *testing.T) {
+	for _, rt := range []server.RouteTimeout{
+		{Route: "/nowhere", Timeout: time.Second},
+		{Route: "GET /delay", Timeout: -time.Second},
+	} {
+		opts := servertest.Options()
+		opts.RouteTimeouts = []server.RouteTimeout{rt}
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error for %v", rt)
+		}
+	}
+}
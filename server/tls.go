@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// certificate holds the server's key pair so that it can be swapped for a
+// renewed one without restarting.
+type certificate struct {
+	certFile string
+	keyFile  string
+	current  atomic.Pointer[tls.Certificate]
+}
+
+func newCertificate(certFile, keyFile string) (*certificate, error) {
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// load (re)reads the key pair from disk.
+func (c *certificate) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading key pair: %v", err)
+	}
+	c.current.Store(&cert)
+
+	return nil
+}
+
+func (c *certificate) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := c.current.Load()
+	if cert == nil {
+		// An ACME certificate that hasn't been issued yet.
+		return nil, fmt.Errorf("no certificate yet")
+	}
+
+	return cert, nil
+}
+
+// tlsConfig returns the configuration TLS is served with. Client
+// certificates are verified against the -tls-client-ca bundle if there is
+// one, and session keys are written to the -tls-key-log file in the NSS key
+// log format if it is set.
+func (s *Server) tlsConfig() *tls.Config {
+	cfg := &tls.Config{
+		GetCertificate: s.cert.get,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if s.keyLog != nil {
+		cfg.KeyLogWriter = s.keyLog
+	}
+	if s.clientCAs != nil {
+		cfg.ClientCAs = s.clientCAs
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if !s.opts.TLSSessionTickets {
+		cfg.SessionTicketsDisabled = true
+	} else if s.opts.TLSTicketRotation > 0 {
+		s.rotateTicketKeys(cfg, s.opts.TLSTicketRotation)
+	}
+
+	return cfg
+}
+
+// rotateTicketKeys replaces the key session tickets are encrypted with every
+// interval until the server shuts down. The previous key is kept to resume
+// sessions with for one more interval, so no ticket outlives two of them and
+// a leaked key exposes at most that much of the TLS 1.2 traffic, whose
+// resumption has no forward secrecy of its own.
+func (s *Server) rotateTicketKeys(cfg *tls.Config, interval time.Duration) {
+	keys := [][32]byte{newTicketKey()}
+	cfg.SetSessionTicketKeys(keys)
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-s.closed:
+				return
+			case <-t.C:
+				keys = [][32]byte{newTicketKey(), keys[0]}
+				cfg.SetSessionTicketKeys(keys)
+			}
+		}
+	}()
+}
+
+func newTicketKey() [32]byte {
+	var key [32]byte
+	rand.Read(key[:])
+
+	return key
+}
+
+// ServeRedirects runs the plain HTTP listener on the HTTPRedirect address,
+// which sends every client to the https:// equivalent of the URL it asked
+// for, until the server is shut down.
+func (s *Server) ServeRedirects() error {
+	if s.cert == nil {
+		return fmt.Errorf("redirecting to HTTPS requires a TLS certificate")
+	}
+
+	l, err := net.Listen("tcp", s.opts.HTTPRedirect)
+	if err != nil {
+		return fmt.Errorf("error binding redirect listener: %v", err)
+	}
+	s.track(l)
+
+	return serveConns(l, s.log, func(conn net.Conn) error {
+		return handleRedirectConn(&defaultsConn{Conn: conn, srv: s}, s.opts)
+	})
+}
+
+func handleRedirectConn(conn net.Conn, opts Options) error {
+	req, err := parseRequest(bufio.NewReader(conn), opts.StrictHTTP)
+	if err != nil {
+		return err
+	}
+	req.setRemoteAddr(conn.RemoteAddr())
+	req.applyForwarded(opts.TrustedProxies)
+
+	if strings.HasPrefix(req.path, acmeChallengePrefix) && opts.ACMEWebroot != "" {
+		return serveACMEChallenge(conn, req, opts)
+	}
+
+	if req.host == "" {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+
+	h := header{"Location": {httpsURL(req.host, req.target(), opts.Host)}}
+	conn.Write(buildResponseHeaders(statusMovedPermanently, h, nil))
+
+	return nil
+}
+
+// httpsURL builds the https:// location for the given Host header and target,
+// pointing at the port the first TLS listener of tlsHost is bound to.
+func httpsURL(host, target, tlsHost string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	if addrs, err := parseHost(tlsHost); err == nil && addrs[0].port != "443" {
+		host = host + ":" + addrs[0].port
+	}
+
+	return "https://" + host + target
+}
+
+func serveACMEChallenge(conn net.Conn, req request, opts Options) error {
+	token := strings.TrimPrefix(req.path, acmeChallengePrefix)
+	if !validACMEToken(token) {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(opts.ACMEWebroot, acmeChallengePrefix, token))
+	if err != nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return fmt.Errorf("error reading challenge %s: %v\n", token, err)
+	}
+
+	c := content{
+		contentType: contentTypeTextPlain,
+		body:        data,
+	}
+	conn.Write(buildResponse(statusOK, &c))
+
+	return nil
+}
+
+// validACMEToken reports whether the token only holds base64url characters,
+// which is all ACME ever issues and keeps lookups inside the webroot.
+func validACMEToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	for _, r := range token {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// openKeyLog opens the TLS key log at path, warning loudly that it is on,
+// since the keys in it decrypt any captured traffic.
+func openKeyLog(path string, log *slog.Logger) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	log.Warn("logging TLS session keys; anyone with this file can decrypt traffic captured from this server, so use it for debugging only", "file", path)
+
+	return f, nil
+}
@@ -0,0 +1,240 @@
+package server_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// newCert issues a certificate from tmpl signed by parent, or self-signed if
+// parent is nil.
+func newCert(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// writeKeyPair writes a self-signed certificate for localhost to dir.
+func writeKeyPair(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	cert, key := newCert(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "localhost"},
+		DNSNames: []string{"localhost"},
+	}, nil, nil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	return certFile, keyFile
+}
+
+// tlsGet sends a GET for path over TLS with the given client certificate,
+// if any, and extra header lines.
+func tlsGet(t *testing.T, s *servertest.Server, path, headers string, certs []tls.Certificate) *http.Response {
+	t.Helper()
+
+	raw, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := tls.Client(raw, &tls.Config{InsecureSkipVerify: true, Certificates: certs})
+	t.Cleanup(func() { conn.Close() })
+	conn.Write([]byte("GET " + path + " HTTP/1.1\r\nConnection: close\r\n" + headers + "\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func TestTLSKeyLog(t *testing.T) {
+	dir := t.TempDir()
+	opts := servertest.Options()
+	opts.TLSCert, opts.TLSKey = writeKeyPair(t, dir)
+	opts.TLSKeyLog = filepath.Join(dir, "keys.log")
+	s := servertest.New(t, opts)
+
+	tlsGet(t, s, "/echo/hi", "", nil).Body.Close()
+	s.Close()
+
+	keys, err := os.ReadFile(opts.TLSKeyLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(keys), "CLIENT_TRAFFIC_SECRET_0 ") {
+		t.Errorf("key log holds no traffic secrets: %q", keys)
+	}
+}
+
+func TestTLSKeyLogWithoutTLS(t *testing.T) {
+	opts := servertest.Options()
+	opts.TLSKeyLog = filepath.Join(t.TempDir(), "keys.log")
+	if _, err := server.New(opts); err == nil {
+		t.Error("got no error logging keys without TLS")
+	}
+}
+
+func TestClientCertHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+	}))
+	defer upstream.Close()
+
+	caTmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	ca, caKey := newCert(t, caTmpl, nil, nil)
+	client, clientKey := newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "alice", Organization: []string{"Example"}},
+		DNSNames:    []string{"alice.example"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	untrusted, untrustedKey := newCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}}, nil, nil)
+
+	dir := t.TempDir()
+	opts := servertest.Options()
+	opts.TLSCert, opts.TLSKey = writeKeyPair(t, dir)
+	opts.TLSClientCA = filepath.Join(dir, "ca.pem")
+	os.WriteFile(opts.TLSClientCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o644)
+	opts.Proxy = upstream.URL
+	s := servertest.New(t, opts)
+
+	forged := "X-Client-Cert-Subject: CN=admin\r\nX-Client-Cert-Fingerprint: 00\r\n"
+
+	resp := tlsGet(t, s, "/", forged, []tls.Certificate{{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey}})
+	resp.Body.Close()
+	sum := sha256.Sum256(client.Raw)
+	for name, want := range map[string]string{
+		"X-Client-Cert-Subject":     "CN=alice,O=Example",
+		"X-Client-Cert-San":         "DNS:alice.example",
+		"X-Client-Cert-Fingerprint": hex.EncodeToString(sum[:]),
+	} {
+		if v := got.Values(name); len(v) != 1 || v[0] != want {
+			t.Errorf("upstream got %s %q, want %q", name, v, want)
+		}
+	}
+
+	tlsGet(t, s, "/", forged, nil).Body.Close()
+	if v := got.Get("X-Client-Cert-Subject") + got.Get("X-Client-Cert-Fingerprint"); v != "" {
+		t.Errorf("forged client certificate headers reached the upstream: %q", v)
+	}
+
+	// A certificate from another CA fails the handshake.
+	raw, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := tls.Client(raw, &tls.Config{
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &tls.Certificate{Certificate: [][]byte{untrusted.Raw}, PrivateKey: untrustedKey}, nil
+		},
+	})
+	defer conn.Close()
+	conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	if _, err := http.ReadResponse(bufio.NewReader(conn), nil); err == nil {
+		t.Error("got a response for an untrusted client certificate")
+	}
+}
+
+func TestTLSSessionTickets(t *testing.T) {
+	// resumed makes two requests sharing a session cache and reports
+	// whether the second resumed the session of the first, wait apart.
+	resumed := func(opts server.Options, wait time.Duration) bool {
+		t.Helper()
+
+		opts.TLSCert, opts.TLSKey = writeKeyPair(t, t.TempDir())
+		s := servertest.New(t, opts)
+		cache := tls.NewLRUClientSessionCache(1)
+
+		var state tls.ConnectionState
+		for i := 0; i < 2; i++ {
+			if i > 0 {
+				time.Sleep(wait)
+			}
+			raw, err := s.Dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn := tls.Client(raw, &tls.Config{InsecureSkipVerify: true, ClientSessionCache: cache})
+			conn.Write([]byte("GET /echo/hi HTTP/1.1\r\nConnection: close\r\n\r\n"))
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			state = conn.ConnectionState()
+			conn.Close()
+		}
+
+		return state.DidResume
+	}
+
+	opts := servertest.Options()
+	if !resumed(opts, 0) {
+		t.Error("session wasn't resumed")
+	}
+
+	opts.TLSTicketRotation = 50 * time.Millisecond
+	if !resumed(opts, 0) {
+		t.Error("session wasn't resumed with rotating keys")
+	}
+	if resumed(opts, 500*time.Millisecond) {
+		t.Error("session was resumed with a ticket older than two rotations")
+	}
+
+	opts.TLSTicketRotation = 0
+	opts.TLSSessionTickets = false
+	if resumed(opts, 0) {
+		t.Error("session was resumed with tickets disabled")
+	}
+}
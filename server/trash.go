@@ -0,0 +1,186 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trashDir holds deleted files until the retention window passes. Each one
+// is kept flat as .trash/<unix nanos>-<escaped name>, so the same name can be
+// deleted more than once. That ID is also safe to put in a URL as it is.
+const trashDir = ".trash"
+
+type trashEntry struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Deleted time.Time `json:"deleted"`
+	Size    int64     `json:"size"`
+}
+
+func parseTrashID(id string) (trashEntry, bool) {
+	nanos, escaped, ok := strings.Cut(id, "-")
+	if !ok {
+		return trashEntry{}, false
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return trashEntry{}, false
+	}
+	name, err := url.PathUnescape(escaped)
+	if err != nil || cleanName(name) != name || name == "" {
+		return trashEntry{}, false
+	}
+
+	return trashEntry{ID: id, Name: name, Deleted: time.Unix(0, n)}, true
+}
+
+// trashFile moves name into the trash, or deletes it outright if there is
+// no retention window.
+func (s *Server) trashFile(name string) error {
+	if s.opts.TrashRetention <= 0 {
+		return s.store.Delete(name)
+	}
+	if info, err := s.store.Stat(name); err != nil {
+		return err
+	} else if info.IsDir() {
+		return s.store.Delete(name)
+	}
+
+	id := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + url.PathEscape(name)
+	if err := s.store.Rename(name, joinName(trashDir, id)); err != nil {
+		return err
+	}
+
+	return s.purgeTrash()
+}
+
+// trash lists what is in the trash, newest first.
+func (s *Server) trash() ([]trashEntry, error) {
+	infos, err := s.store.List(trashDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []trashEntry
+	for _, info := range infos {
+		e, ok := parseTrashID(info.Name())
+		if !ok || info.IsDir() {
+			continue
+		}
+		e.Size = info.Size()
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Deleted.After(entries[j].Deleted) })
+
+	return entries, nil
+}
+
+// purgeTrash deletes whatever has been in the trash longer than the
+// retention window.
+func (s *Server) purgeTrash() error {
+	entries, err := s.trash()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-s.opts.TrashRetention)
+	for _, e := range entries {
+		if e.Deleted.Before(cutoff) {
+			if err := s.store.Delete(joinName(trashDir, e.ID)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// serveTrash handles /trash: GET lists the trash as JSON, POST /trash/<id>
+// restores an entry to where it was deleted from and DELETE /trash/<id>
+// purges it. Like the other file manager operations it needs the UI
+// credentials.
+func (s *Server) serveTrash(conn net.Conn, req request) error {
+	if !s.authEnabled() {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+	if !s.authorized(req) {
+		conn.Write(unauthorized())
+		return nil
+	}
+
+	if req.method == methodGet && len(req.pathParts) == 2 {
+		if err := s.purgeTrash(); err != nil {
+			conn.Write(buildResponse(statusInternalServerError, nil))
+			return fmt.Errorf("error purging trash: %v\n", err)
+		}
+		entries, err := s.trash()
+		if err != nil {
+			conn.Write(buildResponse(statusInternalServerError, nil))
+			return fmt.Errorf("error listing trash: %v\n", err)
+		}
+		if entries == nil {
+			entries = []trashEntry{}
+		}
+		conn.Write(buildResponse(statusOK, jsonContent(entries)))
+		return nil
+	}
+
+	if len(req.pathParts) != 3 {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+	// IDs hold the name escaped so they can go in URLs as they are, which
+	// leaves it unescaped in the path.
+	nanos, name, _ := strings.Cut(req.pathParts[2], "-")
+	e, ok := parseTrashID(nanos + "-" + url.PathEscape(name))
+	if !ok {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+	trashed := joinName(trashDir, e.ID)
+	if _, err := s.store.Stat(trashed); err != nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+
+	switch req.method {
+	case methodPost:
+		unlock, ok := s.locks.tryLock(e.Name)
+		if !ok {
+			conn.Write(buildResponse(statusConflict, nil))
+			return nil
+		}
+		defer unlock()
+
+		if _, err := s.store.Stat(e.Name); err == nil {
+			conn.Write(buildResponse(statusConflict, nil))
+			return nil
+		}
+		if err := s.store.Rename(trashed, e.Name); err != nil {
+			conn.Write(buildResponse(statusInternalServerError, nil))
+			return fmt.Errorf("error restoring %s: %v\n", e.Name, err)
+		}
+		conn.Write(buildResponse(statusCreated, nil))
+	case methodDelete:
+		if err := s.store.Delete(trashed); err != nil {
+			conn.Write(buildResponse(statusInternalServerError, nil))
+			return fmt.Errorf("error purging %s: %v\n", e.ID, err)
+		}
+		conn.Write(buildResponse(statusNoContent, nil))
+	default:
+		conn.Write(buildResponse(statusMethodNotAllowed, nil))
+	}
+
+	return nil
+}
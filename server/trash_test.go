@@ -0,0 +1,61 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestTrash(t *testing.T) {
+	opts := servertest.Options()
+	opts.UIAuth = "me:secret"
+	c := servertest.NewPipe(t, opts).Client()
+	auth := http.Header{"Authorization": {"Basic bWU6c2VjcmV0"}}
+
+	c.Do(http.MethodPut, "/files/d/a.txt", strings.NewReader("hello"), nil).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodDelete, "/files/d/a.txt", nil, auth).AssertStatus(http.StatusNoContent)
+	c.Get("/files/d/a.txt").AssertStatus(http.StatusNotFound)
+
+	c.Get("/trash").AssertStatus(http.StatusUnauthorized)
+	var entries []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+	resp := c.Do(http.MethodGet, "/trash", nil, auth).AssertStatus(http.StatusOK)
+	if err := json.Unmarshal(resp.Body, &entries); err != nil {
+		t.Fatalf("error decoding trash %q: %v", resp.Body, err)
+	}
+	if len(entries) != 1 || entries[0].Name != "d/a.txt" || entries[0].Size != 5 {
+		t.Fatalf("got trash %+v, want d/a.txt", entries)
+	}
+	restore := "/trash/" + entries[0].ID
+
+	c.Do(http.MethodPut, "/files/d/a.txt", strings.NewReader("new"), nil).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodPost, restore, strings.NewReader(""), auth).AssertStatus(http.StatusConflict)
+	c.Do(http.MethodDelete, "/files/d/a.txt", nil, auth).AssertStatus(http.StatusNoContent)
+
+	c.Do(http.MethodPost, restore, strings.NewReader(""), auth).AssertStatus(http.StatusCreated)
+	c.Get("/files/d/a.txt").AssertBody("hello")
+	c.Do(http.MethodPost, restore, strings.NewReader(""), auth).AssertStatus(http.StatusNotFound)
+	c.Do(http.MethodGet, "/trash", nil, auth).AssertBodyContains(`"name":"d/a.txt","deleted"`)
+}
+
+func TestTrashRetention(t *testing.T) {
+	opts := servertest.Options()
+	opts.UIAuth = "me:secret"
+	opts.TrashRetention = 50 * time.Millisecond
+	c := servertest.NewPipe(t, opts).Client()
+	auth := http.Header{"Authorization": {"Basic bWU6c2VjcmV0"}}
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("hello"), nil).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodDelete, "/files/a.txt", nil, auth).AssertStatus(http.StatusNoContent)
+	c.Do(http.MethodGet, "/trash", nil, auth).AssertBodyContains(`"name":"a.txt"`)
+
+	time.Sleep(100 * time.Millisecond)
+	c.Do(http.MethodGet, "/trash", nil, auth).AssertBody("[]")
+}
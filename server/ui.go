@@ -0,0 +1,156 @@
+package server
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"path"
+)
+
+//go:embed templates/ui.html
+var uiPage []byte
+
+// serveUI serves the file manager, a single page working against the JSON
+// listing and the DELETE and MOVE file operations.
+func (s *Server) serveUI(conn net.Conn, req request) error {
+	if !s.authEnabled() {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+	if !s.authorized(req) {
+		if s.oidc != nil && req.headers.get("Authorization") == "" {
+			// People rather than scripts come here, so they are sent to
+			// log in.
+			h := header{"Location": {"/oidc/login?next=/ui"}}
+			conn.Write(buildResponseHeaders(statusFound, h, nil))
+			return nil
+		}
+		conn.Write(unauthorized())
+		return nil
+	}
+
+	c := content{
+		contentType: contentTypeTextHTML,
+		body:        uiPage,
+	}
+	conn.Write(buildResponse(statusOK, &c))
+
+	return nil
+}
+
+func (s *Server) deleteFile(conn net.Conn, req request) error {
+	name := req.fileName()
+	unlock, ok := s.locks.tryLock(name)
+	if !ok {
+		conn.Write(buildResponse(statusConflict, nil))
+		return nil
+	}
+	defer unlock()
+
+	if err := s.trashFile(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			conn.Write(buildResponse(statusNotFound, nil))
+			return nil
+		}
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return fmt.Errorf("error deleting %s: %v\n", name, err)
+	}
+	conn.Write(buildResponse(statusNoContent, nil))
+
+	return nil
+}
+
+// moveFile renames a file to the /files/... path or URL in the Destination
+// header, as WebDAV's MOVE does.
+func (s *Server) moveFile(conn net.Conn, req request) error {
+	from := req.fileName()
+	to, ok := destinationName(req.headers.get("Destination"))
+	if !ok || to == from || reservedName(to) {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+
+	unlock, ok := s.locks.tryLock(from, to)
+	if !ok {
+		conn.Write(buildResponse(statusConflict, nil))
+		return nil
+	}
+	defer unlock()
+
+	info, err := s.store.Stat(from)
+	if errors.Is(err, fs.ErrNotExist) {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	} else if err != nil {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return fmt.Errorf("error reading %s: %v\n", from, err)
+	}
+	if !info.IsDir() {
+		ok, err := s.acceptMove(conn, from, to)
+		if err != nil || !ok {
+			return err
+		}
+	}
+
+	if err := s.store.Rename(from, to); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			conn.Write(buildResponse(statusNotFound, nil))
+			return nil
+		}
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return fmt.Errorf("error renaming %s to %s: %v\n", from, to, err)
+	}
+	conn.Write(buildResponse(statusCreated, nil))
+
+	return nil
+}
+
+// acceptMove answers a move of the file from to the name to if the file
+// couldn't have been uploaded under it, going by the access files over to
+// and the upload types, and reports whether the move may go on.
+func (s *Server) acceptMove(conn net.Conn, from, to string) (bool, error) {
+	if !s.accessRules(to).takesName(to) {
+		refuseUpload(conn, statusUnsupportedMediaType, fmt.Sprintf("files named %s can't be moved there", path.Base(to)))
+		return false, nil
+	}
+
+	f, err := s.store.Open(from)
+	if err != nil {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return false, fmt.Errorf("error opening %s: %v\n", from, err)
+	}
+	defer f.Close()
+	// The sniffer looks no further than this.
+	head, err := io.ReadAll(io.LimitReader(f, 512))
+	if err != nil {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return false, fmt.Errorf("error reading %s: %v\n", from, err)
+	}
+	if !s.acceptUpload(to, head) {
+		conn.Write(buildResponse(statusUnsupportedMediaType, nil))
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// destinationName returns the store name a Destination header points at,
+// its path decoded segment by segment as request paths are, so a name is
+// read the same way wherever it was escaped.
+func destinationName(dest string) (string, bool) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", false
+	}
+	parts, err := splitPath(u.EscapedPath())
+	if err != nil || len(parts) < 3 || parts[0] != "" || parts[1] != "files" {
+		return "", false
+	}
+	name := joinName(parts[2:]...)
+
+	return name, name != ""
+}
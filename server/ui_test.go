@@ -0,0 +1,97 @@
+package server_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestFileManager(t *testing.T) {
+	opts := servertest.Options()
+	opts.UIAuth = "me:secret"
+	c := servertest.NewPipe(t, opts).Client()
+
+	auth := http.Header{"Authorization": {"Basic bWU6c2VjcmV0"}}
+	wrong := http.Header{"Authorization": {"Basic bWU6d3Jvbmc="}}
+
+	c.Get("/ui").
+		AssertStatus(http.StatusUnauthorized).
+		AssertHeader("WWW-Authenticate", `Basic realm="naive-server", charset="UTF-8"`)
+	c.Do(http.MethodGet, "/ui", nil, wrong).AssertStatus(http.StatusUnauthorized)
+	c.Do(http.MethodGet, "/ui", nil, auth).AssertStatus(http.StatusOK)
+
+	c.Do(http.MethodPut, "/files/d/a.txt", strings.NewReader("hello"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/d/?format=json").
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "application/json").
+		AssertBodyContains(`"name":"a.txt","url":"/files/d/a.txt","dir":false,"size":5`)
+
+	move := http.Header{"Destination": {"/files/e/b.txt"}}
+	c.Do("MOVE", "/files/d/a.txt", nil, move).AssertStatus(http.StatusUnauthorized)
+	move["Authorization"] = auth["Authorization"]
+	c.Do("MOVE", "/files/d/a.txt", nil, move).AssertStatus(http.StatusCreated)
+	c.Get("/files/d/a.txt").AssertStatus(http.StatusNotFound)
+	c.Get("/files/e/b.txt").AssertBody("hello")
+
+	c.Do(http.MethodDelete, "/files/e/b.txt", nil, wrong).AssertStatus(http.StatusUnauthorized)
+	c.Do(http.MethodDelete, "/files/e/b.txt", nil, auth).AssertStatus(http.StatusNoContent)
+	c.Do(http.MethodDelete, "/files/e/b.txt", nil, auth).AssertStatus(http.StatusNotFound)
+	c.Get("/files/?format=json").AssertBody("[]")
+}
+
+// TestFileManagerEscapedNames goes through what the page does with a name
+// that needs escaping: it follows the url from the listing to download and
+// delete, and escapes the new name with encodeURIComponent to rename.
+func TestFileManagerEscapedNames(t *testing.T) {
+	opts := servertest.Options()
+	opts.UIAuth = "me:secret"
+	c := servertest.NewPipe(t, opts).Client()
+	auth := http.Header{"Authorization": {"Basic bWU6c2VjcmV0"}}
+
+	c.Do(http.MethodPut, "/files/my%20docs/to%20do+1.txt", strings.NewReader("milk"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/my%20docs/?format=json").AssertBodyContains(`"name":"to do+1.txt","url":"/files/my%20docs/to%20do+1.txt"`)
+	c.Get("/files/my%20docs/to%20do+1.txt").AssertBody("milk")
+
+	move := http.Header{"Destination": {"/files/my%20docs/%C3%A9t%C3%A9%20%2B%26.txt"}, "Authorization": auth["Authorization"]}
+	c.Do("MOVE", "/files/my%20docs/to%20do+1.txt", nil, move).AssertStatus(http.StatusCreated)
+	c.Get("/files/my%20docs/?format=json").AssertBodyContains(`"name":"été +\u0026.txt","url":"/files/my%20docs/%C3%A9t%C3%A9%20+\u0026.txt"`)
+	c.Get("/files/my%20docs/%C3%A9t%C3%A9%20+&.txt").AssertBody("milk")
+	c.Do(http.MethodDelete, "/files/my%20docs/%C3%A9t%C3%A9%20+&.txt", nil, auth).AssertStatus(http.StatusNoContent)
+	c.Get("/files/?format=json").AssertBody("[]")
+}
+
+func TestFileManagerDisabled(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	c.Get("/ui").AssertStatus(http.StatusNotFound)
+	c.Do(http.MethodDelete, "/files/a.txt", nil, nil).AssertStatus(http.StatusMethodNotAllowed)
+}
+
+func TestFileManagerMoveTypes(t *testing.T) {
+	opts := servertest.Options()
+	opts.UIAuth = "me:secret"
+	opts.UploadTypes = []string{"text/plain", "image/*"}
+	opts.UploadSniff = true
+	opts.Storage = "disk"
+	opts.Directory = t.TempDir()
+	c := servertest.NewPipe(t, opts).Client()
+
+	auth := http.Header{"Authorization": {"Basic bWU6c2VjcmV0"}}
+	move := func(from, to string) *servertest.Response {
+		return c.Do("MOVE", "/files/"+from, nil, http.Header{"Authorization": auth["Authorization"], "Destination": {"/files/" + to}})
+	}
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("hi"), nil).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodPut, "/files/a.png", strings.NewReader("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), nil).AssertStatus(http.StatusCreated)
+
+	// A file can't be renamed to what it couldn't be uploaded as.
+	move("a.txt", "a.html").AssertStatus(http.StatusUnsupportedMediaType)
+	move("a.png", "b.txt").AssertStatus(http.StatusUnsupportedMediaType)
+	move("a.png", "b.jpg").AssertStatus(http.StatusCreated)
+	c.Get("/files/a.txt").AssertStatus(http.StatusOK)
+	move("a.txt", "sub/b.txt").AssertStatus(http.StatusCreated)
+	// Directories hold no content of their own to check.
+	move("sub", "other.html").AssertStatus(http.StatusCreated)
+	c.Get("/files/other.html/b.txt").AssertBody("hi")
+}
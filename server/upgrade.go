@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// UpgradeRequest describes a request to switch a connection to another
+// protocol.
+type UpgradeRequest struct {
+	// Protocol is the registered Upgrade token the request was matched on,
+	// lowercased.
+	Protocol   string
+	Method     string
+	Target     string
+	Header     http.Header
+	RemoteAddr string
+}
+
+// UpgradeHandler takes over a connection whose request asked to upgrade to
+// the protocol it was registered for. It answers the request itself, with
+// 101 Switching Protocols or a refusal, and speaks the new protocol from
+// there on. Reads from conn start with whatever the client sent after the
+// request head, its body included. conn is closed once the handler returns.
+type UpgradeHandler func(conn net.Conn, req UpgradeRequest)
+
+// HandleUpgrade registers h for requests carrying Connection: Upgrade with
+// token among their Upgrade protocols, e.g. "websocket" or "h2c". Tokens
+// are matched case-insensitively; registering one again replaces its
+// handler.
+func (s *Server) HandleUpgrade(token string, h UpgradeHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.upgrades == nil {
+		s.upgrades = make(map[string]UpgradeHandler)
+	}
+	s.upgrades[strings.ToLower(token)] = h
+}
+
+// upgradeHandler returns the handler for the first protocol req asks to
+// upgrade to that one is registered for, with its token.
+func (s *Server) upgradeHandler(req request) (UpgradeHandler, string) {
+	if !hasToken(req.headers.get("Connection"), "upgrade") {
+		return nil, ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range strings.Split(req.headers.get("Upgrade"), ",") {
+		token = strings.ToLower(strings.TrimSpace(token))
+		if h, ok := s.upgrades[token]; ok {
+			return h, token
+		}
+	}
+
+	return nil, ""
+}
+
+// upgrade hands the connection of ex to h, reads coming through r so that
+// nothing the client has sent is lost.
+func upgrade(ex *exchangeConn, r *bufio.Reader, req request, h UpgradeHandler, token string) {
+	h(&upgradedConn{Conn: ex.Conn, r: r, ex: ex}, UpgradeRequest{
+		Protocol:   token,
+		Method:     req.method,
+		Target:     req.target(),
+		Header:     http.Header(req.headers),
+		RemoteAddr: req.remoteAddr,
+	})
+}
+
+// upgradedConn is a connection taken over by an upgrade handler. It notes
+// the status the handler answered with and the bytes it sent, for the
+// access log.
+type upgradedConn struct {
+	net.Conn
+	r  *bufio.Reader
+	ex *exchangeConn
+}
+
+func (c *upgradedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *upgradedConn) Write(p []byte) (int, error) {
+	if c.ex.status == 0 {
+		c.ex.status = headStatus(p)
+	}
+	n, err := c.Conn.Write(p)
+	c.ex.written += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,69 @@
+package server_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestUpgrade(t *testing.T) {
+	srv, err := server.New(servertest.Options())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Shutdown() })
+
+	got := make(chan server.UpgradeRequest, 1)
+	srv.HandleUpgrade("X-Echo", func(conn net.Conn, req server.UpgradeRequest) {
+		got <- req
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: x-echo\r\n\r\n")
+		io.Copy(conn, conn)
+	})
+
+	// dial serves a fresh connection and sends raw on it in one write.
+	dial := func(raw string) (net.Conn, *bufio.Reader) {
+		client, conn := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go srv.ServeConn(conn)
+		go io.WriteString(client, raw)
+
+		return client, bufio.NewReader(client)
+	}
+
+	// The bytes sent right behind the request reach the handler.
+	client, r := dial("GET /chat?room=1 HTTP/1.1\r\nHost: x\r\nConnection: keep-alive, Upgrade\r\nUpgrade: h2c, x-echo\r\n\r\nhello")
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got %d, want 101", resp.StatusCode)
+	}
+	req := <-got
+	if req.Protocol != "x-echo" || req.Target != "/chat?room=1" || req.Header.Get("Host") != "x" {
+		t.Errorf("got upgrade request %+v", req)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("got %q, %v echoed, want hello", buf, err)
+	}
+	go io.WriteString(client, "again")
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "again" {
+		t.Errorf("got %q, %v echoed, want again", buf, err)
+	}
+
+	// Protocols nothing is registered for are served as usual.
+	_, r = dial("GET /echo/hi HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	resp, err = http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got %d for an unregistered protocol, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"strings"
+)
+
+//go:embed templates/upload.html
+var uploadPageHTML string
+
+// uploadPage is the browser upload form, executed with the path it posts
+// to.
+var uploadPage = template.Must(template.New("upload").Parse(uploadPageHTML))
+
+// serveUploadPage serves the browser upload form posting to action.
+func serveUploadPage(conn net.Conn, action string) {
+	var buf bytes.Buffer
+	if err := uploadPage.Execute(&buf, action); err != nil {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return
+	}
+	c := content{
+		contentType: contentTypeTextHTML,
+		body:        buf.Bytes(),
+	}
+	conn.Write(buildResponse(statusOK, &c))
+}
+
+// isMultipartForm reports whether the request body is multipart form data,
+// returning its boundary.
+func isMultipartForm(req request) (string, bool) {
+	mediaType, params, err := mime.ParseMediaType(req.headers.get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return "", false
+	}
+
+	return params["boundary"], true
+}
+
+// postForm stores every file in a multipart form under the directory the
+// request was posted to, answering with the stored names one per line and,
+// when storing by content, their hashes in X-Content-Hash in the same order.
+func (s *Server) postForm(conn net.Conn, body io.Reader, req request, boundary string) error {
+	dir := req.fileName()
+
+	return s.storeForm(conn, body, req, boundary, 0, func(fileName string) string {
+		return joinName(dir, fileName)
+	})
+}
+
+// storeForm stores every file in a multipart form as the name nameOf gives
+// its file name, skipping those it gives "" for, and answers as postForm
+// does. If maxSize isn't 0 the files may hold that many bytes in all. The
+// files are staged as the form is read and only stored once all of it has
+// been, so a form that fails part way, or doesn't match its digests, stores
+// none of them.
+func (s *Server) storeForm(conn net.Conn, body io.Reader, req request, boundary string, maxSize int64, nameOf func(fileName string) string) error {
+	mr := multipart.NewReader(s.throttleReader(req, body), boundary)
+	var limited *limitReader
+	if maxSize > 0 {
+		limited = &limitReader{n: maxSize, err: errLinkTooLarge}
+	}
+
+	var pending []formFile
+	locked := make(map[string]func())
+	defer func() {
+		for _, f := range pending {
+			s.store.Delete(f.staged)
+		}
+		for _, unlock := range locked {
+			unlock()
+		}
+	}()
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			conn.Write(buildResponse(formErrorStatus(err), nil))
+			return fmt.Errorf("error reading form: %v\n", err)
+		}
+
+		// Parts that aren't files, like other form fields, are skipped.
+		fileName := part.FileName()
+		if fileName == "" {
+			continue
+		}
+		name := nameOf(fileName)
+		if name == "" || reservedName(name) {
+			continue
+		}
+
+		var r io.Reader = part
+		if limited != nil {
+			limited.r = part
+			r = limited
+		}
+		f, ok, err := s.postFormFile(conn, name, r, locked)
+		if !ok {
+			return err
+		}
+		pending = append(pending, f)
+	}
+
+	// Digests are checked where the body ends, which the form's closing
+	// boundary may come before.
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		conn.Write(buildResponse(formErrorStatus(err), nil))
+		return fmt.Errorf("error reading form: %v\n", err)
+	}
+	if len(pending) == 0 {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+
+	var stored []string
+	h := header{}
+	for len(pending) > 0 {
+		f := pending[0]
+		pending = pending[1:]
+		if err := s.commitFile(f.staged, f.name); err != nil {
+			conn.Write(buildResponse(statusInternalServerError, nil))
+			return fmt.Errorf("error storing %s: %v\n", f.name, err)
+		}
+		stored = append(stored, f.name)
+		if sum := s.contentHash(f.name); sum != "" {
+			h.add("X-Content-Hash", sum)
+		}
+
+		s.uploaded(f.name, f.received, req.clientIP)
+	}
+
+	c := content{
+		contentType: contentTypeTextPlain,
+		body:        []byte(strings.Join(stored, "\n") + "\n"),
+	}
+	conn.Write(buildResponseHeaders(statusCreated, h, &c))
+
+	return nil
+}
+
+// formFile is a file of a form staged to be stored as name.
+type formFile struct {
+	name, staged string
+	received     receivedFile
+}
+
+// postFormFile stages one file of a form, taking the lock on its name into
+// locked unless it is there already, for storeForm to hold until the form
+// is stored. It answers the request itself on failure and returns false
+// then.
+func (s *Server) postFormFile(conn net.Conn, name string, part io.Reader, locked map[string]func()) (formFile, bool, error) {
+	if _, ok := locked[name]; !ok {
+		unlock, ok := s.locks.tryLock(name)
+		if !ok {
+			conn.Write(buildResponse(statusConflict, nil))
+			return formFile{}, false, nil
+		}
+		locked[name] = unlock
+	}
+
+	part, ok := s.limitUpload(conn, name, part, -1)
+	if !ok {
+		return formFile{}, false, nil
+	}
+
+	staged, received, err := s.stageFile(name, part)
+	if errors.Is(err, errUnacceptedType) {
+		conn.Write(buildResponse(statusUnsupportedMediaType, nil))
+		return formFile{}, false, nil
+	}
+	if errors.Is(err, errStoringUpload) {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return formFile{}, false, fmt.Errorf("error storing %s: %v\n", name, err)
+	}
+	if err != nil {
+		conn.Write(buildResponse(formErrorStatus(err), nil))
+		return formFile{}, false, fmt.Errorf("error reading form: %v\n", err)
+	}
+
+	return formFile{name: name, staged: staged, received: received}, true, nil
+}
+
+// formErrorStatus returns the status to answer a malformed form with.
+func formErrorStatus(err error) int {
+	if errors.Is(err, errInflatedTooLarge) || errors.Is(err, errLinkTooLarge) || errors.Is(err, errFileTooLarge) {
+		return statusPayloadTooLarge
+	}
+
+	return statusBadRequest
+}
@@ -0,0 +1,65 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestUploadForm(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	c.Get("/upload").
+		AssertStatus(http.StatusOK).
+		AssertBodyContains(`enctype="multipart/form-data"`)
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("note", "not a file")
+	fw, _ := w.CreateFormFile("file", "a.txt")
+	fw.Write([]byte("hello"))
+	fw, _ = w.CreateFormFile("file", "../b.txt")
+	fw.Write([]byte("world"))
+	w.Close()
+
+	c.Do(http.MethodPost, "/files/docs/", &body, http.Header{"Content-Type": {w.FormDataContentType()}}).
+		AssertStatus(http.StatusCreated).
+		AssertBody("docs/a.txt\ndocs/b.txt\n")
+	c.Get("/files/docs/a.txt").AssertBody("hello")
+	c.Get("/files/docs/b.txt").AssertBody("world")
+}
+
+func TestPutFailedLeavesFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, ".naive-access"), []byte("max-file-size 8\n"), 0o644)
+	opts := servertest.Options()
+	opts.Storage = "disk"
+	opts.Directory = dir
+	opts.Versions = 2
+	c := servertest.NewPipe(t, opts).Client()
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("old"), nil).AssertStatus(http.StatusCreated)
+
+	// Sent without a length, the body is only found too large once it is
+	// being stored.
+	big := io.MultiReader(strings.NewReader(strings.Repeat("x", 100)))
+	c.Do(http.MethodPut, "/files/a.txt", big, nil).AssertStatus(http.StatusRequestEntityTooLarge)
+	c.Get("/files/a.txt").AssertStatus(http.StatusOK).AssertBody("old")
+	c.Get("/files/a.txt?versions").AssertBody("[]")
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("new"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/a.txt").AssertBody("new")
+
+	// Nothing staged is left behind.
+	if staged, _ := os.ReadDir(filepath.Join(dir, ".uploads")); len(staged) != 0 {
+		t.Errorf("got %d staged uploads left", len(staged))
+	}
+	c.Get("/files/.uploads/").AssertStatus(http.StatusNotFound)
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultUploadLinkTTL is how long upload links work for unless minted
+	// with ?expires.
+	defaultUploadLinkTTL = 24 * time.Hour
+	// maxUploadLinkTTL is the longest upload links can work for.
+	maxUploadLinkTTL = 30 * 24 * time.Hour
+)
+
+var errLinkTooLarge = errors.New("upload larger than its link allows")
+
+// uploadLink is what a client holding an upload link may upload: one file
+// as target, or the files of one form into it if it is a directory, of
+// maxSize bytes at most in all, before expires.
+type uploadLink struct {
+	target  string
+	dir     bool
+	maxSize int64
+	expires time.Time
+	// inUse is set while an upload through the link runs, so a second one
+	// is refused rather than racing it for the single use.
+	inUse bool
+}
+
+// uploadLinks holds the upload links minted since the server started, by
+// token. Links go once used or expired.
+type uploadLinks struct {
+	mu    sync.Mutex
+	links map[string]*uploadLink
+}
+
+func newUploadLinks() *uploadLinks {
+	return &uploadLinks{links: make(map[string]*uploadLink)}
+}
+
+// mint adds a link and returns its token.
+func (l *uploadLinks) mint(link *uploadLink) string {
+	var b [16]byte
+	rand.Read(b[:])
+	token := hex.EncodeToString(b[:])
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for t, other := range l.links {
+		if !time.Now().Before(other.expires) {
+			delete(l.links, t)
+		}
+	}
+	l.links[token] = link
+
+	return token
+}
+
+// lookup returns the link of token if it works and isn't in use.
+func (l *uploadLinks) lookup(token string) *uploadLink {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	link := l.links[token]
+	if link == nil || link.inUse || !time.Now().Before(link.expires) {
+		return nil
+	}
+
+	return link
+}
+
+// claim marks the link of token in use, returning it, or nil if there is
+// no such link to use.
+func (l *uploadLinks) claim(token string) *uploadLink {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	link := l.links[token]
+	if link == nil || link.inUse {
+		return nil
+	}
+	if !time.Now().Before(link.expires) {
+		delete(l.links, token)
+		return nil
+	}
+	link.inUse = true
+
+	return link
+}
+
+// release ends the use of the link of token, deleting it if the upload
+// succeeded and leaving it for another try if not.
+func (l *uploadLinks) release(token string, used bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if used {
+		delete(l.links, token)
+		return
+	}
+	if link := l.links[token]; link != nil {
+		link.inUse = false
+	}
+}
+
+// mintUploadLink answers POST /upload/links from a user of the file
+// manager with a link for anyone to upload through once. ?path is the
+// /files path uploads go to, a directory if it ends with /, ?max-size
+// caps their size, as in 10MB, and ?expires is how long the link works
+// for.
+func (s *Server) mintUploadLink(conn net.Conn, req request) error {
+	if !s.authEnabled() {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+	if !s.authorized(req) {
+		conn.Write(unauthorized())
+		return nil
+	}
+
+	p, ok := strings.CutPrefix(req.query.Get("path"), "/files/")
+	name := cleanName(p)
+	if !ok || reservedName(name) {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+	link := &uploadLink{target: name, dir: name == "" || strings.HasSuffix(p, "/")}
+
+	if v := req.query.Get("max-size"); v != "" {
+		var size byteRate
+		if err := size.Set(v); err != nil || size <= 0 {
+			conn.Write(buildResponse(statusBadRequest, nil))
+			return nil
+		}
+		link.maxSize = int64(size)
+	}
+	ttl := defaultUploadLinkTTL
+	if v := req.query.Get("expires"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 || ttl > maxUploadLinkTTL {
+			conn.Write(buildResponse(statusBadRequest, nil))
+			return nil
+		}
+	}
+	link.expires = time.Now().Add(ttl)
+
+	location := "/upload/" + s.uploadLinks.mint(link)
+	s.log.Info("upload link minted", "id", req.id, "target", "/files/"+link.target, "expires", link.expires)
+	c := content{
+		contentType: contentTypeTextPlain,
+		body:        []byte(location + "\n"),
+	}
+	h := header{}
+	h.set("Location", location)
+	conn.Write(buildResponseHeaders(statusCreated, h, &c))
+
+	return nil
+}
+
+// serveUploadLinkPage serves the upload form posting through the link of
+// token.
+func (s *Server) serveUploadLinkPage(conn net.Conn, token string) {
+	if s.uploadLinks.lookup(token) == nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return
+	}
+
+	serveUploadPage(conn, "/upload/"+token)
+}
+
+// uploadThroughLink stores what is posted to the link of token, a form or,
+// for a link to a file, the file itself, and uses the link up if that
+// worked.
+func (s *Server) uploadThroughLink(conn net.Conn, body io.Reader, req request, token string) error {
+	link := s.uploadLinks.claim(token)
+	if link == nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+	ex, _ := conn.(*exchangeConn)
+	var err error
+	defer func() {
+		s.uploadLinks.release(token, err == nil && (ex == nil || ex.status == statusCreated))
+	}()
+
+	// The upload is served as one to the target would be.
+	target := req
+	target.pathParts = append([]string{"", "files"}, strings.Split(link.target, "/")...)
+	target.path = "/files/" + link.target
+
+	boundary, isForm := isMultipartForm(req)
+	switch {
+	case isForm && link.dir:
+		// Whoever has the link can add files, but not replace those there.
+		err = s.storeForm(conn, body, target, boundary, link.maxSize, func(fileName string) string {
+			name := joinName(link.target, fileName)
+			if _, err := s.store.Stat(name); err == nil {
+				return ""
+			}
+			return name
+		})
+	case isForm:
+		stored := false
+		err = s.storeForm(conn, body, target, boundary, link.maxSize, func(string) string {
+			if stored {
+				return ""
+			}
+			stored = true
+			return link.target
+		})
+	case link.dir:
+		conn.Write(buildResponse(statusBadRequest, nil))
+	case link.maxSize > 0 && int64(req.contentLength) > link.maxSize:
+		conn.Write(buildResponse(statusPayloadTooLarge, nil))
+	default:
+		if link.maxSize > 0 {
+			body = &limitReader{r: body, n: link.maxSize, err: errLinkTooLarge}
+		}
+		err = s.putFile(conn, body, target)
+	}
+
+	return err
+}
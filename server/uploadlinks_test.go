@@ -0,0 +1,80 @@
+package server_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// form builds a multipart form of one file, returning it and its headers.
+func form(fileName, data string) (*bytes.Buffer, http.Header) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fw, _ := w.CreateFormFile("file", fileName)
+	fw.Write([]byte(data))
+	w.Close()
+
+	return &body, http.Header{"Content-Type": {w.FormDataContentType()}}
+}
+
+func TestUploadLinks(t *testing.T) {
+	opts := servertest.Options()
+	opts.UIAuth = "me:secret"
+	c := servertest.NewPipe(t, opts).Client()
+	auth := basicAuth("me", "secret")
+
+	mint := func(query string) string {
+		t.Helper()
+		resp := c.Do(http.MethodPost, "/upload/links?"+query, nil, auth).AssertStatus(http.StatusCreated)
+		return resp.Header.Get("Location")
+	}
+
+	c.Do(http.MethodPost, "/upload/links?path=/files/inbox/", nil, nil).AssertStatus(http.StatusUnauthorized)
+	link := mint("path=/files/inbox/&max-size=10&expires=1h")
+	if !strings.HasPrefix(link, "/upload/") {
+		t.Fatalf("got link %q", link)
+	}
+	c.Get(link).AssertStatus(http.StatusOK).AssertBodyContains(`action="` + link + `"`)
+
+	// Failed uploads leave the link for another try.
+	c.Do(http.MethodPost, link, strings.NewReader("raw"), nil).AssertStatus(http.StatusBadRequest)
+	body, h := form("big.txt", "more than ten bytes")
+	c.Do(http.MethodPost, link, body, h).AssertStatus(http.StatusRequestEntityTooLarge)
+	body, h = form("a.txt", "hello")
+	c.Do(http.MethodPost, link, body, h).AssertStatus(http.StatusCreated).AssertBody("inbox/a.txt\n")
+	c.Get("/files/inbox/a.txt").AssertStatus(http.StatusOK).AssertBody("hello")
+	c.Get("/files/inbox/big.txt").AssertStatus(http.StatusNotFound)
+
+	// A link works once.
+	body, h = form("b.txt", "again")
+	c.Do(http.MethodPost, link, body, h).AssertStatus(http.StatusNotFound)
+	c.Get(link).AssertStatus(http.StatusNotFound)
+
+	// Links to a directory don't replace what is there.
+	link = mint("path=/files/inbox/")
+	body, h = form("a.txt", "replaced")
+	c.Do(http.MethodPost, link, body, h).AssertStatus(http.StatusBadRequest)
+	c.Get("/files/inbox/a.txt").AssertBody("hello")
+
+	// Links to a file take it raw or as a form.
+	link = mint("path=/files/exact.txt")
+	c.Do(http.MethodPost, link, strings.NewReader("raw"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/exact.txt").AssertBody("raw")
+	link = mint("path=/files/named.txt")
+	body, h = form("whatever.txt", "formed")
+	c.Do(http.MethodPost, link, body, h).AssertStatus(http.StatusCreated).AssertBody("named.txt\n")
+	c.Get("/files/named.txt").AssertBody("formed")
+
+	c.Do(http.MethodPost, "/upload/"+strings.Repeat("0", 32), strings.NewReader("x"), nil).AssertStatus(http.StatusNotFound)
+	for _, query := range []string{"path=/etc/passwd", "path=/files/.versions/", "path=/files/&expires=-1s", "path=/files/&expires=9000h", "path=/files/&max-size=lots"} {
+		c.Do(http.MethodPost, "/upload/links?"+query, nil, auth).AssertStatus(http.StatusBadRequest)
+	}
+
+	// Without users there is nobody to mint links.
+	servertest.NewPipe(t, servertest.Options()).Client().
+		Do(http.MethodPost, "/upload/links?path=/files/", nil, nil).AssertStatus(http.StatusNotFound)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+)
+
+// errFileTooLarge fails reading an upload past the max-file-size of the
+// access files over it.
+var errFileTooLarge = errors.New("upload larger than its directory allows")
+
+// takesName reports whether the rules let a file be stored under name,
+// going by the extensions they allow and deny. Extensions are matched at
+// the end of the name, so .tar.gz can be told from .gz.
+func (r accessRules) takesName(name string) bool {
+	base := strings.ToLower(path.Base(name))
+	hasExt := func(exts []string) bool {
+		for _, ext := range exts {
+			if strings.HasSuffix(base, ext) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return !hasExt(r.denyExtensions) && (len(r.extensions) == 0 || hasExt(r.extensions))
+}
+
+// limitUpload answers an upload to name the access files over it don't
+// take going by its name, or its size if that is known up front and not
+// -1, and reports whether it may go on. Otherwise it returns body failing
+// with errFileTooLarge past the largest file they allow, before any of it
+// is stored.
+func (s *Server) limitUpload(conn net.Conn, name string, body io.Reader, size int64) (io.Reader, bool) {
+	rules := s.accessRules(name)
+	if !rules.takesName(name) {
+		refuseUpload(conn, statusUnsupportedMediaType, fmt.Sprintf("files named %s can't be uploaded here", path.Base(name)))
+		return nil, false
+	}
+	if rules.maxFileSize <= 0 {
+		return body, true
+	}
+	if size > rules.maxFileSize {
+		refuseUpload(conn, statusPayloadTooLarge, fmt.Sprintf("files larger than %d bytes can't be uploaded here", rules.maxFileSize))
+		return nil, false
+	}
+
+	return &limitReader{r: body, n: rules.maxFileSize, err: errFileTooLarge}, true
+}
+
+// refuseUpload answers an upload with status, saying why in the body.
+func refuseUpload(conn net.Conn, status int, reason string) {
+	c := content{
+		contentType: contentTypeTextPlain,
+		body:        []byte(reason + "\n"),
+	}
+	conn.Write(buildResponse(status, &c))
+}
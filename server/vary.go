@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+)
+
+// varyOn records that the response to r was negotiated on the request header
+// name, so that it is listed in the Vary header of the response.
+func (r request) varyOn(name string) {
+	if r.vary == nil {
+		return
+	}
+	for _, v := range *r.vary {
+		if strings.EqualFold(v, name) {
+			return
+		}
+	}
+	*r.vary = append(*r.vary, name)
+}
+
+// mergeVary returns the response head with names added to its Vary header.
+// Vary lines already in head, say from a proxied upstream, are folded into
+// the one written.
+func mergeVary(head []byte, names []string) []byte {
+	lines := bytes.Split(head, []byte("\r\n"))
+
+	var out bytes.Buffer
+	out.Write(lines[0])
+	for _, line := range lines[1:] {
+		name, value, _ := bytes.Cut(line, []byte(":"))
+		if !strings.EqualFold(string(bytes.TrimSpace(name)), "Vary") {
+			out.WriteString("\r\n")
+			out.Write(line)
+			continue
+		}
+		for _, v := range strings.Split(string(value), ",") {
+			if v = strings.TrimSpace(v); v != "" && !hasToken(strings.Join(names, ","), v) {
+				names = append(names, v)
+			}
+		}
+	}
+
+	value := strings.Join(names, ", ")
+	if hasToken(value, "*") {
+		value = "*"
+	}
+	out.WriteString("\r\nVary: " + value)
+
+	return out.Bytes()
+}
@@ -0,0 +1,23 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestVary(t *testing.T) {
+	opts := servertest.Options()
+	opts.CORS = []server.CORSPolicy{{Prefix: "/files", Origins: []string{"*"}}}
+	c := servertest.NewPipe(t, opts).Client()
+
+	c.Get("/ip").AssertHeader("Vary", "Accept")
+	c.Get("/ip?format=json").AssertHeader("Vary", "")
+	c.Get("/echo/hi").AssertHeader("Vary", "")
+	c.Do(http.MethodGet, "/files/", nil, http.Header{"Accept": {"application/json"}}).
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", "application/json").
+		AssertHeader("Vary", "Origin, Accept")
+}
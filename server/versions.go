@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionsDir holds the prior versions of overwritten files, each under
+// .versions/<name>/<n> with n counting up from 1.
+const versionsDir = ".versions"
+
+// reservedName reports whether name lies in one of the directories the
+// server keeps for itself, or is an access file, which the /files routes
+// don't expose.
+func reservedName(name string) bool {
+	name = cleanName(name)
+	first, _, _ := strings.Cut(name, "/")
+
+	return first == versionsDir || first == trashDir || first == objectsDir || first == uploadsDir ||
+		path.Base(name) == accessFileName
+}
+
+type versionInfo struct {
+	Version  int       `json:"version"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// versions lists the kept versions of name, oldest first.
+func (s *Server) versions(name string) ([]versionInfo, error) {
+	infos, err := s.store.List(joinName(versionsDir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var vs []versionInfo
+	for _, info := range infos {
+		n, err := strconv.Atoi(info.Name())
+		if err != nil || info.IsDir() {
+			continue
+		}
+		vs = append(vs, versionInfo{Version: n, Size: info.Size(), Modified: info.ModTime()})
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i].Version < vs[j].Version })
+
+	return vs, nil
+}
+
+// keepVersion saves the current content of name as its next version before
+// it gets overwritten, dropping the oldest versions beyond the configured
+// number. It does nothing unless versioning is on and name exists.
+func (s *Server) keepVersion(name string) error {
+	if s.opts.Versions <= 0 {
+		return nil
+	}
+	if info, err := s.store.Stat(name); err != nil || info.IsDir() {
+		return nil
+	}
+
+	vs, err := s.versions(name)
+	if err != nil {
+		return err
+	}
+	next := 1
+	if len(vs) > 0 {
+		next = vs[len(vs)-1].Version + 1
+	}
+
+	if err := s.copyFile(name, versionName(name, next)); err != nil {
+		return err
+	}
+
+	for len(vs) >= s.opts.Versions {
+		if err := s.store.Delete(versionName(name, vs[0].Version)); err != nil {
+			return err
+		}
+		vs = vs[1:]
+	}
+
+	return nil
+}
+
+func versionName(name string, n int) string {
+	return joinName(versionsDir, name, strconv.Itoa(n))
+}
+
+func (s *Server) copyFile(from, to string) error {
+	f, err := s.store.Open(from)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return s.store.Write(to, f)
+}
+
+// serveVersions answers GET /files/name?versions with the kept versions of
+// name as JSON.
+func (s *Server) serveVersions(conn net.Conn, name string) error {
+	vs, err := s.versions(name)
+	if err != nil {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return fmt.Errorf("error listing versions of %s: %v\n", name, err)
+	}
+	if vs == nil {
+		vs = []versionInfo{}
+	}
+	conn.Write(buildResponse(statusOK, jsonContent(vs)))
+
+	return nil
+}
+
+// restoreVersion answers POST /files/name?restore=n by making version n the
+// current content of name. What it replaces is kept as a version in turn.
+func (s *Server) restoreVersion(conn net.Conn, name, version string) error {
+	n, err := strconv.Atoi(version)
+	if err != nil || n < 1 {
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil
+	}
+	unlock, ok := s.locks.tryLock(name)
+	if !ok {
+		conn.Write(buildResponse(statusConflict, nil))
+		return nil
+	}
+	defer unlock()
+
+	// Read it first, as keeping the current content may prune it.
+	data, err := s.readFile(versionName(name, n))
+	if err != nil {
+		conn.Write(buildResponse(statusNotFound, nil))
+		return nil
+	}
+
+	if err := s.writeFile(name, data); err != nil {
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return fmt.Errorf("error restoring version %d of %s: %v\n", n, name, err)
+	}
+	conn.Write(buildResponse(statusOK, nil))
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestVersions(t *testing.T) {
+	opts := servertest.Options()
+	opts.Versions = 2
+	c := servertest.NewPipe(t, opts).Client()
+
+	for _, body := range []string{"one", "two", "three", "four"} {
+		c.Do(http.MethodPut, "/files/d/a.txt", strings.NewReader(body), nil).AssertStatus(http.StatusCreated)
+	}
+	c.Get("/files/d/a.txt").AssertBody("four")
+
+	var versions []struct {
+		Version int   `json:"version"`
+		Size    int64 `json:"size"`
+	}
+	resp := c.Get("/files/d/a.txt?versions").AssertStatus(http.StatusOK)
+	if err := json.Unmarshal(resp.Body, &versions); err != nil {
+		t.Fatalf("error decoding versions %q: %v", resp.Body, err)
+	}
+	if len(versions) != 2 || versions[0].Version != 2 || versions[1].Version != 3 || versions[1].Size != 5 {
+		t.Fatalf("got versions %+v, want 2 and 3", versions)
+	}
+
+	c.Do(http.MethodPost, "/files/d/a.txt?restore=2", strings.NewReader(""), nil).AssertStatus(http.StatusOK)
+	c.Get("/files/d/a.txt").AssertBody("two")
+	c.Get("/files/d/a.txt?versions").AssertBodyContains(`"version":4,"size":4`)
+	c.Do(http.MethodPost, "/files/d/a.txt?restore=1", strings.NewReader(""), nil).AssertStatus(http.StatusNotFound)
+
+	c.Get("/files/?format=json").AssertBodyContains(`"name":"d"`)
+	if body := string(c.Get("/files/?format=json").Body); strings.Contains(body, ".versions") {
+		t.Errorf("listing %s shows the versions directory", body)
+	}
+	c.Get("/files/.versions/d/a.txt/3").AssertStatus(http.StatusNotFound)
+	c.Do(http.MethodPut, "/files/.versions/d/a.txt/3", strings.NewReader("x"), nil).AssertStatus(http.StatusNotFound)
+}
+
+func TestVersionsDisabled(t *testing.T) {
+	c := servertest.NewPipe(t, servertest.Options()).Client()
+
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("one"), nil).AssertStatus(http.StatusCreated)
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("two"), nil).AssertStatus(http.StatusCreated)
+	c.Get("/files/a.txt?versions").AssertBody("[]")
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// watchedStorage reports every change made through it, so caches derived
+// from the files can be dropped right away rather than on their next
+// lookup. Changes made behind the server's back aren't seen, but the
+// caches are keyed by modification time and size, so what they hold for a
+// file changed that way is never served; it just ages out.
+type watchedStorage struct {
+	storage
+	changed func(names ...string)
+}
+
+func (w watchedStorage) Write(name string, r io.Reader) error {
+	err := w.storage.Write(name, r)
+	if err == nil {
+		w.changed(name)
+	}
+
+	return err
+}
+
+func (w watchedStorage) Delete(name string) error {
+	err := w.storage.Delete(name)
+	if err == nil {
+		w.changed(name)
+	}
+
+	return err
+}
+
+func (w watchedStorage) Rename(from, to string) error {
+	err := w.storage.Rename(from, to)
+	if err == nil {
+		w.changed(from, to)
+	}
+
+	return err
+}
+
+// filesChanged drops what is cached about the named files and has the
+// file watcher look at the store, so dev mode pages reload and /watch
+// clients hear of the change without waiting for its next look.
+func (s *Server) filesChanged(names ...string) {
+	s.dropCached(names...)
+	s.watcher.poke()
+}
+
+// dropCached drops what is cached about the named files.
+func (s *Server) dropCached(names ...string) {
+	for _, name := range names {
+		if reservedName(name) {
+			continue
+		}
+		s.thumbs.invalidate(cleanName(name))
+		s.files.invalidate(cleanName(name))
+	}
+}
+
+// watchPollInterval is how often the store is looked at for changes while
+// anything is watching it, dev mode aside.
+const watchPollInterval = time.Second
+
+// fileEvent is a change to a file, as sent to /watch clients.
+type fileEvent struct {
+	// Type is create, modify or delete.
+	Type string    `json:"type"`
+	Name string    `json:"name"`
+	Size int64     `json:"size,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// fileState is what a change to a file changes.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// fileWatcher turns changes to the store into events for everything that
+// follows them: /watch clients and dev mode. It compares what the store holds
+// from one look to the next, which sees every change whatever makes it and
+// works the same for every storage backend without asking the OS for
+// change events, and there is one such loop however many follow it, so
+// the store is walked once a look. While nothing follows it, as is the
+// case unless dev mode is on or a /watch client is connected, it doesn't
+// look at all.
+type fileWatcher struct {
+	store    storage
+	interval time.Duration
+	done     <-chan struct{}
+
+	mu sync.Mutex
+	// listeners follow the store for as long as the server runs, and
+	// subscribers for as long as they are subscribed.
+	listeners   []func([]fileEvent)
+	subscribers map[chan fileEvent]struct{}
+	running     bool
+	pokes       chan struct{}
+}
+
+// newFileWatcher returns a watcher looking at store every interval until
+// done is closed.
+func newFileWatcher(store storage, interval time.Duration, done <-chan struct{}) *fileWatcher {
+	return &fileWatcher{
+		store:       store,
+		interval:    interval,
+		done:        done,
+		subscribers: make(map[chan fileEvent]struct{}),
+		pokes:       make(chan struct{}, 1),
+	}
+}
+
+// poke makes the watcher look now, for when the server knows it has just
+// changed something itself.
+func (w *fileWatcher) poke() {
+	if w == nil {
+		return
+	}
+	select {
+	case w.pokes <- struct{}{}:
+	default:
+	}
+}
+
+// listen has fn called with the events of every look from now on that
+// finds changes, until the server shuts down.
+func (w *fileWatcher) listen(fn func([]fileEvent)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.listeners = append(w.listeners, fn)
+	w.startLocked()
+}
+
+// subscribe returns a channel the events from now on are sent to. It is
+// closed if the subscriber falls too far behind, or the server shuts down.
+func (w *fileWatcher) subscribe() chan fileEvent {
+	ch := make(chan fileEvent, 64)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers[ch] = struct{}{}
+	w.startLocked()
+
+	return ch
+}
+
+// startLocked starts looking at the store, if the watcher isn't already.
+func (w *fileWatcher) startLocked() {
+	if w.running {
+		return
+	}
+	w.running = true
+	// The first look is taken before anyone is told of changes, so the
+	// files already there don't come as created.
+	go w.watch(snapshotStore(w.store))
+}
+
+func (w *fileWatcher) unsubscribe(ch chan fileEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.subscribers[ch]; ok {
+		delete(w.subscribers, ch)
+		close(ch)
+	}
+}
+
+// watch looks at the store until nothing follows it any more or the
+// server shuts down, sending the differences between one look and the
+// next.
+func (w *fileWatcher) watch(last map[string]fileState) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.pokes:
+		case <-w.done:
+			w.mu.Lock()
+			for ch := range w.subscribers {
+				delete(w.subscribers, ch)
+				close(ch)
+			}
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+
+		now := snapshotStore(w.store)
+		events := diffSnapshots(last, now, time.Now())
+		last = now
+
+		w.mu.Lock()
+		if len(w.listeners) == 0 && len(w.subscribers) == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+		listeners := w.listeners
+		for _, ev := range events {
+			for ch := range w.subscribers {
+				select {
+				case ch <- ev:
+				default:
+					// Missing events would leave the client out of step,
+					// so it is cut off to start over instead.
+					delete(w.subscribers, ch)
+					close(ch)
+				}
+			}
+		}
+		w.mu.Unlock()
+
+		if len(events) > 0 {
+			for _, fn := range listeners {
+				fn(events)
+			}
+		}
+	}
+}
+
+// snapshotStore returns the state of every file in store.
+func snapshotStore(store storage) map[string]fileState {
+	files := make(map[string]fileState)
+	walkFiles(store, "", func(name string, info fs.FileInfo) error {
+		files[name] = fileState{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+
+	return files
+}
+
+// diffSnapshots returns the events that make before into after, in name
+// order.
+func diffSnapshots(before, after map[string]fileState, now time.Time) []fileEvent {
+	var events []fileEvent
+	for name, st := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			events = append(events, fileEvent{Type: "create", Name: name, Size: st.size, Time: now})
+		case old != st:
+			events = append(events, fileEvent{Type: "modify", Name: name, Size: st.size, Time: now})
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			events = append(events, fileEvent{Type: "delete", Name: name, Time: now})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Name < events[j].Name })
+
+	return events
+}
+
+// serveWatch answers GET /watch, a WebSocket carrying a JSON fileEvent in
+// a text message for every change to the files the client may list, or
+// only to those below ?path= if it is given.
+func (s *Server) serveWatch(conn net.Conn, req request) error {
+	// Subscribing first means every change made once the client has the
+	// handshake's answer reaches it.
+	events := s.watcher.subscribe()
+	defer s.watcher.unsubscribe(events)
+	ws, ok := acceptWebSocket(conn, req)
+	if !ok {
+		return nil
+	}
+	defer ws.Close()
+
+	prefix := cleanName(req.query.Get("path"))
+	authorized := s.authorized(req)
+	visible := func(name string) bool {
+		if prefix != "" && name != prefix && !strings.HasPrefix(name, prefix+"/") {
+			return false
+		}
+		if req.apiKey != nil && !req.apiKey.covers("/files/"+name) {
+			return false
+		}
+		rules := s.accessRules(path.Dir(name))
+		return rules.allows(methodGet, authorized) && rules.listing
+	}
+
+	heartbeat := time.NewTicker(devHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				ws.close(wsCloseGoingAway)
+				return nil
+			}
+			if !visible(ev.Name) {
+				continue
+			}
+			data, _ := json.Marshal(ev)
+			err = ws.write(wsText, data)
+		case <-heartbeat.C:
+			err = ws.write(wsPing, nil)
+		case <-ws.done:
+			return nil
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
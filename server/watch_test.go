@@ -0,0 +1,109 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilesChangedDropsThumbnails(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Storage = storageMemory
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.png", "d/b.png", "d/e/c.png", "other.png"} {
+		s.thumbs.put(thumbKey{name: name, w: 10, h: 10}, &content{body: []byte("thumb")})
+	}
+
+	s.store.Write("a.png", strings.NewReader("new"))
+	s.store.Write("d/e/c.png", strings.NewReader("new"))
+	s.store.Rename("d", "f")
+
+	for name, want := range map[string]bool{"a.png": false, "d/b.png": false, "d/e/c.png": false, "other.png": true} {
+		if got := s.thumbs.get(thumbKey{name: name, w: 10, h: 10}) != nil; got != want {
+			t.Errorf("%s: cached %v, want %v", name, got, want)
+		}
+	}
+	if s.thumbs.used != int64(len("thumb")) {
+		t.Errorf("cache holds %d bytes, want %d", s.thumbs.used, len("thumb"))
+	}
+}
+
+func TestChangedFilesReadFresh(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.Directory = dir
+	opts.FileCacheSize = 1 << 20
+	s, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown()
+
+	// Nothing follows the store, so it isn't walked.
+	s.watcher.mu.Lock()
+	running := s.watcher.running
+	s.watcher.mu.Unlock()
+	if running {
+		t.Error("file watcher running with only the caches enabled")
+	}
+
+	read := func() string {
+		t.Helper()
+		info, err := s.store.Stat("a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := s.readCachedFile("a.txt", info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got := read(); got != "old" {
+		t.Fatalf("got %q, want old", got)
+	}
+
+	// Written behind the server's back.
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("newer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != "newer" {
+		t.Errorf("got %q, want newer", got)
+	}
+}
+
+// failingStorage fails every write.
+type failingStorage struct {
+	storage
+}
+
+func (failingStorage) Write(string, io.Reader) error {
+	return errors.New("disk full")
+}
+
+func TestWatchedStorageFailedWrite(t *testing.T) {
+	var changed []string
+	w := watchedStorage{
+		storage: failingStorage{newMemStorage()},
+		changed: func(names ...string) { changed = append(changed, names...) },
+	}
+	if err := w.Write("a.txt", strings.NewReader("a")); err == nil {
+		t.Fatal("got no error from a failing write")
+	}
+	if err := w.Delete("a.txt"); err == nil {
+		t.Fatal("got no error deleting a missing file")
+	}
+	if len(changed) != 0 {
+		t.Errorf("got %q changed by failed writes", changed)
+	}
+}
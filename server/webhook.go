@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	webhookTimeout     = 10 * time.Second
+	webhookBaseBackoff = time.Second
+	webhookMaxBackoff  = time.Minute
+)
+
+type uploadEvent struct {
+	Filename   string `json:"filename"`
+	Size       int64  `json:"size"`
+	Checksum   string `json:"checksum"`
+	UploaderIP string `json:"uploader_ip"`
+}
+
+func newUploadEvent(filename string, f receivedFile, ip string) uploadEvent {
+	return uploadEvent{
+		Filename:   filename,
+		Size:       f.size,
+		Checksum:   "sha256:" + hex.EncodeToString(f.sum),
+		UploaderIP: ip,
+	}
+}
+
+// notifyUpload delivers the event to the configured webhook, retrying with
+// exponential backoff until it is accepted, the attempts run out or the
+// server shuts down.
+func (s *Server) notifyUpload(ev uploadEvent) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		s.log.Error("error encoding upload event", "err", err)
+		return
+	}
+
+	client := http.Client{Timeout: webhookTimeout}
+	backoff := webhookBaseBackoff
+
+	for attempt := 1; attempt <= s.opts.WebhookTries; attempt++ {
+		err = postEvent(&client, s.opts.WebhookURL, payload)
+		if err == nil {
+			return
+		}
+
+		s.log.Warn("webhook attempt failed", "file", ev.Filename, "attempt", attempt, "tries", s.opts.WebhookTries, "err", err)
+		if attempt == s.opts.WebhookTries {
+			break
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-s.closed:
+			t.Stop()
+			s.log.Error("giving up on webhook on shutdown", "file", ev.Filename)
+			return
+		}
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+
+	s.log.Error("giving up on webhook", "file", ev.Filename)
+}
+
+func postEvent(client *http.Client, url string, payload []byte) error {
+	resp, err := client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
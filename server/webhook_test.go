@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/server"
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	delivered := make(chan map[string]any, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		n := attempts
+		mu.Unlock()
+		if n <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var ev map[string]any
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &ev); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s of type %q", body, r.Header.Get("Content-Type"))
+		}
+		delivered <- ev
+	}))
+	defer hook.Close()
+
+	opts := servertest.Options()
+	opts.WebhookURL = hook.URL
+	opts.WebhookTries = 3
+	c := servertest.NewPipe(t, opts).Client()
+	c.Do(http.MethodPut, "/files/a.txt", strings.NewReader("hello"), nil).AssertStatus(http.StatusCreated)
+
+	select {
+	case ev := <-delivered:
+		want := map[string]any{
+			"filename":    "a.txt",
+			"size":        float64(5),
+			"checksum":    "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+			"uploader_ip": "pipe",
+		}
+		for k, v := range want {
+			if ev[k] != v {
+				t.Errorf("got %s %v, want %v", k, ev[k], v)
+			}
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("event not delivered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 3 {
+		t.Errorf("delivered in %d attempts, want 3", attempts)
+	}
+}
+
+func TestWebhookTries(t *testing.T) {
+	for _, tries := range []int{0, -1} {
+		opts := servertest.Options()
+		opts.WebhookURL = "http://127.0.0.1:1/"
+		opts.WebhookTries = tries
+		if _, err := server.New(opts); err == nil {
+			t.Errorf("got no error with -webhook-tries %d", tries)
+		}
+	}
+}
+
+func TestWebhookShutdown(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer hook.Close()
+
+	opts := servertest.Options()
+	opts.WebhookURL = hook.URL
+	s := servertest.NewPipe(t, opts)
+	s.Client().Do(http.MethodPut, "/files/a.txt", strings.NewReader("hello"), nil).AssertStatus(http.StatusCreated)
+	time.Sleep(200 * time.Millisecond)
+	s.Shutdown()
+
+	// The retry due a second after the first attempt is never made.
+	time.Sleep(1500 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 1 {
+		t.Errorf("got %d attempts, want the retries to stop on shutdown", attempts)
+	}
+}
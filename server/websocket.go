@@ -0,0 +1,189 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// The WebSocket opcodes and close codes of RFC 6455 the server uses.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+
+	wsCloseNormal    = 1000
+	wsCloseGoingAway = 1001
+	wsCloseTooBig    = 1009
+)
+
+// wsGUID is mixed into the client's key to prove the server speaks
+// WebSocket.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWSMessage bounds the frames accepted from clients, which the server
+// only ever reads to answer pings and closes.
+const maxWSMessage = 64 << 10
+
+var errWSFrame = errors.New("invalid WebSocket frame")
+
+// webSocket is the server end of a WebSocket connection. Messages from the
+// client are read and dropped in the background, pings answered, and done
+// closed once the client closes the connection or goes away.
+type webSocket struct {
+	conn net.Conn
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// acceptWebSocket completes the opening handshake of a WebSocket request
+// and takes the connection over from the exchange. If req isn't one it is
+// answered with an error and acceptWebSocket reports false.
+func acceptWebSocket(conn net.Conn, req request) (*webSocket, bool) {
+	ex, ok := conn.(*exchangeConn)
+	key := req.headers.get("Sec-WebSocket-Key")
+	switch {
+	case !ok || ex.reader == nil:
+		conn.Write(buildResponse(statusInternalServerError, nil))
+		return nil, false
+	case !hasToken(req.headers.get("Connection"), "upgrade") || !hasToken(req.headers.get("Upgrade"), "websocket"),
+		req.headers.get("Sec-WebSocket-Version") != "13":
+		h := header{}
+		h.set("Upgrade", "websocket")
+		h.set("Sec-WebSocket-Version", "13")
+		conn.Write(buildResponseHeaders(statusUpgradeRequired, h, nil))
+		return nil, false
+	case key == "":
+		conn.Write(buildResponse(statusBadRequest, nil))
+		return nil, false
+	}
+
+	// The connection is the WebSocket's from here on, read through what
+	// the request was read with, and closed when it is done.
+	ex.forceClose()
+	if pc, ok := ex.Conn.(*peerConn); ok {
+		pc.stopWatching()
+	}
+	ws := &webSocket{conn: &upgradedConn{Conn: ex.Conn, r: ex.reader, ex: ex}, done: make(chan struct{})}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	ws.conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n"))
+	go ws.readLoop()
+
+	return ws, true
+}
+
+func (ws *webSocket) readLoop() {
+	defer close(ws.done)
+
+	for {
+		op, payload, err := ws.readFrame()
+		if errors.Is(err, errWSFrame) {
+			ws.close(wsCloseTooBig)
+			return
+		}
+		if err != nil {
+			return
+		}
+		switch op {
+		case wsClose:
+			ws.close(wsCloseNormal)
+			return
+		case wsPing:
+			ws.write(wsPong, payload)
+		}
+	}
+}
+
+// readFrame reads a frame from the client, unmasking its payload.
+func (ws *webSocket) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(ws.conn, head[:]); err != nil {
+		return 0, nil, err
+	}
+	op := head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.conn, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.conn, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	// Clients must mask what they send, and control frames fit in 125
+	// bytes.
+	if !masked || n > maxWSMessage || op >= wsClose && n > 125 {
+		return 0, nil, errWSFrame
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.conn, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(ws.conn, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return op, payload, nil
+}
+
+// write sends payload in a single frame with opcode op.
+func (ws *webSocket) write(op byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.closed {
+		return net.ErrClosed
+	}
+	if op == wsClose {
+		ws.closed = true
+	}
+
+	frame := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	_, err := ws.conn.Write(append(frame, payload...))
+
+	return err
+}
+
+// close sends a close frame with code, unless one has been sent already.
+func (ws *webSocket) close(code uint16) {
+	ws.write(wsClose, binary.BigEndian.AppendUint16(nil, code))
+}
+
+// Close ends the WebSocket with a normal closure if it hasn't ended yet.
+// The connection itself is closed once its exchange is over.
+func (ws *webSocket) Close() {
+	ws.close(wsCloseNormal)
+}
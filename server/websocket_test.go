@@ -0,0 +1,137 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudemuller/naive-server/servertest"
+)
+
+// readWSFrame reads a frame the server sent, which it never masks.
+func readWSFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("got a masked frame from the server")
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(r, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	return head[0] & 0x0f, payload
+}
+
+func TestWatchWebSocket(t *testing.T) {
+	dir := t.TempDir()
+	opts := servertest.Options()
+	opts.Storage = "disk"
+	opts.Directory = dir
+	s := servertest.New(t, opts)
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /watch HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got %d, want 101", resp.StatusCode)
+	}
+	// The accept value of the example handshake in RFC 6455.
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got Sec-WebSocket-Accept %q", got)
+	}
+
+	next := func() map[string]any {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			op, payload := readWSFrame(t, r)
+			if op != 1 {
+				continue
+			}
+			var ev map[string]any
+			if err := json.Unmarshal(payload, &ev); err != nil {
+				t.Fatalf("got %q: %v", payload, err)
+			}
+			return ev
+		}
+	}
+
+	s.Client().Do("PUT", "/files/uploaded.txt", strings.NewReader("hi"), nil)
+	if ev := next(); ev["type"] != "create" || ev["name"] != "uploaded.txt" {
+		t.Errorf("got %v after an upload, want uploaded.txt created", ev)
+	}
+
+	// Changes made behind the server's back are seen too.
+	os.WriteFile(filepath.Join(dir, "uploaded.txt"), []byte("hello"), 0o644)
+	if ev := next(); ev["type"] != "modify" || ev["name"] != "uploaded.txt" || ev["size"] != float64(5) {
+		t.Errorf("got %v after a write, want uploaded.txt modified", ev)
+	}
+	os.Remove(filepath.Join(dir, "uploaded.txt"))
+	if ev := next(); ev["type"] != "delete" || ev["name"] != "uploaded.txt" {
+		t.Errorf("got %v after a removal, want uploaded.txt deleted", ev)
+	}
+
+	// The client closing is answered in kind.
+	conn.Write([]byte{0x88, 0x82, 1, 2, 3, 4, 0x03 ^ 1, 0xe8 ^ 2})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		op, _ := readWSFrame(t, r)
+		if op == 8 {
+			break
+		}
+	}
+}
+
+func TestWatchNeedsUpgrade(t *testing.T) {
+	s := servertest.New(t, servertest.Options())
+
+	s.Client().Get("/watch").
+		AssertStatus(http.StatusUpgradeRequired).
+		AssertHeader("Sec-WebSocket-Version", "13")
+
+	conn, err := s.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /watch HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	got, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %d without a key, want 400", got.StatusCode)
+	}
+}
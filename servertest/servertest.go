@@ -0,0 +1,343 @@
+// Package servertest runs a naive-server in-process so that integration tests
+// can talk to it without shelling out to the binary.
+package servertest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/claudemuller/naive-server/server"
+)
+
+// Options returns the server defaults with /files kept in memory, which is
+// what most tests want.
+func Options() server.Options {
+	opts := server.DefaultOptions()
+	opts.Storage = "memory"
+
+	return opts
+}
+
+// Server is a naive-server running in the test process.
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:40123.
+	URL string
+
+	t    testing.TB
+	srv  *server.Server
+	addr string
+	pipe *pipeListener
+	done chan struct{}
+}
+
+// New starts a server with opts on an ephemeral loopback port. It is shut
+// down when the test finishes.
+func New(t testing.TB, opts server.Options) *Server {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("servertest: error listening: %v", err)
+	}
+
+	s := start(t, opts, l)
+	s.addr = l.Addr().String()
+	s.URL = "http://" + s.addr
+
+	return s
+}
+
+// NewPipe starts a server with opts on an in-memory listener, so no socket
+// is bound at all. It is shut down when the test finishes.
+func NewPipe(t testing.TB, opts server.Options) *Server {
+	t.Helper()
+
+	l := newPipeListener()
+	s := start(t, opts, l)
+	s.pipe = l
+	s.URL = "http://pipe"
+
+	return s
+}
+
+func start(t testing.TB, opts server.Options, l net.Listener) *Server {
+	t.Helper()
+
+	srv, err := server.New(opts)
+	if err != nil {
+		l.Close()
+		t.Fatalf("servertest: error setting up server: %v", err)
+	}
+
+	s := &Server{t: t, srv: srv, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		if err := srv.Serve(l); err != nil {
+			t.Errorf("servertest: error serving: %v", err)
+		}
+	}()
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Close shuts the server down and waits for it to finish.
+func (s *Server) Close() {
+	s.Shutdown()
+}
+
+// Shutdown is Close, returning the error from shutting the server down.
+func (s *Server) Shutdown() error {
+	err := s.srv.Shutdown()
+	<-s.done
+
+	return err
+}
+
+// Dial opens a raw connection to the server.
+func (s *Server) Dial() (net.Conn, error) {
+	if s.pipe != nil {
+		return s.pipe.dial()
+	}
+
+	return net.Dial("tcp", s.addr)
+}
+
+// Client returns a client for making requests to the server.
+func (s *Server) Client() *Client {
+	transport := &http.Transport{
+		// A fresh connection per request keeps tests independent of
+		// keep-alive.
+		DisableKeepAlives: true,
+		DialContext: func(context.Context, string, string) (net.Conn, error) {
+			return s.Dial()
+		},
+	}
+
+	return &Client{
+		t:    s.t,
+		s:    s,
+		http: &http.Client{Transport: transport},
+	}
+}
+
+// Client makes requests to a test server, failing the test on transport
+// errors.
+type Client struct {
+	t    testing.TB
+	s    *Server
+	http *http.Client
+}
+
+// Get requests path from the server.
+func (c *Client) Get(path string) *Response {
+	c.t.Helper()
+
+	return c.Do(http.MethodGet, path, nil, nil)
+}
+
+// Do sends a request with the given method, body and headers to path.
+func (c *Client) Do(method, path string, body io.Reader, header http.Header) *Response {
+	c.t.Helper()
+
+	req, err := http.NewRequest(method, c.s.URL+path, body)
+	if err != nil {
+		c.t.Fatalf("servertest: error building request: %v", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatalf("servertest: %s %s: %v", method, path, err)
+	}
+
+	return readResponse(c.t, resp)
+}
+
+// Raw writes raw to a fresh connection as-is and reads back one response,
+// for requests net/http would refuse to send. Over TCP the write side is
+// closed once raw is sent, ending bodies that run to the end of the
+// connection.
+func (c *Client) Raw(raw string) *Response {
+	c.t.Helper()
+
+	conn, err := c.s.Dial()
+	if err != nil {
+		c.t.Fatalf("servertest: error dialling: %v", err)
+	}
+	defer conn.Close()
+
+	go func() {
+		io.WriteString(conn, raw)
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		c.t.Fatalf("servertest: error reading response to %q: %v", raw, err)
+	}
+
+	return readResponse(c.t, resp)
+}
+
+// RawHead writes raw to a fresh connection as-is and reads back only the
+// head of the response, with its header fields exactly as sent, for
+// checking how responses that stream without end are framed. The body is
+// left unread.
+func (c *Client) RawHead(raw string) *Response {
+	c.t.Helper()
+
+	conn, err := c.s.Dial()
+	if err != nil {
+		c.t.Fatalf("servertest: error dialling: %v", err)
+	}
+	defer conn.Close()
+
+	go io.WriteString(conn, raw)
+
+	tp := textproto.NewReader(bufio.NewReader(conn))
+	line, err := tp.ReadLine()
+	if err != nil {
+		c.t.Fatalf("servertest: error reading status line of response to %q: %v", raw, err)
+	}
+	_, status, _ := strings.Cut(line, " ")
+	code, err := strconv.Atoi(strings.SplitN(status, " ", 2)[0])
+	if err != nil {
+		c.t.Fatalf("servertest: malformed status line %q", line)
+	}
+	h, err := tp.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("servertest: error reading head of response to %q: %v", raw, err)
+	}
+
+	return &Response{StatusCode: code, Header: http.Header(h), t: c.t}
+}
+
+// Response is a fully read response.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	t testing.TB
+}
+
+func readResponse(t testing.TB, resp *http.Response) *Response {
+	t.Helper()
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("servertest: error reading body: %v", err)
+	}
+
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: body, t: t}
+}
+
+// AssertStatus fails the test unless the response has the given status.
+func (r *Response) AssertStatus(code int) *Response {
+	r.t.Helper()
+
+	if r.StatusCode != code {
+		r.t.Errorf("got status %d, want %d", r.StatusCode, code)
+	}
+
+	return r
+}
+
+// AssertHeader fails the test unless the header name has the value want.
+func (r *Response) AssertHeader(name, want string) *Response {
+	r.t.Helper()
+
+	if got := r.Header.Get(name); got != want {
+		r.t.Errorf("got %s %q, want %q", name, got, want)
+	}
+
+	return r
+}
+
+// AssertBody fails the test unless the body is exactly want.
+func (r *Response) AssertBody(want string) *Response {
+	r.t.Helper()
+
+	if !bytes.Equal(r.Body, []byte(want)) {
+		r.t.Errorf("got body %q, want %q", r.Body, want)
+	}
+
+	return r
+}
+
+// AssertBodyContains fails the test unless the body contains sub.
+func (r *Response) AssertBodyContains(sub string) *Response {
+	r.t.Helper()
+
+	if !strings.Contains(string(r.Body), sub) {
+		r.t.Errorf("body %q doesn't contain %q", r.Body, sub)
+	}
+
+	return r
+}
+
+// pipeListener hands out the server ends of in-memory connections.
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+func (l *pipeListener) dial() (net.Conn, error) {
+	client, srv := net.Pipe()
+
+	select {
+	case l.conns <- srv:
+		return client, nil
+	case <-l.closed:
+		client.Close()
+		srv.Close()
+		return nil, errors.New("servertest: listener closed")
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
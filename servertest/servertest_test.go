@@ -0,0 +1,47 @@
+package servertest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	for name, start := range map[string]func(testing.TB) *Server{
+		"tcp":  func(t testing.TB) *Server { return New(t, Options()) },
+		"pipe": func(t testing.TB) *Server { return NewPipe(t, Options()) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := start(t).Client()
+
+			c.Get("/echo/abc").
+				AssertStatus(http.StatusOK).
+				AssertHeader("Content-Type", "text/plain; charset=utf-8").
+				AssertBody("abc")
+
+			c.Do(http.MethodPost, "/files/a.txt", strings.NewReader("hello"), nil).
+				AssertStatus(http.StatusCreated)
+			c.Get("/files/a.txt").
+				AssertStatus(http.StatusOK).
+				AssertBody("hello")
+
+			c.Get("/nope").AssertStatus(http.StatusNotFound)
+		})
+	}
+}
+
+func TestRaw(t *testing.T) {
+	c := NewPipe(t, Options()).Client()
+
+	c.Raw("GET /user-agent HTTP/1.1\r\nUser-Agent: raw\r\n\r\n").
+		AssertStatus(http.StatusOK).
+		AssertBody("raw")
+}
+
+func TestRawHead(t *testing.T) {
+	c := NewPipe(t, Options()).Client()
+
+	c.RawHead("GET /echo/abc HTTP/1.1\r\nHost: x\r\n\r\n").
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Length", "3")
+}